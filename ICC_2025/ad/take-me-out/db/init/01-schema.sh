@@ -0,0 +1,38 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Applies the web service's schema migrations (copied to /migrations) the
+# same way "take-me-out-web migrate up" does: in version order, each in one
+# transaction together with its schema_migrations row.
+
+echo "[db-init] Applying schema migrations..."
+
+psql_user="${POSTGRES_USER:-postgres}"
+psql_db="${POSTGRES_DB:-postgres}"
+psql_base=(psql -v ON_ERROR_STOP=1 --username "$psql_user" --dbname "$psql_db")
+migrations_dir="${MIGRATIONS_DIR:-/migrations}"
+
+"${psql_base[@]}" <<'SQL'
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+SQL
+
+shopt -s nullglob
+for file in "$migrations_dir"/*.up.sql; do
+  base="$(basename "$file" .up.sql)"
+  version="$((10#${base%%_*}))"
+  name="${base#*_}"
+  applied="$("${psql_base[@]}" -tAc "SELECT 1 FROM schema_migrations WHERE version = ${version}")"
+  if [[ "$applied" == "1" ]]; then
+    continue
+  fi
+  echo "[db-init] Migrating up ${base}"
+  "${psql_base[@]}" --single-transaction \
+    -f "$file" \
+    -c "INSERT INTO schema_migrations(version, name) VALUES (${version}, '${name//\'/\'\'}')"
+done
+
+echo "[db-init] Schema migrations applied."
@@ -6,7 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
@@ -15,11 +15,12 @@ import (
 
 const sessionCookieName = "session"
 
-var (
-	sessionSecret       []byte
-	sessionCookieSecure bool
-	sessionDuration     = 24 * time.Hour
-)
+// sessionManager issues and checks the JWT session cookies.
+type sessionManager struct {
+	secret       []byte
+	cookieSecure bool
+	duration     time.Duration
+}
 
 type sessionClaims struct {
 	Subject   string `json:"sub"`
@@ -32,44 +33,45 @@ type jwtHeaderFields struct {
 	Typ string `json:"typ"`
 }
 
-func initAuth() error {
+func newSessionManager(secret []byte, cookieSecure bool) *sessionManager {
+	return &sessionManager{secret: secret, cookieSecure: cookieSecure, duration: 24 * time.Hour}
+}
+
+// newSessionManagerFromEnv reads SESSION_SECRET and SESSION_COOKIE_SECURE.
+func newSessionManagerFromEnv() (*sessionManager, error) {
 	secret := strings.TrimSpace(os.Getenv("SESSION_SECRET"))
 	if secret == "" {
-		return errors.New("SESSION_SECRET environment variable must be set")
+		return nil, errors.New("SESSION_SECRET environment variable must be set")
 	}
-	sessionSecret = []byte(secret)
-
+	var cookieSecure bool
 	switch strings.ToLower(strings.TrimSpace(os.Getenv("SESSION_COOKIE_SECURE"))) {
 	case "1", "true", "t", "yes", "y", "on":
-		sessionCookieSecure = true
-	default:
-		sessionCookieSecure = false
+		cookieSecure = true
 	}
-
-	return nil
+	return newSessionManager([]byte(secret), cookieSecure), nil
 }
 
 // getUser retrieves the logged-in user from the session cookie
-func getUser(r *http.Request) *User {
+func (s *server) getUser(r *http.Request) *User {
 	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil || cookie.Value == "" {
 		return nil
 	}
-	claims, err := parseSessionToken(cookie.Value)
+	claims, err := s.sessions.parseToken(cookie.Value)
 	if err != nil {
-		log.Printf("invalid session token: %v", err)
+		slog.WarnContext(r.Context(), "invalid session token", "err", err)
 		return nil
 	}
-	user, err := getUserByUsername(claims.Subject)
+	user, err := s.store.GetUserByUsername(claims.Subject)
 	if err != nil {
 		return nil
 	}
 	return user
 }
 
-// setSession logs in a user by setting a JWT session cookie
-func setSession(w http.ResponseWriter, userID string) error {
-	token, expiresAt, err := createSessionToken(userID)
+// set logs in a user by setting a JWT session cookie
+func (sm *sessionManager) set(w http.ResponseWriter, userID string) error {
+	token, expiresAt, err := sm.createToken(userID)
 	if err != nil {
 		return err
 	}
@@ -79,31 +81,31 @@ func setSession(w http.ResponseWriter, userID string) error {
 		Path:     "/",
 		Expires:  expiresAt,
 		HttpOnly: true,
-		Secure:   sessionCookieSecure,
+		Secure:   sm.cookieSecure,
 		SameSite: http.SameSiteLaxMode,
 	})
 	return nil
 }
 
-// clearSession logs out a user
-func clearSession(w http.ResponseWriter) {
+// clear logs out a user
+func (sm *sessionManager) clear(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    "",
 		Path:     "/",
 		MaxAge:   -1,
 		HttpOnly: true,
-		Secure:   sessionCookieSecure,
+		Secure:   sm.cookieSecure,
 		SameSite: http.SameSiteLaxMode,
 	})
 }
 
-func createSessionToken(userID string) (string, time.Time, error) {
-	if len(sessionSecret) == 0 {
+func (sm *sessionManager) createToken(userID string) (string, time.Time, error) {
+	if len(sm.secret) == 0 {
 		return "", time.Time{}, errors.New("session secret is not initialized")
 	}
 	now := time.Now().UTC()
-	expiration := now.Add(sessionDuration)
+	expiration := now.Add(sm.duration)
 	claims := sessionClaims{
 		Subject:   userID,
 		IssuedAt:  now.Unix(),
@@ -123,12 +125,12 @@ func createSessionToken(userID string) (string, time.Time, error) {
 	header := base64.RawURLEncoding.EncodeToString(headerJSON)
 	body := base64.RawURLEncoding.EncodeToString(payload)
 	unsigned := header + "." + body
-	signature := sign(unsigned)
+	signature := sm.sign(unsigned)
 	token := unsigned + "." + signature
 	return token, expiration, nil
 }
 
-func parseSessionToken(token string) (*sessionClaims, error) {
+func (sm *sessionManager) parseToken(token string) (*sessionClaims, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) < 2 || len(parts) > 3 {
 		return nil, errors.New("token format invalid")
@@ -151,10 +153,10 @@ func parseSessionToken(token string) (*sessionClaims, error) {
 	}
 	unsigned := parts[0] + "." + parts[1]
 	if alg == "HS256" {
-		if len(sessionSecret) == 0 {
+		if len(sm.secret) == 0 {
 			return nil, errors.New("session secret is not initialized")
 		}
-		expectedSig := sign(unsigned)
+		expectedSig := sm.sign(unsigned)
 		if !hmac.Equal([]byte(signature), []byte(expectedSig)) {
 			return nil, errors.New("signature mismatch")
 		}
@@ -179,8 +181,8 @@ func parseSessionToken(token string) (*sessionClaims, error) {
 	return &claims, nil
 }
 
-func sign(unsigned string) string {
-	mac := hmac.New(sha256.New, sessionSecret)
+func (sm *sessionManager) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, sm.secret)
 	mac.Write([]byte(unsigned))
 	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
 }
@@ -1,102 +1,30 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"goexe/internal/tracing"
 )
 
-var db *sql.DB
 var ErrUserExists = errors.New("user already exists")
 
-// initDB connects to PostgreSQL and loads seed data required by the web app
-func initDB() {
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		host = "db"
-	}
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "5432"
-	}
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		user = "app_web"
-	}
-	flagPath := strings.TrimSpace(os.Getenv("DB_PASSWORD_FLAG_PATH"))
-	if flagPath == "" {
-		flagPath = "/flag1"
-	}
-	password, err := hashFileSHA256(flagPath)
-	if err != nil {
-		log.Fatalf("Failed to derive DB password from %s: %v", flagPath, err)
-	}
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "postgres"
-	}
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbName)
-	db, err = sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatalf("DB connection failed: %v", err)
-	}
-	// Connection pool configuration
-	maxOpen := 20
-	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			maxOpen = n
-		}
-	}
-	maxIdle := 10
-	if v := os.Getenv("DB_MAX_IDLE_CONNS"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n >= 0 {
-			maxIdle = n
-		}
-	}
-	lifeMin := 15
-	if v := os.Getenv("DB_CONN_MAX_LIFETIME_MINUTES"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			lifeMin = n
-		}
-	}
-	db.SetMaxOpenConns(maxOpen)
-	db.SetMaxIdleConns(maxIdle)
-	db.SetConnMaxLifetime(time.Duration(lifeMin) * time.Minute)
-	// Wait for DB to be ready (up to ~10s)
-	var pingErr error
-	for i := 0; i < 10; i++ {
-		pingErr = db.Ping()
-		if pingErr == nil {
-			break
-		}
-		log.Printf("Waiting for DB to be ready (%d/10): %v", i+1, pingErr)
-		time.Sleep(1 * time.Second)
-	}
-	if pingErr != nil {
-		log.Fatalf("DB ping failed after retries: %v", pingErr)
-	}
+// sqlStore implements Store on top of PostgreSQL or SQLite. Queries are
+// written once with PostgreSQL placeholders; db adapts them to its dialect.
+type sqlStore struct {
+	db *dbConn
 }
 
-func hashFileSHA256(path string) (string, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	sum := sha256.Sum256(data)
-	return fmt.Sprintf("%x", sum[:]), nil
+func newSQLStore(conn *dbConn) *sqlStore {
+	return &sqlStore{db: conn}
 }
 
-// getChallengeSummaries fetches a paginated list of public challenges
-func getChallengeSummaries(page, perPage int) ([]ChallengeSummary, int, error) {
+// GetChallengeSummaries fetches a paginated list of public challenges
+func (s *sqlStore) GetChallengeSummaries(page, perPage int) ([]ChallengeSummary, int, error) {
 	if perPage <= 0 {
 		perPage = 12
 	}
@@ -104,7 +32,7 @@ func getChallengeSummaries(page, perPage int) ([]ChallengeSummary, int, error) {
 		page = 1
 	}
 	offset := (page - 1) * perPage
-	rows, err := db.Query(`SELECT id, name, points
+	rows, err := s.db.Query(`SELECT id, name, points
         FROM challenges WHERE is_public=TRUE ORDER BY name LIMIT $1 OFFSET $2`, perPage, offset)
 	if err != nil {
 		return nil, 0, err
@@ -119,14 +47,14 @@ func getChallengeSummaries(page, perPage int) ([]ChallengeSummary, int, error) {
 		list = append(list, item)
 	}
 	var total int
-	if err := db.QueryRow(`SELECT COUNT(*) FROM challenges WHERE is_public=TRUE`).Scan(&total); err != nil {
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM challenges WHERE is_public=TRUE`).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 	return list, total, nil
 }
 
-// searchChallenges finds public challenges matching the query by name or description
-func searchChallenges(query string, limit int) ([]ChallengeSummary, error) {
+// SearchChallenges finds public challenges matching the query by name or description
+func (s *sqlStore) SearchChallenges(query string, limit int) ([]ChallengeSummary, error) {
 	query = strings.TrimSpace(query)
 	if query == "" {
 		return nil, nil
@@ -135,9 +63,9 @@ func searchChallenges(query string, limit int) ([]ChallengeSummary, error) {
 		limit = 20
 	}
 	pattern := "%" + query + "%"
-	rows, err := db.Query(`SELECT id, name, points, description
+	rows, err := s.db.Query(`SELECT id, name, points, description
         FROM challenges
-        WHERE is_public = TRUE AND (name ILIKE $1 OR description ILIKE $1)
+        WHERE is_public = TRUE AND (LOWER(name) LIKE LOWER($1) OR LOWER(description) LIKE LOWER($1))
         ORDER BY LOWER(name)
         LIMIT $2`, pattern, limit)
 	if err != nil {
@@ -159,15 +87,15 @@ func searchChallenges(query string, limit int) ([]ChallengeSummary, error) {
 	return results, nil
 }
 
-// getTestCase retrieves a challenge by name
-func getTestCase(name string) (TestCase, error) {
+// GetTestCase retrieves a challenge by name
+func (s *sqlStore) GetTestCase(name string) (TestCase, error) {
 	// Return the first sample case along with the challenge description
 	var tc TestCase
-	desc, err := getChallengeDescription(name)
+	desc, err := s.GetChallengeDescription(name)
 	if err != nil {
 		return tc, err
 	}
-	samples, err := getSampleCases(name)
+	samples, err := s.GetSampleCases(name)
 	if err != nil {
 		return tc, err
 	}
@@ -178,17 +106,17 @@ func getTestCase(name string) (TestCase, error) {
 	return tc, nil
 }
 
-// getChallengeDescription returns the description for a challenge
-func getChallengeDescription(name string) (string, error) {
+// GetChallengeDescription returns the description for a challenge
+func (s *sqlStore) GetChallengeDescription(name string) (string, error) {
 	var desc string
-	row := db.QueryRow(`SELECT description FROM challenges WHERE name=$1`, name)
+	row := s.db.QueryRow(`SELECT description FROM challenges WHERE name=$1`, name)
 	err := row.Scan(&desc)
 	return desc, err
 }
 
-// getSampleCases returns public sample cases for a challenge
-func getSampleCases(name string) ([]TestCase, error) {
-	rows, err := db.Query(`SELECT idx, input, output FROM sample_cases WHERE challenge=$1 ORDER BY idx ASC`, name)
+// GetSampleCases returns public sample cases for a challenge
+func (s *sqlStore) GetSampleCases(name string) ([]TestCase, error) {
+	rows, err := s.db.Query(`SELECT idx, input, output FROM sample_cases WHERE challenge=$1 ORDER BY idx ASC`, name)
 	if err != nil {
 		return nil, err
 	}
@@ -205,9 +133,9 @@ func getSampleCases(name string) ([]TestCase, error) {
 	return tcs, nil
 }
 
-// getChallengeForEdit gathers challenge ownership and numeric metadata by ID (description is fetched via runner)
-func getChallengeForEdit(id int) (*ChallengeDetail, error) {
-	row := db.QueryRow(`SELECT name, points, created_by, is_public FROM challenges WHERE id=$1`, id)
+// GetChallengeForEdit gathers challenge ownership and numeric metadata by ID (description is fetched via runner)
+func (s *sqlStore) GetChallengeForEdit(id int) (*ChallengeDetail, error) {
+	row := s.db.QueryRow(`SELECT name, points, created_by, is_public FROM challenges WHERE id=$1`, id)
 	var name string
 	var points int
 	var createdBy sql.NullInt64
@@ -229,9 +157,9 @@ func getChallengeForEdit(id int) (*ChallengeDetail, error) {
 	}, nil
 }
 
-// getChallengeNameByID returns the canonical name for a challenge ID
-func getChallengeNameByID(id int) (string, error) {
-	row := db.QueryRow(`SELECT name FROM challenges WHERE id=$1`, id)
+// GetChallengeNameByID returns the canonical name for a challenge ID
+func (s *sqlStore) GetChallengeNameByID(id int) (string, error) {
+	row := s.db.QueryRow(`SELECT name FROM challenges WHERE id=$1`, id)
 	var name string
 	if err := row.Scan(&name); err != nil {
 		return "", err
@@ -239,9 +167,9 @@ func getChallengeNameByID(id int) (string, error) {
 	return name, nil
 }
 
-// getChallengeIDByName returns the numeric ID for a challenge name
-func getChallengeIDByName(name string) (int, error) {
-	row := db.QueryRow(`SELECT id FROM challenges WHERE name=$1`, name)
+// GetChallengeIDByName returns the numeric ID for a challenge name
+func (s *sqlStore) GetChallengeIDByName(name string) (int, error) {
+	row := s.db.QueryRow(`SELECT id FROM challenges WHERE name=$1`, name)
 	var id int
 	if err := row.Scan(&id); err != nil {
 		return 0, err
@@ -249,13 +177,13 @@ func getChallengeIDByName(name string) (int, error) {
 	return id, nil
 }
 
-// setChallengeVisibility toggles whether a challenge is visible to players
-func setChallengeVisibility(id int, public bool) error {
-	_, err := db.Exec(`UPDATE challenges SET is_public=$1 WHERE id=$2`, public, id)
+// SetChallengeVisibility toggles whether a challenge is visible to players
+func (s *sqlStore) SetChallengeVisibility(id int, public bool) error {
+	_, err := s.db.Exec(`UPDATE challenges SET is_public=$1 WHERE id=$2`, public, id)
 	return err
 }
 
-func replaceSampleCasesTx(tx *sql.Tx, name string, tests []TestCase) error {
+func (s *sqlStore) replaceSampleCasesTx(tx *dbTx, name string, tests []TestCase) error {
 	if _, err := tx.Exec(`DELETE FROM sample_cases WHERE challenge=$1`, name); err != nil {
 		return err
 	}
@@ -267,11 +195,11 @@ func replaceSampleCasesTx(tx *sql.Tx, name string, tests []TestCase) error {
 	return nil
 }
 
-func replaceJudgeCasesTx(tx *sql.Tx, name string, tests []TestCase) error {
+func (s *sqlStore) replaceJudgeCasesTx(tx *dbTx, name string, tests []TestCase) error {
 	if tests == nil {
 		return nil
 	}
-	if _, err := tx.Exec(`SELECT purge_judge_cases($1)`, name); err != nil {
+	if err := s.db.dialect.purgeJudgeCases(tx, name); err != nil {
 		return err
 	}
 	for i, t := range tests {
@@ -282,9 +210,9 @@ func replaceJudgeCasesTx(tx *sql.Tx, name string, tests []TestCase) error {
 	return nil
 }
 
-// updateChallengeWithTests atomically updates challenge metadata and replaces its tests
-func updateChallengeWithTests(name, description string, points int, sampleTests, judgeTests []TestCase) error {
-	tx, err := db.Begin()
+// UpdateChallengeWithTests atomically updates challenge metadata and replaces its tests
+func (s *sqlStore) UpdateChallengeWithTests(name, description string, points int, sampleTests, judgeTests []TestCase) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
@@ -292,19 +220,77 @@ func updateChallengeWithTests(name, description string, points int, sampleTests,
 	if _, err := tx.Exec(`UPDATE challenges SET description=$1, points=$2 WHERE name=$3`, description, points, name); err != nil {
 		return err
 	}
-	if err := replaceSampleCasesTx(tx, name, sampleTests); err != nil {
+	if err := s.replaceSampleCasesTx(tx, name, sampleTests); err != nil {
 		return err
 	}
-	if err := replaceJudgeCasesTx(tx, name, judgeTests); err != nil {
+	if err := s.replaceJudgeCasesTx(tx, name, judgeTests); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-// createUser inserts a new user
-func createUser(username, password string, isWriter bool) (*User, error) {
+// CreateChallenge inserts a challenge owned by userID together with its
+// sample and hidden test cases, all or nothing
+func (s *sqlStore) CreateChallenge(userID int, name, description string, points int, publish bool, samples, hidden []TestCase) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newChallengeID int
+	if err := tx.QueryRow(
+		`INSERT INTO challenges(name, description, created_by, input, output, points, is_public) VALUES($1,$2,$3,'','',$4,$5) RETURNING id`,
+		name, description, userID, points, publish,
+	).Scan(&newChallengeID); err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return 0, errDuplicateChallenge
+		}
+		return 0, err
+	}
+
+	for i, t := range samples {
+		if _, err := tx.Exec(`INSERT INTO sample_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, name, i, t.Input, t.Output); err != nil {
+			return 0, fmt.Errorf("%w: %w", errSampleCaseInsert, err)
+		}
+	}
+	for i, t := range hidden {
+		if _, err := tx.Exec(`INSERT INTO judge_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, name, i, t.Input, t.Output); err != nil {
+			return 0, fmt.Errorf("%w: %w", errHiddenCaseInsert, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newChallengeID, nil
+}
+
+// ImportChallenge adds a private challenge and appends its test cases. It is
+// insert-only: an existing challenge keeps its metadata, and a failed insert
+// does not stop the remaining ones; every failure is returned.
+func (s *sqlStore) ImportChallenge(ownerID int, name, description string, points int, samples, hidden []TestCase) error {
+	var errs []error
+	if _, err := s.db.Exec(`INSERT INTO challenges(name, description, created_by, input, output, points, is_public) VALUES($1,$2,$3,'','',$4,FALSE) ON CONFLICT(name) DO NOTHING`, name, description, ownerID, points); err != nil {
+		errs = append(errs, fmt.Errorf("insert challenge: %w", err))
+	}
+	for i, t := range samples {
+		if _, err := s.db.Exec(`INSERT INTO sample_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, name, i, t.Input, t.Output); err != nil {
+			errs = append(errs, fmt.Errorf("insert sample case %d: %w", i, err))
+		}
+	}
+	for i, t := range hidden {
+		if _, err := s.db.Exec(`INSERT INTO judge_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, name, i, t.Input, t.Output); err != nil {
+			errs = append(errs, fmt.Errorf("insert judge case %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CreateUser inserts a new user
+func (s *sqlStore) CreateUser(username, password string, isWriter bool) (*User, error) {
 	var existingID int
-	err := db.QueryRow(`SELECT id FROM users WHERE username=$1`, username).Scan(&existingID)
+	err := s.db.QueryRow(`SELECT id FROM users WHERE username=$1`, username).Scan(&existingID)
 	if err == nil {
 		return nil, ErrUserExists
 	}
@@ -312,11 +298,14 @@ func createUser(username, password string, isWriter bool) (*User, error) {
 		return nil, err
 	}
 	isAdmin := false
-	row := db.QueryRow(`INSERT INTO users(username, password, is_admin, is_writer)
+	row := s.db.QueryRow(`INSERT INTO users(username, password, is_admin, is_writer)
         VALUES($1,$2,$3,$4)
         RETURNING id, is_admin, is_writer`, username, password, isAdmin, isWriter)
 	var u User
 	if err := row.Scan(&u.ID, &u.IsAdmin, &u.IsWriter); err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return nil, ErrUserExists
+		}
 		return nil, err
 	}
 	u.Username = username
@@ -324,17 +313,17 @@ func createUser(username, password string, isWriter bool) (*User, error) {
 	return &u, nil
 }
 
-// getUserByUsername fetches a user by username
-func getUserByUsername(username string) (*User, error) {
-	row := db.QueryRow(`SELECT id, username, password, is_admin, is_writer FROM users WHERE username=$1`, username)
+// GetUserByUsername fetches a user by username
+func (s *sqlStore) GetUserByUsername(username string) (*User, error) {
+	row := s.db.QueryRow(`SELECT id, username, password, is_admin, is_writer FROM users WHERE username=$1`, username)
 	var u User
 	err := row.Scan(&u.ID, &u.Username, &u.Password, &u.IsAdmin, &u.IsWriter)
 	return &u, err
 }
 
-// getUserByID fetches a user by ID (admin view)
-func getUserByID(userID int) (*User, error) {
-	row := db.QueryRow(`SELECT id, username, password, is_admin, is_writer FROM users WHERE id=$1`, userID)
+// GetUserByID fetches a user by ID (admin view)
+func (s *sqlStore) GetUserByID(userID int) (*User, error) {
+	row := s.db.QueryRow(`SELECT id, username, password, is_admin, is_writer FROM users WHERE id=$1`, userID)
 	var u User
 	if err := row.Scan(&u.ID, &u.Username, &u.Password, &u.IsAdmin, &u.IsWriter); err != nil {
 		return nil, err
@@ -342,9 +331,9 @@ func getUserByID(userID int) (*User, error) {
 	return &u, nil
 }
 
-// getAllUsersForAdmin lists users ordered by username (password omitted)
-func getAllUsersForAdmin() ([]User, error) {
-	rows, err := db.Query(`SELECT id, username, is_admin, is_writer FROM users ORDER BY username ASC`)
+// GetAllUsersForAdmin lists users ordered by username (password omitted)
+func (s *sqlStore) GetAllUsersForAdmin() ([]User, error) {
+	rows, err := s.db.Query(`SELECT id, username, is_admin, is_writer FROM users ORDER BY username ASC`)
 	if err != nil {
 		return nil, err
 	}
@@ -360,8 +349,8 @@ func getAllUsersForAdmin() ([]User, error) {
 	return users, nil
 }
 
-// getUsersPaginated returns a page of users ordered by username
-func getUsersPaginated(page, perPage int) ([]User, int, error) {
+// GetUsersPaginated returns a page of users ordered by username
+func (s *sqlStore) GetUsersPaginated(page, perPage int) ([]User, int, error) {
 	if perPage <= 0 {
 		perPage = 20
 	}
@@ -369,7 +358,7 @@ func getUsersPaginated(page, perPage int) ([]User, int, error) {
 		page = 1
 	}
 	offset := (page - 1) * perPage
-	rows, err := db.Query(`SELECT id, username, is_admin, is_writer
+	rows, err := s.db.Query(`SELECT id, username, is_admin, is_writer
         FROM users
         ORDER BY LOWER(username) ASC
         LIMIT $1 OFFSET $2`, perPage, offset)
@@ -386,14 +375,14 @@ func getUsersPaginated(page, perPage int) ([]User, int, error) {
 		users = append(users, u)
 	}
 	var total int
-	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&total); err != nil {
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 	return users, total, nil
 }
 
-// searchUsers finds users whose username matches the query
-func searchUsers(query string, limit int) ([]User, error) {
+// SearchUsers finds users whose username matches the query
+func (s *sqlStore) SearchUsers(query string, limit int) ([]User, error) {
 	query = strings.TrimSpace(query)
 	if query == "" {
 		return nil, nil
@@ -402,9 +391,9 @@ func searchUsers(query string, limit int) ([]User, error) {
 		limit = 20
 	}
 	pattern := "%" + query + "%"
-	rows, err := db.Query(`SELECT id, username, is_admin, is_writer
+	rows, err := s.db.Query(`SELECT id, username, is_admin, is_writer
         FROM users
-        WHERE username ILIKE $1
+        WHERE LOWER(username) LIKE LOWER($1)
         ORDER BY LOWER(username)
         LIMIT $2`, pattern, limit)
 	if err != nil {
@@ -422,30 +411,68 @@ func searchUsers(query string, limit int) ([]User, error) {
 	return users, nil
 }
 
-// setUserWriterFlag updates writer flag for a user
-func setUserWriterFlag(userID int, isWriter bool) error {
-	_, err := db.Exec(`UPDATE users SET is_writer = $1 WHERE id = $2`, isWriter, userID)
+// SetUserWriterFlag updates writer flag for a user
+func (s *sqlStore) SetUserWriterFlag(userID int, isWriter bool) error {
+	_, err := s.db.Exec(`UPDATE users SET is_writer = $1 WHERE id = $2`, isWriter, userID)
 	return err
 }
 
-// createSubmission records a submission and returns its ID
-func createSubmission(sub Submission) (int, error) {
-	row := db.QueryRow(
-		`INSERT INTO submissions(user_id, challenge, language, code, result, created_at, execution_time_ms, fail_case_index, last_output, expected_output)
-        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+// CreateSubmission records a submission and returns its ID. The trace
+// context of ctx is stored with it so the worker that judges it continues
+// the same trace.
+func (s *sqlStore) CreateSubmission(ctx context.Context, sub Submission) (int, error) {
+	if sub.TraceParent == "" {
+		sub.TraceParent = tracing.Traceparent(ctx)
+	}
+	ctx, span := tracer.Start(ctx, "db.insert_submission")
+	defer span.End()
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO submissions(user_id, challenge, language, code, result, created_at, execution_time_ms, fail_case_index, last_output, expected_output, priority, request_id, trace_parent)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
         RETURNING id`,
-		sub.UserID, sub.Challenge, sub.Language, sub.Code, sub.Result, sub.CreatedAt, sub.DurationMs, sub.FailCaseIdx, sub.LastOutput, sub.ExpectedOut,
+		sub.UserID, sub.Challenge, sub.Language, sub.Code, sub.Result, sub.CreatedAt, sub.DurationMs, sub.FailCaseIdx, sub.LastOutput, sub.ExpectedOut, sub.Priority, sub.RequestID, sub.TraceParent,
 	)
 	var id int
 	if err := row.Scan(&id); err != nil {
+		tracing.RecordError(span, err)
 		return 0, err
 	}
 	return id, nil
 }
 
-// ensureSolve records a first-time solve for a user and challenge
-func ensureSolve(userID int, challenge string, at time.Time) error {
-	_, err := db.Exec(`INSERT INTO solves(user_id, challenge, created_at) VALUES($1,$2,$3) ON CONFLICT (user_id, challenge) DO NOTHING`, userID, challenge, at)
+// CountPendingSubmissions returns how many submissions are waiting for a worker
+func (s *sqlStore) CountPendingSubmissions() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM submissions WHERE result = 'Pending'`).Scan(&n)
+	return n, err
+}
+
+// CountQueuedSubmissions returns how many submissions are Pending and how
+// many are Running
+func (s *sqlStore) CountQueuedSubmissions() (pending, running int, err error) {
+	rows, err := s.db.Query(`SELECT result, COUNT(*) FROM submissions WHERE result IN ('Pending', 'Running') GROUP BY result`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state string
+		var n int
+		if err := rows.Scan(&state, &n); err != nil {
+			return 0, 0, err
+		}
+		if state == "Pending" {
+			pending = n
+		} else {
+			running = n
+		}
+	}
+	return pending, running, rows.Err()
+}
+
+// EnsureSolve records a first-time solve for a user and challenge
+func (s *sqlStore) EnsureSolve(userID int, challenge string, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO solves(user_id, challenge, created_at) VALUES($1,$2,$3) ON CONFLICT (user_id, challenge) DO NOTHING`, userID, challenge, at)
 	return err
 }
 
@@ -456,9 +483,9 @@ type ScoreEntry struct {
 	Solves   int
 }
 
-// getScoreboard aggregates scores per user
-func getScoreboard() ([]ScoreEntry, error) {
-	rows, err := db.Query(`
+// GetScoreboard aggregates scores per user
+func (s *sqlStore) GetScoreboard() ([]ScoreEntry, error) {
+	rows, err := s.db.Query(`
        SELECT u.username, COALESCE(SUM(c.points),0) AS total, COUNT(s.challenge) AS solves
        FROM users u
        LEFT JOIN solves s ON s.user_id = u.id
@@ -480,9 +507,9 @@ func getScoreboard() ([]ScoreEntry, error) {
 	return list, nil
 }
 
-// getSubmissionsByUser returns submissions for a user
-func getSubmissionsByUser(userID int) ([]Submission, error) {
-	rows, err := db.Query(
+// GetSubmissionsByUser returns submissions for a user
+func (s *sqlStore) GetSubmissionsByUser(userID int) ([]Submission, error) {
+	rows, err := s.db.Query(
 		`SELECT s.id, s.challenge, c.id, s.language, s.code, s.result, s.created_at
         FROM submissions s
         LEFT JOIN challenges c ON c.name = s.challenge
@@ -493,29 +520,23 @@ func getSubmissionsByUser(userID int) ([]Submission, error) {
 	defer rows.Close()
 	var subs []Submission
 	for rows.Next() {
-		var s Submission
+		var sub Submission
 		var chalID sql.NullInt64
-		if err := rows.Scan(&s.ID, &s.Challenge, &chalID, &s.Language, &s.Code, &s.Result, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&sub.ID, &sub.Challenge, &chalID, &sub.Language, &sub.Code, &sub.Result, &sub.CreatedAt); err != nil {
 			return nil, err
 		}
 		if chalID.Valid {
-			s.ChallengeID = int(chalID.Int64)
+			sub.ChallengeID = int(chalID.Int64)
 		}
-		s.UserID = userID
-		subs = append(subs, s)
+		sub.UserID = userID
+		subs = append(subs, sub)
 	}
 	return subs, nil
 }
 
-// getSubmissionsByChallenge returns submissions for a specific challenge (with user)
-func getSubmissionsByChallenge(challenge string) ([]struct {
-	ID        int
-	Username  string
-	Language  string
-	Result    string
-	CreatedAt string
-}, error) {
-	rows, err := db.Query(
+// GetSubmissionsByChallenge returns submissions for a specific challenge (with user)
+func (s *sqlStore) GetSubmissionsByChallenge(challenge string) ([]ChallengeSubmission, error) {
+	rows, err := s.db.Query(
 		`SELECT s.id, u.username, s.language, s.result, s.created_at
         FROM submissions s JOIN users u ON s.user_id = u.id
         WHERE s.challenge=$1 ORDER BY s.created_at DESC`, challenge)
@@ -523,13 +544,7 @@ func getSubmissionsByChallenge(challenge string) ([]struct {
 		return nil, err
 	}
 	defer rows.Close()
-	var subs []struct {
-		ID        int
-		Username  string
-		Language  string
-		Result    string
-		CreatedAt string
-	}
+	var subs []ChallengeSubmission
 	for rows.Next() {
 		var id int
 		var username, language, result string
@@ -537,26 +552,14 @@ func getSubmissionsByChallenge(challenge string) ([]struct {
 		if err := rows.Scan(&id, &username, &language, &result, &createdAt); err != nil {
 			return nil, err
 		}
-		subs = append(subs, struct {
-			ID        int
-			Username  string
-			Language  string
-			Result    string
-			CreatedAt string
-		}{id, username, language, result, createdAt.Format(time.RFC1123)})
+		subs = append(subs, ChallengeSubmission{id, username, language, result, createdAt.Format(time.RFC1123)})
 	}
 	return subs, nil
 }
 
-// getMySubmissionsByChallenge returns submissions for a specific challenge by a specific user
-func getMySubmissionsByChallenge(userID int, challenge string) ([]struct {
-	ID        int
-	Username  string
-	Language  string
-	Result    string
-	CreatedAt string
-}, error) {
-	rows, err := db.Query(
+// GetMySubmissionsByChallenge returns submissions for a specific challenge by a specific user
+func (s *sqlStore) GetMySubmissionsByChallenge(userID int, challenge string) ([]ChallengeSubmission, error) {
+	rows, err := s.db.Query(
 		`SELECT s.id, u.username, s.language, s.result, s.created_at
         FROM submissions s JOIN users u ON s.user_id = u.id
         WHERE s.challenge=$1 AND s.user_id=$2 ORDER BY s.created_at DESC`, challenge, userID)
@@ -564,13 +567,7 @@ func getMySubmissionsByChallenge(userID int, challenge string) ([]struct {
 		return nil, err
 	}
 	defer rows.Close()
-	var subs []struct {
-		ID        int
-		Username  string
-		Language  string
-		Result    string
-		CreatedAt string
-	}
+	var subs []ChallengeSubmission
 	for rows.Next() {
 		var id int
 		var username, language, result string
@@ -578,48 +575,15 @@ func getMySubmissionsByChallenge(userID int, challenge string) ([]struct {
 		if err := rows.Scan(&id, &username, &language, &result, &createdAt); err != nil {
 			return nil, err
 		}
-		subs = append(subs, struct {
-			ID        int
-			Username  string
-			Language  string
-			Result    string
-			CreatedAt string
-		}{id, username, language, result, createdAt.Format(time.RFC1123)})
+		subs = append(subs, ChallengeSubmission{id, username, language, result, createdAt.Format(time.RFC1123)})
 	}
 	return subs, nil
 }
 
-// getSubmissionDetail fetches a single submission with user info
-
-func getSubmissionDetail(subID int) (struct {
-	ID          int
-	Username    string
-	Challenge   string
-	ChallengeID int
-	Language    string
-	Code        string
-	Result      string
-	CreatedAt   string
-	DurationMs  int
-	FailedCase  int
-	Got         string
-	Want        string
-}, error) {
-	var detail struct {
-		ID          int
-		Username    string
-		Challenge   string
-		ChallengeID int
-		Language    string
-		Code        string
-		Result      string
-		CreatedAt   string
-		DurationMs  int
-		FailedCase  int
-		Got         string
-		Want        string
-	}
-	row := db.QueryRow(
+// GetSubmissionDetail fetches a single submission with user info
+func (s *sqlStore) GetSubmissionDetail(subID int) (SubmissionDetail, error) {
+	var detail SubmissionDetail
+	row := s.db.QueryRow(
 		`SELECT s.id, u.username, s.challenge, c.id, s.language, s.code, s.result, s.created_at, s.execution_time_ms, s.fail_case_index, s.last_output, s.expected_output
         FROM submissions s JOIN users u ON s.user_id = u.id
         LEFT JOIN challenges c ON c.name = s.challenge
@@ -636,22 +600,32 @@ func getSubmissionDetail(subID int) (struct {
 	return detail, nil
 }
 
-// getSubmissionStatusByID returns submission fields for API polling
-func getSubmissionStatusByID(subID int) (Submission, error) {
-	var s Submission
+// GetSubmissionStatusByID returns submission fields for API polling
+func (s *sqlStore) GetSubmissionStatusByID(subID int) (Submission, error) {
+	var sub Submission
 	var createdAt time.Time
 	var chalID sql.NullInt64
-	row := db.QueryRow(
+	row := s.db.QueryRow(
 		`SELECT s.id, s.user_id, s.challenge, c.id, s.language, s.code, s.result, s.execution_time_ms, s.fail_case_index, s.last_output, s.expected_output, s.created_at
         FROM submissions s
         LEFT JOIN challenges c ON c.name = s.challenge
         WHERE s.id = $1`, subID)
-	if err := row.Scan(&s.ID, &s.UserID, &s.Challenge, &chalID, &s.Language, &s.Code, &s.Result, &s.DurationMs, &s.FailCaseIdx, &s.LastOutput, &s.ExpectedOut, &createdAt); err != nil {
-		return s, err
+	if err := row.Scan(&sub.ID, &sub.UserID, &sub.Challenge, &chalID, &sub.Language, &sub.Code, &sub.Result, &sub.DurationMs, &sub.FailCaseIdx, &sub.LastOutput, &sub.ExpectedOut, &createdAt); err != nil {
+		return sub, err
 	}
 	if chalID.Valid {
-		s.ChallengeID = int(chalID.Int64)
+		sub.ChallengeID = int(chalID.Int64)
 	}
-	s.CreatedAt = createdAt
-	return s, nil
+	sub.CreatedAt = createdAt
+	return sub, nil
+}
+
+// Ping checks that the database answers.
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the connection pool.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bufio"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 )
@@ -13,7 +13,7 @@ func loadDotEnv() {
 	f, err := os.Open(".env")
 	if err != nil {
 		if !os.IsNotExist(err) {
-			log.Printf("Failed to open .env: %v", err)
+			slog.Warn("failed to open .env", "err", err)
 		}
 		return
 	}
@@ -47,6 +47,6 @@ func loadDotEnv() {
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		log.Printf("Failed to scan .env: %v", err)
+		slog.Warn("failed to scan .env", "err", err)
 	}
 }
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goexe/internal/runnerclient"
+)
+
+// memStore is an in-memory Store for handler tests.
+type memStore struct {
+	mu          sync.Mutex
+	users       []User
+	challenges  []memChallenge
+	submissions []Submission
+	solves      map[int]map[string]time.Time
+	pingErr     error
+}
+
+type memChallenge struct {
+	ChallengeDetail
+	Description string
+	Samples     []TestCase
+	Hidden      []TestCase
+}
+
+func newMemStore() *memStore {
+	return &memStore{solves: map[int]map[string]time.Time{}}
+}
+
+var _ Store = (*memStore)(nil)
+
+func (m *memStore) challengeByName(name string) *memChallenge {
+	for i := range m.challenges {
+		if m.challenges[i].Name == name {
+			return &m.challenges[i]
+		}
+	}
+	return nil
+}
+
+func (m *memStore) userByID(id int) *User {
+	for i := range m.users {
+		if m.users[i].ID == id {
+			return &m.users[i]
+		}
+	}
+	return nil
+}
+
+func (m *memStore) publicChallenges() []ChallengeSummary {
+	var out []ChallengeSummary
+	for _, c := range m.challenges {
+		if c.IsPublic {
+			out = append(out, ChallengeSummary{ID: c.ID, Name: c.Name, Points: c.Points})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (m *memStore) GetChallengeSummaries(page, perPage int) ([]ChallengeSummary, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := m.publicChallenges()
+	start := (page - 1) * perPage
+	if start > len(all) {
+		start = len(all)
+	}
+	end := start + perPage
+	if end > len(all) {
+		end = len(all)
+	}
+	return all[start:end], len(all), nil
+}
+
+func (m *memStore) SearchChallenges(query string, limit int) ([]ChallengeSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	query = strings.ToLower(query)
+	var out []ChallengeSummary
+	for _, c := range m.publicChallenges() {
+		if len(out) < limit && strings.Contains(strings.ToLower(c.Name+" "+m.challengeByName(c.Name).Description), query) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) GetSampleCases(name string) ([]TestCase, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c := m.challengeByName(name); c != nil {
+		return append([]TestCase(nil), c.Samples...), nil
+	}
+	return nil, nil
+}
+
+func (m *memStore) GetChallengeForEdit(id int) (*ChallengeDetail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.challenges {
+		if c.ID == id {
+			detail := c.ChallengeDetail
+			return &detail, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memStore) GetChallengeIDByName(name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c := m.challengeByName(name); c != nil {
+		return c.ID, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (m *memStore) SetChallengeVisibility(id int, public bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.challenges {
+		if m.challenges[i].ID == id {
+			m.challenges[i].IsPublic = public
+		}
+	}
+	return nil
+}
+
+func (m *memStore) CreateChallenge(userID int, name, description string, points int, publish bool, samples, hidden []TestCase) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.challengeByName(name) != nil {
+		return 0, errDuplicateChallenge
+	}
+	id := len(m.challenges) + 1
+	owner := userID
+	m.challenges = append(m.challenges, memChallenge{
+		ChallengeDetail: ChallengeDetail{ID: id, Name: name, Points: points, CreatedBy: &owner, IsPublic: publish},
+		Description:     description,
+		Samples:         samples,
+		Hidden:          hidden,
+	})
+	return id, nil
+}
+
+func (m *memStore) ImportChallenge(ownerID int, name, description string, points int, samples, hidden []TestCase) error {
+	if _, err := m.CreateChallenge(ownerID, name, description, points, false, samples, hidden); err != nil && !errors.Is(err, errDuplicateChallenge) {
+		return err
+	}
+	return nil
+}
+
+func (m *memStore) UpdateChallengeWithTests(name, description string, points int, sampleTests, judgeTests []TestCase) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.challengeByName(name)
+	if c == nil {
+		return sql.ErrNoRows
+	}
+	c.Description, c.Points, c.Samples = description, points, sampleTests
+	if judgeTests != nil {
+		c.Hidden = judgeTests
+	}
+	return nil
+}
+
+func (m *memStore) CreateUser(username, password string, isWriter bool) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			return nil, ErrUserExists
+		}
+	}
+	u := User{ID: len(m.users) + 1, Username: username, Password: password, IsWriter: isWriter}
+	m.users = append(m.users, u)
+	return &u, nil
+}
+
+func (m *memStore) GetUserByUsername(username string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memStore) GetUserByID(userID int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.userByID(userID); u != nil {
+		user := *u
+		return &user, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memStore) GetAllUsersForAdmin() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]User(nil), m.users...), nil
+}
+
+func (m *memStore) GetUsersPaginated(page, perPage int) ([]User, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start := (page - 1) * perPage
+	if start > len(m.users) {
+		start = len(m.users)
+	}
+	end := start + perPage
+	if end > len(m.users) {
+		end = len(m.users)
+	}
+	return append([]User(nil), m.users[start:end]...), len(m.users), nil
+}
+
+func (m *memStore) SearchUsers(query string, limit int) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []User
+	for _, u := range m.users {
+		if len(out) < limit && strings.Contains(strings.ToLower(u.Username), strings.ToLower(query)) {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) SetUserWriterFlag(userID int, isWriter bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.userByID(userID); u != nil {
+		u.IsWriter = isWriter
+	}
+	return nil
+}
+
+func (m *memStore) CreateSubmission(ctx context.Context, sub Submission) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub.ID = len(m.submissions) + 1
+	m.submissions = append(m.submissions, sub)
+	return sub.ID, nil
+}
+
+func (m *memStore) CountPendingSubmissions() (int, error) {
+	pending, _, err := m.CountQueuedSubmissions()
+	return pending, err
+}
+
+func (m *memStore) CountQueuedSubmissions() (pending, running int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sub := range m.submissions {
+		switch sub.Result {
+		case "Pending":
+			pending++
+		case "Running":
+			running++
+		}
+	}
+	return pending, running, nil
+}
+
+// ClaimNextPending hands out submissions oldest first; the fair-share order
+// is the SQL store's business.
+func (m *memStore) ClaimNextPending() (pendingJob, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.submissions {
+		sub := &m.submissions[i]
+		if sub.Result == "Pending" {
+			sub.Result = "Running"
+			return pendingJob{ID: sub.ID, UserID: sub.UserID, Challenge: sub.Challenge, Language: sub.Language, Code: sub.Code, CreatedAt: sub.CreatedAt, RequestID: sub.RequestID, TraceParent: sub.TraceParent}, true, nil
+		}
+	}
+	return pendingJob{}, false, nil
+}
+
+func (m *memStore) RequeueSubmission(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sub := m.submission(id); sub != nil && sub.Result == "Running" {
+		sub.Result = "Pending"
+	}
+	return nil
+}
+
+func (m *memStore) UpdateSubmissionAfterRun(ctx context.Context, id int, result string, durationMs, failIdx int, lastOut, expect string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sub := m.submission(id); sub != nil {
+		sub.Result, sub.DurationMs, sub.FailCaseIdx, sub.LastOutput, sub.ExpectedOut = result, durationMs, failIdx, lastOut, expect
+	}
+	return nil
+}
+
+func (m *memStore) submission(id int) *Submission {
+	if id < 1 || id > len(m.submissions) {
+		return nil
+	}
+	return &m.submissions[id-1]
+}
+
+func (m *memStore) EnsureSolve(userID int, challenge string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.solves[userID] == nil {
+		m.solves[userID] = map[string]time.Time{}
+	}
+	if _, ok := m.solves[userID][challenge]; !ok {
+		m.solves[userID][challenge] = at
+	}
+	return nil
+}
+
+func (m *memStore) GetScoreboard() ([]ScoreEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []ScoreEntry
+	for _, u := range m.users {
+		entry := ScoreEntry{Username: u.Username}
+		for name := range m.solves[u.ID] {
+			if c := m.challengeByName(name); c != nil {
+				entry.Total += c.Points
+				entry.Solves++
+			}
+		}
+		out = append(out, entry)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Total > out[j].Total })
+	return out, nil
+}
+
+func (m *memStore) GetSubmissionsByUser(userID int) ([]Submission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Submission
+	for _, sub := range m.submissions {
+		if sub.UserID == userID {
+			out = append(out, sub)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) GetSubmissionsByChallenge(challenge string) ([]ChallengeSubmission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []ChallengeSubmission
+	for _, sub := range m.submissions {
+		if sub.Challenge != challenge {
+			continue
+		}
+		var username string
+		if u := m.userByID(sub.UserID); u != nil {
+			username = u.Username
+		}
+		out = append(out, ChallengeSubmission{ID: sub.ID, Username: username, Language: sub.Language, Result: sub.Result, CreatedAt: sub.CreatedAt.Format(time.RFC1123)})
+	}
+	return out, nil
+}
+
+func (m *memStore) GetSubmissionDetail(subID int) (SubmissionDetail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub := m.submission(subID)
+	if sub == nil {
+		return SubmissionDetail{}, sql.ErrNoRows
+	}
+	detail := SubmissionDetail{
+		ID: sub.ID, Challenge: sub.Challenge, Language: sub.Language, Code: sub.Code, Result: sub.Result,
+		CreatedAt: sub.CreatedAt.Format(time.RFC1123), DurationMs: sub.DurationMs, FailedCase: sub.FailCaseIdx,
+		Got: sub.LastOutput, Want: sub.ExpectedOut,
+	}
+	if u := m.userByID(sub.UserID); u != nil {
+		detail.Username = u.Username
+	}
+	if c := m.challengeByName(sub.Challenge); c != nil {
+		detail.ChallengeID = c.ID
+	}
+	return detail, nil
+}
+
+func (m *memStore) GetSubmissionStatusByID(subID int) (Submission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub := m.submission(subID)
+	if sub == nil {
+		return Submission{}, sql.ErrNoRows
+	}
+	out := *sub
+	if c := m.challengeByName(sub.Challenge); c != nil {
+		out.ChallengeID = c.ID
+	}
+	return out, nil
+}
+
+func (m *memStore) ListLiveRunners(stale time.Duration) ([]runnerclient.Endpoint, error) {
+	return nil, nil
+}
+
+func (m *memStore) Ping(ctx context.Context) error { return m.pingErr }
+
+func (m *memStore) Close() error { return nil }
+
+// fakeRunner answers every run with Accepted and describes challenges from
+// the store it is given.
+type fakeRunner struct {
+	store   *memStore
+	runs    []RunnerRequest
+	pingErr error
+	mu      sync.Mutex
+}
+
+var _ RunnerClient = (*fakeRunner)(nil)
+
+func (f *fakeRunner) Run(ctx context.Context, req RunnerRequest) (RunnerResponse, error) {
+	f.mu.Lock()
+	f.runs = append(f.runs, req)
+	f.mu.Unlock()
+	return RunnerResponse{Result: "Accepted"}, nil
+}
+
+func (f *fakeRunner) RunJob(ctx context.Context, req RunnerRequest, webhookURL string, poll time.Duration) (RunnerResponse, error) {
+	return f.Run(ctx, req)
+}
+
+func (f *fakeRunner) ChallengeMeta(ctx context.Context, name string) (ChallengeMeta, error) {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+	c := f.store.challengeByName(name)
+	if c == nil {
+		return ChallengeMeta{}, errors.New("challenge not found")
+	}
+	return ChallengeMeta{Description: c.Description}, nil
+}
+
+func (f *fakeRunner) ParseWebhook(r *http.Request) (runnerclient.JobStatus, error) {
+	return runnerclient.JobStatus{}, errors.New("webhooks are not supported")
+}
+
+func (f *fakeRunner) NotifyJob(id string) bool { return false }
+
+func (f *fakeRunner) SetEndpoints(list []runnerclient.Endpoint) {}
+
+func (f *fakeRunner) Ping(ctx context.Context) error { return f.pingErr }
+
+func (f *fakeRunner) Close() {}
@@ -2,23 +2,22 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"goexe/internal/logging"
 )
 
 type challengeTestYAML struct {
@@ -26,55 +25,6 @@ type challengeTestYAML struct {
 	Output string `yaml:"output"`
 }
 
-const sampleResultCacheTTL = 30 * time.Second
-
-type cachedSampleResult struct {
-	Result     string
-	DurationMs int
-	FailIdx    int
-	Output     string
-	Expect     string
-	CachedAt   time.Time
-}
-
-var (
-	sampleResultCache   = make(map[string]cachedSampleResult)
-	sampleResultCacheMu sync.Mutex
-)
-
-func makeSampleResultCacheKey(userID, challengeID int, language, code string) string {
-	hashed := sha256.Sum256([]byte(code))
-	return fmt.Sprintf("%d:%d:%s:%s", userID, challengeID, language, hex.EncodeToString(hashed[:]))
-}
-
-func lookupSampleResultCache(key string) (cachedSampleResult, bool) {
-	now := time.Now()
-	sampleResultCacheMu.Lock()
-	defer sampleResultCacheMu.Unlock()
-	entry, ok := sampleResultCache[key]
-	if !ok {
-		return cachedSampleResult{}, false
-	}
-	if now.Sub(entry.CachedAt) > sampleResultCacheTTL {
-		delete(sampleResultCache, key)
-		return cachedSampleResult{}, false
-	}
-	return entry, true
-}
-
-func storeSampleResultCache(key string, entry cachedSampleResult) {
-	now := time.Now()
-	sampleResultCacheMu.Lock()
-	defer sampleResultCacheMu.Unlock()
-	for k, v := range sampleResultCache {
-		if now.Sub(v.CachedAt) > sampleResultCacheTTL {
-			delete(sampleResultCache, k)
-		}
-	}
-	entry.CachedAt = now
-	sampleResultCache[key] = entry
-}
-
 // parseChallengeTestsYAML converts YAML textarea input into a cleaned list of test specs
 func parseChallengeTestsYAML(src string) ([]challengeTestYAML, error) {
 	src = strings.TrimSpace(src)
@@ -127,8 +77,8 @@ var (
 	errHiddenCaseInsert   = errors.New("hidden case insert failed")
 )
 
-func challengeExists(name string) (int, bool, error) {
-	id, err := getChallengeIDByName(name)
+func (s *server) challengeExists(name string) (int, bool, error) {
+	id, err := s.store.GetChallengeIDByName(name)
 	if err == nil {
 		return id, true, nil
 	}
@@ -157,39 +107,16 @@ func sanitizeChallengeTests(tests []challengeTestYAML) []challengeTestYAML {
 	return cleaned
 }
 
-func createChallengeRecord(userID int, name, description string, points int, publish bool, samples, hidden []challengeTestYAML) (int, error) {
-	tx, err := db.Begin()
-	if err != nil {
-		return 0, err
-	}
-	defer tx.Rollback()
-
-	var newChallengeID int
-	if err := tx.QueryRow(
-		`INSERT INTO challenges(name, description, created_by, input, output, points, is_public) VALUES($1,$2,$3,'','',$4,$5) RETURNING id`,
-		name, description, userID, points, publish,
-	).Scan(&newChallengeID); err != nil {
-		if strings.Contains(err.Error(), "duplicate") {
-			return 0, errDuplicateChallenge
-		}
-		return 0, err
-	}
-
-	for i, t := range samples {
-		if _, err := tx.Exec(`INSERT INTO sample_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, name, i, t.Input, t.Output); err != nil {
-			return 0, fmt.Errorf("%w: %w", errSampleCaseInsert, err)
-		}
-	}
-	for i, t := range hidden {
-		if _, err := tx.Exec(`INSERT INTO judge_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, name, i, t.Input, t.Output); err != nil {
-			return 0, fmt.Errorf("%w: %w", errHiddenCaseInsert, err)
-		}
-	}
+func (s *server) createChallengeRecord(userID int, name, description string, points int, publish bool, samples, hidden []challengeTestYAML) (int, error) {
+	return s.store.CreateChallenge(userID, name, description, points, publish, testCasesFromYAML(samples), testCasesFromYAML(hidden))
+}
 
-	if err := tx.Commit(); err != nil {
-		return 0, err
+func testCasesFromYAML(tests []challengeTestYAML) []TestCase {
+	out := make([]TestCase, 0, len(tests))
+	for i, t := range tests {
+		out = append(out, TestCase{Input: t.Input, Output: t.Output, Index: i})
 	}
-	return newChallengeID, nil
+	return out
 }
 
 type apiChallengeRequest struct {
@@ -209,20 +136,20 @@ type apiChallengeResponse struct {
 	Status      string `json:"status"`
 }
 
-func getBasePageData(r *http.Request) BasePageData {
-	return newBasePageData(getUser(r))
+func (s *server) getBasePageData(r *http.Request) BasePageData {
+	return newBasePageData(s.getUser(r))
 }
 
-func renderNotFound(w http.ResponseWriter, r *http.Request, base BasePageData) {
+func (s *server) renderNotFound(w http.ResponseWriter, r *http.Request, base BasePageData) {
 	w.WriteHeader(http.StatusNotFound)
-	if err := templates.ExecuteTemplate(w, "404.html", struct {
+	if err := s.templates.ExecuteTemplate(w, "404.html", struct {
 		BasePageData
 		Path string
 	}{
 		BasePageData: base,
 		Path:         r.URL.Path,
 	}); err != nil {
-		log.Printf("renderNotFound failed: %v", err)
+		slog.ErrorContext(r.Context(), "render not found page failed", "err", err)
 		http.Error(w, "Not Found", http.StatusNotFound)
 	}
 }
@@ -241,11 +168,11 @@ func summarizeDescription(raw string) string {
 	return preview
 }
 
-func populateChallengePreviews(chals []ChallengeSummary) {
+func (s *server) populateChallengePreviews(ctx context.Context, chals []ChallengeSummary) {
 	for i := range chals {
-		meta, err := fetchChallengeMeta(chals[i].Name)
+		meta, err := s.fetchChallengeMeta(ctx, chals[i].Name)
 		if err != nil {
-			log.Printf("Failed to fetch preview for %s: %v", chals[i].Name, err)
+			slog.WarnContext(ctx, "failed to fetch challenge preview", "challenge", chals[i].Name, "err", err)
 			continue
 		}
 		summary := summarizeDescription(meta.Description)
@@ -268,16 +195,16 @@ func splitTestsBySample(tests []TestCase) (samples []TestCase, hidden []TestCase
 }
 
 // registerHandler handles user registration
-func registerHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) registerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		templates.ExecuteTemplate(w, "register.html", getBasePageData(r))
+		s.templates.ExecuteTemplate(w, "register.html", s.getBasePageData(r))
 		return
 	}
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 	wantWriter := r.FormValue("is_writer") == "on"
 	// Insert user into DB
-	user, err := createUser(username, password, wantWriter)
+	user, err := s.store.CreateUser(username, password, wantWriter)
 	if err != nil {
 		if errors.Is(err, ErrUserExists) {
 			http.Error(w, "User already exists", http.StatusConflict)
@@ -286,8 +213,8 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Registration failed", http.StatusInternalServerError)
 		return
 	}
-	if err := setSession(w, user.Username); err != nil {
-		log.Printf("failed to set session after registration: %v", err)
+	if err := s.sessions.set(w, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set session after registration", "err", err)
 		http.Error(w, "Registration failed", http.StatusInternalServerError)
 		return
 	}
@@ -295,22 +222,22 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // adminHandler shows the YAML upload form for admins
-func adminHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil || !user.IsAdmin {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 	if r.Method == http.MethodGet {
-		templates.ExecuteTemplate(w, "admin.html", newBasePageData(user))
+		s.templates.ExecuteTemplate(w, "admin.html", newBasePageData(user))
 		return
 	}
 	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 }
 
 // adminUploadHandler processes uploaded YAML to add challenges (insert-only)
-func adminUploadHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) adminUploadHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil || !user.IsAdmin {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -357,40 +284,30 @@ func adminUploadHandler(w http.ResponseWriter, r *http.Request) {
 		if desc == "" {
 			desc = strings.TrimSpace(p.LegacyStatement)
 		}
-		if _, err := db.Exec(`INSERT INTO challenges(name, description, created_by, input, output, points, is_public) VALUES($1,$2,$3,'','',$4,FALSE) ON CONFLICT(name) DO NOTHING;`, p.Name, desc, user.ID, pts); err != nil {
-			log.Printf("Failed to insert challenge %s: %v", p.Name, err)
-		}
-		// Insert tests (append-only)
-		if len(p.Tests) > 0 {
-			sampleIdx := 0
-			hiddenIdx := 0
-			for _, t := range p.Tests {
-				if t.IsSample {
-					if _, err := db.Exec(`INSERT INTO sample_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, p.Name, sampleIdx, t.Input, t.Output); err != nil {
-						log.Printf("Failed to insert sample case for %s: %v", p.Name, err)
-					}
-					sampleIdx++
-				} else {
-					if _, err := db.Exec(`INSERT INTO judge_cases(challenge, idx, input, output) VALUES($1,$2,$3,$4)`, p.Name, hiddenIdx, t.Input, t.Output); err != nil {
-						log.Printf("Failed to insert judge case for %s: %v", p.Name, err)
-					}
-					hiddenIdx++
-				}
-			}
-		} else if p.Input != "" || p.Output != "" {
-			if _, err := db.Exec(`INSERT INTO sample_cases(challenge, idx, input, output) VALUES($1,0,$2,$3)`, p.Name, p.Input, p.Output); err != nil {
-				log.Printf("Failed to insert fallback sample case for %s: %v", p.Name, err)
-			}
-			if _, err := db.Exec(`INSERT INTO judge_cases(challenge, idx, input, output) VALUES($1,0,$2,$3)`, p.Name, p.Input, p.Output); err != nil {
-				log.Printf("Failed to insert fallback judge case for %s: %v", p.Name, err)
+		// Split tests into samples and hidden cases; a problem without tests
+		// uses its input/output pair as both
+		var samples, hidden []TestCase
+		for _, t := range p.Tests {
+			if t.IsSample {
+				samples = append(samples, TestCase{Input: t.Input, Output: t.Output})
+			} else {
+				hidden = append(hidden, TestCase{Input: t.Input, Output: t.Output})
 			}
 		}
+		if len(p.Tests) == 0 && (p.Input != "" || p.Output != "") {
+			samples = []TestCase{{Input: p.Input, Output: p.Output}}
+			hidden = samples
+		}
+		if err := s.store.ImportChallenge(user.ID, p.Name, desc, pts, samples, hidden); err != nil {
+			slog.ErrorContext(r.Context(), "failed to import challenge", "challenge", p.Name, "err", err)
+		}
+		s.invalidateSampleResults(p.Name)
 	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) adminDebugHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil || !user.IsAdmin {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -414,7 +331,7 @@ func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case http.MethodGet:
-		templates.ExecuteTemplate(w, "admin_debug.html", data)
+		s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 		return
 	case http.MethodPost:
 		lang := strings.TrimSpace(r.FormValue("language"))
@@ -431,7 +348,7 @@ func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
 		data.Input = input
 		if strings.TrimSpace(code) == "" {
 			data.Error = "Code is required."
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 		maxBytes := 131072
@@ -442,23 +359,23 @@ func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		if len([]byte(code)) > maxBytes {
 			data.Error = "Code size limit exceeded."
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 		if adminPassword == "" {
 			data.Error = "Admin password is required."
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 		if adminPassword != user.Password {
 			data.Error = "Admin password is incorrect."
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 		proof, err := powProofFromForm(r)
 		if err != nil {
 			data.Error = "Proof-of-Work is required."
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 
@@ -470,7 +387,7 @@ func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
 			sandbox = "nsjail_only"
 		default:
 			data.Error = "Unsupported sandbox mode."
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 
@@ -478,24 +395,24 @@ func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
 		if mode == "nsjail" {
 			challengeName = "admin_debug_nsjail"
 		}
-		if err := powMgr.Verify(user.ID, challengeName, proof, powPurposeAdmin); err != nil {
+		if err := s.pow.Verify(user.ID, challengeName, proof, powPurposeAdmin); err != nil {
 			_, msg := powErrorToHTTP(err)
 			if msg == "" {
 				msg = "Proof-of-Work validation failed."
 			}
 			data.Error = msg
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 
-		res, err := executeDebugRun(lang, code, input, sandbox)
+		res, err := s.executeDebugRun(r.Context(), lang, code, input, sandbox)
 		if err != nil {
 			data.Error = fmt.Sprintf("Runner request failed: %v", err)
-			templates.ExecuteTemplate(w, "admin_debug.html", data)
+			s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 			return
 		}
 		data.Result = &res
-		templates.ExecuteTemplate(w, "admin_debug.html", data)
+		s.templates.ExecuteTemplate(w, "admin_debug.html", data)
 		return
 	default:
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -504,8 +421,8 @@ func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // writerDashboardHandler shows entry points for writers to add challenges
-func writerDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) writerDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -519,12 +436,12 @@ func writerDashboardHandler(w http.ResponseWriter, r *http.Request) {
 	}{
 		BasePageData: newBasePageData(user),
 	}
-	templates.ExecuteTemplate(w, "writer_dashboard.html", data)
+	s.templates.ExecuteTemplate(w, "writer_dashboard.html", data)
 }
 
 // writerNewChallengeHandler renders and processes challenge creation for writers
-func writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -560,7 +477,7 @@ func writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 	if r.Method != http.MethodPost {
@@ -586,19 +503,19 @@ func writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
 
 	if form.Name == "" {
 		data.Error = "Please enter a challenge name."
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 	if form.Description == "" {
 		data.Error = "Please provide a challenge description."
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 
 	points := 100
 	if p, err := strconv.Atoi(form.Points); err != nil || p <= 0 {
 		data.Error = "Points must be a positive integer."
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	} else {
 		points = p
@@ -607,47 +524,47 @@ func writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	samples, err := parseChallengeTestsYAML(form.SampleYAML)
 	if err != nil {
 		data.Error = "Failed to parse sample tests YAML: " + err.Error()
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 	if len(samples) == 0 {
 		data.Error = "Provide at least one sample test case."
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 	samples = sanitizeChallengeTests(samples)
 	if len(samples) == 0 {
 		data.Error = "Provide at least one sample test case."
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 
 	hidden, err := parseChallengeTestsYAML(form.HiddenYAML)
 	if err != nil {
 		data.Error = "Failed to parse hidden tests YAML: " + err.Error()
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 	hidden = sanitizeChallengeTests(hidden)
 
-	if existingID, exists, err := challengeExists(form.Name); err != nil {
+	if existingID, exists, err := s.challengeExists(form.Name); err != nil {
 		data.Error = "Failed to verify existing challenges."
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	} else if exists {
 		data.Error = "A challenge with that name already exists."
 		data.CreatedID = existingID
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 
 	publishNow := form.PublishNow
-	newChallengeID, err := createChallengeRecord(user.ID, form.Name, form.Description, points, publishNow, samples, hidden)
+	newChallengeID, err := s.createChallengeRecord(user.ID, form.Name, form.Description, points, publishNow, samples, hidden)
 	if err != nil {
 		switch {
 		case errors.Is(err, errDuplicateChallenge):
 			data.Error = "A challenge with that name already exists."
-			if existingID, exists, lookupErr := challengeExists(form.Name); lookupErr == nil && exists {
+			if existingID, exists, lookupErr := s.challengeExists(form.Name); lookupErr == nil && exists {
 				data.CreatedID = existingID
 			}
 		case errors.Is(err, errSampleCaseInsert):
@@ -657,11 +574,11 @@ func writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		default:
 			data.Error = "Failed to finalize the challenge."
 		}
-		templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+		s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 		return
 	}
 
-	log.Printf("Writer %s created challenge %s", user.Username, form.Name)
+	slog.InfoContext(r.Context(), "challenge created", "writer", user.Username, "challenge", form.Name)
 
 	data.Success = true
 	data.CreatedName = form.Name
@@ -691,13 +608,13 @@ func writerNewChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
+	s.templates.ExecuteTemplate(w, "writer_new_challenge.html", data)
 	return
 }
 
 // apiChallengeHandler provides JSON endpoints to query and create challenges.
-func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -710,9 +627,9 @@ func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, http.StatusBadRequest, "name is required")
 			return
 		}
-		id, exists, err := challengeExists(name)
+		id, exists, err := s.challengeExists(name)
 		if err != nil {
-			log.Printf("apiChallengeHandler lookup failed: %v", err)
+			slog.ErrorContext(r.Context(), "challenge lookup failed", "err", err)
 			writeJSONError(w, http.StatusInternalServerError, "failed to lookup challenge")
 			return
 		}
@@ -720,9 +637,9 @@ func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, http.StatusNotFound, "challenge not found")
 			return
 		}
-		detail, err := getChallengeForEdit(id)
+		detail, err := s.store.GetChallengeForEdit(id)
 		if err != nil {
-			log.Printf("apiChallengeHandler detail failed: %v", err)
+			slog.ErrorContext(r.Context(), "challenge detail failed", "err", err)
 			writeJSONError(w, http.StatusInternalServerError, "failed to load challenge")
 			return
 		}
@@ -768,8 +685,8 @@ func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, http.StatusBadRequest, "sample_tests must contain at least one case")
 			return
 		}
-		if existingID, exists, err := challengeExists(req.Name); err != nil {
-			log.Printf("apiChallengeHandler duplicate lookup failed: %v", err)
+		if existingID, exists, err := s.challengeExists(req.Name); err != nil {
+			slog.ErrorContext(r.Context(), "challenge duplicate lookup failed", "err", err)
 			writeJSONError(w, http.StatusInternalServerError, "failed to verify existing challenge")
 			return
 		} else if exists {
@@ -783,13 +700,13 @@ func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		newID, err := createChallengeRecord(user.ID, req.Name, req.Description, points, req.IsPublic, samples, hidden)
+		newID, err := s.createChallengeRecord(user.ID, req.Name, req.Description, points, req.IsPublic, samples, hidden)
 		if err != nil {
 			switch {
 			case errors.Is(err, errDuplicateChallenge):
-				existingID, exists, lookupErr := challengeExists(req.Name)
+				existingID, exists, lookupErr := s.challengeExists(req.Name)
 				if lookupErr != nil {
-					log.Printf("apiChallengeHandler duplicate resolution failed: %v", lookupErr)
+					slog.ErrorContext(r.Context(), "challenge duplicate resolution failed", "err", lookupErr)
 					writeJSONError(w, http.StatusInternalServerError, "failed to resolve duplicate challenge")
 					return
 				}
@@ -812,13 +729,13 @@ func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
 				writeJSONError(w, http.StatusInternalServerError, "failed to add hidden test case")
 				return
 			default:
-				log.Printf("apiChallengeHandler create failed: %v", err)
+				slog.ErrorContext(r.Context(), "challenge create failed", "err", err)
 				writeJSONError(w, http.StatusInternalServerError, "failed to create challenge")
 				return
 			}
 		}
 
-		log.Printf("Writer %s created challenge %s via API", user.Username, req.Name)
+		slog.InfoContext(r.Context(), "challenge created", "writer", user.Username, "challenge", req.Name, "via", "api")
 		resp := apiChallengeResponse{
 			ChallengeID: newID,
 			Name:        req.Name,
@@ -838,8 +755,8 @@ func apiChallengeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // adminUsersHandler lists users for admin overview
-func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil || !user.IsAdmin {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -848,7 +765,7 @@ func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	users, err := getAllUsersForAdmin()
+	users, err := s.store.GetAllUsersForAdmin()
 	if err != nil {
 		http.Error(w, "Failed to load users", http.StatusInternalServerError)
 		return
@@ -860,12 +777,12 @@ func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
 		BasePageData: newBasePageData(user),
 		Users:        users,
 	}
-	templates.ExecuteTemplate(w, "admin_users.html", data)
+	s.templates.ExecuteTemplate(w, "admin_users.html", data)
 }
 
 // adminUserDetailHandler shows and updates a specific user's flags
-func adminUserDetailHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) adminUserDetailHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil || !user.IsAdmin {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -873,18 +790,18 @@ func adminUserDetailHandler(w http.ResponseWriter, r *http.Request) {
 	base := newBasePageData(user)
 	idStr := strings.TrimPrefix(r.URL.Path, "/admin/users/")
 	if idStr == "" {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	userID, err := strconv.Atoi(idStr)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 
-	target, err := getUserByID(userID)
+	target, err := s.store.GetUserByID(userID)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 
@@ -900,19 +817,19 @@ func adminUserDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		templates.ExecuteTemplate(w, "admin_user_detail.html", data)
+		s.templates.ExecuteTemplate(w, "admin_user_detail.html", data)
 		return
 	case http.MethodPost:
 		wantWriter := r.FormValue("is_writer") == "on"
-		if err := setUserWriterFlag(userID, wantWriter); err != nil {
+		if err := s.store.SetUserWriterFlag(userID, wantWriter); err != nil {
 			data.Error = "Failed to update permissions."
-			templates.ExecuteTemplate(w, "admin_user_detail.html", data)
+			s.templates.ExecuteTemplate(w, "admin_user_detail.html", data)
 			return
 		}
 		target.IsWriter = wantWriter
 		data.Target = *target
 		data.Success = true
-		templates.ExecuteTemplate(w, "admin_user_detail.html", data)
+		s.templates.ExecuteTemplate(w, "admin_user_detail.html", data)
 		return
 	default:
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -921,9 +838,9 @@ func adminUserDetailHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // scoreboardHandler displays the aggregated scoreboard
-func scoreboardHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
-	entries, err := getScoreboard()
+func (s *server) scoreboardHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
+	entries, err := s.store.GetScoreboard()
 	if err != nil {
 		http.Error(w, "Failed to load scoreboard", http.StatusInternalServerError)
 		return
@@ -935,25 +852,25 @@ func scoreboardHandler(w http.ResponseWriter, r *http.Request) {
 		BasePageData: newBasePageData(user),
 		Entries:      entries,
 	}
-	templates.ExecuteTemplate(w, "scoreboard.html", data)
+	s.templates.ExecuteTemplate(w, "scoreboard.html", data)
 }
 
 // loginHandler handles user login
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		templates.ExecuteTemplate(w, "login.html", getBasePageData(r))
+		s.templates.ExecuteTemplate(w, "login.html", s.getBasePageData(r))
 		return
 	}
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 	// Fetch user from DB
-	user, err := getUserByUsername(username)
+	user, err := s.store.GetUserByUsername(username)
 	if err != nil || user.Password != password {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
-	if err := setSession(w, user.Username); err != nil {
-		log.Printf("failed to set session after login: %v", err)
+	if err := s.sessions.set(w, user.Username); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set session after login", "err", err)
 		http.Error(w, "Login failed", http.StatusInternalServerError)
 		return
 	}
@@ -961,14 +878,14 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // logoutHandler logs out the current user
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	clearSession(w)
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	s.sessions.clear(w)
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
 
 // indexHandler displays the list of challenges
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) indexHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user != nil && user.IsWriter && !user.IsAdmin {
 		http.Redirect(w, r, "/writer", http.StatusFound)
 		return
@@ -991,9 +908,9 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	)
 	var totalPages int
 	if isSearching {
-		challenges, err = searchChallenges(query, 60)
+		challenges, err = s.store.SearchChallenges(query, 60)
 		if err != nil {
-			log.Printf("Failed to search challenges: %v", err)
+			slog.ErrorContext(r.Context(), "failed to search challenges", "err", err)
 			http.Error(w, "Failed to search challenges", http.StatusInternalServerError)
 			return
 		}
@@ -1001,9 +918,9 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		totalPages = 1
 		page = 1
 	} else {
-		challenges, total, err = getChallengeSummaries(page, perPage)
+		challenges, total, err = s.store.GetChallengeSummaries(page, perPage)
 		if err != nil {
-			log.Printf("Failed to load challenges: %v", err)
+			slog.ErrorContext(r.Context(), "failed to load challenges", "err", err)
 			http.Error(w, "Failed to load challenges", http.StatusInternalServerError)
 			return
 		}
@@ -1022,7 +939,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if len(challenges) > 0 {
-		populateChallengePreviews(challenges)
+		s.populateChallengePreviews(r.Context(), challenges)
 	}
 	start := 0
 	end := 0
@@ -1097,12 +1014,12 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		Query:        query,
 		SearchTotal:  len(challenges),
 	}
-	templates.ExecuteTemplate(w, "index.html", data)
+	s.templates.ExecuteTemplate(w, "index.html", data)
 }
 
 // usersHandler shows the community directory with optional search
-func usersHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) usersHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	base := newBasePageData(user)
 
 	page := 1
@@ -1122,9 +1039,9 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 	)
 	var totalPages int
 	if isSearching {
-		users, err = searchUsers(query, 100)
+		users, err = s.store.SearchUsers(query, 100)
 		if err != nil {
-			log.Printf("Failed to search users: %v", err)
+			slog.ErrorContext(r.Context(), "failed to search users", "err", err)
 			http.Error(w, "Failed to search users", http.StatusInternalServerError)
 			return
 		}
@@ -1132,9 +1049,9 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 		totalPages = 1
 		page = 1
 	} else {
-		users, total, err = getUsersPaginated(page, perPage)
+		users, total, err = s.store.GetUsersPaginated(page, perPage)
 		if err != nil {
-			log.Printf("Failed to load users: %v", err)
+			slog.ErrorContext(r.Context(), "failed to load users", "err", err)
 			http.Error(w, "Failed to load users", http.StatusInternalServerError)
 			return
 		}
@@ -1229,12 +1146,12 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 		SearchTotal:  len(users),
 	}
 
-	templates.ExecuteTemplate(w, "users.html", data)
+	s.templates.ExecuteTemplate(w, "users.html", data)
 }
 
 // challengeHandler renders and manages challenge view/edit flows
-func challengeHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) challengeHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -1243,18 +1160,18 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/challenges/")
 	path = strings.Trim(path, "/")
 	if path == "" {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	parts := strings.Split(path, "/")
 	if len(parts) == 0 {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	idStr := parts[0]
 	chalID, err := strconv.Atoi(idStr)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	var action string
@@ -1262,24 +1179,24 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 		action = parts[1]
 	}
 	if len(parts) > 2 {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 
-	detail, err := getChallengeForEdit(chalID)
+	detail, err := s.store.GetChallengeForEdit(chalID)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	name := detail.Name
-	meta, err := fetchChallengeMeta(name)
+	meta, err := s.fetchChallengeMeta(r.Context(), name)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 
 	if action != "" && action != "update" && action != "publish" {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 
@@ -1287,7 +1204,7 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 	canEdit := user.IsAdmin || isOwner
 	canView := detail.IsPublic || canEdit
 	if !canView {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	canSubmit := !(user.IsWriter && !user.IsAdmin)
@@ -1302,18 +1219,12 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 
 	loadSubs := func() []submissionRow {
 		var (
-			records []struct {
-				ID        int
-				Username  string
-				Language  string
-				Result    string
-				CreatedAt string
-			}
-			err error
+			records []ChallengeSubmission
+			err     error
 		)
-		records, err = getSubmissionsByChallenge(name)
+		records, err = s.store.GetSubmissionsByChallenge(name)
 		if err != nil {
-			log.Printf("Failed to load submissions for challenge %s: %v", name, err)
+			slog.ErrorContext(r.Context(), "failed to load submissions", "challenge", name, "err", err)
 			return nil
 		}
 		out := make([]submissionRow, 0, len(records))
@@ -1329,9 +1240,9 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 		return out
 	}
 
-	sampleCases, err := getSampleCases(name)
+	sampleCases, err := s.store.GetSampleCases(name)
 	if err != nil {
-		log.Printf("Failed to load sample cases for %s: %v", name, err)
+		slog.ErrorContext(r.Context(), "failed to load sample cases", "challenge", name, "err", err)
 		sampleCases = nil
 	}
 	type challengeEditForm struct {
@@ -1391,7 +1302,7 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 			Error:        errMsg,
 			Success:      successMsg,
 		}
-		templates.ExecuteTemplate(w, "challenge.html", data)
+		s.templates.ExecuteTemplate(w, "challenge.html", data)
 	}
 
 	switch {
@@ -1458,11 +1369,12 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 				judgeTests[i] = TestCase{Input: t.Input, Output: t.Output, Index: i}
 			}
 		}
-		if err := updateChallengeWithTests(name, form.Description, points, sampleTests, judgeTests); err != nil {
-			log.Printf("Failed to update challenge %s: %v", name, err)
+		if err := s.store.UpdateChallengeWithTests(name, form.Description, points, sampleTests, judgeTests); err != nil {
+			slog.ErrorContext(r.Context(), "failed to update challenge", "challenge", name, "err", err)
 			render(form, "Failed to update the challenge.", "", sampleTests)
 			return
 		}
+		s.invalidateSampleResults(name)
 		http.Redirect(w, r, "/challenges/"+strconv.Itoa(detail.ID)+"?updated=1", http.StatusSeeOther)
 		return
 	case r.Method == http.MethodPost && action == "publish":
@@ -1474,8 +1386,8 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/challenges/"+strconv.Itoa(detail.ID), http.StatusSeeOther)
 			return
 		}
-		if err := setChallengeVisibility(detail.ID, true); err != nil {
-			log.Printf("Failed to publish challenge %s: %v", name, err)
+		if err := s.store.SetChallengeVisibility(detail.ID, true); err != nil {
+			slog.ErrorContext(r.Context(), "failed to publish challenge", "challenge", name, "err", err)
 			render(defaultForm, "Failed to publish the challenge.", "", sampleCases)
 			return
 		}
@@ -1489,8 +1401,8 @@ func challengeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // apiTestHandler runs sample tests without navigation and returns JSON
-func apiTestHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) apiTestHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -1503,13 +1415,16 @@ func apiTestHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !s.enforceRateLimit(w, r, user, rateClassTest, true) {
+		return
+	}
 	challengeIDStr := r.FormValue("challenge_id")
 	challengeID, err := strconv.Atoi(challengeIDStr)
 	if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	detail, err := getChallengeForEdit(challengeID)
+	detail, err := s.store.GetChallengeForEdit(challengeID)
 	if err != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
@@ -1550,7 +1465,7 @@ func apiTestHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]any{"error": msg})
 		return
 	}
-	if err := powMgr.Verify(user.ID, challenge, proof, powPurposeTest); err != nil {
+	if err := s.pow.Verify(user.ID, challenge, proof, powPurposeTest); err != nil {
 		status, msg := powErrorToHTTP(err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(status)
@@ -1559,9 +1474,9 @@ func apiTestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := time.Now().UnixNano()
-	result, durationMs, failIdx, got, want := executeSample(id, challenge, language, code)
+	result, durationMs, failIdx, got, want := s.executeSample(r.Context(), id, challenge, language, code)
 	cacheKey := makeSampleResultCacheKey(user.ID, challengeID, language, code)
-	storeSampleResultCache(cacheKey, cachedSampleResult{
+	s.storeSampleResult(cacheKey, challenge, cachedSampleResult{
 		Result:     result,
 		DurationMs: durationMs,
 		FailIdx:    failIdx,
@@ -1584,8 +1499,8 @@ func apiTestHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // submitHandler processes code submissions
-func submitHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) submitHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -1598,13 +1513,16 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
+	if !s.enforceRateLimit(w, r, user, rateClassSubmission, strings.Contains(r.Header.Get("Accept"), "application/json")) {
+		return
+	}
 	challengeIDStr := r.FormValue("challenge_id")
 	challengeID, err := strconv.Atoi(challengeIDStr)
 	if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	detail, err := getChallengeForEdit(challengeID)
+	detail, err := s.store.GetChallengeForEdit(challengeID)
 	if err != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
@@ -1639,7 +1557,7 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, msg, status)
 		return
 	}
-	if err := powMgr.Verify(user.ID, challenge, proof, powPurposeSubmission); err != nil {
+	if err := s.pow.Verify(user.ID, challenge, proof, powPurposeSubmission); err != nil {
 		status, msg := powErrorToHTTP(err)
 		http.Error(w, msg, status)
 		return
@@ -1658,10 +1576,12 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 		LastOutput:  "",
 		ExpectedOut: "",
 		CreatedAt:   time.Now(),
+		Priority:    submissionPriority(user, challenge),
+		RequestID:   logging.RequestID(r.Context()),
 	}
-	submissionID, err := createSubmission(sub)
+	submissionID, err := s.store.CreateSubmission(r.Context(), sub)
 	if err != nil {
-		log.Printf("Failed to enqueue submission: %v", err)
+		slog.ErrorContext(r.Context(), "failed to enqueue submission", "err", err)
 		http.Error(w, "Failed to enqueue", http.StatusInternalServerError)
 		return
 	}
@@ -1683,8 +1603,8 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // testHandler allows users to verify code against sample tests without recording
-func testHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) testHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -1697,13 +1617,16 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
+	if !s.enforceRateLimit(w, r, user, rateClassTest, false) {
+		return
+	}
 	challengeIDStr := r.FormValue("challenge_id")
 	challengeID, err := strconv.Atoi(challengeIDStr)
 	if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	detail, err := getChallengeForEdit(challengeID)
+	detail, err := s.store.GetChallengeForEdit(challengeID)
 	if err != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
@@ -1738,14 +1661,14 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, msg, status)
 		return
 	}
-	if err := powMgr.Verify(user.ID, challenge, proof, powPurposeTest); err != nil {
+	if err := s.pow.Verify(user.ID, challenge, proof, powPurposeTest); err != nil {
 		status, msg := powErrorToHTTP(err)
 		http.Error(w, msg, status)
 		return
 	}
 
 	cacheKey := makeSampleResultCacheKey(user.ID, challengeID, language, code)
-	if entry, ok := lookupSampleResultCache(cacheKey); ok {
+	if entry, ok := s.lookupSampleResult(cacheKey); ok {
 		data := struct {
 			Result      string
 			Challenge   string
@@ -1765,7 +1688,7 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 			Want:        entry.Expect,
 			DurationMs:  entry.DurationMs,
 		}
-		templates.ExecuteTemplate(w, "test.html", data)
+		s.templates.ExecuteTemplate(w, "test.html", data)
 		return
 	}
 
@@ -1773,7 +1696,7 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 	id := time.Now().UnixNano()
 
 	// For test, run only sample tests and show details
-	result, durationMs, failIdx, output, want := executeSample(id, challenge, language, code)
+	result, durationMs, failIdx, output, want := s.executeSample(r.Context(), id, challenge, language, code)
 	data := struct {
 		Result      string
 		Challenge   string
@@ -1793,25 +1716,25 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 		Want:        want,
 		DurationMs:  durationMs,
 	}
-	storeSampleResultCache(cacheKey, cachedSampleResult{
+	s.storeSampleResult(cacheKey, challenge, cachedSampleResult{
 		Result:     result,
 		DurationMs: durationMs,
 		FailIdx:    failIdx,
 		Output:     output,
 		Expect:     want,
 	})
-	templates.ExecuteTemplate(w, "test.html", data)
+	s.templates.ExecuteTemplate(w, "test.html", data)
 }
 
 // submissionsHandler shows past submissions for a user
-func submissionsHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) submissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 	// Load submissions from DB
-	subs, err := getSubmissionsByUser(user.ID)
+	subs, err := s.store.GetSubmissionsByUser(user.ID)
 	if err != nil {
 		http.Error(w, "Failed to load submissions", http.StatusInternalServerError)
 		return
@@ -1825,7 +1748,7 @@ func submissionsHandler(w http.ResponseWriter, r *http.Request) {
 		Code        template.HTML
 		CreatedAt   string
 	}
-	for _, s := range subs {
+	for _, sub := range subs {
 		userSubs = append(userSubs, struct {
 			ID          int
 			Challenge   string
@@ -1835,13 +1758,13 @@ func submissionsHandler(w http.ResponseWriter, r *http.Request) {
 			Code        template.HTML
 			CreatedAt   string
 		}{
-			ID:          s.ID,
-			Challenge:   s.Challenge,
-			ChallengeID: s.ChallengeID,
-			Language:    s.Language,
-			Result:      s.Result,
-			Code:        template.HTML(s.Code),
-			CreatedAt:   s.CreatedAt.Format(time.RFC1123),
+			ID:          sub.ID,
+			Challenge:   sub.Challenge,
+			ChallengeID: sub.ChallengeID,
+			Language:    sub.Language,
+			Result:      sub.Result,
+			Code:        template.HTML(sub.Code),
+			CreatedAt:   sub.CreatedAt.Format(time.RFC1123),
 		})
 	}
 	data := struct {
@@ -1859,12 +1782,12 @@ func submissionsHandler(w http.ResponseWriter, r *http.Request) {
 		BasePageData: newBasePageData(user),
 		Submissions:  userSubs,
 	}
-	templates.ExecuteTemplate(w, "submissions.html", data)
+	s.templates.ExecuteTemplate(w, "submissions.html", data)
 }
 
 // submissionDetailHandler shows details of a specific submission
-func submissionDetailHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
+func (s *server) submissionDetailHandler(w http.ResponseWriter, r *http.Request) {
+	user := s.getUser(r)
 	if user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -1874,13 +1797,13 @@ func submissionDetailHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Path[len("/submission/"):]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	// fetch submission detail
-	detail, err := getSubmissionDetail(id)
+	detail, err := s.store.GetSubmissionDetail(id)
 	if err != nil {
-		renderNotFound(w, r, base)
+		s.renderNotFound(w, r, base)
 		return
 	}
 	data := struct {
@@ -1912,7 +1835,7 @@ func submissionDetailHandler(w http.ResponseWriter, r *http.Request) {
 		Got:          detail.Got,
 		Want:         detail.Want,
 	}
-	templates.ExecuteTemplate(w, "submission.html", data)
+	s.templates.ExecuteTemplate(w, "submission.html", data)
 }
 
 const (
@@ -1961,7 +1884,7 @@ type apiAdminDebugRequest struct {
 	Pow           powProof `json:"pow"`
 }
 
-func resolveChallengeForUser(user *User, idPtr *int, name string) (*ChallengeDetail, int, string) {
+func (s *server) resolveChallengeForUser(user *User, idPtr *int, name string) (*ChallengeDetail, int, string) {
 	var challengeID int
 	if idPtr != nil {
 		challengeID = *idPtr
@@ -1970,23 +1893,23 @@ func resolveChallengeForUser(user *User, idPtr *int, name string) (*ChallengeDet
 		if name == "" {
 			return nil, http.StatusBadRequest, "challenge_id or challenge is required"
 		}
-		id, err := getChallengeIDByName(name)
+		id, err := s.store.GetChallengeIDByName(name)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, http.StatusNotFound, "challenge not found"
 			}
-			log.Printf("getChallengeIDByName failed: %v", err)
+			slog.Error("challenge id lookup failed", "challenge", name, "err", err)
 			return nil, http.StatusInternalServerError, "failed to resolve challenge"
 		}
 		challengeID = id
 	}
 
-	detail, err := getChallengeForEdit(challengeID)
+	detail, err := s.store.GetChallengeForEdit(challengeID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, http.StatusNotFound, "challenge not found"
 		}
-		log.Printf("getChallengeForEdit failed: %v", err)
+		slog.Error("challenge load failed", "challenge", name, "err", err)
 		return nil, http.StatusInternalServerError, "failed to load challenge"
 	}
 	isOwner := detail.CreatedBy != nil && *detail.CreatedBy == user.ID
@@ -1996,12 +1919,12 @@ func resolveChallengeForUser(user *User, idPtr *int, name string) (*ChallengeDet
 	return detail, http.StatusOK, ""
 }
 
-func apiPowChallengeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) apiPowChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	user := getUser(r)
+	user := s.getUser(r)
 	if user == nil {
 		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -2045,7 +1968,7 @@ func apiPowChallengeHandler(w http.ResponseWriter, r *http.Request) {
 			challengeName = "admin_debug_runner"
 		}
 	} else {
-		detail, status, message := resolveChallengeForUser(user, req.ChallengeID, req.Challenge)
+		detail, status, message := s.resolveChallengeForUser(user, req.ChallengeID, req.Challenge)
 		if status != http.StatusOK {
 			writeJSONError(w, status, message)
 			return
@@ -2054,9 +1977,9 @@ func apiPowChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		challengeName = detail.Name
 	}
 
-	challenge, err := powMgr.Issue(user.ID, challengeName, purpose)
+	challenge, err := s.pow.Issue(user.ID, challengeName, purpose)
 	if err != nil {
-		log.Printf("pow issue failed: %v", err)
+		slog.ErrorContext(r.Context(), "pow issue failed", "err", err)
 		writeJSONError(w, http.StatusInternalServerError, "failed to issue proof-of-work")
 		return
 	}
@@ -2075,12 +1998,12 @@ func apiPowChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, response)
 }
 
-func apiAdminDebugHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) apiAdminDebugHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	user := getUser(r)
+	user := s.getUser(r)
 	if user == nil {
 		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -2150,13 +2073,13 @@ func apiAdminDebugHandler(w http.ResponseWriter, r *http.Request) {
 		challengeName = "admin_debug_nsjail"
 	}
 
-	if err := powMgr.Verify(user.ID, challengeName, req.Pow, powPurposeAdmin); err != nil {
+	if err := s.pow.Verify(user.ID, challengeName, req.Pow, powPurposeAdmin); err != nil {
 		status, msg := powErrorToHTTP(err)
 		writeJSONError(w, status, msg)
 		return
 	}
 
-	res, err := executeDebugRun(normalized, code, req.Input, sandbox)
+	res, err := s.executeDebugRun(r.Context(), normalized, code, req.Input, sandbox)
 	if err != nil {
 		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("runner request failed: %v", err))
 		return
@@ -2167,12 +2090,12 @@ func apiAdminDebugHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // apiSubmissionCreateHandler enqueues a submission and optionally waits for completion.
-func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	user := getUser(r)
+	user := s.getUser(r)
 	if user == nil {
 		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -2182,6 +2105,10 @@ func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.enforceRateLimit(w, r, user, rateClassSubmission, true) {
+		return
+	}
+
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	var req apiSubmissionRequest
@@ -2190,7 +2117,7 @@ func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	detail, status, message := resolveChallengeForUser(user, req.ChallengeID, req.Challenge)
+	detail, status, message := s.resolveChallengeForUser(user, req.ChallengeID, req.Challenge)
 	if status != http.StatusOK {
 		writeJSONError(w, status, message)
 		return
@@ -2220,7 +2147,7 @@ func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := powMgr.Verify(user.ID, challengeName, req.Pow, powPurposeSubmission); err != nil {
+	if err := s.pow.Verify(user.ID, challengeName, req.Pow, powPurposeSubmission); err != nil {
 		status, msg := powErrorToHTTP(err)
 		writeJSONError(w, status, msg)
 		return
@@ -2239,10 +2166,12 @@ func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 		LastOutput:  "",
 		ExpectedOut: "",
 		CreatedAt:   now,
+		Priority:    submissionPriority(user, challengeName),
+		RequestID:   logging.RequestID(r.Context()),
 	}
-	subID, err := createSubmission(submission)
+	subID, err := s.store.CreateSubmission(r.Context(), submission)
 	if err != nil {
-		log.Printf("Failed to create submission: %v", err)
+		slog.ErrorContext(r.Context(), "failed to create submission", "err", err)
 		writeJSONError(w, http.StatusInternalServerError, "failed to create submission")
 		return
 	}
@@ -2278,13 +2207,13 @@ func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	statusCode := http.StatusAccepted
 	if shouldWait {
-		subStatus, completed, waitErr := waitForSubmissionResult(r.Context(), subID, apiSubmissionPollInterval, waitDuration)
+		subStatus, completed, waitErr := s.waitForSubmissionResult(r.Context(), subID, apiSubmissionPollInterval, waitDuration)
 		if waitErr != nil {
 			if errors.Is(waitErr, context.Canceled) || errors.Is(waitErr, context.DeadlineExceeded) {
 				writeJSONError(w, http.StatusRequestTimeout, "request canceled")
 				return
 			}
-			log.Printf("waitForSubmissionResult failed: %v", waitErr)
+			slog.ErrorContext(r.Context(), "waiting for submission result failed", "err", waitErr)
 			writeJSONError(w, http.StatusInternalServerError, "failed to retrieve submission result")
 			return
 		}
@@ -2301,12 +2230,12 @@ func apiSubmissionCreateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // apiSubmissionDetailHandler returns the status of a specific submission.
-func apiSubmissionDetailHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) apiSubmissionDetailHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	user := getUser(r)
+	user := s.getUser(r)
 	if user == nil {
 		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -2324,12 +2253,12 @@ func apiSubmissionDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	subStatus, err := getSubmissionStatusByID(subID)
+	subStatus, err := s.store.GetSubmissionStatusByID(subID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeJSONError(w, http.StatusNotFound, "submission not found")
 		} else {
-			log.Printf("getSubmissionStatusByID failed: %v", err)
+			slog.ErrorContext(r.Context(), "submission status lookup failed", "err", err)
 			writeJSONError(w, http.StatusInternalServerError, "failed to load submission")
 		}
 		return
@@ -2389,16 +2318,18 @@ func powErrorToHTTP(err error) (int, string) {
 		return http.StatusTooManyRequests, "proof-of-work replay detected"
 	case errors.Is(err, errPowConfiguration):
 		return http.StatusInternalServerError, "proof-of-work temporarily unavailable"
+	case errors.Is(err, errPowReplayStore):
+		return http.StatusServiceUnavailable, "proof-of-work temporarily unavailable"
 	default:
 		return http.StatusBadRequest, "invalid proof-of-work"
 	}
 }
 
-func waitForSubmissionResult(ctx context.Context, submissionID int, pollInterval, maxWait time.Duration) (Submission, bool, error) {
+func (s *server) waitForSubmissionResult(ctx context.Context, submissionID int, pollInterval, maxWait time.Duration) (Submission, bool, error) {
 	if pollInterval <= 0 {
 		pollInterval = apiSubmissionPollInterval
 	}
-	sub, err := getSubmissionStatusByID(submissionID)
+	sub, err := s.store.GetSubmissionStatusByID(submissionID)
 	if err != nil {
 		return sub, false, err
 	}
@@ -2419,7 +2350,7 @@ func waitForSubmissionResult(ctx context.Context, submissionID int, pollInterval
 			return sub, false, ctx.Err()
 		case <-ticker.C:
 		}
-		sub, err = getSubmissionStatusByID(submissionID)
+		sub, err = s.store.GetSubmissionStatusByID(submissionID)
 		if err != nil {
 			return sub, false, err
 		}
@@ -2458,7 +2389,7 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(payload); err != nil {
-		log.Printf("writeJSON encode failed: %v", err)
+		slog.Error("JSON response encode failed", "err", err)
 	}
 }
 
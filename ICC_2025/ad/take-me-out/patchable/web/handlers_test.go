@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*server, *memStore) {
+	t.Helper()
+	st := newMemStore()
+	return &server{
+		store:     st,
+		runner:    &fakeRunner{store: st},
+		templates: parseTemplates(),
+		sessions:  newSessionManager([]byte("test-session-secret"), false),
+		samples:   newMemorySampleCache(1024, 30*time.Second),
+	}, st
+}
+
+func addUser(t *testing.T, st *memStore, username string, admin, writer bool) *User {
+	t.Helper()
+	u, err := st.CreateUser(username, username+"-pw", writer)
+	if err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	if admin {
+		st.users[u.ID-1].IsAdmin = true
+		u.IsAdmin = true
+	}
+	return u
+}
+
+func addChallenge(t *testing.T, st *memStore, owner *User, name string, public bool) int {
+	t.Helper()
+	id, err := st.CreateChallenge(owner.ID, name, "Solve "+name, 100, public, []TestCase{{Input: "1", Output: "1"}}, nil)
+	if err != nil {
+		t.Fatalf("create challenge %s: %v", name, err)
+	}
+	return id
+}
+
+func sessionCookie(t *testing.T, s *server, username string) *http.Cookie {
+	t.Helper()
+	token, _, err := s.sessions.createToken(username)
+	if err != nil {
+		t.Fatalf("create session token: %v", err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: token}
+}
+
+// serve sends a request through the server's routes; a non-nil form is
+// posted, and user (if any) is logged in.
+func serve(t *testing.T, s *server, method, path string, form url.Values, user *User) *httptest.ResponseRecorder {
+	t.Helper()
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	if user != nil {
+		req.AddCookie(sessionCookie(t, s, user.Username))
+	}
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func responseCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func expectRedirect(t *testing.T, rec *httptest.ResponseRecorder, status int, location string) {
+	t.Helper()
+	if rec.Code != status || rec.Header().Get("Location") != location {
+		t.Fatalf("got %d to %q, want %d to %q", rec.Code, rec.Header().Get("Location"), status, location)
+	}
+}
+
+func TestRegisterSetsSession(t *testing.T) {
+	s, st := newTestServer(t)
+	rec := serve(t, s, http.MethodPost, "/register", url.Values{"username": {"alice"}, "password": {"pw"}}, nil)
+	expectRedirect(t, rec, http.StatusFound, "/")
+	cookie := responseCookie(rec, sessionCookieName)
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("register did not set a session cookie")
+	}
+	claims, err := s.sessions.parseToken(cookie.Value)
+	if err != nil || claims.Subject != "alice" {
+		t.Fatalf("session token: claims %+v, err %v", claims, err)
+	}
+	if u, err := st.GetUserByUsername("alice"); err != nil || u.IsWriter {
+		t.Fatalf("stored user %+v, err %v", u, err)
+	}
+
+	rec = serve(t, s, http.MethodPost, "/register", url.Values{"username": {"alice"}, "password": {"other"}}, nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate register: got %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s, st := newTestServer(t)
+	addUser(t, st, "alice", false, false)
+
+	rec := serve(t, s, http.MethodPost, "/login", url.Values{"username": {"alice"}, "password": {"wrong"}}, nil)
+	expectRedirect(t, rec, http.StatusFound, "/login")
+	if responseCookie(rec, sessionCookieName) != nil {
+		t.Fatal("failed login set a session cookie")
+	}
+
+	rec = serve(t, s, http.MethodPost, "/login", url.Values{"username": {"nobody"}, "password": {"nobody-pw"}}, nil)
+	expectRedirect(t, rec, http.StatusFound, "/login")
+
+	rec = serve(t, s, http.MethodPost, "/login", url.Values{"username": {"alice"}, "password": {"alice-pw"}}, nil)
+	expectRedirect(t, rec, http.StatusFound, "/")
+	cookie := responseCookie(rec, sessionCookieName)
+	if cookie == nil {
+		t.Fatal("login did not set a session cookie")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/submissions", nil)
+	req.AddCookie(cookie)
+	page := httptest.NewRecorder()
+	s.routes().ServeHTTP(page, req)
+	if page.Code != http.StatusOK {
+		t.Fatalf("submissions with login cookie: got %d, want 200", page.Code)
+	}
+}
+
+func TestProtectedPagesRedirectToLogin(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, path := range []string{"/submissions", "/challenges/1", "/submission/1", "/writer", "/writer/challenges/new"} {
+		rec := serve(t, s, http.MethodGet, path, nil, nil)
+		expectRedirect(t, rec, http.StatusFound, "/login")
+	}
+	rec := serve(t, s, http.MethodGet, "/api/submissions/1", nil, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("api without session: got %d, want 401", rec.Code)
+	}
+}
+
+func TestTamperedSessionIsRejected(t *testing.T) {
+	s, st := newTestServer(t)
+	addUser(t, st, "alice", false, false)
+	addUser(t, st, "admin", true, false)
+
+	// Swap the subject but keep alice's signature
+	parts := strings.Split(sessionCookie(t, s, "alice").Value, ".")
+	payload, err := json.Marshal(sessionClaims{Subject: "admin", IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	req := httptest.NewRequest(http.MethodGet, "/submissions", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: forged})
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	expectRedirect(t, rec, http.StatusFound, "/login")
+}
+
+func TestSessionForDeletedUserIsIgnored(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := serve(t, s, http.MethodGet, "/submissions", nil, &User{Username: "ghost"})
+	expectRedirect(t, rec, http.StatusFound, "/login")
+}
+
+func TestIndexListsOnlyPublicChallenges(t *testing.T) {
+	s, st := newTestServer(t)
+	writer := addUser(t, st, "writer", false, true)
+	addChallenge(t, st, writer, "public-sum", true)
+	addChallenge(t, st, writer, "secret-draft", false)
+
+	rec := serve(t, s, http.MethodGet, "/", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("index: got %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "public-sum") {
+		t.Error("index does not list the public challenge")
+	}
+	if strings.Contains(body, "secret-draft") {
+		t.Error("index lists a private challenge")
+	}
+
+	rec = serve(t, s, http.MethodGet, "/?q=secret", nil, nil)
+	if strings.Contains(rec.Body.String(), "secret-draft") {
+		t.Error("search finds a private challenge")
+	}
+}
+
+func TestPrivateChallengeVisibility(t *testing.T) {
+	s, st := newTestServer(t)
+	owner := addUser(t, st, "owner", false, true)
+	other := addUser(t, st, "other", false, false)
+	admin := addUser(t, st, "admin", true, false)
+	id := addChallenge(t, st, owner, "secret-draft", false)
+	path := "/challenges/" + strconv.Itoa(id)
+
+	if rec := serve(t, s, http.MethodGet, path, nil, other); rec.Code != http.StatusNotFound {
+		t.Fatalf("other user: got %d, want 404", rec.Code)
+	}
+	for _, u := range []*User{owner, admin} {
+		rec := serve(t, s, http.MethodGet, path, nil, u)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "secret-draft") {
+			t.Fatalf("%s: got %d, want the challenge page", u.Username, rec.Code)
+		}
+	}
+
+	// Only the owner or an admin may publish
+	if rec := serve(t, s, http.MethodPost, path+"/publish", url.Values{}, other); rec.Code != http.StatusNotFound {
+		t.Fatalf("publish by other user: got %d, want 404", rec.Code)
+	}
+	rec := serve(t, s, http.MethodPost, path+"/publish", url.Values{}, owner)
+	expectRedirect(t, rec, http.StatusSeeOther, path+"?published=1")
+	if rec := serve(t, s, http.MethodGet, path, nil, other); rec.Code != http.StatusOK {
+		t.Fatalf("other user after publish: got %d, want 200", rec.Code)
+	}
+}
+
+func TestPublicChallengeEditRequiresOwner(t *testing.T) {
+	s, st := newTestServer(t)
+	owner := addUser(t, st, "owner", false, true)
+	other := addUser(t, st, "other", false, false)
+	id := addChallenge(t, st, owner, "public-sum", true)
+
+	form := url.Values{"description": {"Changed"}, "points": {"5"}, "sample_tests": {"- input: \"2\"\n  output: \"2\""}}
+	rec := serve(t, s, http.MethodPost, "/challenges/"+strconv.Itoa(id)+"/update", form, other)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("update by other user: got %d, want 403", rec.Code)
+	}
+	if c := st.challengeByName("public-sum"); c.Description != "Solve public-sum" {
+		t.Fatalf("challenge changed by other user: %q", c.Description)
+	}
+
+	rec = serve(t, s, http.MethodPost, "/challenges/"+strconv.Itoa(id)+"/update", form, owner)
+	expectRedirect(t, rec, http.StatusSeeOther, "/challenges/"+strconv.Itoa(id)+"?updated=1")
+	if c := st.challengeByName("public-sum"); c.Description != "Changed" || c.Points != 5 {
+		t.Fatalf("challenge after update: %q, %d points", c.Description, c.Points)
+	}
+}
+
+func TestChallengeUpdateInvalidatesSampleResults(t *testing.T) {
+	s, st := newTestServer(t)
+	owner := addUser(t, st, "owner", false, true)
+	id := addChallenge(t, st, owner, "sum", false)
+	addChallenge(t, st, owner, "mul", false)
+	s.storeSampleResult("sum-key", "sum", cachedSampleResult{Result: "Accepted"})
+	s.storeSampleResult("mul-key", "mul", cachedSampleResult{Result: "Accepted"})
+
+	form := url.Values{"description": {"Changed"}, "points": {"5"}, "sample_tests": {"- input: \"2\"\n  output: \"2\""}}
+	rec := serve(t, s, http.MethodPost, "/challenges/"+strconv.Itoa(id)+"/update", form, owner)
+	expectRedirect(t, rec, http.StatusSeeOther, "/challenges/"+strconv.Itoa(id)+"?updated=1")
+	if _, ok := s.lookupSampleResult("sum-key"); ok {
+		t.Error("a cached sample verdict survived the update of its challenge")
+	}
+	if _, ok := s.lookupSampleResult("mul-key"); !ok {
+		t.Error("updating one challenge dropped another's cached verdicts")
+	}
+}
+
+func TestSubmissionOwnership(t *testing.T) {
+	s, st := newTestServer(t)
+	alice := addUser(t, st, "alice", false, false)
+	bob := addUser(t, st, "bob", false, false)
+	admin := addUser(t, st, "admin", true, false)
+	addChallenge(t, st, admin, "public-sum", true)
+	id, err := st.CreateSubmission(t.Context(), Submission{
+		UserID: alice.ID, Challenge: "public-sum", Language: "python", Code: "print('alice-code')",
+		Result: "Accepted", CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/submissions/" + strconv.Itoa(id)
+
+	if rec := serve(t, s, http.MethodGet, path, nil, bob); rec.Code != http.StatusForbidden {
+		t.Fatalf("other user: got %d, want 403", rec.Code)
+	}
+	for _, u := range []*User{alice, admin} {
+		rec := serve(t, s, http.MethodGet, path, nil, u)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d, want 200", u.Username, rec.Code)
+		}
+		var payload submissionStatusPayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.SubmissionID != id || payload.Result != "Accepted" {
+			t.Fatalf("%s: payload %+v", u.Username, payload)
+		}
+	}
+	if rec := serve(t, s, http.MethodGet, "/api/submissions/999", nil, alice); rec.Code != http.StatusNotFound {
+		t.Fatalf("missing submission: got %d, want 404", rec.Code)
+	}
+
+	// The submissions page lists only the user's own submissions
+	link := `href="/submission/` + strconv.Itoa(id) + `"`
+	rec := serve(t, s, http.MethodGet, "/submissions", nil, bob)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("submissions page: got %d, want 200", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), link) {
+		t.Fatal("submissions page lists another user's submission")
+	}
+	rec = serve(t, s, http.MethodGet, "/submissions", nil, alice)
+	if !strings.Contains(rec.Body.String(), link) {
+		t.Fatal("submissions page does not list the user's own submission")
+	}
+}
+
+func TestWriterPagesRequireWriter(t *testing.T) {
+	s, st := newTestServer(t)
+	player := addUser(t, st, "player", false, false)
+	writer := addUser(t, st, "writer", false, true)
+	admin := addUser(t, st, "admin", true, false)
+
+	for _, path := range []string{"/writer", "/writer/challenges/new"} {
+		if rec := serve(t, s, http.MethodGet, path, nil, player); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s as player: got %d, want 403", path, rec.Code)
+		}
+		for _, u := range []*User{writer, admin} {
+			if rec := serve(t, s, http.MethodGet, path, nil, u); rec.Code != http.StatusOK {
+				t.Fatalf("%s as %s: got %d, want 200", path, u.Username, rec.Code)
+			}
+		}
+	}
+
+	form := url.Values{"name": {"sneaky"}, "description": {"x"}, "sample_tests": {"- input: \"1\"\n  output: \"1\""}}
+	if rec := serve(t, s, http.MethodPost, "/writer/challenges/new", form, player); rec.Code != http.StatusForbidden {
+		t.Fatalf("create as player: got %d, want 403", rec.Code)
+	}
+	if st.challengeByName("sneaky") != nil {
+		t.Fatal("player created a challenge")
+	}
+
+	// Writers land on their dashboard rather than the challenge list
+	expectRedirect(t, serve(t, s, http.MethodGet, "/", nil, writer), http.StatusFound, "/writer")
+}
+
+func TestWriterCreatesChallenge(t *testing.T) {
+	s, st := newTestServer(t)
+	writer := addUser(t, st, "writer", false, true)
+	player := addUser(t, st, "player", false, false)
+
+	form := url.Values{
+		"name":         {"new-sum"},
+		"description":  {"Add two numbers"},
+		"points":       {"250"},
+		"sample_tests": {"- input: \"1 2\"\n  output: \"3\""},
+		"hidden_tests": {"- input: \"2 2\"\n  output: \"4\"\n- input: \"\"\n  output: \"\""},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/writer/challenges/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(sessionCookie(t, s, writer.Username))
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var created apiChallengeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "new-sum" || created.IsPublic || created.DetailURL != "/challenges/"+strconv.Itoa(created.ChallengeID) {
+		t.Fatalf("create response %+v", created)
+	}
+
+	c := st.challengeByName("new-sum")
+	if c == nil {
+		t.Fatal("challenge not stored")
+	}
+	if c.Points != 250 || c.CreatedBy == nil || *c.CreatedBy != writer.ID || c.IsPublic {
+		t.Fatalf("stored challenge %+v", c.ChallengeDetail)
+	}
+	if len(c.Samples) != 1 || len(c.Hidden) != 1 || c.Hidden[0].Output != "4" {
+		t.Fatalf("stored tests: samples %+v, hidden %+v", c.Samples, c.Hidden)
+	}
+
+	// Unpublished challenges stay hidden from players
+	if rec := serve(t, s, http.MethodGet, created.DetailURL, nil, player); rec.Code != http.StatusNotFound {
+		t.Fatalf("player before publish: got %d, want 404", rec.Code)
+	}
+
+	rec = serve(t, s, http.MethodPost, "/writer/challenges/new", form, writer)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "A challenge with that name already exists.") {
+		t.Fatalf("duplicate name: got %d without the duplicate error", rec.Code)
+	}
+	if len(st.challenges) != 1 {
+		t.Fatalf("duplicate created another challenge: %d stored", len(st.challenges))
+	}
+}
+
+func TestWriterFormValidation(t *testing.T) {
+	s, st := newTestServer(t)
+	writer := addUser(t, st, "writer", false, true)
+	cases := []struct {
+		form url.Values
+		want string
+	}{
+		{url.Values{"description": {"x"}}, "Please enter a challenge name."},
+		{url.Values{"name": {"a"}}, "Please provide a challenge description."},
+		{url.Values{"name": {"a"}, "description": {"x"}, "points": {"-1"}}, "Points must be a positive integer."},
+		{url.Values{"name": {"a"}, "description": {"x"}}, "Provide at least one sample test case."},
+		{url.Values{"name": {"a"}, "description": {"x"}, "sample_tests": {"{"}}, "Failed to parse sample tests YAML"},
+	}
+	for _, tc := range cases {
+		rec := serve(t, s, http.MethodPost, "/writer/challenges/new", tc.form, writer)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), tc.want) {
+			t.Errorf("form %v: got %d, want page with %q", tc.form, rec.Code, tc.want)
+		}
+	}
+	if len(st.challenges) != 0 {
+		t.Fatalf("invalid forms created %d challenges", len(st.challenges))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown is set on SIGTERM so load balancers stop routing here
+// before the listener closes.
+var shuttingDown atomic.Bool
+
+// healthReport is the JSON body of /healthz and /readyz. Checks only say
+// "ok" or "fail": the probes are unauthenticated, so the reasons go to the
+// log instead.
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthzHandler handles GET /healthz: the process is up and serving.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, healthReport{Status: "ok"})
+}
+
+// readyzHandler handles GET /readyz: the database answers, at least one
+// runner is reachable and the server is not shutting down.
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	checks := make(map[string]string)
+	ok := true
+	record := func(name string, err error) {
+		checks[name] = "ok"
+		if err != nil {
+			slog.WarnContext(r.Context(), "readiness check failed", "check", name, "err", err)
+			checks[name] = "fail"
+			ok = false
+		}
+	}
+	if shuttingDown.Load() {
+		checks["shutting_down"] = "fail"
+		ok = false
+	}
+	record("db", s.store.Ping(ctx))
+	record("runner", s.runner.Ping(ctx))
+	status, rep := http.StatusOK, healthReport{Status: "ok", Checks: checks}
+	if !ok {
+		status, rep.Status = http.StatusServiceUnavailable, "unavailable"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, rep)
+}
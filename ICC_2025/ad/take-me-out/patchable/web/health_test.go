@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		dbErr        error
+		runnerErr    error
+		shuttingDown bool
+		status       int
+		checks       map[string]string
+	}{
+		{"ready", nil, nil, false, http.StatusOK, map[string]string{"db": "ok", "runner": "ok"}},
+		{"db down", errors.New(`dial tcp 10.0.0.5:5432: connect: connection refused`), nil, false,
+			http.StatusServiceUnavailable, map[string]string{"db": "fail", "runner": "ok"}},
+		{"no runner", nil, errors.New("runnerclient: no runner endpoint http://runner:9000 reachable"), false,
+			http.StatusServiceUnavailable, map[string]string{"db": "ok", "runner": "fail"}},
+		{"shutting down", nil, nil, true,
+			http.StatusServiceUnavailable, map[string]string{"db": "ok", "runner": "ok", "shutting_down": "fail"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, st := newTestServer(t)
+			st.pingErr = tc.dbErr
+			s.runner.(*fakeRunner).pingErr = tc.runnerErr
+			shuttingDown.Store(tc.shuttingDown)
+			defer shuttingDown.Store(false)
+
+			rec := httptest.NewRecorder()
+			s.readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+			if rec.Code != tc.status {
+				t.Errorf("status %d, want %d", rec.Code, tc.status)
+			}
+			var rep healthReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+				t.Fatal(err)
+			}
+			if len(rep.Checks) != len(tc.checks) {
+				t.Errorf("checks %v, want %v", rep.Checks, tc.checks)
+			}
+			for name, want := range tc.checks {
+				if rep.Checks[name] != want {
+					t.Errorf("check %s = %q, want %q", name, rep.Checks[name], want)
+				}
+			}
+			// failure details stay out of the unauthenticated response
+			for _, leak := range []string{"10.0.0.5", "runner:9000", "refused"} {
+				if strings.Contains(rec.Body.String(), leak) {
+					t.Errorf("body leaks %q: %s", leak, rec.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// capture installs a logger writing to a buffer and restores the default
+// logger and clip limit afterwards.
+func capture(t *testing.T, service string) *bytes.Buffer {
+	t.Helper()
+	oldLogger, oldClip := slog.Default(), clipLimit
+	t.Cleanup(func() {
+		slog.SetDefault(oldLogger)
+		clipLimit = oldClip
+	})
+	var buf bytes.Buffer
+	SetupWriter(&buf, service)
+	return &buf
+}
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("not JSON: %q", line)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestSetupWriter(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_CLIP_BYTES", "")
+	buf := capture(t, "web")
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	slog.InfoContext(ctx, "dropped below the level")
+	slog.WarnContext(ctx, "kept", "k", "v")
+	slog.With("job", "j1").WithGroup("g").ErrorContext(ctx, "grouped", "x", 1)
+	slog.Warn("no request")
+
+	got := lines(t, buf)
+	if len(got) != 3 {
+		t.Fatalf("%d lines, want 3:\n%s", len(got), buf)
+	}
+	if got[0]["msg"] != "kept" || got[0]["service"] != "web" || got[0]["request_id"] != "req-1" || got[0]["k"] != "v" {
+		t.Errorf("line 1: %v", got[0])
+	}
+	// the request ID survives With and WithGroup, landing in the open group
+	if g, _ := got[1]["g"].(map[string]any); got[1]["job"] != "j1" || g["x"] != 1.0 || g["request_id"] != "req-1" {
+		t.Errorf("line 2: %v", got[1])
+	}
+	if _, ok := got[2]["request_id"]; ok {
+		t.Errorf("line 3 has a request ID: %v", got[2])
+	}
+}
+
+func TestLevelFromEnv(t *testing.T) {
+	for env, want := range map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"bogus":   slog.LevelInfo,
+		"DEBUG":   slog.LevelDebug,
+		" info ":  slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+	} {
+		t.Setenv("LOG_LEVEL", env)
+		if got := levelFromEnv(); got != want {
+			t.Errorf("LOG_LEVEL=%q: %v, want %v", env, got, want)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+	if WithRequestID(ctx, "") != ctx {
+		t.Error("an empty ID changed the context")
+	}
+	if got := RequestID(WithRequestID(ctx, "abc")); got != "abc" {
+		t.Errorf("RequestID = %q", got)
+	}
+	if RequestID(ctx) != "" || RequestID(nil) != "" {
+		t.Error("RequestID without an ID is not empty")
+	}
+	id := NewRequestID()
+	if len(id) != 32 || !ValidRequestID(id) || id == NewRequestID() {
+		t.Errorf("NewRequestID = %q", id)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"":                      false,
+		"abc-DEF_0.9":           true,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"a b":                   false,
+		"a\nb":                  false,
+		`a"b`:                   false,
+		"é":                     false,
+	} {
+		if got := ValidRequestID(id); got != want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	old := clipLimit
+	t.Cleanup(func() { clipLimit = old })
+	clipLimit = 4
+
+	if got := Clip("abcd"); got != "abcd" {
+		t.Errorf("Clip at the limit = %q", got)
+	}
+	if got := Clip("abcdefg"); got != "abcd...(3 bytes truncated)" {
+		t.Errorf("Clip = %q", got)
+	}
+	// "aé" is 3 bytes; a cut after 4 bytes of "aéé" would split the second é
+	got := Clip("aééx")
+	if !utf8.ValidString(got) || !strings.HasPrefix(got, "aé...") {
+		t.Errorf("Clip split a rune: %q", got)
+	}
+}
+
+func TestSetupWriterClipBytes(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_CLIP_BYTES", "3")
+	buf := capture(t, "")
+	slog.Info("out", Output("stdout", "hello"))
+	got := lines(t, buf)
+	if len(got) != 1 || got[0]["stdout"] != "hel...(2 bytes truncated)" {
+		t.Errorf("clipped output: %v", got)
+	}
+	if _, ok := got[0]["service"]; ok {
+		t.Errorf("empty service was logged: %v", got[0])
+	}
+}
+
+func TestCode(t *testing.T) {
+	attr := Code("print(1)")
+	group := attr.Value.Group()
+	if attr.Key != "code" || len(group) != 2 || group[0].Value.Int64() != 8 || len(group[1].Value.String()) != 16 {
+		t.Errorf("Code = %v", attr)
+	}
+	if Code("print(2)").Value.Group()[1].Value.String() == group[1].Value.String() {
+		t.Error("different code hashed the same")
+	}
+}
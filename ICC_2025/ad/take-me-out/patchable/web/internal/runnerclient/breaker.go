@@ -0,0 +1,109 @@
+package runnerclient
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// endpoint is one runner base URL with its circuit breaker state. The
+// circuit is closed while failures stay below the threshold, open (rejecting
+// traffic) until openUntil once they reach it, and then half-open: a single
+// trial request is let through, and its outcome closes or reopens the circuit.
+type endpoint struct {
+	base     string
+	inflight atomic.Int64
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+	capacity  int
+	load      int
+	languages []string
+}
+
+func (e *endpoint) allow(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(e.openUntil) || e.trial {
+		return false
+	}
+	e.trial = true
+	return true
+}
+
+func (e *endpoint) success() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = 0
+	e.openUntil = time.Time{}
+	e.trial = false
+}
+
+func (e *endpoint) failure(now time.Time, threshold int, openFor time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures++
+	if e.trial || e.failures >= threshold {
+		e.openUntil = now.Add(openFor)
+		e.trial = false
+	}
+}
+
+// release gives up a half-open trial that ended without telling us anything
+// about the runner, e.g. because the caller cancelled.
+func (e *endpoint) release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trial = false
+}
+
+func (e *endpoint) update(capacity, load int, languages []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.capacity = capacity
+	e.load = load
+	e.languages = languages
+}
+
+// saturate marks the endpoint as full until the next update.
+func (e *endpoint) saturate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.capacity > 0 {
+		e.load = e.capacity
+	} else {
+		e.load++
+	}
+}
+
+// score is the fraction of the endpoint's capacity in use, counting both the
+// load it last reported and the requests this client has in flight to it.
+func (e *endpoint) score() float64 {
+	e.mu.Lock()
+	capacity, load := e.capacity, e.load
+	e.mu.Unlock()
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return float64(load+int(e.inflight.Load())) / float64(capacity)
+}
+
+func (e *endpoint) supports(language string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if language == "" || len(e.languages) == 0 {
+		return true
+	}
+	for _, l := range e.languages {
+		if strings.EqualFold(l, language) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,618 @@
+// Package runnerclient talks to one or more sandbox runner instances over
+// HTTP. It sends each request to the least-loaded endpoint that supports the
+// language and retries requests that never reached a runner, and jobs a
+// runner rejected, on another endpoint with backoff. A per-endpoint circuit
+// breaker takes endpoints that keep failing out of rotation, and background
+// probes bring them back once healthy. Endpoints come from static
+// configuration or are replaced at runtime from a registry with
+// SetEndpoints.
+package runnerclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe/internal/logging"
+	"goexe/internal/tracing"
+)
+
+var tracer = otel.Tracer("goexe/internal/runnerclient")
+
+// DefaultEndpoint is used when no endpoint is configured.
+const DefaultEndpoint = "http://runner:9000"
+
+const maxResponseBytes = 16 << 20
+
+// ErrNoEndpoint is returned when every endpoint's circuit is open.
+var ErrNoEndpoint = errors.New("runnerclient: no runner endpoint available")
+
+// Request is sent to the runner's /run endpoint.
+type Request struct {
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+	Input     string `json:"input,omitempty"`
+	Want      string `json:"want,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	Sandbox   string `json:"sandbox,omitempty"`
+	// RequestID ties runner and sandbox logs to the web request. When empty
+	// it is filled from the context passed to Run or SubmitJob.
+	RequestID string `json:"request_id,omitempty"`
+	// TraceParent is the W3C trace context the runner's spans continue.
+	// When empty the runner uses the traceparent header that every call
+	// carries, so runner spans hang off the client span of that attempt.
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// Response is returned from the runner's /run endpoint.
+type Response struct {
+	Result      string `json:"result"`
+	Output      string `json:"output,omitempty"`
+	DurationMs  int    `json:"duration_ms,omitempty"`
+	FailedIndex int    `json:"failed_index,omitempty"`
+	Expected    string `json:"expected,omitempty"`
+}
+
+// ChallengeMeta is the runner's description and sample I/O for a challenge.
+type ChallengeMeta struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Samples     []struct {
+		Input  string `json:"input"`
+		Output string `json:"output"`
+	} `json:"samples"`
+}
+
+// StatusError reports a non-2xx reply from a runner. It is not retried: the
+// runner received the request, so repeating it could run the job twice. The
+// exception is a job the runner explicitly rejected before starting it
+// (Rejected is set from the X-Runner-Rejected header, e.g. "busy" or
+// "draining"); those are sent to another endpoint.
+//
+// ErrorCode and Message come from the runner's structured error body when it
+// sent one, e.g. "bad_signature" or "sandbox_mode_forbidden".
+type StatusError struct {
+	Endpoint  string
+	Code      int
+	Rejected  string
+	ErrorCode string
+	Message   string
+}
+
+func (e *StatusError) Error() string {
+	if e.Rejected != "" {
+		return fmt.Sprintf("runner %s rejected the job (%s)", e.Endpoint, e.Rejected)
+	}
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("runner %s returned %d: %s: %s", e.Endpoint, e.Code, e.ErrorCode, e.Message)
+	}
+	return fmt.Sprintf("runner %s returned %d", e.Endpoint, e.Code)
+}
+
+// statusError builds a StatusError from a non-2xx reply.
+func statusError(base string, resp *http.Response, rejected string) *StatusError {
+	se := &StatusError{Endpoint: base, Code: resp.StatusCode, Rejected: rejected}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body) == nil {
+		se.ErrorCode, se.Message = body.Error.Code, body.Error.Message
+	}
+	return se
+}
+
+// Endpoint describes a runner as advertised through the registry.
+type Endpoint struct {
+	URL       string
+	Capacity  int      // worker and queue slots; 0 when unknown
+	Load      int      // queued plus running jobs last reported by the runner
+	Languages []string // empty means every language
+}
+
+// IsUnavailable reports whether err means no runner took the job, as opposed
+// to a runner accepting it and failing. Such jobs can safely be retried later.
+// A request that was sent but timed out is not unavailable: the runner may
+// have started it.
+func IsUnavailable(err error) bool {
+	if errors.Is(err, ErrNoEndpoint) || errors.Is(err, ErrJobLost) || errors.Is(err, ErrJobCancelled) || isTransportError(err) {
+		return true
+	}
+	var se *StatusError
+	return errors.As(err, &se) && se.Rejected != ""
+}
+
+// Config controls endpoint selection, retries and circuit breaking.
+type Config struct {
+	Endpoints        []string
+	Timeout          time.Duration // per attempt, including the runner's reply
+	MaxRetries       int           // further attempts after one that never reached a runner
+	RetryBackoff     time.Duration // doubled after every attempt
+	FailureThreshold int           // consecutive failures before the circuit opens
+	OpenDuration     time.Duration // how long an open circuit rejects traffic
+	HealthInterval   time.Duration // 0 disables background probes
+	HealthPath       string
+
+	// Request signing; an empty Secret sends unsigned requests. Jobs that
+	// ask for a non-default sandbox mode are signed with the debug
+	// credentials when those are set, so the runner can tell them apart.
+	Caller      string
+	Secret      []byte
+	DebugCaller string
+	DebugSecret []byte
+}
+
+// ConfigFromEnv reads the client configuration:
+// RUNNER_ENDPOINTS (comma-separated base URLs, falls back to RUNNER_URL),
+// RUNNER_HTTP_TIMEOUT_MS, RUNNER_MAX_RETRIES, RUNNER_RETRY_BACKOFF_MS,
+// RUNNER_BREAKER_THRESHOLD, RUNNER_BREAKER_OPEN_MS,
+// RUNNER_HEALTH_INTERVAL_MS, RUNNER_HEALTH_PATH, and the signing credentials
+// RUNNER_AUTH_CALLER/RUNNER_AUTH_SECRET and
+// RUNNER_AUTH_DEBUG_CALLER/RUNNER_AUTH_DEBUG_SECRET.
+func ConfigFromEnv() Config {
+	raw := os.Getenv("RUNNER_ENDPOINTS")
+	if strings.TrimSpace(raw) == "" {
+		raw = os.Getenv("RUNNER_URL")
+	}
+	var endpoints []string
+	for _, ep := range strings.Split(raw, ",") {
+		if ep = strings.TrimRight(strings.TrimSpace(ep), "/"); ep != "" {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	if len(endpoints) == 0 {
+		endpoints = []string{DefaultEndpoint}
+	}
+	healthPath := strings.TrimSpace(os.Getenv("RUNNER_HEALTH_PATH"))
+	if healthPath == "" {
+		healthPath = "/healthz"
+	}
+	return Config{
+		Endpoints:        endpoints,
+		Timeout:          time.Duration(envInt("RUNNER_HTTP_TIMEOUT_MS", 40000, 1)) * time.Millisecond,
+		MaxRetries:       envInt("RUNNER_MAX_RETRIES", 2, 0),
+		RetryBackoff:     time.Duration(envInt("RUNNER_RETRY_BACKOFF_MS", 100, 0)) * time.Millisecond,
+		FailureThreshold: envInt("RUNNER_BREAKER_THRESHOLD", 5, 1),
+		OpenDuration:     time.Duration(envInt("RUNNER_BREAKER_OPEN_MS", 10000, 0)) * time.Millisecond,
+		HealthInterval:   time.Duration(envInt("RUNNER_HEALTH_INTERVAL_MS", 5000, 0)) * time.Millisecond,
+		HealthPath:       healthPath,
+		Caller:           getenv("RUNNER_AUTH_CALLER", "web"),
+		Secret:           []byte(strings.TrimSpace(os.Getenv("RUNNER_AUTH_SECRET"))),
+		DebugCaller:      getenv("RUNNER_AUTH_DEBUG_CALLER", "web-debug"),
+		DebugSecret:      []byte(strings.TrimSpace(os.Getenv("RUNNER_AUTH_DEBUG_SECRET"))),
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback, min int) int {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= min {
+			return n
+		}
+	}
+	return fallback
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	cfg      Config
+	http     *http.Client
+	next     atomic.Uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu        sync.RWMutex
+	endpoints []*endpoint
+
+	waiters jobWaiters
+}
+
+// New builds a client and starts background health probes when
+// cfg.HealthInterval is set. Call Close to stop them.
+func New(cfg Config) (*Client, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("runnerclient: no endpoints configured")
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 1
+	}
+	c := &Client{
+		cfg:  cfg,
+		http: &http.Client{},
+		stop: make(chan struct{}),
+	}
+	for _, raw := range cfg.Endpoints {
+		if err := validateEndpoint(raw); err != nil {
+			return nil, err
+		}
+		c.endpoints = append(c.endpoints, &endpoint{base: strings.TrimRight(raw, "/")})
+	}
+	if cfg.HealthInterval > 0 {
+		go c.healthLoop()
+	}
+	return c, nil
+}
+
+// Close stops background health probes.
+func (c *Client) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func validateEndpoint(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("runnerclient: invalid endpoint %q", raw)
+	}
+	return nil
+}
+
+// SetEndpoints replaces the endpoint set, typically with what runners
+// advertised in the registry. Breaker state is kept for runners that stay in
+// the set. An empty list restores the statically configured endpoints.
+func (c *Client) SetEndpoints(list []Endpoint) {
+	if len(list) == 0 {
+		list = make([]Endpoint, 0, len(c.cfg.Endpoints))
+		for _, raw := range c.cfg.Endpoints {
+			list = append(list, Endpoint{URL: raw})
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	known := make(map[string]*endpoint, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		known[ep.base] = ep
+	}
+	next := make([]*endpoint, 0, len(list))
+	for _, info := range list {
+		base := strings.TrimRight(strings.TrimSpace(info.URL), "/")
+		if validateEndpoint(base) != nil {
+			continue
+		}
+		ep, ok := known[base]
+		if !ok {
+			ep = &endpoint{base: base}
+		}
+		ep.update(info.Capacity, info.Load, info.Languages)
+		next = append(next, ep)
+	}
+	if len(next) > 0 {
+		c.endpoints = next
+	}
+}
+
+func (c *Client) snapshot() []*endpoint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*endpoint(nil), c.endpoints...)
+}
+
+// Run submits a job to a runner. FailedIndex is normalised to -1 unless the
+// verdict points at a specific test case.
+func (c *Client) Run(ctx context.Context, req Request) (Response, error) {
+	if req.RequestID == "" {
+		req.RequestID = logging.RequestID(ctx)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	creds := c.credentialsFor(req)
+	var resp Response
+	if _, err := c.do(ctx, http.MethodPost, "/run", req.Language, creds, body, &resp); err != nil {
+		return Response{}, err
+	}
+	normalize(&resp)
+	return resp, nil
+}
+
+// caseVerdicts are the results that point at a failing test case.
+var caseVerdicts = map[string]bool{
+	"Wrong Answer":            true,
+	"Runtime Error":           true,
+	"Time Limit Exceeded":     true,
+	"Memory Limit Exceeded":   true,
+	"Security Violation":      true,
+	"Idleness Limit Exceeded": true,
+	"Output Limit Exceeded":   true,
+}
+
+// TruncationMarker ends an output the runner cut at its output limit; it
+// matches the runner's sandbox.TruncationMarker.
+const TruncationMarker = "\n[output truncated]"
+
+// SplitTruncated removes TruncationMarker from the end of output and reports
+// whether it was there.
+func SplitTruncated(output string) (string, bool) {
+	if rest, ok := strings.CutSuffix(output, TruncationMarker); ok {
+		return rest, true
+	}
+	return output, false
+}
+
+// normalize resets FailedIndex unless the verdict points at a test case.
+func normalize(resp *Response) {
+	if !caseVerdicts[resp.Result] {
+		resp.FailedIndex = -1
+	}
+}
+
+// credentialsFor picks the signing credentials for a job request.
+func (c *Client) credentialsFor(req Request) credentials {
+	if mode := strings.TrimSpace(req.Sandbox); mode != "" && mode != "default" {
+		return c.credentials(true)
+	}
+	return c.credentials(false)
+}
+
+// ChallengeMeta fetches the description and samples for a challenge.
+func (c *Client) ChallengeMeta(ctx context.Context, name string) (ChallengeMeta, error) {
+	var meta ChallengeMeta
+	if _, err := c.do(ctx, http.MethodGet, "/challenge?name="+url.QueryEscape(name), "", c.credentials(false), nil, &meta); err != nil {
+		return ChallengeMeta{}, err
+	}
+	return meta, nil
+}
+
+// do sends the request to the least-loaded available endpoint. Requests that
+// failed before they were sent and rejected jobs are retried on another
+// endpoint with exponential backoff; once a request is sent, a timeout or
+// lost connection is returned as is, like HTTP status errors and decode
+// failures, since the runner may already be running the job. It returns the
+// endpoint that answered.
+func (c *Client) do(ctx context.Context, method, path, language string, creds credentials, body []byte, out any) (*endpoint, error) {
+	backoff := c.cfg.RetryBackoff
+	tried := make(map[*endpoint]bool)
+	var lastErr error
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 && backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+		}
+		ep := c.pick(time.Now(), language, tried)
+		if ep == nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, ErrNoEndpoint
+		}
+		tried[ep] = true
+		err := c.attempt(ctx, ep, method, path, creds, body, out)
+		if err == nil {
+			return ep, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !IsUnavailable(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *Client) attempt(ctx context.Context, ep *endpoint, method, path string, creds credentials, body []byte, out any) (err error) {
+	// each attempt is its own client span; the runner's spans hang off it
+	ctx, span := tracer.Start(ctx, "runner "+method+" "+path, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("runner.endpoint", ep.base)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	actx := ctx
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	var rdr io.Reader
+	if body != nil {
+		rdr = bytes.NewReader(body)
+	}
+	// sent is set once the whole request was written; a failure before that
+	// means the runner cannot have acted on it
+	var sent atomic.Bool
+	actx = httptrace.WithClientTrace(actx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				sent.Store(true)
+			}
+		},
+	})
+	req, err := http.NewRequestWithContext(actx, method, ep.base+path, rdr)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.HeaderRequestID, id)
+	}
+	tracing.Inject(ctx, req.Header)
+	nonce, err := creds.sign(req, body, time.Now())
+	if err != nil {
+		return err
+	}
+	ep.inflight.Add(1)
+	defer ep.inflight.Add(-1)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// a request cancelled by the caller says nothing about the runner
+		if ctx.Err() == nil {
+			ep.failure(time.Now(), c.cfg.FailureThreshold, c.cfg.OpenDuration)
+		} else {
+			ep.release()
+		}
+		if sent.Load() {
+			return fmt.Errorf("runnerclient: no reply from %s: %w", ep.base, err)
+		}
+		return &transportError{err: err}
+	}
+	defer resp.Body.Close()
+	if rejected := resp.Header.Get("X-Runner-Rejected"); rejected != "" && resp.StatusCode == http.StatusServiceUnavailable {
+		// the runner is alive but full or draining: steer traffic away
+		// from it until its next advertised load says otherwise
+		ep.saturate()
+		return statusError(ep.base, resp, rejected)
+	}
+	if resp.StatusCode >= 500 {
+		ep.failure(time.Now(), c.cfg.FailureThreshold, c.cfg.OpenDuration)
+	} else {
+		ep.success()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(ep.base, resp, "")
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if err := creds.verify(resp, nonce, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// pick returns the least-loaded endpoint that supports language and whose
+// circuit lets traffic through, preferring endpoints not yet tried for this
+// request. Ties rotate so equally loaded runners share the work.
+func (c *Client) pick(now time.Time, language string, tried map[*endpoint]bool) *endpoint {
+	eps := c.snapshot()
+	n := len(eps)
+	if n == 0 {
+		return nil
+	}
+	start := int(c.next.Add(1) % uint64(n))
+	candidates := make([]*endpoint, 0, n)
+	for i := 0; i < n; i++ {
+		ep := eps[(start+i)%n]
+		if ep.supports(language) {
+			candidates = append(candidates, ep)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score() < candidates[j].score()
+	})
+	for _, skipTried := range []bool{true, false} {
+		for _, ep := range candidates {
+			if skipTried && tried[ep] {
+				continue
+			}
+			if ep.allow(now) {
+				return ep
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) healthLoop() {
+	ticker := time.NewTicker(c.cfg.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			for _, ep := range c.snapshot() {
+				c.probe(ep)
+			}
+		}
+	}
+}
+
+// probe marks an endpoint healthy when it answers the health path with
+// anything below 500, and counts a failure otherwise.
+func (c *Client) probe(ep *endpoint) {
+	timeout := c.cfg.HealthInterval
+	if c.cfg.Timeout > 0 && c.cfg.Timeout < timeout {
+		timeout = c.cfg.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.base+c.cfg.HealthPath, nil)
+	if err != nil {
+		return
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		ep.failure(time.Now(), c.cfg.FailureThreshold, c.cfg.OpenDuration)
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		ep.failure(time.Now(), c.cfg.FailureThreshold, c.cfg.OpenDuration)
+		return
+	}
+	ep.success()
+}
+
+// Ping reports whether at least one runner answers its health path. It is
+// meant for readiness checks and does not touch breaker state.
+func (c *Client) Ping(ctx context.Context) error {
+	var lastErr error = ErrNoEndpoint
+	for _, ep := range c.snapshot() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.base+c.cfg.HealthPath, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode < 500 {
+			return nil
+		}
+		lastErr = fmt.Errorf("runnerclient: %s health check returned %d", ep.base, resp.StatusCode)
+	}
+	return lastErr
+}
+
+// transportError is a failure before the request was sent, e.g. a refused
+// connection. It is one of the failures IsUnavailable reports, and so one
+// that do retries on another runner; a request that reached a runner and
+// then failed is never sent again.
+type transportError struct{ err error }
+
+func (e *transportError) Error() string { return e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+func isTransportError(err error) bool {
+	var te *transportError
+	return errors.As(err, &te)
+}
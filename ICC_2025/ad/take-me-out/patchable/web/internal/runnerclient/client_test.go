@@ -0,0 +1,392 @@
+package runnerclient
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goexe/internal/logging"
+	"goexe/internal/tracing"
+)
+
+// testRunner is an httptest runner that counts the jobs it receives.
+type testRunner struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+func newTestRunner(t *testing.T, handler http.HandlerFunc) *testRunner {
+	t.Helper()
+	r := &testRunner{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.hits.Add(1)
+		handler(w, req)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func accepted(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(Response{Result: "Accepted"})
+}
+
+// refusedURL is an address nothing listens on.
+func refusedURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr
+}
+
+func newTestClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenDuration == 0 {
+		cfg.OpenDuration = time.Minute
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestRunPropagatesContext(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	headers := make(chan http.Header, 1)
+	runner := newTestRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		accepted(w, r)
+	})
+	c := newTestClient(t, Config{Endpoints: []string{runner.URL}})
+
+	ctx := logging.WithRequestID(tracing.WithTraceparent(context.Background(), parent), "req-1")
+	if _, err := c.Run(ctx, Request{Language: "python"}); err != nil {
+		t.Fatal(err)
+	}
+	h := <-headers
+	// with the no-op tracer provider the client span is the caller's span,
+	// so the runner continues the same trace from the same parent
+	if got := h.Get(tracing.HeaderTraceparent); got != parent {
+		t.Errorf("traceparent %q, want %q", got, parent)
+	}
+	if got := h.Get(logging.HeaderRequestID); got != "req-1" {
+		t.Errorf("request ID %q", got)
+	}
+
+	if _, err := c.Run(context.Background(), Request{Language: "python"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := (<-headers).Get(tracing.HeaderTraceparent); got != "" {
+		t.Errorf("traceparent %q sent without a trace", got)
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		first       http.HandlerFunc // nil: the first endpoint refuses connections
+		timeout     time.Duration
+		wantErr     bool
+		unavailable bool
+		firstHits   int32
+		secondHits  int32
+	}{
+		{
+			name:       "refused connection is retried",
+			secondHits: 1,
+		},
+		{
+			name: "rejected job is retried",
+			first: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Runner-Rejected", "busy")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			firstHits:  1,
+			secondHits: 1,
+		},
+		{
+			name: "server error is not retried",
+			first: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantErr:   true,
+			firstHits: 1,
+		},
+		{
+			name: "timeout after the request was sent is not retried",
+			first: func(w http.ResponseWriter, r *http.Request) {
+				// reading the body lets the server notice the client
+				// hanging up
+				io.ReadAll(r.Body)
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			timeout:   100 * time.Millisecond,
+			wantErr:   true,
+			firstHits: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			second := newTestRunner(t, accepted)
+			first := refusedURL(t)
+			var firstRunner *testRunner
+			if tc.first != nil {
+				firstRunner = newTestRunner(t, tc.first)
+				first = firstRunner.URL
+			}
+			c := newTestClient(t, Config{Endpoints: []string{first, second.URL}, MaxRetries: 2, Timeout: tc.timeout})
+			// make sure the first endpoint is tried first
+			c.endpoints[1].update(1, 1, nil)
+
+			resp, err := c.Run(context.Background(), Request{Language: "python"})
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Run = %+v, want an error", resp)
+				}
+				if IsUnavailable(err) != tc.unavailable {
+					t.Errorf("IsUnavailable(%v) = %v", err, !tc.unavailable)
+				}
+			} else if err != nil || resp.Result != "Accepted" {
+				t.Fatalf("Run = %+v, %v", resp, err)
+			}
+			if firstRunner != nil && firstRunner.hits.Load() != tc.firstHits {
+				t.Errorf("first runner got %d requests, want %d", firstRunner.hits.Load(), tc.firstHits)
+			}
+			if got := second.hits.Load(); got != tc.secondHits {
+				t.Errorf("second runner got %d requests, want %d", got, tc.secondHits)
+			}
+		})
+	}
+}
+
+func TestRunGivesUpAfterMaxRetries(t *testing.T) {
+	c := newTestClient(t, Config{Endpoints: []string{refusedURL(t), refusedURL(t)}, MaxRetries: 3})
+	_, err := c.Run(context.Background(), Request{})
+	if !isTransportError(err) || !IsUnavailable(err) {
+		t.Errorf("Run = %v, want an unavailable transport error", err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ep := &endpoint{base: "http://runner"}
+	for i := 0; i < 2; i++ {
+		if !ep.allow(now) {
+			t.Fatalf("closed circuit rejected request %d", i)
+		}
+		ep.failure(now, 3, time.Minute)
+	}
+	ep.success()
+	for i := 0; i < 3; i++ {
+		ep.failure(now, 3, time.Minute)
+	}
+	if ep.allow(now.Add(30 * time.Second)) {
+		t.Fatal("open circuit let a request through")
+	}
+
+	// half-open: one trial at a time
+	later := now.Add(2 * time.Minute)
+	if !ep.allow(later) {
+		t.Fatal("half-open circuit rejected the trial")
+	}
+	if ep.allow(later) {
+		t.Fatal("half-open circuit let a second request through")
+	}
+	// a failed trial reopens the circuit at once
+	ep.failure(later, 3, time.Minute)
+	if ep.allow(later.Add(time.Second)) {
+		t.Fatal("circuit did not reopen after a failed trial")
+	}
+	// a released trial lets the next one through
+	trial := later.Add(2 * time.Minute)
+	if !ep.allow(trial) {
+		t.Fatal("no trial after the circuit reopened")
+	}
+	ep.release()
+	if !ep.allow(trial) {
+		t.Fatal("released trial blocked the next one")
+	}
+	ep.success()
+	if !ep.allow(trial) || !ep.allow(trial) {
+		t.Fatal("circuit did not close after a successful trial")
+	}
+}
+
+func TestBreakerOpensOnServerErrors(t *testing.T) {
+	failing := newTestRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	c := newTestClient(t, Config{Endpoints: []string{failing.URL}, FailureThreshold: 2})
+	for i := 0; i < 2; i++ {
+		var se *StatusError
+		if _, err := c.Run(context.Background(), Request{}); !errors.As(err, &se) || se.Code != http.StatusBadGateway {
+			t.Fatalf("Run %d = %v", i, err)
+		}
+	}
+	if _, err := c.Run(context.Background(), Request{}); !errors.Is(err, ErrNoEndpoint) {
+		t.Fatalf("Run with an open circuit = %v, want %v", err, ErrNoEndpoint)
+	}
+	if n := failing.hits.Load(); n != 2 {
+		t.Errorf("runner got %d requests, want 2", n)
+	}
+}
+
+func TestPick(t *testing.T) {
+	c := newTestClient(t, Config{Endpoints: []string{"http://a", "http://b", "http://c"}})
+	c.SetEndpoints([]Endpoint{
+		{URL: "http://a", Capacity: 4, Load: 3},
+		{URL: "http://b", Capacity: 4, Load: 1, Languages: []string{"python"}},
+		{URL: "http://c", Capacity: 4, Load: 2},
+	})
+	now := time.Now()
+	for _, tc := range []struct {
+		language string
+		tried    []string
+		want     string
+	}{
+		{"python", nil, "http://b"},
+		{"Python", nil, "http://b"},
+		{"ruby", nil, "http://c"},
+		{"", nil, "http://b"},
+		{"python", []string{"http://b"}, "http://c"},
+		// every candidate tried: start over
+		{"python", []string{"http://a", "http://b", "http://c"}, "http://b"},
+	} {
+		tried := map[*endpoint]bool{}
+		for _, ep := range c.snapshot() {
+			for _, base := range tc.tried {
+				if ep.base == base {
+					tried[ep] = true
+				}
+			}
+		}
+		if got := c.pick(now, tc.language, tried); got == nil || got.base != tc.want {
+			t.Errorf("pick(%q, tried %v) = %v, want %s", tc.language, tc.tried, got, tc.want)
+		}
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	secret := []byte("runner-secret")
+	for _, tc := range []struct {
+		name   string
+		secret []byte // the runner's reply is signed with this
+		want   error
+	}{
+		{"valid reply", secret, nil},
+		{"forged reply", []byte("other"), ErrBadResponseSignature},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := newTestRunner(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				ts, nonce := r.Header.Get(headerTimestamp), r.Header.Get(headerNonce)
+				var tsVal int64
+				if err := json.Unmarshal([]byte(ts), &tsVal); err != nil {
+					t.Errorf("timestamp %q: %v", ts, err)
+				}
+				want := hex.EncodeToString(signRequest(secret, r.Method, r.URL.RequestURI(), tsVal, nonce, body))
+				if r.Header.Get(headerCaller) != "web" || r.Header.Get(headerSignature) != want {
+					t.Errorf("request signed by %q as %q, want %q", r.Header.Get(headerCaller), r.Header.Get(headerSignature), want)
+				}
+				reply, _ := json.Marshal(Response{Result: "Accepted"})
+				w.Header().Set(headerSignature, hex.EncodeToString(signResponse(tc.secret, nonce, http.StatusOK, reply)))
+				w.Write(reply)
+			})
+			c := newTestClient(t, Config{Endpoints: []string{runner.URL}, Caller: "web", Secret: secret})
+			if _, err := c.Run(context.Background(), Request{Language: "python"}); !errors.Is(err, tc.want) {
+				t.Errorf("Run = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestCredentialsFor(t *testing.T) {
+	c := newTestClient(t, Config{
+		Endpoints: []string{"http://a"},
+		Caller:    "web", Secret: []byte("s"),
+		DebugCaller: "web-debug", DebugSecret: []byte("d"),
+	})
+	for mode, want := range map[string]string{"": "web", "default": "web", "nsjail_only": "web-debug", "unprivileged": "web-debug"} {
+		if got := c.credentialsFor(Request{Sandbox: mode}).caller; got != want {
+			t.Errorf("sandbox %q signed as %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestNormalizeAndTruncation(t *testing.T) {
+	for result, want := range map[string]int{"Wrong Answer": 3, "Accepted": -1, "Compile Error": -1, "Output Limit Exceeded": 3} {
+		resp := Response{Result: result, FailedIndex: 3}
+		normalize(&resp)
+		if resp.FailedIndex != want {
+			t.Errorf("%s: FailedIndex = %d, want %d", result, resp.FailedIndex, want)
+		}
+	}
+	if out, ok := SplitTruncated("abc" + TruncationMarker); !ok || out != "abc" {
+		t.Errorf("SplitTruncated = %q, %v", out, ok)
+	}
+	if out, ok := SplitTruncated("abc"); ok || out != "abc" {
+		t.Errorf("SplitTruncated of a whole output = %q, %v", out, ok)
+	}
+}
+
+func TestSetEndpoints(t *testing.T) {
+	c := newTestClient(t, Config{Endpoints: []string{"http://static"}, FailureThreshold: 1})
+	c.SetEndpoints([]Endpoint{{URL: "http://a/"}, {URL: "not a url"}, {URL: "http://b", Capacity: 2, Load: 1}})
+	eps := c.snapshot()
+	if len(eps) != 2 || eps[0].base != "http://a" || eps[1].base != "http://b" {
+		t.Fatalf("endpoints = %v", eps)
+	}
+
+	// breaker state survives a refresh that keeps the runner
+	now := time.Now()
+	eps[0].failure(now, 1, time.Minute)
+	c.SetEndpoints([]Endpoint{{URL: "http://a"}, {URL: "http://b"}})
+	if c.snapshot()[0] != eps[0] || c.snapshot()[0].allow(now) {
+		t.Error("refresh reset the open circuit of a known runner")
+	}
+
+	// no registered runners: back to the static endpoints
+	c.SetEndpoints(nil)
+	if eps := c.snapshot(); len(eps) != 1 || eps[0].base != "http://static" {
+		t.Errorf("endpoints after an empty refresh = %v", eps)
+	}
+}
+
+func TestEndpointScore(t *testing.T) {
+	ep := &endpoint{base: "http://a"}
+	ep.update(4, 1, nil)
+	ep.inflight.Add(1)
+	if got := ep.score(); got != 0.5 {
+		t.Errorf("score = %v, want 0.5", got)
+	}
+	ep.saturate()
+	if got := ep.score(); got != 1.25 {
+		t.Errorf("score after saturate = %v, want 1.25", got)
+	}
+	unknown := &endpoint{base: "http://b"}
+	unknown.saturate()
+	if got := unknown.score(); got != 1 {
+		t.Errorf("score of a runner without capacity = %v, want 1", got)
+	}
+}
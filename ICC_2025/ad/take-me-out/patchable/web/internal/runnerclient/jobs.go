@@ -0,0 +1,236 @@
+package runnerclient
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"goexe/internal/logging"
+)
+
+// Job states reported by the runner.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+)
+
+var (
+	// ErrJobLost means the runner no longer knows the job, e.g. because it
+	// restarted. The job may be resubmitted.
+	ErrJobLost = errors.New("runnerclient: runner lost the job")
+	// ErrJobCancelled means the job was cancelled on the runner, e.g. by a
+	// runner shutting down past its drain deadline. The job may be
+	// resubmitted.
+	ErrJobCancelled = errors.New("runnerclient: job cancelled")
+	// ErrBadWebhookSignature means a webhook did not carry a valid signature.
+	ErrBadWebhookSignature = errors.New("runnerclient: webhook signature invalid")
+)
+
+// JobStatus is the runner's view of an asynchronous job. Result is set once
+// State is JobDone.
+type JobStatus struct {
+	ID            string    `json:"id"`
+	State         string    `json:"state"`
+	QueuePosition int       `json:"queue_position,omitempty"`
+	TestsDone     int       `json:"tests_done"`
+	TestsTotal    int       `json:"tests_total"`
+	Result        *Response `json:"result,omitempty"`
+}
+
+// Job is a handle to a job accepted by a specific runner.
+type Job struct {
+	ID       string
+	endpoint *endpoint
+	creds    credentials
+}
+
+type jobRequest struct {
+	Request
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// jobWaiters wakes RunJob callers when a webhook reports their job finished.
+type jobWaiters struct {
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+func (w *jobWaiters) add(id string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiting == nil {
+		w.waiting = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{}, 1)
+	w.waiting[id] = ch
+	return ch
+}
+
+func (w *jobWaiters) remove(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.waiting, id)
+}
+
+func (w *jobWaiters) notify(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch, ok := w.waiting[id]
+	if ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return ok
+}
+
+// SubmitJob queues req on the least-loaded runner and returns immediately.
+// When webhookURL is set the runner POSTs the final status there.
+func (c *Client) SubmitJob(ctx context.Context, req Request, webhookURL string) (*Job, JobStatus, error) {
+	if req.RequestID == "" {
+		req.RequestID = logging.RequestID(ctx)
+	}
+	body, err := json.Marshal(jobRequest{Request: req, WebhookURL: webhookURL})
+	if err != nil {
+		return nil, JobStatus{}, err
+	}
+	creds := c.credentialsFor(req)
+	var st JobStatus
+	ep, err := c.do(ctx, http.MethodPost, "/jobs", req.Language, creds, body, &st)
+	if err != nil {
+		return nil, JobStatus{}, err
+	}
+	return &Job{ID: st.ID, endpoint: ep, creds: creds}, st, nil
+}
+
+// JobStatus fetches the current status of job from the runner that owns it.
+func (c *Client) JobStatus(ctx context.Context, job *Job) (JobStatus, error) {
+	var st JobStatus
+	err := c.attempt(ctx, job.endpoint, http.MethodGet, "/jobs/"+job.ID, job.creds, nil, &st)
+	var se *StatusError
+	if errors.As(err, &se) && se.Code == http.StatusNotFound {
+		return JobStatus{}, ErrJobLost
+	}
+	if err != nil {
+		return JobStatus{}, err
+	}
+	if st.Result != nil {
+		normalize(st.Result)
+	}
+	return st, nil
+}
+
+// CancelJob asks the runner to cancel job, killing it if it already runs.
+func (c *Client) CancelJob(ctx context.Context, job *Job) error {
+	var st JobStatus
+	return c.attempt(ctx, job.endpoint, http.MethodDelete, "/jobs/"+job.ID, job.creds, nil, &st)
+}
+
+// RunJob submits req as an asynchronous job and waits for its result. It
+// polls the runner every poll interval, or sooner when NotifyJob reports the
+// job finished. Cancelling ctx cancels the job on the runner. Status polls
+// that keep failing, or a runner that lost the job, yield an error for
+// which IsUnavailable is true.
+func (c *Client) RunJob(ctx context.Context, req Request, webhookURL string, poll time.Duration) (Response, error) {
+	job, st, err := c.SubmitJob(ctx, req, webhookURL)
+	if err != nil {
+		return Response{}, err
+	}
+	wake := c.waiters.add(job.ID)
+	defer c.waiters.remove(job.ID)
+	failures := 0
+	for {
+		switch st.State {
+		case JobDone:
+			if st.Result == nil {
+				return Response{}, ErrJobLost
+			}
+			return *st.Result, nil
+		case JobCancelled:
+			return Response{}, ErrJobCancelled
+		}
+		select {
+		case <-ctx.Done():
+			cctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			c.CancelJob(cctx, job)
+			cancel()
+			return Response{}, ctx.Err()
+		case <-wake:
+		case <-time.After(poll):
+		}
+		next, err := c.JobStatus(ctx, job)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			if errors.Is(err, ErrJobLost) {
+				return Response{}, err
+			}
+			if failures++; failures >= 5 {
+				return Response{}, err
+			}
+			continue
+		}
+		failures = 0
+		st = next
+	}
+}
+
+// NotifyJob wakes a RunJob waiting for id; it reports whether one was.
+// Webhook payloads only trigger a status poll, so a forged webhook cannot
+// change a verdict.
+func (c *Client) NotifyJob(id string) bool {
+	return c.waiters.notify(id)
+}
+
+// ParseWebhook checks a webhook's signature (when a secret is configured)
+// and decodes its body.
+func (c *Client) ParseWebhook(r *http.Request) (JobStatus, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return JobStatus{}, err
+	}
+	if secret := c.webhookSecret(r.Header.Get(headerCaller)); len(secret) > 0 {
+		ts, err := strconv.ParseInt(r.Header.Get(headerTimestamp), 10, 64)
+		if err != nil || time.Since(time.Unix(ts, 0)).Abs() > 5*time.Minute {
+			return JobStatus{}, ErrBadWebhookSignature
+		}
+		got, err := hex.DecodeString(r.Header.Get(headerSignature))
+		sum := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, secret)
+		io.WriteString(mac, strconv.FormatInt(ts, 10)+"\n"+hex.EncodeToString(sum[:]))
+		if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+			return JobStatus{}, ErrBadWebhookSignature
+		}
+	}
+	var st JobStatus
+	if err := json.Unmarshal(body, &st); err != nil {
+		return JobStatus{}, err
+	}
+	return st, nil
+}
+
+// webhookSecret returns the secret the runner signs caller's webhooks with.
+// If any secret is configured, an unknown caller gets an unverifiable key.
+func (c *Client) webhookSecret(caller string) []byte {
+	switch {
+	case len(c.cfg.DebugSecret) > 0 && caller == c.cfg.DebugCaller:
+		return c.cfg.DebugSecret
+	case len(c.cfg.Secret) > 0 && caller == c.cfg.Caller:
+		return c.cfg.Secret
+	case len(c.cfg.Secret) > 0 || len(c.cfg.DebugSecret) > 0:
+		return []byte{0}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package runnerclient
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Signing headers shared with the runner. The request signature covers
+// method, path and query, timestamp, nonce and a hash of the body; the
+// runner's reply is signed over nonce, status and a hash of its body.
+const (
+	headerCaller    = "X-Runner-Caller"
+	headerTimestamp = "X-Runner-Timestamp"
+	headerNonce     = "X-Runner-Nonce"
+	headerSignature = "X-Runner-Signature"
+)
+
+// ErrBadResponseSignature means a runner's reply was not signed with the
+// caller's secret, so it cannot be trusted.
+var ErrBadResponseSignature = errors.New("runnerclient: runner response signature invalid")
+
+type credentials struct {
+	caller string
+	secret []byte
+}
+
+func (c *Client) credentials(debug bool) credentials {
+	if debug && len(c.cfg.DebugSecret) > 0 {
+		return credentials{caller: c.cfg.DebugCaller, secret: c.cfg.DebugSecret}
+	}
+	return credentials{caller: c.cfg.Caller, secret: c.cfg.Secret}
+}
+
+// sign adds the signing headers to req and returns the nonce it used.
+func (cr credentials) sign(req *http.Request, body []byte, now time.Time) (string, error) {
+	if len(cr.secret) == 0 {
+		return "", nil
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(raw)
+	ts := now.Unix()
+	req.Header.Set(headerCaller, cr.caller)
+	req.Header.Set(headerTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, hex.EncodeToString(signRequest(cr.secret, req.Method, req.URL.RequestURI(), ts, nonce, body)))
+	return nonce, nil
+}
+
+// verify checks the runner's response signature for a signed request.
+func (cr credentials) verify(resp *http.Response, nonce string, body []byte) error {
+	if len(cr.secret) == 0 {
+		return nil
+	}
+	got, err := hex.DecodeString(resp.Header.Get(headerSignature))
+	if err != nil || !hmac.Equal(got, signResponse(cr.secret, nonce, resp.StatusCode, body)) {
+		return ErrBadResponseSignature
+	}
+	return nil
+}
+
+func signRequest(secret []byte, method, uri string, ts int64, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+uri+"\n"+strconv.FormatInt(ts, 10)+"\n"+nonce+"\n"+hex.EncodeToString(sum[:]))
+	return mac.Sum(nil)
+}
+
+func signResponse(secret []byte, nonce string, status int, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, nonce+"\n"+strconv.Itoa(status)+"\n"+hex.EncodeToString(sum[:]))
+	return mac.Sum(nil)
+}
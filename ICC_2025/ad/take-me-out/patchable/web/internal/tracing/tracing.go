@@ -0,0 +1,97 @@
+// Package tracing sets up the OpenTelemetry SDK and moves W3C trace context
+// between processes, in HTTP headers or as a traceparent string in request
+// fields and command-line flags. Spans are created with the OpenTelemetry
+// API (otel.Tracer); without an exporter endpoint the global provider stays
+// a no-op, which still passes incoming trace context along.
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HeaderTraceparent is the W3C trace context header.
+const HeaderTraceparent = "traceparent"
+
+// propagator is used for every hop regardless of OTEL_PROPAGATORS: the
+// services only ever exchange W3C trace context.
+var propagator = propagation.TraceContext{}
+
+// Setup installs an SDK tracer provider exporting over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT is set;
+// the exporter reads the rest of its OTEL_EXPORTER_OTLP_* settings itself.
+// OTEL_SERVICE_NAME overrides service. The returned function flushes pending
+// spans and must be called before the process exits.
+func Setup(service string) func(context.Context) {
+	otel.SetTextMapPropagator(propagator)
+	if strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")) == "" &&
+		strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == "" {
+		return func(context.Context) {}
+	}
+	ctx := context.Background()
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		slog.Warn("tracing: exporter setup failed, tracing disabled", "err", err)
+		return func(context.Context) {}
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithFromEnv())
+	if err != nil {
+		slog.Warn("tracing: resource", "err", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) {
+		if err := tp.Shutdown(ctx); err != nil {
+			slog.Warn("tracing: shutdown", "err", err)
+		}
+	}
+}
+
+// RecordError marks span as failed with err. A nil err is ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Traceparent formats the current trace context of ctx, or returns "".
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(HeaderTraceparent)
+}
+
+// WithTraceparent returns ctx with the remote parent described by tp. A
+// malformed tp leaves ctx unchanged.
+func WithTraceparent(ctx context.Context, tp string) context.Context {
+	if tp = strings.TrimSpace(tp); tp == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{HeaderTraceparent: tp})
+}
+
+// Inject writes the trace context of ctx into h.
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Extract returns ctx with the remote parent carried by h, if any.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
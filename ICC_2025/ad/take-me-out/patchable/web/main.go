@@ -1,55 +1,106 @@
 package main
 
 import (
-	"html/template"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"goexe/internal/logging"
+	"goexe/internal/tracing"
 )
 
 func main() {
 	loadDotEnv()
-	if err := initAuth(); err != nil {
-		log.Fatal(err)
+	logging.Setup("web")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+	shutdownTracing := tracing.Setup("web")
+	sessions, err := newSessionManagerFromEnv()
+	if err != nil {
+		slog.Error("auth init failed", "err", err)
+		os.Exit(1)
 	}
-	initPowManager()
 	os.MkdirAll("sandbox", 0755)
-	templates = template.Must(template.New("").Option("missingkey=zero").Funcs(template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-	}).ParseGlob("templates/*.html"))
 	// Initialize DB and load challenge data
-	initDB()
+	conn := initDB()
+	autoMigrate()
+	st := newSQLStore(conn)
+	registerDBMetrics(conn)
+	registerQueueMetrics(st)
+	// background cleanups stop before the database is closed
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	// PoW replay protection is stored in the DB, so it comes after initDB
+	pow, err := newPowManagerFromEnv(bgCtx, conn, st.CountPendingSubmissions)
+	if err != nil {
+		slog.Error("pow manager init failed", "err", err)
+		os.Exit(1)
+	}
+	samples, err := newSampleCacheFromEnv(bgCtx, conn)
+	if err != nil {
+		slog.Error("sample cache init failed", "err", err)
+		os.Exit(1)
+	}
+	app := &server{
+		store:     st,
+		runner:    newRunnerClient(),
+		templates: parseTemplates(),
+		sessions:  sessions,
+		pow:       pow,
+		limiter:   newRequestRateLimiterFromEnv(),
+		samples:   samples,
+	}
+	app.startRunnerDiscovery()
 	// Start FIFO submission workers (DB-backed)
-	startSubmissionWorkersFromEnv()
-	// Serve static assets
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	// Route handlers
-	http.HandleFunc("/", indexHandler)
-	http.HandleFunc("/register", registerHandler)
-	http.HandleFunc("/login", loginHandler)
-	http.HandleFunc("/logout", logoutHandler)
-	http.HandleFunc("/challenges/", challengeHandler)
-	http.HandleFunc("/submit", submitHandler)
-	http.HandleFunc("/test", testHandler)
-	http.HandleFunc("/api/test", apiTestHandler)
-	http.HandleFunc("/api/pow", apiPowChallengeHandler)
-	http.HandleFunc("/api/admin/debug", apiAdminDebugHandler)
-	http.HandleFunc("/api/challenges", apiChallengeHandler)
-	http.HandleFunc("/api/submissions", apiSubmissionCreateHandler)
-	http.HandleFunc("/api/submissions/", apiSubmissionDetailHandler)
-	http.HandleFunc("/submissions", submissionsHandler)
-	http.HandleFunc("/scoreboard", scoreboardHandler)
-	http.HandleFunc("/users", usersHandler)
-	http.HandleFunc("/writer", writerDashboardHandler)
-	http.HandleFunc("/writer/challenges/new", writerNewChallengeHandler)
-	http.HandleFunc("/admin/users", adminUsersHandler)
-	http.HandleFunc("/admin/users/", adminUserDetailHandler)
-	http.HandleFunc("/admin/debug", adminDebugHandler)
-
-	// Admin upload is disabled for now (hidden tests live only in runner)
+	app.startSubmissionWorkersFromEnv()
 
-	// Submission detail route
-	http.HandleFunc("/submission/", submissionDetailHandler)
-	log.Println("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Test runs are answered synchronously, so the write timeout has to
+	// cover a sample run
+	srv := &http.Server{
+		Addr:              ":8080",
+		Handler:           withRequestID(app.routes()),
+		ReadHeaderTimeout: time.Duration(envIntWithClamp("WEB_HTTP_READ_HEADER_TIMEOUT_SECONDS", 10, 1, 300)) * time.Second,
+		ReadTimeout:       time.Duration(envIntWithClamp("WEB_HTTP_READ_TIMEOUT_SECONDS", 30, 1, 600)) * time.Second,
+		WriteTimeout:      time.Duration(envIntWithClamp("WEB_HTTP_WRITE_TIMEOUT_SECONDS", 120, 1, 3600)) * time.Second,
+		IdleTimeout:       time.Duration(envIntWithClamp("WEB_HTTP_IDLE_TIMEOUT_SECONDS", 120, 1, 3600)) * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		// Fail readiness and stop claiming submissions, but keep serving:
+		// runner webhooks wake the workers that are finishing up
+		slog.Info("server shutting down")
+		shuttingDown.Store(true)
+		timeout := time.Duration(envIntWithClamp("WEB_SHUTDOWN_TIMEOUT_SECONDS", 30, 1, 3600)) * time.Second
+		drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		stopSubmissionWorkers(drainCtx)
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelShutdown()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("server shutdown", "err", err)
+			srv.Close()
+		}
+	}()
+	slog.Info("server started", "addr", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server failed", "err", err)
+		os.Exit(1)
+	}
+	<-stopped
+	app.runner.Close()
+	stopBackground()
+	st.Close()
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownTracing(flushCtx)
+	cancelFlush()
+	slog.Info("server stopped")
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricClaimLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "goexe_queue_claim_latency_seconds",
+		Help:    "Time a submission waited in the queue before a worker claimed it.",
+		Buckets: prometheus.DefBuckets,
+	})
+	metricJudgeLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goexe_judge_latency_seconds",
+		Help:    "Time from submission to recorded verdict.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"language"})
+	metricVerdicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goexe_verdicts_total",
+		Help: "Judged submissions by language and verdict.",
+	}, []string{"language", "result"})
+	metricPowVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goexe_pow_verifications_total",
+		Help: "Proof-of-work verifications by purpose and outcome.",
+	}, []string{"purpose", "outcome"})
+)
+
+var (
+	dbConnectionsDesc = prometheus.NewDesc("goexe_db_connections",
+		"Database pool connections by state.", []string{"state"}, nil)
+	dbWaitCountDesc = prometheus.NewDesc("goexe_db_wait_count",
+		"Total connections waited for (sql.DBStats.WaitCount).", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc("goexe_db_wait_duration_seconds",
+		"Total time spent waiting for connections.", nil, nil)
+	queueDepthDesc = prometheus.NewDesc("goexe_queue_depth",
+		"Submissions waiting in or being processed by the judge queue.", []string{"state"}, nil)
+)
+
+// registerDBMetrics exports the connection pool statistics of conn.
+func registerDBMetrics(conn *dbConn) {
+	prometheus.MustRegister(dbStatsCollector{conn})
+}
+
+// registerQueueMetrics exports the judge queue depth kept in st.
+func registerQueueMetrics(st Store) {
+	prometheus.MustRegister(queueDepthCollector{st})
+}
+
+// dbStatsCollector reports the pool statistics of conn at scrape time.
+type dbStatsCollector struct{ conn *dbConn }
+
+func (dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbConnectionsDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+}
+
+func (c dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.conn.Stats()
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.OpenConnections), "open")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.MaxOpenConnections), "max_open")
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(st.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, st.WaitDuration.Seconds())
+}
+
+// queueDepthCollector reads the judge queue depth from st at scrape time.
+type queueDepthCollector struct{ st Store }
+
+func (queueDepthCollector) Describe(ch chan<- *prometheus.Desc) { ch <- queueDepthDesc }
+
+func (c queueDepthCollector) Collect(ch chan<- prometheus.Metric) {
+	pending, running, err := c.st.CountQueuedSubmissions()
+	if err != nil {
+		slog.Warn("metrics: queue depth lookup failed", "err", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(pending), "pending")
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(running), "running")
+}
+
+// metricsHandler serves /metrics to scrapers that send METRICS_TOKEN as a
+// bearer token. Without a token configured the endpoint is disabled, so the
+// public listener never exposes it by accident.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSpace(os.Getenv("METRICS_TOKEN"))
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	promhttp.Handler().ServeHTTP(w, r)
+}
+
+// powOutcome maps a Verify error to a metric label.
+func powOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, errPowInvalid):
+		return "invalid"
+	case errors.Is(err, errPowExpired):
+		return "expired"
+	case errors.Is(err, errPowMismatch):
+		return "mismatch"
+	case errors.Is(err, errPowDifficulty):
+		return "difficulty"
+	case errors.Is(err, errPowReuse):
+		return "reuse"
+	case errors.Is(err, errPowReplayStore):
+		return "store_error"
+	default:
+		return "error"
+	}
+}
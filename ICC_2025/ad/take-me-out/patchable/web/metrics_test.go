@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func scrape(t *testing.T, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, r)
+	return rec
+}
+
+func TestMetricsHandlerRequiresToken(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "")
+	if rec := scrape(t, "Bearer anything"); rec.Code != http.StatusNotFound {
+		t.Errorf("without METRICS_TOKEN: got %d, want 404", rec.Code)
+	}
+
+	t.Setenv("METRICS_TOKEN", "scrape-secret")
+	for _, auth := range []string{"", "Bearer wrong", "scrape-secret", "Basic scrape-secret"} {
+		if rec := scrape(t, auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: got %d, want 401", auth, rec.Code)
+		}
+	}
+	if rec := scrape(t, "Bearer scrape-secret"); rec.Code != http.StatusOK {
+		t.Errorf("with the token: got %d, want 200", rec.Code)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "scrape-secret")
+	metricVerdicts.WithLabelValues("py\"thon\n", `Wrong\Answer`).Inc()
+	metricPowVerifications.WithLabelValues("submit", "ok").Inc()
+
+	body := scrape(t, "Bearer scrape-secret").Body.String()
+	for _, want := range []string{
+		"# TYPE goexe_verdicts_total counter",
+		`goexe_verdicts_total{language="py\"thon\n",result="Wrong\\Answer"} 1`,
+		`goexe_pow_verifications_total{outcome="ok",purpose="submit"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition lacks %q", want)
+		}
+	}
+	// verdicts are not broken down per challenge, which would leak which
+	// challenges teams are solving and grow with every challenge
+	if strings.Contains(body, `challenge="`) {
+		t.Error("exposition has a challenge label")
+	}
+}
+
+func TestQueueDepthCollector(t *testing.T) {
+	st := newMemStore()
+	for _, result := range []string{"Pending", "Pending", "Running", "Accepted"} {
+		st.CreateSubmission(context.Background(), Submission{Result: result})
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(queueDepthCollector{st})
+	want := `
+# HELP goexe_queue_depth Submissions waiting in or being processed by the judge queue.
+# TYPE goexe_queue_depth gauge
+goexe_queue_depth{state="pending"} 2
+goexe_queue_depth{state="running"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "goexe_queue_depth"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(dbStatsCollector{testSQLiteConn(t)})
+	n, err := testutil.GatherAndCount(reg, "goexe_db_connections", "goexe_db_wait_count", "goexe_db_wait_duration_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Errorf("%d db series, want 6", n)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema migrations are SQL files named NNNN_name.up.sql / NNNN_name.down.sql,
+// applied in version order. Each dialect has its own directory under
+// migrations/. Applied versions are recorded in schema_migrations, and the
+// dialect keeps migrators that start together from applying the same
+// migration twice.
+//
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifies the PostgreSQL advisory lock held while
+// migrating.
+const migrationLockKey int64 = 0x676f657865 // "goexe"
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m migration) String() string { return fmt.Sprintf("%04d_%s", m.Version, m.Name) }
+
+// loadMigrations reads the embedded migrations for d sorted by version.
+func loadMigrations(d dialect) ([]migration, error) {
+	return parseMigrations(migrationFiles, d.migrationsDir())
+}
+
+func parseMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, label, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 || label == "" {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.%s.sql", name, direction)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	out := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %s has no up script", m)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// migrator applies migrations to one database. With dryRun set it only
+// writes the plan and the SQL it would run to out.
+type migrator struct {
+	db         *dbConn
+	migrations []migration
+	dryRun     bool
+	out        io.Writer
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	Name      string
+	AppliedAt time.Time
+}
+
+// Up applies pending migrations in order, at most steps of them (0 = all).
+func (m *migrator) Up(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		n := 0
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if steps > 0 && n == steps {
+				break
+			}
+			if err := m.apply(ctx, conn, mig, "up"); err != nil {
+				return err
+			}
+			n++
+		}
+		if n == 0 {
+			fmt.Fprintln(m.out, "schema is up to date")
+		}
+		return nil
+	})
+}
+
+// Down reverts the most recently applied migrations, steps of them (at
+// least one).
+func (m *migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		steps = 1
+	}
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		n := 0
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(mig.Down) == "" {
+				return fmt.Errorf("migration %s cannot be reverted: no down script", mig)
+			}
+			if err := m.apply(ctx, conn, mig, "down"); err != nil {
+				return err
+			}
+			n++
+		}
+		if n == 0 {
+			fmt.Fprintln(m.out, "no applied migrations to revert")
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration and when it was applied.
+func (m *migrator) Status(ctx context.Context) error {
+	return m.locked(ctx, func(_ *sql.Conn, applied map[int]appliedMigration) error {
+		known := make(map[int]bool, len(m.migrations))
+		for _, mig := range m.migrations {
+			known[mig.Version] = true
+			state := "pending"
+			if a, ok := applied[mig.Version]; ok {
+				state = "applied " + a.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(m.out, "%-40s %s\n", mig, state)
+		}
+		for version, a := range applied {
+			if !known[version] {
+				fmt.Fprintf(m.out, "%04d_%-35s applied but unknown to this binary\n", version, a.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// locked runs fn on a dedicated connection holding the migration lock.
+func (m *migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int]appliedMigration) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unlock, err := m.db.dialect.lockMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("migration lock: %w", err)
+	}
+	defer unlock()
+	if !m.dryRun {
+		if _, err := conn.ExecContext(ctx, m.db.dialect.schemaMigrationsDDL()); err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+	}
+	applied, err := m.appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+func (m *migrator) appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+	exists, err := m.db.dialect.hasTable(ctx, conn, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		// only possible in dry-run mode on a database never migrated
+		return applied, nil
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.Name, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// apply runs one direction of mig and records it, in a single transaction.
+func (m *migrator) apply(ctx context.Context, conn *sql.Conn, mig migration, direction string) error {
+	script := mig.Up
+	if direction == "down" {
+		script = mig.Down
+	}
+	if m.dryRun {
+		fmt.Fprintf(m.out, "-- would migrate %s %s\n%s\n", direction, mig, strings.TrimSpace(script))
+		return nil
+	}
+	start := time.Now()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// no arguments: lib/pq sends the script as a simple query and
+	// go-sqlite3 runs it statement by statement, so it may hold several
+	// statements (and DO blocks on PostgreSQL)
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %s %s: %w", mig, direction, err)
+	}
+	record := &dbTx{Tx: tx, dialect: m.db.dialect}
+	if direction == "up" {
+		_, err = record.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES($1, $2, $3)`, mig.Version, mig.Name, time.Now().UTC())
+	} else {
+		_, err = record.Exec(`DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s: record: %w", mig, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %s %s: %w", mig, direction, err)
+	}
+	slog.InfoContext(ctx, "migration applied", "migration", mig.String(), "direction", direction, "duration_ms", time.Since(start).Milliseconds())
+	fmt.Fprintf(m.out, "migrated %s %s\n", direction, mig)
+	return nil
+}
+
+// openMigrationDB opens the database selected by DB_DRIVER for migrating.
+// On PostgreSQL it connects as the role that owns the schema, MIGRATE_DB_USER
+// (default postgres), with MIGRATE_DB_PASSWORD. The web role has no DDL
+// privileges, so it is never used as a fallback.
+func openMigrationDB() (*dbConn, error) {
+	switch driver := dbDriver(); driver {
+	case "postgres":
+	case "sqlite":
+		path := sqlitePath()
+		pool, err := sql.Open("sqlite3", sqliteDSN(path))
+		if err != nil {
+			return nil, err
+		}
+		return &dbConn{DB: pool, dialect: sqliteDialect{path: path}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", driver)
+	}
+	user := strings.TrimSpace(os.Getenv("MIGRATE_DB_USER"))
+	if user == "" {
+		user = "postgres"
+	}
+	password := os.Getenv("MIGRATE_DB_PASSWORD")
+	if password == "" {
+		return nil, errors.New("MIGRATE_DB_PASSWORD is not set")
+	}
+	mdb, err := openPostgresAs(user, password)
+	if err != nil {
+		return nil, err
+	}
+	mdb.SetMaxOpenConns(2)
+	return mdb, nil
+}
+
+// migrateCommand implements "take-me-out-web migrate [flags] up|down|status"
+// and returns the process exit code.
+func migrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the migrations and SQL that would run without changing the database")
+	steps := flags.Int("steps", 0, "number of migrations to apply (up, default all) or revert (down, default 1)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: take-me-out-web migrate [-dry-run] [-steps N] up|down|status")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	action := "up"
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+	if flags.NArg() == 1 {
+		action = flags.Arg(0)
+	}
+	mdb, err := openMigrationDB()
+	if err != nil {
+		slog.Error("migration DB connection failed", "err", err)
+		return 1
+	}
+	defer mdb.Close()
+	migrations, err := loadMigrations(mdb.dialect)
+	if err != nil {
+		slog.Error("loading migrations failed", "err", err)
+		return 1
+	}
+	m := &migrator{db: mdb, migrations: migrations, dryRun: *dryRun, out: os.Stdout}
+	ctx := context.Background()
+	switch action {
+	case "up":
+		err = m.Up(ctx, *steps)
+	case "down":
+		err = m.Down(ctx, *steps)
+	case "status":
+		err = m.Status(ctx)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		slog.Error("migrate failed", "action", action, "err", err)
+		return 1
+	}
+	return 0
+}
+
+// autoMigrate applies pending migrations at startup when DB_AUTO_MIGRATE is
+// enabled, so a fresh deployment needs no separate migrate step.
+func autoMigrate() {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("DB_AUTO_MIGRATE"))) {
+	case "1", "true", "yes", "on":
+	default:
+		return
+	}
+	mdb, err := openMigrationDB()
+	if err == nil {
+		var migrations []migration
+		if migrations, err = loadMigrations(mdb.dialect); err == nil {
+			m := &migrator{db: mdb, migrations: migrations, out: io.Discard}
+			err = m.Up(context.Background(), 0)
+		}
+		mdb.Close()
+	}
+	if err != nil {
+		slog.Error("schema migration failed", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+	migs, err := parseMigrations(fstest.MapFS{
+		"m/0010_later.up.sql":    file("CREATE TABLE later(id INT);"),
+		"m/0002_second.up.sql":   file("CREATE TABLE second(id INT);"),
+		"m/0002_second.down.sql": file("DROP TABLE second;"),
+		"m/0001_first.up.sql":    file("CREATE TABLE first(id INT);"),
+		"m/README.md":            file("not a migration"),
+	}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range migs {
+		names = append(names, m.String())
+	}
+	if got := strings.Join(names, " "); got != "0001_first 0002_second 0010_later" {
+		t.Errorf("order %s", got)
+	}
+	if migs[1].Down != "DROP TABLE second;" || migs[0].Down != "" {
+		t.Errorf("down scripts %q, %q", migs[0].Down, migs[1].Down)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"no up script": {"m/0001_first.down.sql": file("DROP TABLE first;")},
+		"empty up":     {"m/0001_first.up.sql": file("  \n")},
+		"no version":   {"m/first.up.sql": file("SELECT 1;")},
+		"version zero": {"m/0000_first.up.sql": file("SELECT 1;")},
+		"no name":      {"m/0001_.up.sql": file("SELECT 1;")},
+		"two names": {
+			"m/0001_first.up.sql":   file("SELECT 1;"),
+			"m/0001_other.down.sql": file("SELECT 1;"),
+		},
+	} {
+		if _, err := parseMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsParse(t *testing.T) {
+	for _, d := range []dialect{postgresDialect{}, sqliteDialect{}} {
+		migs, err := loadMigrations(d)
+		if err != nil || len(migs) == 0 {
+			t.Fatalf("%s: %d migrations, %v", d.migrationsDir(), len(migs), err)
+		}
+		for i, m := range migs {
+			if m.Version != i+1 {
+				t.Errorf("%s: %s out of sequence", d.migrationsDir(), m)
+			}
+			if strings.TrimSpace(m.Down) == "" {
+				t.Errorf("%s: %s has no down script", d.migrationsDir(), m)
+			}
+		}
+	}
+}
+
+// emptySQLite opens a SQLite database with no schema at all.
+func emptySQLite(t *testing.T) *dbConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "migrate.db")
+	pool, err := sql.Open("sqlite3", sqliteDSN(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return &dbConn{DB: pool, dialect: sqliteDialect{path: path}}
+}
+
+var testMigrations = []migration{
+	{Version: 1, Name: "a", Up: "CREATE TABLE a(id INTEGER);", Down: "DROP TABLE a;"},
+	{Version: 2, Name: "b", Up: "CREATE TABLE b(id INTEGER);", Down: "DROP TABLE b;"},
+	{Version: 3, Name: "c", Up: "CREATE TABLE c(id INTEGER);", Down: "DROP TABLE c;"},
+}
+
+func appliedVersions(t *testing.T, conn *dbConn) string {
+	t.Helper()
+	rows, err := conn.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		rows.Scan(&v)
+		out = append(out, v)
+	}
+	return strings.Join(out, ",")
+}
+
+func tableExists(t *testing.T, conn *dbConn, name string) bool {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	conn := emptySQLite(t)
+	var out strings.Builder
+	m := &migrator{db: conn, migrations: testMigrations, out: &out}
+
+	steps := []struct {
+		name    string
+		run     func() error
+		applied string
+		output  string
+	}{
+		{"up one step", func() error { return m.Up(ctx, 1) }, "1", "migrated up 0001_a\n"},
+		{"up the rest", func() error { return m.Up(ctx, 0) }, "1,2,3", "migrated up 0002_b\nmigrated up 0003_c\n"},
+		{"up to date", func() error { return m.Up(ctx, 0) }, "1,2,3", "schema is up to date\n"},
+		{"down defaults to one", func() error { return m.Down(ctx, 0) }, "1,2", "migrated down 0003_c\n"},
+		{"down newest first", func() error { return m.Down(ctx, 5) }, "", "migrated down 0002_b\nmigrated down 0001_a\n"},
+		{"nothing to revert", func() error { return m.Down(ctx, 1) }, "", "no applied migrations to revert\n"},
+	}
+	for _, step := range steps {
+		out.Reset()
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := appliedVersions(t, conn); got != step.applied {
+			t.Errorf("%s: applied %q, want %q", step.name, got, step.applied)
+		}
+		if out.String() != step.output {
+			t.Errorf("%s: output %q, want %q", step.name, out.String(), step.output)
+		}
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if tableExists(t, conn, name) {
+			t.Errorf("table %s left after reverting everything", name)
+		}
+	}
+}
+
+func TestMigratorFailedMigrationRollsBack(t *testing.T) {
+	conn := emptySQLite(t)
+	m := &migrator{db: conn, out: &strings.Builder{}, migrations: []migration{
+		testMigrations[0],
+		{Version: 2, Name: "broken", Up: "CREATE TABLE half(id INTEGER); SELECT * FROM missing;"},
+	}}
+	if err := m.Up(context.Background(), 0); err == nil || !strings.Contains(err.Error(), "0002_broken") {
+		t.Fatalf("Up = %v", err)
+	}
+	if got := appliedVersions(t, conn); got != "1" {
+		t.Errorf("applied %q, want 1", got)
+	}
+	if tableExists(t, conn, "half") {
+		t.Error("the failed migration was partly applied")
+	}
+
+	m.migrations = []migration{{Version: 1, Name: "a", Up: "CREATE TABLE a(id INTEGER);"}}
+	if err := m.Down(context.Background(), 1); err == nil || !strings.Contains(err.Error(), "no down script") {
+		t.Errorf("Down without a down script = %v", err)
+	}
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	ctx := context.Background()
+	conn := emptySQLite(t)
+	var out strings.Builder
+	dry := &migrator{db: conn, migrations: testMigrations, dryRun: true, out: &out}
+	if err := dry.Up(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	want := "-- would migrate up 0001_a\nCREATE TABLE a(id INTEGER);\n-- would migrate up 0002_b\nCREATE TABLE b(id INTEGER);\n"
+	if out.String() != want {
+		t.Errorf("dry-run output %q, want %q", out.String(), want)
+	}
+	// a dry run on a fresh database does not even create schema_migrations
+	if tableExists(t, conn, "schema_migrations") || tableExists(t, conn, "a") {
+		t.Error("dry run changed the database")
+	}
+
+	real := &migrator{db: conn, migrations: testMigrations, out: &strings.Builder{}}
+	if err := real.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := dry.Down(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "-- would migrate down 0003_c\n") || !strings.Contains(out.String(), "-- would migrate down 0002_b\n") {
+		t.Errorf("dry-run down output %q", out.String())
+	}
+	if got := appliedVersions(t, conn); got != "1,2,3" {
+		t.Errorf("dry-run down reverted: applied %q", got)
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	ctx := context.Background()
+	conn := emptySQLite(t)
+	var out strings.Builder
+	m := &migrator{db: conn, migrations: testMigrations[:2], out: &out}
+	if err := m.Up(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// a migration applied by a newer binary
+	if _, err := conn.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(9, 'future', $1)`, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := m.Status(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 ||
+		!strings.HasPrefix(lines[0], "0001_a") || !strings.Contains(lines[0], "applied ") ||
+		!strings.HasPrefix(lines[1], "0002_b") || !strings.HasSuffix(lines[1], "pending") ||
+		!strings.Contains(lines[2], "0009_future") || !strings.HasSuffix(lines[2], "unknown to this binary") {
+		t.Errorf("status:\n%s", out.String())
+	}
+}
+
+func TestEmbeddedSQLiteMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	conn := emptySQLite(t)
+	migs, err := loadMigrations(conn.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &migrator{db: conn, migrations: migs, out: &strings.Builder{}}
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(ctx, len(migs)); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, conn); got != "" {
+		t.Errorf("applied %q after reverting everything", got)
+	}
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatalf("up after a full down: %v", err)
+	}
+}
+
+func TestSQLiteMigrationLock(t *testing.T) {
+	conn := emptySQLite(t)
+	ctx := context.Background()
+	c1, err := conn.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	unlock, err := conn.dialect.lockMigrations(ctx, c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second migrator waits for the lock and gives up with its context
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := conn.dialect.lockMigrations(waitCtx, c1); err == nil {
+		t.Fatal("took the migration lock twice")
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		u, err := conn.dialect.lockMigrations(ctx, c1)
+		if err != nil {
+			t.Error(err)
+			u = func() {}
+		}
+		acquired <- u
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("took the lock while it was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case u := <-acquired:
+		u()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock was not handed over after unlock")
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS solves;
+DROP TABLE IF EXISTS submissions;
+DROP TABLE IF EXISTS challenges;
+DROP TABLE IF EXISTS users;
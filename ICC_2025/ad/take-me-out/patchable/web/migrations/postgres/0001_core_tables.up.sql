@@ -0,0 +1,78 @@
+-- Users, challenges, submissions and solves. The DO blocks bring databases
+-- created by older init scripts up to the same shape.
+CREATE TABLE IF NOT EXISTS users (
+  id SERIAL PRIMARY KEY,
+  username TEXT UNIQUE NOT NULL,
+  password TEXT NOT NULL,
+  is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+  is_writer BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS challenges (
+  id SERIAL UNIQUE NOT NULL,
+  name TEXT PRIMARY KEY,
+  description TEXT,
+  input TEXT,
+  output TEXT,
+  points INT NOT NULL DEFAULT 100
+);
+
+CREATE TABLE IF NOT EXISTS submissions (
+  id SERIAL PRIMARY KEY,
+  user_id INT REFERENCES users(id),
+  challenge TEXT REFERENCES challenges(name),
+  language TEXT,
+  code TEXT,
+  result TEXT,
+  created_at TIMESTAMP,
+  execution_time_ms INT NOT NULL DEFAULT 0,
+  fail_case_index INT NOT NULL DEFAULT -1,
+  last_output TEXT,
+  expected_output TEXT
+);
+
+CREATE TABLE IF NOT EXISTS solves (
+  user_id INT REFERENCES users(id),
+  challenge TEXT REFERENCES challenges(name),
+  created_at TIMESTAMP,
+  PRIMARY KEY(user_id, challenge)
+);
+
+-- Legacy: challenges.id used to be a plain nullable column
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'challenges' AND column_name = 'id'
+  ) THEN
+    BEGIN
+      EXECUTE 'ALTER TABLE challenges ALTER COLUMN id SET DEFAULT nextval(''challenges_id_seq'')';
+    EXCEPTION WHEN undefined_column THEN
+      EXECUTE 'CREATE SEQUENCE IF NOT EXISTS challenges_id_seq OWNED BY challenges.id';
+      EXECUTE 'ALTER TABLE challenges ALTER COLUMN id SET DEFAULT nextval(''challenges_id_seq'')';
+    END;
+    EXECUTE 'UPDATE challenges SET id = nextval(''challenges_id_seq'') WHERE id IS NULL';
+    EXECUTE 'ALTER TABLE challenges ALTER COLUMN id SET NOT NULL';
+  END IF;
+END$$;
+
+-- Legacy: challenges.statement was renamed to description
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'challenges' AND column_name = 'statement'
+  ) THEN
+    IF EXISTS (
+      SELECT 1 FROM information_schema.columns
+      WHERE table_name = 'challenges' AND column_name = 'description'
+    ) THEN
+      EXECUTE 'UPDATE challenges SET description = statement WHERE description IS NULL';
+      EXECUTE 'ALTER TABLE challenges DROP COLUMN statement';
+    ELSE
+      EXECUTE 'ALTER TABLE challenges RENAME COLUMN statement TO description';
+    END IF;
+  END IF;
+END$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_challenges_id ON challenges(id);
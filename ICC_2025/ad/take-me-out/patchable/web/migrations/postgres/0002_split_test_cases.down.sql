@@ -0,0 +1,4 @@
+-- The legacy test_cases table is not restored.
+DROP FUNCTION IF EXISTS purge_judge_cases(TEXT);
+DROP TABLE IF EXISTS sample_cases;
+DROP TABLE IF EXISTS judge_cases;
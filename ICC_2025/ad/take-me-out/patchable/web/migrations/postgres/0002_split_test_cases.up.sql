@@ -0,0 +1,67 @@
+-- Test cases are split into hidden judge cases and public sample cases.
+-- A legacy test_cases table is renamed, copied over and dropped.
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.tables
+    WHERE table_name = 'legacy_test_cases'
+  ) THEN
+    EXECUTE 'DROP TABLE legacy_test_cases';
+  END IF;
+  IF EXISTS (
+    SELECT 1 FROM information_schema.tables
+    WHERE table_name = 'test_cases'
+  ) THEN
+    EXECUTE 'ALTER TABLE test_cases RENAME TO legacy_test_cases';
+  END IF;
+END$$;
+
+CREATE TABLE IF NOT EXISTS judge_cases (
+  id SERIAL PRIMARY KEY,
+  challenge TEXT REFERENCES challenges(name) ON DELETE CASCADE,
+  idx INT NOT NULL DEFAULT 0,
+  input TEXT,
+  output TEXT,
+  UNIQUE (challenge, idx)
+);
+
+CREATE TABLE IF NOT EXISTS sample_cases (
+  id SERIAL PRIMARY KEY,
+  challenge TEXT REFERENCES challenges(name) ON DELETE CASCADE,
+  idx INT NOT NULL DEFAULT 0,
+  input TEXT,
+  output TEXT,
+  UNIQUE (challenge, idx)
+);
+
+-- Helper to clear judge cases without granting delete privileges to the web role
+CREATE OR REPLACE FUNCTION purge_judge_cases(challenge_name TEXT)
+RETURNS VOID
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+BEGIN
+  DELETE FROM judge_cases WHERE challenge = challenge_name;
+END;
+$$;
+
+REVOKE ALL ON FUNCTION purge_judge_cases(TEXT) FROM PUBLIC;
+
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.tables
+    WHERE table_name = 'legacy_test_cases'
+  ) THEN
+    EXECUTE 'INSERT INTO sample_cases(challenge, idx, input, output)
+             SELECT challenge, ROW_NUMBER() OVER (PARTITION BY challenge ORDER BY idx) - 1, input, output
+             FROM legacy_test_cases WHERE is_sample = TRUE
+             ON CONFLICT (challenge, idx) DO NOTHING';
+    EXECUTE 'INSERT INTO judge_cases(challenge, idx, input, output)
+             SELECT challenge, ROW_NUMBER() OVER (PARTITION BY challenge ORDER BY idx) - 1, input, output
+             FROM legacy_test_cases WHERE is_sample = FALSE
+             ON CONFLICT (challenge, idx) DO NOTHING';
+    EXECUTE 'DROP TABLE legacy_test_cases';
+  END IF;
+END$$;
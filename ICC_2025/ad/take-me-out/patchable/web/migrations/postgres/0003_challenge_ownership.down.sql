@@ -0,0 +1,2 @@
+ALTER TABLE challenges DROP COLUMN IF EXISTS is_public;
+ALTER TABLE challenges DROP COLUMN IF EXISTS created_by;
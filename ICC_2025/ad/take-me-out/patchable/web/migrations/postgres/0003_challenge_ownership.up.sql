@@ -0,0 +1,13 @@
+-- Writers own the challenges they create; built-in ones are public.
+ALTER TABLE challenges
+  ADD COLUMN IF NOT EXISTS created_by INT REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE challenges
+  ADD COLUMN IF NOT EXISTS is_public BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE challenges
+  ALTER COLUMN is_public SET NOT NULL;
+
+UPDATE challenges
+  SET is_public = TRUE
+  WHERE created_by IS NULL;
@@ -0,0 +1,3 @@
+-- Admin and contest submissions jump the judge queue.
+ALTER TABLE submissions
+  ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
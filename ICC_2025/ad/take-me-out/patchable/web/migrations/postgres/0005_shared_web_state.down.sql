@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sample_result_cache;
+DROP TABLE IF EXISTS pow_used_nonces;
@@ -0,0 +1,18 @@
+-- Spent proof-of-work solutions shared by every web replica
+CREATE TABLE IF NOT EXISTS pow_used_nonces (
+  nonce_key TEXT PRIMARY KEY,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Cached sample-test verdicts shared by every web replica
+CREATE TABLE IF NOT EXISTS sample_result_cache (
+  cache_key TEXT PRIMARY KEY,
+  challenge TEXT NOT NULL,
+  result TEXT NOT NULL,
+  duration_ms INT NOT NULL DEFAULT 0,
+  fail_index INT NOT NULL DEFAULT -1,
+  output TEXT NOT NULL DEFAULT '',
+  expected_output TEXT NOT NULL DEFAULT '',
+  cached_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
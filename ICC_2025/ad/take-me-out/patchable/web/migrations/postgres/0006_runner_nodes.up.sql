@@ -0,0 +1,12 @@
+-- Runner instances advertising capacity to the web tier (heartbeat)
+CREATE TABLE IF NOT EXISTS runner_nodes (
+  id TEXT PRIMARY KEY,
+  url TEXT NOT NULL,
+  workers INT NOT NULL DEFAULT 0,
+  queue_size INT NOT NULL DEFAULT 0,
+  queued INT NOT NULL DEFAULT 0,
+  running INT NOT NULL DEFAULT 0,
+  languages TEXT NOT NULL DEFAULT '',
+  draining BOOLEAN NOT NULL DEFAULT FALSE,
+  last_seen TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE submissions DROP COLUMN IF EXISTS trace_parent;
+ALTER TABLE submissions DROP COLUMN IF EXISTS request_id;
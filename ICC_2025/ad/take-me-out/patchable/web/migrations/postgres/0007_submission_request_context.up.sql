@@ -0,0 +1,7 @@
+-- Request ID and W3C traceparent of the request that created a submission,
+-- so the worker that judges it logs and traces under the same IDs.
+ALTER TABLE submissions
+  ADD COLUMN IF NOT EXISTS request_id TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE submissions
+  ADD COLUMN IF NOT EXISTS trace_parent TEXT NOT NULL DEFAULT '';
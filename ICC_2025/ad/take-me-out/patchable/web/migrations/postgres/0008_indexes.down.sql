@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_sample_result_cache_expires;
+DROP INDEX IF EXISTS idx_sample_result_cache_challenge;
+DROP INDEX IF EXISTS idx_pow_used_nonces_expires;
+DROP INDEX IF EXISTS idx_judge_cases_chal_idx;
+DROP INDEX IF EXISTS idx_sample_cases_chal_idx;
+DROP INDEX IF EXISTS idx_submissions_pending_user;
+DROP INDEX IF EXISTS idx_submissions_result_created;
+DROP INDEX IF EXISTS idx_submissions_chal_user_created;
+DROP INDEX IF EXISTS idx_submissions_chal_created;
+DROP INDEX IF EXISTS idx_submissions_user_created;
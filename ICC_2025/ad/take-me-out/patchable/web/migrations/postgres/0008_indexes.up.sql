@@ -0,0 +1,18 @@
+-- submissions listing by user/challenge (FIFO by created_at)
+CREATE INDEX IF NOT EXISTS idx_submissions_user_created ON submissions(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_submissions_chal_created ON submissions(challenge, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_submissions_chal_user_created ON submissions(challenge, user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_submissions_result_created ON submissions(result, created_at ASC);
+-- fair-share claim: pending rows per user in arrival order
+CREATE INDEX IF NOT EXISTS idx_submissions_pending_user ON submissions(user_id, created_at ASC) WHERE result = 'Pending';
+
+-- sample/judge case access by challenge and index
+CREATE INDEX IF NOT EXISTS idx_sample_cases_chal_idx ON sample_cases(challenge, idx);
+CREATE INDEX IF NOT EXISTS idx_judge_cases_chal_idx ON judge_cases(challenge, idx);
+
+-- expired proof-of-work cleanup
+CREATE INDEX IF NOT EXISTS idx_pow_used_nonces_expires ON pow_used_nonces(expires_at);
+
+-- sample result cache invalidation and expiry
+CREATE INDEX IF NOT EXISTS idx_sample_result_cache_challenge ON sample_result_cache(challenge);
+CREATE INDEX IF NOT EXISTS idx_sample_result_cache_expires ON sample_result_cache(expires_at);
@@ -0,0 +1,7 @@
+REVOKE ALL ON ALL SEQUENCES IN SCHEMA public FROM "app_web", "app_runner";
+REVOKE ALL ON runner_nodes, judge_cases, sample_cases, challenges FROM "app_runner";
+REVOKE ALL ON sample_result_cache, pow_used_nonces, runner_nodes FROM "app_web";
+REVOKE ALL ON users, submissions, solves, judge_cases, sample_cases, challenges FROM "app_web";
+REVOKE EXECUTE ON FUNCTION purge_judge_cases(TEXT) FROM "app_web";
+REVOKE USAGE ON SCHEMA public FROM "app_web", "app_runner";
+REVOKE CONNECT ON DATABASE postgres FROM "app_web", "app_runner";
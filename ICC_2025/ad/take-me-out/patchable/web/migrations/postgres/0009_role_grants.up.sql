@@ -0,0 +1,25 @@
+-- Privileges of the web and runner roles (created by the DB init scripts).
+-- Migrations that add tables grant access to them in the same migration.
+GRANT CONNECT ON DATABASE postgres TO "app_web", "app_runner";
+GRANT USAGE ON SCHEMA public TO "app_web", "app_runner";
+
+-- Web: insert-only for challenge data, but allow listing and scoreboard
+GRANT INSERT, UPDATE ON challenges TO "app_web";
+GRANT SELECT, INSERT, UPDATE, DELETE ON sample_cases TO "app_web";
+GRANT INSERT ON judge_cases TO "app_web";
+GRANT EXECUTE ON FUNCTION purge_judge_cases(TEXT) TO "app_web";
+GRANT SELECT (id, name, description, points, created_by, is_public) ON TABLE challenges TO "app_web";
+
+-- Web app needs full access to its own tables
+GRANT SELECT, INSERT, UPDATE, DELETE ON users, submissions, solves TO "app_web";
+GRANT SELECT, INSERT, UPDATE, DELETE ON pow_used_nonces TO "app_web";
+GRANT SELECT, INSERT, UPDATE, DELETE ON sample_result_cache TO "app_web";
+GRANT SELECT ON runner_nodes TO "app_web";
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO "app_web";
+
+-- Runner: needs to seed and refresh built-in challenges
+GRANT SELECT, INSERT, UPDATE ON challenges TO "app_runner";
+GRANT SELECT, INSERT, UPDATE, DELETE ON sample_cases TO "app_runner";
+GRANT SELECT, INSERT, UPDATE, DELETE ON judge_cases TO "app_runner";
+GRANT SELECT, INSERT, UPDATE, DELETE ON runner_nodes TO "app_runner";
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO "app_runner";
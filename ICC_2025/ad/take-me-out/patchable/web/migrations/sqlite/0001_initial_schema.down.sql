@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS runner_nodes;
+DROP TABLE IF EXISTS sample_result_cache;
+DROP TABLE IF EXISTS pow_used_nonces;
+DROP TABLE IF EXISTS sample_cases;
+DROP TABLE IF EXISTS judge_cases;
+DROP TABLE IF EXISTS solves;
+DROP TABLE IF EXISTS submissions;
+DROP TABLE IF EXISTS challenges;
+DROP TABLE IF EXISTS users;
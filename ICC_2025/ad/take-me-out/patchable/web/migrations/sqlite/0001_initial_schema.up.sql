@@ -0,0 +1,104 @@
+-- The PostgreSQL schema as of its migration 0009, for single-node SQLite
+-- deployments. There are no roles to grant to, and judge cases are deleted
+-- directly instead of through purge_judge_cases.
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username TEXT UNIQUE NOT NULL,
+  password TEXT NOT NULL,
+  is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+  is_writer BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS challenges (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT UNIQUE NOT NULL,
+  description TEXT,
+  input TEXT,
+  output TEXT,
+  points INT NOT NULL DEFAULT 100,
+  created_by INT REFERENCES users(id) ON DELETE SET NULL,
+  is_public BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS submissions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INT REFERENCES users(id),
+  challenge TEXT REFERENCES challenges(name),
+  language TEXT,
+  code TEXT,
+  result TEXT,
+  created_at TIMESTAMP,
+  execution_time_ms INT NOT NULL DEFAULT 0,
+  fail_case_index INT NOT NULL DEFAULT -1,
+  last_output TEXT,
+  expected_output TEXT,
+  priority INT NOT NULL DEFAULT 0,
+  request_id TEXT NOT NULL DEFAULT '',
+  trace_parent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS solves (
+  user_id INT REFERENCES users(id),
+  challenge TEXT REFERENCES challenges(name),
+  created_at TIMESTAMP,
+  PRIMARY KEY(user_id, challenge)
+);
+
+CREATE TABLE IF NOT EXISTS judge_cases (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  challenge TEXT REFERENCES challenges(name) ON DELETE CASCADE,
+  idx INT NOT NULL DEFAULT 0,
+  input TEXT,
+  output TEXT,
+  UNIQUE (challenge, idx)
+);
+
+CREATE TABLE IF NOT EXISTS sample_cases (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  challenge TEXT REFERENCES challenges(name) ON DELETE CASCADE,
+  idx INT NOT NULL DEFAULT 0,
+  input TEXT,
+  output TEXT,
+  UNIQUE (challenge, idx)
+);
+
+-- Timestamps are stored as UTC text, which sorts chronologically
+CREATE TABLE IF NOT EXISTS pow_used_nonces (
+  nonce_key TEXT PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sample_result_cache (
+  cache_key TEXT PRIMARY KEY,
+  challenge TEXT NOT NULL,
+  result TEXT NOT NULL,
+  duration_ms INT NOT NULL DEFAULT 0,
+  fail_index INT NOT NULL DEFAULT -1,
+  output TEXT NOT NULL DEFAULT '',
+  expected_output TEXT NOT NULL DEFAULT '',
+  cached_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS runner_nodes (
+  id TEXT PRIMARY KEY,
+  url TEXT NOT NULL,
+  workers INT NOT NULL DEFAULT 0,
+  queue_size INT NOT NULL DEFAULT 0,
+  queued INT NOT NULL DEFAULT 0,
+  running INT NOT NULL DEFAULT 0,
+  languages TEXT NOT NULL DEFAULT '',
+  draining BOOLEAN NOT NULL DEFAULT FALSE,
+  last_seen TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_submissions_user_created ON submissions(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_submissions_chal_created ON submissions(challenge, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_submissions_chal_user_created ON submissions(challenge, user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_submissions_result_created ON submissions(result, created_at ASC);
+CREATE INDEX IF NOT EXISTS idx_submissions_pending_user ON submissions(user_id, created_at ASC) WHERE result = 'Pending';
+CREATE INDEX IF NOT EXISTS idx_sample_cases_chal_idx ON sample_cases(challenge, idx);
+CREATE INDEX IF NOT EXISTS idx_judge_cases_chal_idx ON judge_cases(challenge, idx);
+CREATE INDEX IF NOT EXISTS idx_pow_used_nonces_expires ON pow_used_nonces(expires_at);
+CREATE INDEX IF NOT EXISTS idx_sample_result_cache_challenge ON sample_result_cache(challenge);
+CREATE INDEX IF NOT EXISTS idx_sample_result_cache_expires ON sample_result_cache(expires_at);
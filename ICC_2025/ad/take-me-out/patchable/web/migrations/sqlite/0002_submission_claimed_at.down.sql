@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_submissions_user_claimed;
+ALTER TABLE submissions DROP COLUMN claimed_at;
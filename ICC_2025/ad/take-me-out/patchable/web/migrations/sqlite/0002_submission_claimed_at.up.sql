@@ -0,0 +1,5 @@
+-- When a worker claimed a submission, so the queue can serve the user it
+-- served least recently first.
+ALTER TABLE submissions ADD COLUMN claimed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_submissions_user_claimed ON submissions(user_id, claimed_at) WHERE claimed_at IS NOT NULL;
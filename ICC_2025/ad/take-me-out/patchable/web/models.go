@@ -1,7 +1,6 @@
 package main
 
 import (
-	"html/template"
 	"time"
 )
 
@@ -28,6 +27,34 @@ type Submission struct {
 	LastOutput  string
 	ExpectedOut string
 	CreatedAt   time.Time
+	Priority    int
+	RequestID   string
+	TraceParent string
+}
+
+// ChallengeSubmission is a row of a challenge's submission list
+type ChallengeSubmission struct {
+	ID        int
+	Username  string
+	Language  string
+	Result    string
+	CreatedAt string
+}
+
+// SubmissionDetail is a single submission with its author, for the detail page
+type SubmissionDetail struct {
+	ID          int
+	Username    string
+	Challenge   string
+	ChallengeID int
+	Language    string
+	Code        string
+	Result      string
+	CreatedAt   string
+	DurationMs  int
+	FailedCase  int
+	Got         string
+	Want        string
 }
 
 // TestCase holds input and output for a challenge
@@ -73,6 +100,3 @@ func newBasePageData(user *User) BasePageData {
 		IsWriter: user.IsAdmin || user.IsWriter,
 	}
 }
-
-// templates holds parsed HTML templates
-var templates *template.Template
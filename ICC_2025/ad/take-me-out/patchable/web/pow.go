@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
@@ -28,6 +29,7 @@ var (
 	errPowDifficulty    = errors.New("insufficient proof-of-work difficulty")
 	errPowReuse         = errors.New("proof-of-work value already used")
 	errPowConfiguration = errors.New("proof-of-work manager not initialized")
+	errPowReplayStore   = errors.New("proof-of-work replay store unavailable")
 )
 
 type powChallenge struct {
@@ -47,50 +49,110 @@ type powProof struct {
 	Nonce      string `json:"nonce"`
 }
 
-type powManager struct {
-	mu         sync.Mutex
-	secret     []byte
-	ttl        time.Duration
-	difficulty int
-	used       map[string]time.Time
+// maxPowDifficultyBits bounds any difficulty the manager will issue or accept.
+const maxPowDifficultyBits = 32
+
+// powPolicy describes how difficulty is derived for a single purpose.
+type powPolicy struct {
+	Base         int // bits required with an idle queue and a quiet user
+	QueueStep    int // pending submissions per extra bit (0 disables)
+	UserRateStep int // recent verified proofs per extra bit (0 disables)
 }
 
-var powMgr *powManager
+type powManager struct {
+	mu            sync.Mutex
+	secrets       [][]byte // secrets[0] signs, all of them verify
+	ttl           time.Duration
+	policies      map[string]powPolicy
+	maxDifficulty int
+	rateWindow    time.Duration
+	replay        powReplayStore
+	recent        map[int][]time.Time // per-replica view of recent proofs
 
-func initPowManager() {
-	mgr, err := newPowManager()
+	// queueDepth reports the current number of Pending submissions. The
+	// value is cached for queueCacheTTL so issuing a challenge does not
+	// hit the database on every request.
+	queueDepth    func() (int, error)
+	queueCacheTTL time.Duration
+	queueCached   int
+	queueCachedAt time.Time
+}
+
+// newPowManagerFromEnv builds the manager from the POW_* settings, keeping
+// spent proofs in conn unless POW_REPLAY_STORE says otherwise; the replay
+// store's cleanup stops with ctx. queueDepth scales the difficulty with the
+// judge queue.
+func newPowManagerFromEnv(ctx context.Context, conn *dbConn, queueDepth func() (int, error)) (*powManager, error) {
+	secrets, err := loadPowSecrets()
+	if err != nil {
+		return nil, err
+	}
+	replay, err := newPowReplayStoreFromEnv(ctx, conn)
 	if err != nil {
-		log.Fatalf("pow manager init failed: %v", err)
+		return nil, err
 	}
-	powMgr = mgr
+	mgr, err := newPowManager(secrets, replay)
+	if err != nil {
+		return nil, err
+	}
+	mgr.queueDepth = queueDepth
+	return mgr, nil
 }
 
-func newPowManager() (*powManager, error) {
-	secret := make([]byte, 32)
-	if _, err := rand.Read(secret); err != nil {
-		return nil, fmt.Errorf("pow secret: %w", err)
+func newPowManager(secrets [][]byte, replay powReplayStore) (*powManager, error) {
+	if len(secrets) == 0 || len(secrets[0]) == 0 {
+		return nil, errors.New("pow secret: no signing key configured")
+	}
+	if replay == nil {
+		replay = newMemoryReplayStore()
 	}
 
-	difficulty := envIntWithClamp("POW_DIFFICULTY_BITS", 18, 1, 32)
+	base := envIntWithClamp("POW_DIFFICULTY_BITS", 18, 1, maxPowDifficultyBits)
+	maxDifficulty := envIntWithClamp("POW_MAX_DIFFICULTY_BITS", base+6, base, maxPowDifficultyBits)
 	ttlSeconds := envIntWithClamp("POW_TTL_SECONDS", 60, 1, 3600)
 	ttl := time.Duration(ttlSeconds) * time.Second
+	windowSeconds := envIntWithClamp("POW_USER_RATE_WINDOW_SECONDS", 60, 1, 3600)
+	queueStep := envIntWithClamp("POW_QUEUE_STEP", 10, 0, 100000)
+	userRateStep := envIntWithClamp("POW_USER_RATE_STEP", 5, 0, 100000)
+
+	// Sample tests are cheap and frequent, the admin debug runner is not.
+	policies := map[string]powPolicy{
+		powPurposeSubmission: {
+			Base:         envIntWithClamp("POW_DIFFICULTY_BITS_SUBMISSION", base, 1, maxDifficulty),
+			QueueStep:    queueStep,
+			UserRateStep: userRateStep,
+		},
+		powPurposeTest: {
+			Base:         envIntWithClamp("POW_DIFFICULTY_BITS_TEST", base-2, 1, maxDifficulty),
+			QueueStep:    queueStep,
+			UserRateStep: userRateStep,
+		},
+		powPurposeAdmin: {
+			Base:         envIntWithClamp("POW_DIFFICULTY_BITS_ADMIN_DEBUG", base+2, 1, maxDifficulty),
+			QueueStep:    0,
+			UserRateStep: userRateStep,
+		},
+	}
 
 	return &powManager{
-		secret:     secret,
-		ttl:        ttl,
-		difficulty: difficulty,
-		used:       make(map[string]time.Time),
+		secrets:       secrets,
+		ttl:           ttl,
+		policies:      policies,
+		maxDifficulty: maxDifficulty,
+		rateWindow:    time.Duration(windowSeconds) * time.Second,
+		replay:        replay,
+		recent:        make(map[int][]time.Time),
+		queueCacheTTL: 2 * time.Second,
 	}, nil
 }
 
+// envIntWithClamp reads key as an integer, falling back to fallback when it
+// is unset or malformed, and clamps the result to [min, max]. The fallback
+// is clamped too, since callers derive it from other settings.
 func envIntWithClamp(key string, fallback, min, max int) int {
-	val := strings.TrimSpace(os.Getenv(key))
-	if val == "" {
-		return fallback
-	}
-	n, err := strconv.Atoi(val)
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
 	if err != nil {
-		return fallback
+		n = fallback
 	}
 	if n < min {
 		return min
@@ -101,11 +163,82 @@ func envIntWithClamp(key string, fallback, min, max int) int {
 	return n
 }
 
-func (pm *powManager) Difficulty() int {
+// Difficulty returns the number of leading zero bits a new challenge for the
+// given user and purpose would require. The base difficulty of the purpose
+// grows by one bit per QueueStep pending submissions and per UserRateStep
+// proofs the user had verified within the rate window.
+func (pm *powManager) Difficulty(userID int, purpose string) int {
 	if pm == nil {
 		return 0
 	}
-	return pm.difficulty
+	policy, ok := pm.policies[purpose]
+	if !ok {
+		policy = pm.policies[powPurposeSubmission]
+	}
+	difficulty := policy.Base
+	if policy.QueueStep > 0 {
+		difficulty += pm.pendingQueueDepth() / policy.QueueStep
+	}
+	if policy.UserRateStep > 0 {
+		difficulty += pm.recentProofCount(userID, time.Now()) / policy.UserRateStep
+	}
+	if difficulty > pm.maxDifficulty {
+		difficulty = pm.maxDifficulty
+	}
+	if difficulty < 1 {
+		difficulty = 1
+	}
+	return difficulty
+}
+
+func (pm *powManager) pendingQueueDepth() int {
+	if pm.queueDepth == nil {
+		return 0
+	}
+	pm.mu.Lock()
+	if !pm.queueCachedAt.IsZero() && time.Since(pm.queueCachedAt) < pm.queueCacheTTL {
+		depth := pm.queueCached
+		pm.mu.Unlock()
+		return depth
+	}
+	pm.mu.Unlock()
+
+	depth, err := pm.queueDepth()
+	if err != nil {
+		slog.Warn("pow: queue depth lookup failed", "err", err)
+		pm.mu.Lock()
+		depth = pm.queueCached
+		pm.mu.Unlock()
+		return depth
+	}
+	pm.mu.Lock()
+	pm.queueCached = depth
+	pm.queueCachedAt = time.Now()
+	pm.mu.Unlock()
+	return depth
+}
+
+func (pm *powManager) recentProofCount(userID int, now time.Time) int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return len(pm.pruneRecentLocked(userID, now))
+}
+
+func (pm *powManager) pruneRecentLocked(userID int, now time.Time) []time.Time {
+	times := pm.recent[userID]
+	cutoff := now.Add(-pm.rateWindow)
+	kept := times[:0]
+	for _, t := range times {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		delete(pm.recent, userID)
+		return nil
+	}
+	pm.recent[userID] = kept
+	return kept
 }
 
 func (pm *powManager) Issue(userID int, challengeName, purpose string) (powChallenge, error) {
@@ -118,12 +251,13 @@ func (pm *powManager) Issue(userID int, challengeName, purpose string) (powChall
 	}
 	target := base64.RawURLEncoding.EncodeToString(targetBytes)
 	expiresAt := time.Now().Add(pm.ttl).Unix()
+	difficulty := pm.Difficulty(userID, purpose)
 
-	sig := pm.sign(userID, challengeName, purpose, target, expiresAt, pm.difficulty)
+	sig := pm.sign(pm.secrets[0], userID, challengeName, purpose, target, expiresAt, difficulty)
 
 	return powChallenge{
 		Target:     target,
-		Difficulty: pm.difficulty,
+		Difficulty: difficulty,
 		ExpiresAt:  expiresAt,
 		Purpose:    purpose,
 		Signature:  sig,
@@ -131,6 +265,15 @@ func (pm *powManager) Issue(userID int, challengeName, purpose string) (powChall
 }
 
 func (pm *powManager) Verify(userID int, challengeName string, proof powProof, purpose string) error {
+	err := pm.verify(userID, challengeName, proof, purpose)
+	if errors.Is(err, errPowReplayStore) {
+		slog.Error("pow: replay check failed", "err", err)
+	}
+	metricPowVerifications.WithLabelValues(purpose, powOutcome(err)).Inc()
+	return err
+}
+
+func (pm *powManager) verify(userID int, challengeName string, proof powProof, purpose string) error {
 	if pm == nil {
 		return errPowConfiguration
 	}
@@ -140,7 +283,10 @@ func (pm *powManager) Verify(userID int, challengeName string, proof powProof, p
 	if proof.Purpose != purpose {
 		return errPowInvalid
 	}
-	if proof.Difficulty != pm.difficulty {
+	// The signature binds the difficulty that was issued, so any value we
+	// signed is acceptable while the token is still valid, even if the
+	// current policy would ask for more or fewer bits.
+	if proof.Difficulty < 1 || proof.Difficulty > maxPowDifficultyBits {
 		return errPowDifficulty
 	}
 	now := time.Now().Unix()
@@ -148,8 +294,7 @@ func (pm *powManager) Verify(userID int, challengeName string, proof powProof, p
 		return errPowExpired
 	}
 
-	expectedSig := pm.sign(userID, challengeName, purpose, proof.Target, proof.ExpiresAt, proof.Difficulty)
-	if !hmacEqual(expectedSig, proof.Signature) {
+	if !pm.signatureValid(userID, challengeName, purpose, proof) {
 		return errPowMismatch
 	}
 
@@ -160,26 +305,41 @@ func (pm *powManager) Verify(userID int, challengeName string, proof powProof, p
 
 	key := base64.RawURLEncoding.EncodeToString(hashed[:])
 
+	if err := pm.replay.MarkUsed(key, time.Unix(proof.ExpiresAt, 0)); err != nil {
+		return err
+	}
+
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	pm.cleanupLocked(time.Now())
-	if _, ok := pm.used[key]; ok {
-		return errPowReuse
-	}
-	pm.used[key] = time.Unix(proof.ExpiresAt, 0)
+	pm.recordProofLocked(userID, time.Now())
 	return nil
 }
 
-func (pm *powManager) cleanupLocked(now time.Time) {
-	for k, expiry := range pm.used {
-		if expiry.Before(now) {
-			delete(pm.used, k)
+// signatureValid checks the proof against every configured key so tokens
+// signed before a rotation keep working until they expire.
+func (pm *powManager) signatureValid(userID int, challengeName, purpose string, proof powProof) bool {
+	for _, secret := range pm.secrets {
+		expected := pm.sign(secret, userID, challengeName, purpose, proof.Target, proof.ExpiresAt, proof.Difficulty)
+		if hmacEqual(expected, proof.Signature) {
+			return true
 		}
 	}
+	return false
+}
+
+func (pm *powManager) recordProofLocked(userID int, now time.Time) {
+	pm.recent[userID] = append(pm.pruneRecentLocked(userID, now), now)
+}
+
+func (pm *powManager) cleanupLocked(now time.Time) {
+	for userID := range pm.recent {
+		pm.pruneRecentLocked(userID, now)
+	}
 }
 
-func (pm *powManager) sign(userID int, challengeName, purpose, target string, expiresAt int64, difficulty int) string {
-	mac := hmac.New(sha256.New, pm.secret)
+func (pm *powManager) sign(secret []byte, userID int, challengeName, purpose, target string, expiresAt int64, difficulty int) string {
+	mac := hmac.New(sha256.New, secret)
 	data := fmt.Sprintf("%d|%s|%s|%s|%d|%d", userID, challengeName, purpose, target, expiresAt, difficulty)
 	mac.Write([]byte(data))
 	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
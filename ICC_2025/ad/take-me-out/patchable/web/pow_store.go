@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// powReplayStore remembers which proof-of-work solutions were already spent.
+// Implementations must be safe for concurrent use; the database store makes
+// the replay check hold across every web replica sharing the database.
+type powReplayStore interface {
+	// MarkUsed records key as spent until expiresAt. It returns errPowReuse
+	// when the key was already recorded and has not expired yet.
+	MarkUsed(key string, expiresAt time.Time) error
+}
+
+// memoryReplayStore keeps spent keys in process memory (single replica, tests).
+type memoryReplayStore struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func newMemoryReplayStore() *memoryReplayStore {
+	return &memoryReplayStore{used: make(map[string]time.Time)}
+}
+
+func (s *memoryReplayStore) MarkUsed(key string, expiresAt time.Time) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, expiry := range s.used {
+		if expiry.Before(now) {
+			delete(s.used, k)
+		}
+	}
+	if _, ok := s.used[key]; ok {
+		return errPowReuse
+	}
+	s.used[key] = expiresAt
+	return nil
+}
+
+// sqlReplayStore records spent keys in the pow_used_nonces table.
+type sqlReplayStore struct {
+	db *dbConn
+}
+
+// newSQLReplayStore returns a store in conn that deletes expired keys every
+// cleanupEvery until ctx is done.
+func newSQLReplayStore(ctx context.Context, conn *dbConn, cleanupEvery time.Duration) *sqlReplayStore {
+	s := &sqlReplayStore{db: conn}
+	if cleanupEvery > 0 {
+		go s.cleanupLoop(ctx, cleanupEvery)
+	}
+	return s
+}
+
+func (s *sqlReplayStore) MarkUsed(key string, expiresAt time.Time) error {
+	res, err := s.db.Exec(`
+        INSERT INTO pow_used_nonces(nonce_key, expires_at)
+        VALUES($1, $2)
+        ON CONFLICT (nonce_key) DO UPDATE
+          SET expires_at = EXCLUDED.expires_at
+          WHERE pow_used_nonces.expires_at < $3`, key, expiresAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPowReplayStore, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPowReplayStore, err)
+	}
+	if n == 0 {
+		return errPowReuse
+	}
+	return nil
+}
+
+func (s *sqlReplayStore) cleanupLoop(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM pow_used_nonces WHERE expires_at < $1`, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Warn("pow: replay store cleanup failed", "err", err)
+		}
+	}
+}
+
+// newPowReplayStoreFromEnv selects the replay backend from POW_REPLAY_STORE
+// ("db" by default, which uses the web database whatever its driver, or
+// "memory"). "postgres" is still accepted as an alias of "db".
+func newPowReplayStoreFromEnv(ctx context.Context, conn *dbConn) (powReplayStore, error) {
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("POW_REPLAY_STORE"))); kind {
+	case "", "db", "postgres":
+		if conn == nil {
+			return nil, fmt.Errorf("pow replay store: database is not initialized")
+		}
+		every := time.Duration(envIntWithClamp("POW_REPLAY_CLEANUP_SECONDS", 60, 1, 3600)) * time.Second
+		return newSQLReplayStore(ctx, conn, every), nil
+	case "memory":
+		return newMemoryReplayStore(), nil
+	default:
+		return nil, fmt.Errorf("pow replay store: unsupported backend %q", kind)
+	}
+}
+
+// loadPowSecrets returns the HMAC keys used for proof-of-work challenges.
+// POW_SECRETS holds a comma-separated list: the first key signs new
+// challenges, the remaining ones are still accepted so a rotation does not
+// invalidate tokens that are already in flight. POW_SECRET is accepted as a
+// single-key shorthand. Keys may be given as hex or used verbatim. Without
+// any configuration a random per-process key is generated, which only works
+// for a single replica.
+func loadPowSecrets() ([][]byte, error) {
+	raw := strings.TrimSpace(os.Getenv("POW_SECRETS"))
+	if raw == "" {
+		raw = strings.TrimSpace(os.Getenv("POW_SECRET"))
+	}
+	var secrets [][]byte
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if decoded, err := hex.DecodeString(part); err == nil && len(decoded) >= 16 {
+			secrets = append(secrets, decoded)
+			continue
+		}
+		secrets = append(secrets, []byte(part))
+	}
+	if len(secrets) > 0 {
+		return secrets, nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("pow secret: %w", err)
+	}
+	slog.Warn("pow: POW_SECRETS not set, using a random key; challenges will not verify on other replicas")
+	return [][]byte{secret}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testSQLiteConn opens a migrated SQLite database in a temporary directory.
+func testSQLiteConn(t *testing.T) *dbConn {
+	t.Helper()
+	conn, err := openSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReplayStores(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for name, open := range map[string]func(t *testing.T) powReplayStore{
+		"memory": func(t *testing.T) powReplayStore { return newMemoryReplayStore() },
+		"sqlite": func(t *testing.T) powReplayStore { return newSQLReplayStore(ctx, testSQLiteConn(t), time.Hour) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			later := time.Now().Add(time.Minute)
+			for _, tc := range []struct {
+				key       string
+				expiresAt time.Time
+				want      error
+			}{
+				{"a", later, nil},
+				{"a", later, errPowReuse},
+				{"b", later, nil},
+				// an expired key may be spent again
+				{"old", time.Now().Add(-time.Minute), nil},
+				{"old", later, nil},
+				{"old", later, errPowReuse},
+			} {
+				if err := store.MarkUsed(tc.key, tc.expiresAt); !errors.Is(err, tc.want) {
+					t.Errorf("MarkUsed(%q) = %v, want %v", tc.key, err, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLReplayStoreError(t *testing.T) {
+	conn := testSQLiteConn(t)
+	store := newSQLReplayStore(context.Background(), conn, 0)
+	conn.Close()
+	err := store.MarkUsed("a", time.Now().Add(time.Minute))
+	if !errors.Is(err, errPowReplayStore) || errors.Is(err, errPowConfiguration) {
+		t.Errorf("MarkUsed on a closed database = %v, want %v", err, errPowReplayStore)
+	}
+	if got := powOutcome(err); got != "store_error" {
+		t.Errorf("powOutcome = %q", got)
+	}
+}
+
+func TestPowReplayAcrossReplicas(t *testing.T) {
+	// two replicas share the replay store and the key list; the second
+	// has rotated in a new signing key
+	replay := newMemoryReplayStore()
+	t.Setenv("POW_DIFFICULTY_BITS", "2")
+	first, err := newPowManager([][]byte{[]byte("old-key")}, replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newPowManager([][]byte{[]byte("new-key"), []byte("old-key")}, replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := first.Issue(1, "sum", powPurposeSubmission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := solvePow(t, c)
+	if err := second.Verify(1, "sum", proof, powPurposeSubmission); err != nil {
+		t.Fatalf("Verify on the rotated replica = %v", err)
+	}
+	if err := first.Verify(1, "sum", proof, powPurposeSubmission); !errors.Is(err, errPowReuse) {
+		t.Errorf("replay on the other replica = %v, want %v", err, errPowReuse)
+	}
+
+	// a key that was dropped from the list no longer verifies
+	third, err := newPowManager([][]byte{[]byte("new-key")}, newMemoryReplayStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := third.Verify(1, "sum", proof, powPurposeSubmission); !errors.Is(err, errPowMismatch) {
+		t.Errorf("Verify with a retired key = %v, want %v", err, errPowMismatch)
+	}
+}
+
+func TestLoadPowSecrets(t *testing.T) {
+	hexKey := "000102030405060708090a0b0c0d0e0f"
+	for _, tc := range []struct {
+		secrets, secret string
+		want            []string
+	}{
+		{"a, b ,,c", "", []string{"a", "b", "c"}},
+		{"", "single", []string{"single"}},
+		{"list", "single", []string{"list"}},
+		{hexKey, "", []string{"\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f"}},
+		// short hex is taken verbatim
+		{"abcd", "", []string{"abcd"}},
+	} {
+		t.Setenv("POW_SECRETS", tc.secrets)
+		t.Setenv("POW_SECRET", tc.secret)
+		got, err := loadPowSecrets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("POW_SECRETS=%q POW_SECRET=%q: got %d keys, want %d", tc.secrets, tc.secret, len(got), len(tc.want))
+			continue
+		}
+		for i := range got {
+			if string(got[i]) != tc.want[i] {
+				t.Errorf("POW_SECRETS=%q: key %d = %q, want %q", tc.secrets, i, got[i], tc.want[i])
+			}
+		}
+	}
+
+	t.Setenv("POW_SECRETS", "")
+	t.Setenv("POW_SECRET", "")
+	got, err := loadPowSecrets()
+	if err != nil || len(got) != 1 || len(got[0]) != 32 {
+		t.Errorf("without configuration got %d keys, %v; want one random key", len(got), err)
+	}
+}
@@ -1,16 +1,35 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe/internal/logging"
+	"goexe/internal/tracing"
+)
+
+// Workers stop claiming once stopClaiming is closed; jobCtx is cancelled
+// when the shutdown deadline passes, aborting the runs still in progress.
+var (
+	workersWG    sync.WaitGroup
+	stopClaiming = make(chan struct{})
+	stopOnce     sync.Once
+
+	jobCtx, cancelJobs = context.WithCancel(context.Background())
 )
 
 // startSubmissionWorkersFromEnv starts FIFO workers based on env var WORKER_CONCURRENCY
-func startSubmissionWorkersFromEnv() {
+func (s *server) startSubmissionWorkersFromEnv() {
 	n := runtime.NumCPU()
 	if v := os.Getenv("WORKER_CONCURRENCY"); v != "" {
 		if x, e := strconv.Atoi(v); e == nil && x > 0 {
@@ -23,88 +42,198 @@ func startSubmissionWorkersFromEnv() {
 		}
 	}
 	for i := 0; i < n; i++ {
-		go submissionWorkerLoop(i)
+		workersWG.Add(1)
+		go s.submissionWorkerLoop(i)
 	}
-	log.Printf("Started %d submission worker(s)", n)
+	slog.Info("submission workers started", "workers", n)
 }
 
-// submissionWorkerLoop claims oldest Pending submission and processes it via runner
-func submissionWorkerLoop(workerID int) {
+// stopSubmissionWorkers stops workers from claiming new submissions and
+// waits for the ones in progress until ctx is done. Runs still going at that
+// point are cancelled and their submissions put back to Pending, so no row
+// is left Running when the process exits.
+func stopSubmissionWorkers(ctx context.Context) {
+	stopOnce.Do(func() { close(stopClaiming) })
+	done := make(chan struct{})
+	go func() {
+		workersWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	slog.Warn("submission workers still busy at shutdown deadline, requeueing their jobs")
+	cancelJobs()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		slog.Error("submission workers did not stop")
+	}
+}
+
+// idle waits d, returning false early when workers are being stopped.
+func idle(d time.Duration) bool {
+	select {
+	case <-stopClaiming:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// submissionWorkerLoop claims the next Pending submission in fair-share order
+// (see nextPendingQuery) and processes it via runner
+func (s *server) submissionWorkerLoop(workerID int) {
+	defer workersWG.Done()
 	for {
-		job, ok, err := claimNextPending()
+		select {
+		case <-stopClaiming:
+			return
+		default:
+		}
+		job, ok, err := s.store.ClaimNextPending()
 		if err != nil {
-			log.Printf("[worker %d] claim error: %v", workerID, err)
-			time.Sleep(500 * time.Millisecond)
+			slog.Error("submission claim failed", "worker", workerID, "err", err)
+			idle(500 * time.Millisecond)
 			continue
 		}
 		if !ok {
-			time.Sleep(200 * time.Millisecond)
+			idle(200 * time.Millisecond)
+			continue
+		}
+		metricClaimLatency.Observe(time.Since(job.CreatedAt).Seconds())
+		// Runner and sandbox logs for this job carry the submitting request's
+		// ID, and its spans join the submitting request's trace
+		ctx := logging.WithRequestID(jobCtx, job.RequestID)
+		ctx = tracing.WithTraceparent(ctx, job.TraceParent)
+		_, wait := tracer.Start(ctx, "queue.wait", trace.WithTimestamp(job.CreatedAt), trace.WithAttributes(attribute.Int("submission.id", job.ID)))
+		wait.End()
+		ctx, span := tracer.Start(ctx, "judge", trace.WithAttributes(
+			attribute.Int("submission.id", job.ID), attribute.Int("worker", workerID),
+			attribute.String("language", job.Language), attribute.String("challenge", job.Challenge)))
+		result, durationMs, failIdx, lastOut, expect, err := s.executeSubmission(ctx, int64(job.ID), job.Challenge, job.Language, job.Code)
+		if err != nil {
+			tracing.RecordError(span, err)
+			span.End()
+			// No runner took the job, or we are shutting down: put it back
+			// for another worker
+			if err := s.store.RequeueSubmission(job.ID); err != nil {
+				slog.ErrorContext(ctx, "submission requeue failed", "worker", workerID, "submission", job.ID, "err", err)
+			}
+			idle(time.Second)
 			continue
 		}
-		// Execute via runner
-		id := time.Now().UnixNano()
-		result, durationMs, failIdx, lastOut, expect := executeSubmission(id, job.Challenge, job.Language, job.Code)
-		// Update DB
-		if err := updateSubmissionAfterRun(job.ID, result, durationMs, failIdx, lastOut, expect); err != nil {
-			log.Printf("[worker %d] update submission %d failed: %v", workerID, job.ID, err)
+		span.SetAttributes(attribute.String("result", result), attribute.Int("duration_ms", durationMs))
+		// Update DB; a verdict that made it back is kept even if shutdown
+		// cancelled ctx in the meantime
+		if err := s.store.UpdateSubmissionAfterRun(context.WithoutCancel(ctx), job.ID, result, durationMs, failIdx, lastOut, expect); err != nil {
+			slog.ErrorContext(ctx, "submission update failed", "worker", workerID, "submission", job.ID, "err", err)
 		}
+		span.End()
+		slog.InfoContext(ctx, "submission judged", "worker", workerID, "submission", job.ID, "challenge", job.Challenge, "language", job.Language, "result", result, "duration_ms", durationMs)
+		metricVerdicts.WithLabelValues(job.Language, result).Inc()
+		metricJudgeLatency.WithLabelValues(job.Language).Observe(time.Since(job.CreatedAt).Seconds())
 		if result == "Success" {
 			// best-effort solve record
-			if err := ensureSolve(job.UserID, job.Challenge, time.Now()); err != nil {
-				log.Printf("[worker %d] ensureSolve failed: %v", workerID, err)
+			if err := s.store.EnsureSolve(job.UserID, job.Challenge, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "recording solve failed", "worker", workerID, "submission", job.ID, "err", err)
 			}
 		}
 	}
 }
 
 type pendingJob struct {
-	ID        int
-	UserID    int
-	Challenge string
-	Language  string
-	Code      string
+	ID          int
+	UserID      int
+	Challenge   string
+	Language    string
+	Code        string
+	CreatedAt   time.Time
+	RequestID   string
+	TraceParent string
 }
 
-// claimNextPending atomically selects the oldest Pending submission and marks it Running
-func claimNextPending() (pendingJob, bool, error) {
-	var job pendingJob
-	tx, err := db.Begin()
-	if err != nil {
-		return job, false, err
-	}
-	defer func() {
-		// ensure rollback on early returns
-		_ = tx.Rollback()
-	}()
+// nextPendingQuery selects the submission the next free worker should
+// judge. Jobs are handed out round-robin across users: only each user's
+// oldest pending submission is a candidate, and the user whose last claim is
+// oldest (or who was never served) goes first, so a single user cannot
+// starve the queue by submitting in bulk. Higher priority (admin or contest
+// submissions) is served first. Last claims are only looked up for users
+// with pending work, through idx_submissions_user_claimed, so the query
+// costs what the backlog does rather than the whole history. Each dialect
+// claims the row its own way and sets claimed_at.
+const nextPendingQuery = `
+        SELECT s.id
+        FROM submissions s
+        JOIN (
+            SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY created_at ASC, id ASC) AS user_rank
+            FROM submissions
+            WHERE result = 'Pending'
+        ) q ON q.id = s.id
+        LEFT JOIN (
+            SELECT user_id, MAX(claimed_at) AS last_claimed
+            FROM submissions
+            WHERE claimed_at IS NOT NULL
+              AND user_id IN (SELECT user_id FROM submissions WHERE result = 'Pending')
+            GROUP BY user_id
+        ) u ON u.user_id = s.user_id
+        WHERE s.result = 'Pending'
+        ORDER BY s.priority DESC, q.user_rank ASC, u.last_claimed ASC NULLS FIRST, s.created_at ASC, s.id ASC
+        LIMIT 1`
+
+// pendingJobColumns are scanned by scanPendingJob, in order.
+const pendingJobColumns = `id, user_id, challenge, language, code, created_at, request_id, trace_parent`
 
-	row := tx.QueryRow(`
-        SELECT id, user_id, challenge, language, code
-        FROM submissions
-        WHERE result = 'Pending'
-        ORDER BY created_at ASC
-        LIMIT 1
-        FOR UPDATE SKIP LOCKED`)
-	var id, uid int
-	var chal, lang, code string
-	if err := row.Scan(&id, &uid, &chal, &lang, &code); err != nil {
+func scanPendingJob(row *sql.Row) (pendingJob, bool, error) {
+	var job pendingJob
+	if err := row.Scan(&job.ID, &job.UserID, &job.Challenge, &job.Language, &job.Code, &job.CreatedAt, &job.RequestID, &job.TraceParent); err != nil {
 		if err == sql.ErrNoRows {
-			return job, false, nil
+			return pendingJob{}, false, nil
 		}
-		return job, false, err
+		return pendingJob{}, false, err
 	}
-	if _, err := tx.Exec(`UPDATE submissions SET result = 'Running' WHERE id = $1 AND result = 'Pending'`, id); err != nil {
-		return job, false, err
+	return job, true, nil
+}
+
+// ClaimNextPending atomically picks the next Pending submission (see
+// nextPendingQuery) and marks it Running.
+func (s *sqlStore) ClaimNextPending() (pendingJob, bool, error) {
+	return s.db.dialect.claimNextPending(s.db)
+}
+
+// submissionPriority returns the queue priority for a new submission. Admins
+// get QUEUE_PRIORITY_ADMIN, submissions to challenges listed in
+// QUEUE_PRIORITY_CHALLENGES (comma-separated, e.g. the current contest set)
+// get QUEUE_PRIORITY_CONTEST; everything else is 0.
+func submissionPriority(user *User, challenge string) int {
+	priority := 0
+	if user != nil && user.IsAdmin {
+		priority = envIntWithClamp("QUEUE_PRIORITY_ADMIN", 10, 0, 1000)
 	}
-	if err := tx.Commit(); err != nil {
-		return job, false, err
+	for _, name := range strings.Split(os.Getenv("QUEUE_PRIORITY_CHALLENGES"), ",") {
+		if name = strings.TrimSpace(name); name != "" && name == challenge {
+			if boost := envIntWithClamp("QUEUE_PRIORITY_CONTEST", 5, 0, 1000); boost > priority {
+				priority = boost
+			}
+			break
+		}
 	}
-	job = pendingJob{ID: id, UserID: uid, Challenge: chal, Language: lang, Code: code}
-	return job, true, nil
+	return priority
+}
+
+// RequeueSubmission returns a Running submission to the Pending queue
+func (s *sqlStore) RequeueSubmission(id int) error {
+	_, err := s.db.Exec(`UPDATE submissions SET result = 'Pending' WHERE id = $1 AND result = 'Running'`, id)
+	return err
 }
 
-// updateSubmissionAfterRun writes the final result and details
-func updateSubmissionAfterRun(id int, result string, durationMs, failIdx int, lastOut, expect string) error {
-	_, err := db.Exec(`
+// UpdateSubmissionAfterRun writes the final result and details
+func (s *sqlStore) UpdateSubmissionAfterRun(ctx context.Context, id int, result string, durationMs, failIdx int, lastOut, expect string) error {
+	ctx, span := tracer.Start(ctx, "db.update_submission")
+	defer span.End()
+	_, err := s.db.ExecContext(ctx, `
         UPDATE submissions
         SET result = $1,
             execution_time_ms = $2,
@@ -113,5 +242,6 @@ func updateSubmissionAfterRun(id int, result string, durationMs, failIdx int, la
             expected_output = $5
         WHERE id = $6
     `, result, durationMs, failIdx, lastOut, expect, id)
+	tracing.RecordError(span, err)
 	return err
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClaimNextPendingOrder(t *testing.T) {
+	st := newSQLStore(testSQLiteConn(t))
+	users := map[string]int{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u, err := st.CreateUser(name, "pw", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = u.ID
+	}
+	if _, err := st.CreateChallenge(users["alice"], "sum", "Add", 100, true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice floods the queue first; bob and carol come later, and carol's
+	// last submission has a priority boost
+	start := time.Now().Add(-time.Hour)
+	queue := []struct {
+		user     string
+		priority int
+		result   string
+	}{
+		{"alice", 0, "Pending"},
+		{"alice", 0, "Pending"},
+		{"alice", 0, "Pending"},
+		{"bob", 0, "Pending"},
+		{"alice", 0, "Accepted"},
+		{"bob", 0, "Pending"},
+		{"carol", 0, "Running"},
+		{"carol", 0, "Pending"},
+		{"carol", 5, "Pending"},
+	}
+	ids := make([]int, len(queue))
+	for i, q := range queue {
+		id, err := st.CreateSubmission(context.Background(), Submission{
+			UserID:    users[q.user],
+			Challenge: "sum",
+			Language:  "python",
+			Code:      "print(" + strconv.Itoa(i) + ")",
+			Result:    q.result,
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+			Priority:  q.priority,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	// priority first, then the oldest pending submission of whichever user
+	// was served least recently: claiming alice's first does not put her
+	// second ahead of bob
+	want := []int{ids[8], ids[0], ids[3], ids[7], ids[1], ids[5], ids[2]}
+	for i, id := range want {
+		job, ok, err := st.ClaimNextPending()
+		if err != nil || !ok {
+			t.Fatalf("claim %d: %v, %v", i, ok, err)
+		}
+		if job.ID != id {
+			t.Fatalf("claim %d got submission %d, want %d", i, job.ID, id)
+		}
+		sub, err := st.GetSubmissionStatusByID(job.ID)
+		if err != nil || sub.Result != "Running" {
+			t.Fatalf("claimed submission is %q, %v", sub.Result, err)
+		}
+	}
+	if job, ok, err := st.ClaimNextPending(); ok || err != nil {
+		t.Fatalf("claimed %d from an empty queue, %v", job.ID, err)
+	}
+
+	// a requeued submission goes back in line
+	if err := st.RequeueSubmission(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if job, ok, err := st.ClaimNextPending(); !ok || err != nil || job.ID != ids[2] {
+		t.Fatalf("claim after requeue got %d, %v, %v", job.ID, ok, err)
+	}
+}
+
+func TestSubmissionPriority(t *testing.T) {
+	t.Setenv("QUEUE_PRIORITY_ADMIN", "10")
+	t.Setenv("QUEUE_PRIORITY_CONTEST", "5")
+	t.Setenv("QUEUE_PRIORITY_CHALLENGES", "final, semi")
+	for _, tc := range []struct {
+		user      *User
+		challenge string
+		want      int
+	}{
+		{&User{}, "sum", 0},
+		{nil, "sum", 0},
+		{&User{}, "final", 5},
+		{&User{}, "semi", 5},
+		{&User{IsAdmin: true}, "sum", 10},
+		{&User{IsAdmin: true}, "final", 10},
+	} {
+		if got := submissionPriority(tc.user, tc.challenge); got != tc.want {
+			t.Errorf("submissionPriority(admin=%v, %q) = %d, want %d", tc.user != nil && tc.user.IsAdmin, tc.challenge, got, tc.want)
+		}
+	}
+}
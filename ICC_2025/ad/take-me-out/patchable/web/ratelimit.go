@@ -0,0 +1,197 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rateClassSubmission = "submission"
+	rateClassTest       = "test"
+)
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// tokenBucketLimiter keeps one bucket per key (user or client IP).
+type tokenBucketLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	lastGC  time.Time
+}
+
+func newTokenBucketLimiter(perMinute, burst int) *tokenBucketLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucketLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes one token for key. When the bucket is empty it reports how long
+// the caller has to wait until a token becomes available.
+func (l *tokenBucketLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gcLocked(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// refund gives back the token Allow took for key, for a request another
+// limit refused after all.
+func (l *tokenBucketLimiter) refund(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(l.burst, b.tokens+1)
+	}
+}
+
+// gcLocked drops buckets that have been idle long enough to be full again.
+func (l *tokenBucketLimiter) gcLocked(now time.Time) {
+	if now.Sub(l.lastGC) < time.Minute {
+		return
+	}
+	l.lastGC = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// requestRateLimiter applies a per-user and a per-IP bucket for each class of
+// judge-backed endpoint.
+type requestRateLimiter struct {
+	user map[string]*tokenBucketLimiter
+	ip   map[string]*tokenBucketLimiter
+}
+
+// newRequestRateLimiterFromEnv reads the RATE_LIMIT_* budgets.
+func newRequestRateLimiterFromEnv() *requestRateLimiter {
+	return &requestRateLimiter{
+		user: map[string]*tokenBucketLimiter{
+			rateClassSubmission: newTokenBucketLimiter(envIntWithClamp("RATE_LIMIT_SUBMIT_USER_PER_MINUTE", 6, 0, 100000), envIntWithClamp("RATE_LIMIT_SUBMIT_USER_BURST", 3, 1, 100000)),
+			rateClassTest:       newTokenBucketLimiter(envIntWithClamp("RATE_LIMIT_TEST_USER_PER_MINUTE", 20, 0, 100000), envIntWithClamp("RATE_LIMIT_TEST_USER_BURST", 5, 1, 100000)),
+		},
+		ip: map[string]*tokenBucketLimiter{
+			rateClassSubmission: newTokenBucketLimiter(envIntWithClamp("RATE_LIMIT_SUBMIT_IP_PER_MINUTE", 30, 0, 100000), envIntWithClamp("RATE_LIMIT_SUBMIT_IP_BURST", 10, 1, 100000)),
+			rateClassTest:       newTokenBucketLimiter(envIntWithClamp("RATE_LIMIT_TEST_IP_PER_MINUTE", 60, 0, 100000), envIntWithClamp("RATE_LIMIT_TEST_IP_BURST", 20, 1, 100000)),
+		},
+	}
+}
+
+// Allow checks both the user and the IP bucket for class. Admins bypass the
+// per-user limit but still share their IP bucket. A request the IP bucket
+// refuses costs the user nothing.
+func (rl *requestRateLimiter) Allow(class string, user *User, ip string) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+	now := time.Now()
+	userKey := ""
+	if user != nil && !user.IsAdmin {
+		userKey = strconv.Itoa(user.ID)
+		if ok, wait := rl.user[class].Allow(userKey, now); !ok {
+			return false, wait
+		}
+	}
+	if ip != "" {
+		if ok, wait := rl.ip[class].Allow(ip, now); !ok {
+			if userKey != "" {
+				rl.user[class].refund(userKey)
+			}
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// enforceRateLimit writes a 429 with Retry-After and returns false when the
+// request exceeds its budget.
+func (s *server) enforceRateLimit(w http.ResponseWriter, r *http.Request, user *User, class string, asJSON bool) bool {
+	ok, wait := s.limiter.Allow(class, user, clientIP(r))
+	if ok {
+		return true
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	if asJSON {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return false
+	}
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return false
+}
+
+// clientIP returns the peer address. Forwarding headers are only honoured
+// when TRUST_PROXY_HEADERS is enabled, since clients can set them freely.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders() {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first := strings.TrimSpace(strings.Split(fwd, ",")[0])
+			if first != "" {
+				return first
+			}
+		}
+		if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+			return real
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustProxyHeaders reports whether TRUST_PROXY_HEADERS is enabled.
+func trustProxyHeaders() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("TRUST_PROXY_HEADERS"))) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	for _, tc := range []struct {
+		name      string
+		perMinute int
+		burst     int
+		// offsets from start at which requests arrive, and whether each
+		// is allowed
+		at      []time.Duration
+		allowed []bool
+	}{
+		{"burst then empty", 60, 2, []time.Duration{0, 0, 0}, []bool{true, true, false}},
+		{"refills at rate", 60, 1, []time.Duration{0, 500 * time.Millisecond, time.Second}, []bool{true, false, true}},
+		{"refill is capped at burst", 60, 2, []time.Duration{0, 0, time.Hour, time.Hour, time.Hour}, []bool{true, true, true, true, false}},
+		{"disabled", 0, 1, []time.Duration{0, 0, 0}, []bool{true, true, true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTokenBucketLimiter(tc.perMinute, tc.burst)
+			for i, at := range tc.at {
+				ok, wait := l.Allow("k", start.Add(at))
+				if ok != tc.allowed[i] {
+					t.Fatalf("request %d at %v: allowed %v, want %v", i, at, ok, tc.allowed[i])
+				}
+				if !ok && wait <= 0 {
+					t.Errorf("request %d denied without a wait", i)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	l := newTokenBucketLimiter(30, 1)
+	now := time.Unix(1000, 0)
+	l.Allow("k", now)
+	ok, wait := l.Allow("k", now.Add(500*time.Millisecond))
+	if ok || wait != 1500*time.Millisecond {
+		t.Errorf("Allow = %v, %v; want a 1.5s wait", ok, wait)
+	}
+	// keys have their own buckets
+	if ok, _ := l.Allow("other", now); !ok {
+		t.Error("another key was limited")
+	}
+}
+
+func TestRequestRateLimiter(t *testing.T) {
+	rl := &requestRateLimiter{
+		user: map[string]*tokenBucketLimiter{rateClassSubmission: newTokenBucketLimiter(1, 1)},
+		ip:   map[string]*tokenBucketLimiter{rateClassSubmission: newTokenBucketLimiter(1, 3)},
+	}
+	alice := &User{ID: 1}
+	bob := &User{ID: 2}
+	admin := &User{ID: 3, IsAdmin: true}
+	carol := &User{ID: 4}
+	for i, tc := range []struct {
+		user *User
+		ip   string
+		want bool
+	}{
+		{alice, "10.0.0.1", true},
+		{alice, "10.0.0.2", false}, // per-user budget spent
+		{bob, "10.0.0.1", true},
+		{admin, "10.0.0.1", true},  // admins skip the user bucket
+		{admin, "10.0.0.1", false}, // but share the IP's
+		{carol, "10.0.0.1", false}, // refused by the IP limit...
+		{carol, "10.0.0.4", true},  // ...without spending her own budget
+		{nil, "10.0.0.3", true},
+	} {
+		if got, _ := rl.Allow(rateClassSubmission, tc.user, tc.ip); got != tc.want {
+			t.Errorf("request %d: allowed %v, want %v", i, got, tc.want)
+		}
+	}
+	var unset *requestRateLimiter
+	if ok, _ := unset.Allow(rateClassSubmission, alice, "10.0.0.1"); !ok {
+		t.Error("a nil limiter denied a request")
+	}
+}
+
+func TestEnforceRateLimit(t *testing.T) {
+	s := &server{limiter: &requestRateLimiter{
+		user: map[string]*tokenBucketLimiter{rateClassTest: newTokenBucketLimiter(1, 1)},
+		ip:   map[string]*tokenBucketLimiter{},
+	}}
+	user := &User{ID: 1}
+	r := httptest.NewRequest(http.MethodPost, "/test", nil)
+	if !s.enforceRateLimit(httptest.NewRecorder(), r, user, rateClassTest, true) {
+		t.Fatal("first request was limited")
+	}
+	rec := httptest.NewRecorder()
+	if s.enforceRateLimit(rec, r, user, rateClassTest, true) {
+		t.Fatal("second request was allowed")
+	}
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("got %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		trust   string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "192.0.2.1"},
+		{"true", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9, 10.0.0.1"}, "203.0.113.9"},
+		{"true", "192.0.2.1:1234", map[string]string{"X-Real-IP": " 203.0.113.7 "}, "203.0.113.7"},
+		{"true", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"", "no-port", nil, "no-port"},
+	} {
+		t.Setenv("TRUST_PROXY_HEADERS", tc.trust)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		if got := clientIP(r); got != tc.want {
+			t.Errorf("trust=%q remote=%q headers=%v: clientIP = %q, want %q", tc.trust, tc.remote, tc.headers, got, tc.want)
+		}
+	}
+}
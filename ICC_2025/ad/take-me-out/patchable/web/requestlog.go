@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe/internal/logging"
+	"goexe/internal/tracing"
+)
+
+var tracer = otel.Tracer("goexe")
+
+// withRequestID gives every request an ID for log correlation. An incoming
+// X-Request-ID is reused when TRUST_PROXY_HEADERS is enabled and it is well
+// formed; otherwise a fresh one is generated. The ID is echoed in the
+// response, stored in the request context (and from there on submissions and
+// runner requests) and logged with the request once it completes.
+//
+// Each request is also a server span. An incoming traceparent is only
+// continued under TRUST_PROXY_HEADERS, like the request ID.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.HeaderRequestID)
+		if !trustProxyHeaders() || !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.HeaderRequestID, id)
+		ctx := logging.WithRequestID(r.Context(), id)
+		if trustProxyHeaders() {
+			ctx = tracing.Extract(ctx, r.Header)
+		}
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("http.method", r.Method), attribute.String("url.path", r.URL.Path), attribute.String("request_id", id)))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		r = r.WithContext(ctx)
+		next.ServeHTTP(sw, r)
+		// the mux fills in the matched pattern on r as it routes
+		span.SetAttributes(attribute.String("http.route", r.Pattern), attribute.Int("http.status_code", sw.status))
+		if sw.status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("status %d", sw.status))
+		}
+		span.End()
+		level := slog.LevelDebug
+		if sw.status >= 500 {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote", clientIP(r))
+	})
+}
+
+// statusWriter records the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goexe/internal/logging"
+	"goexe/internal/tracing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+	t.Setenv("LOG_LEVEL", "debug")
+
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	for _, tc := range []struct {
+		name, trust, incoming string
+		reused                bool
+	}{
+		{"untrusted", "", "from-client", false},
+		{"trusted", "true", "from-proxy", true},
+		{"trusted but malformed", "true", "bad id\n", false},
+		{"trusted without one", "true", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY_HEADERS", tc.trust)
+			var buf bytes.Buffer
+			logging.SetupWriter(&buf, "web")
+
+			r := httptest.NewRequest("GET", "/x", nil)
+			if tc.incoming != "" {
+				r.Header.Set(logging.HeaderRequestID, tc.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			echoed := rec.Header().Get(logging.HeaderRequestID)
+			if !logging.ValidRequestID(echoed) || seen != echoed {
+				t.Fatalf("echoed %q, handler saw %q", echoed, seen)
+			}
+			if (echoed == tc.incoming) != tc.reused {
+				t.Errorf("incoming %q, echoed %q", tc.incoming, echoed)
+			}
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q: %v", buf.String(), err)
+			}
+			if line["msg"] != "http request" || line["request_id"] != echoed || line["status"] != float64(http.StatusTeapot) || line["path"] != "/x" {
+				t.Errorf("log line %v", line)
+			}
+		})
+	}
+}
+
+func TestWithRequestIDContinuesTrustedTrace(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = tracing.Traceparent(r.Context())
+	}))
+	for trust, want := range map[string]string{"": "", "true": parent} {
+		t.Setenv("TRUST_PROXY_HEADERS", trust)
+		r := httptest.NewRequest("GET", "/x", nil)
+		r.Header.Set(tracing.HeaderTraceparent, parent)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if seen != want {
+			t.Errorf("TRUST_PROXY_HEADERS=%q: handler saw trace %q, want %q", trust, seen, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"goexe/internal/runnerclient"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// startRunnerDiscovery keeps the runner client's endpoint set in sync with
+// the runner_nodes registry when RUNNER_DISCOVERY=db. Runners that stopped
+// heartbeating for RUNNER_STALE_MS or are draining drop out of rotation; when
+// none are registered the static RUNNER_ENDPOINTS are used.
+func (s *server) startRunnerDiscovery() {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("RUNNER_DISCOVERY"))) != "db" {
+		return
+	}
+	every := time.Duration(envIntWithClamp("RUNNER_DISCOVERY_INTERVAL_MS", 2000, 100, 60000)) * time.Millisecond
+	stale := time.Duration(envIntWithClamp("RUNNER_STALE_MS", 10000, 1000, 600000)) * time.Millisecond
+	go func() {
+		for {
+			nodes, err := s.store.ListLiveRunners(stale)
+			if err != nil {
+				slog.Warn("runner discovery failed", "err", err)
+			} else {
+				s.runner.SetEndpoints(nodes)
+			}
+			time.Sleep(every)
+		}
+	}()
+}
+
+// ListLiveRunners returns registered runners that are accepting work and
+// have heartbeated within stale.
+func (s *sqlStore) ListLiveRunners(stale time.Duration) ([]runnerclient.Endpoint, error) {
+	rows, err := s.db.Query(`
+        SELECT url, workers + queue_size, queued + running, languages
+        FROM runner_nodes
+        WHERE NOT draining AND last_seen > $1`, time.Now().Add(-stale))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var nodes []runnerclient.Endpoint
+	for rows.Next() {
+		var ep runnerclient.Endpoint
+		var langs string
+		if err := rows.Scan(&ep.URL, &ep.Capacity, &ep.Load, &langs); err != nil {
+			return nil, err
+		}
+		for _, l := range strings.Split(langs, ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				ep.Languages = append(ep.Languages, l)
+			}
+		}
+		nodes = append(nodes, ep)
+	}
+	return nodes, rows.Err()
+}
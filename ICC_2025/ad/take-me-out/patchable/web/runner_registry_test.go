@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"goexe/internal/runnerclient"
+)
+
+func TestListLiveRunners(t *testing.T) {
+	conn := testSQLiteConn(t)
+	st := newSQLStore(conn)
+	now := time.Now().UTC()
+	for _, n := range []struct {
+		id, url, languages string
+		workers, queue     int
+		queued, running    int
+		draining           bool
+		lastSeen           time.Time
+	}{
+		{"a", "http://a:9000", "python, ruby", 4, 8, 1, 2, false, now},
+		{"b", "http://b:9000", "", 2, 2, 0, 0, false, now.Add(-5 * time.Second)},
+		{"draining", "http://c:9000", "c", 4, 4, 0, 0, true, now},
+		{"stale", "http://d:9000", "c", 4, 4, 0, 0, false, now.Add(-time.Minute)},
+	} {
+		if _, err := conn.Exec(`INSERT INTO runner_nodes(id, url, workers, queue_size, queued, running, languages, draining, last_seen)
+            VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)`, n.id, n.url, n.workers, n.queue, n.queued, n.running, n.languages, n.draining, n.lastSeen); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := st.ListLiveRunners(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].URL < got[j].URL })
+	want := []runnerclient.Endpoint{
+		{URL: "http://a:9000", Capacity: 12, Load: 3, Languages: []string{"python", "ruby"}},
+		{URL: "http://b:9000", Capacity: 4, Load: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListLiveRunners = %+v, want %+v", got, want)
+	}
+}
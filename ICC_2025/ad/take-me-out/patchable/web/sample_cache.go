@@ -0,0 +1,227 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cachedSampleResult struct {
+	Result     string
+	DurationMs int
+	FailIdx    int
+	Output     string
+	Expect     string
+	CachedAt   time.Time
+}
+
+// sampleResultStore caches sample-test verdicts keyed by user, challenge,
+// language and code hash. Entries carry the challenge name so that editing a
+// challenge's sample cases can drop every result computed against the old set.
+type sampleResultStore interface {
+	Get(key string) (cachedSampleResult, bool)
+	Set(key, challenge string, entry cachedSampleResult)
+	InvalidateChallenge(challenge string) error
+}
+
+// newSampleCacheFromEnv selects the sample result backend from SAMPLE_CACHE_BACKEND
+// ("memory" by default, or "db" to keep results in the web database, shared
+// across replicas and restarts; "postgres" is an alias of "db"). SAMPLE_CACHE_TTL_SECONDS and SAMPLE_CACHE_MAX_ENTRIES tune it.
+// The database cache's cleanup stops with ctx.
+func newSampleCacheFromEnv(ctx context.Context, conn *dbConn) (sampleResultStore, error) {
+	ttl := time.Duration(envIntWithClamp("SAMPLE_CACHE_TTL_SECONDS", 30, 0, 86400)) * time.Second
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("SAMPLE_CACHE_BACKEND"))); kind {
+	case "", "memory":
+		return newMemorySampleCache(envIntWithClamp("SAMPLE_CACHE_MAX_ENTRIES", 1024, 0, 1000000), ttl), nil
+	case "db", "postgres":
+		if conn == nil {
+			return nil, fmt.Errorf("sample cache: database is not initialized")
+		}
+		every := time.Duration(envIntWithClamp("SAMPLE_CACHE_CLEANUP_SECONDS", 60, 1, 3600)) * time.Second
+		return newSQLSampleCache(ctx, conn, ttl, every), nil
+	default:
+		return nil, fmt.Errorf("sample cache: unsupported backend %q", kind)
+	}
+}
+
+func makeSampleResultCacheKey(userID, challengeID int, language, code string) string {
+	hashed := sha256.Sum256([]byte(code))
+	return fmt.Sprintf("%d:%d:%s:%s", userID, challengeID, language, hex.EncodeToString(hashed[:]))
+}
+
+func (s *server) lookupSampleResult(key string) (cachedSampleResult, bool) {
+	return s.samples.Get(key)
+}
+
+func (s *server) storeSampleResult(key, challenge string, entry cachedSampleResult) {
+	entry.CachedAt = time.Now()
+	s.samples.Set(key, challenge, entry)
+}
+
+// invalidateSampleResults drops cached verdicts for challenge. Failures are
+// only logged: stale entries still expire after the TTL.
+func (s *server) invalidateSampleResults(challenge string) {
+	if err := s.samples.InvalidateChallenge(challenge); err != nil {
+		slog.Warn("sample cache: invalidate failed", "challenge", challenge, "err", err)
+	}
+}
+
+// memorySampleCache is a process-local LRU with a per-entry TTL.
+type memorySampleCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+type memorySampleEntry struct {
+	key       string
+	challenge string
+	value     cachedSampleResult
+}
+
+func newMemorySampleCache(maxEntries int, ttl time.Duration) *memorySampleCache {
+	return &memorySampleCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *memorySampleCache) Get(key string) (cachedSampleResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return cachedSampleResult{}, false
+	}
+	entry := el.Value.(*memorySampleEntry)
+	if time.Since(entry.value.CachedAt) > c.ttl {
+		c.removeLocked(el)
+		return cachedSampleResult{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *memorySampleCache) Set(key, challenge string, value cachedSampleResult) {
+	if c.maxEntries <= 0 || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*memorySampleEntry)
+		entry.challenge = challenge
+		entry.value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&memorySampleEntry{key: key, challenge: challenge, value: value})
+	for c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+func (c *memorySampleCache) InvalidateChallenge(challenge string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*memorySampleEntry).challenge == challenge {
+			c.removeLocked(el)
+		}
+		el = next
+	}
+	return nil
+}
+
+func (c *memorySampleCache) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*memorySampleEntry).key)
+}
+
+// sqlSampleCache stores results in the sample_result_cache table so they are
+// shared by every web replica and survive restarts.
+type sqlSampleCache struct {
+	db  *dbConn
+	ttl time.Duration
+}
+
+// newSQLSampleCache returns a cache in conn that deletes expired entries
+// every cleanupEvery until ctx is done.
+func newSQLSampleCache(ctx context.Context, conn *dbConn, ttl, cleanupEvery time.Duration) *sqlSampleCache {
+	c := &sqlSampleCache{db: conn, ttl: ttl}
+	if cleanupEvery > 0 {
+		go c.cleanupLoop(ctx, cleanupEvery)
+	}
+	return c
+}
+
+func (c *sqlSampleCache) Get(key string) (cachedSampleResult, bool) {
+	var entry cachedSampleResult
+	err := c.db.QueryRow(`
+        SELECT result, duration_ms, fail_index, output, expected_output, cached_at
+        FROM sample_result_cache
+        WHERE cache_key = $1 AND expires_at > $2`, key, time.Now()).
+		Scan(&entry.Result, &entry.DurationMs, &entry.FailIdx, &entry.Output, &entry.Expect, &entry.CachedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Warn("sample cache: lookup failed", "err", err)
+		}
+		return cachedSampleResult{}, false
+	}
+	return entry, true
+}
+
+func (c *sqlSampleCache) Set(key, challenge string, entry cachedSampleResult) {
+	if c.ttl <= 0 {
+		return
+	}
+	_, err := c.db.Exec(`
+        INSERT INTO sample_result_cache(cache_key, challenge, result, duration_ms, fail_index, output, expected_output, cached_at, expires_at)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
+        ON CONFLICT (cache_key) DO UPDATE SET
+          challenge = EXCLUDED.challenge,
+          result = EXCLUDED.result,
+          duration_ms = EXCLUDED.duration_ms,
+          fail_index = EXCLUDED.fail_index,
+          output = EXCLUDED.output,
+          expected_output = EXCLUDED.expected_output,
+          cached_at = EXCLUDED.cached_at,
+          expires_at = EXCLUDED.expires_at`,
+		key, challenge, entry.Result, entry.DurationMs, entry.FailIdx, entry.Output, entry.Expect, entry.CachedAt, entry.CachedAt.Add(c.ttl))
+	if err != nil {
+		slog.Warn("sample cache: store failed", "err", err)
+	}
+}
+
+func (c *sqlSampleCache) InvalidateChallenge(challenge string) error {
+	_, err := c.db.Exec(`DELETE FROM sample_result_cache WHERE challenge = $1`, challenge)
+	return err
+}
+
+func (c *sqlSampleCache) cleanupLoop(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := c.db.ExecContext(ctx, `DELETE FROM sample_result_cache WHERE expires_at < $1`, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Warn("sample cache: cleanup failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSampleCaches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for name, open := range map[string]func(t *testing.T, ttl time.Duration) sampleResultStore{
+		"memory": func(t *testing.T, ttl time.Duration) sampleResultStore { return newMemorySampleCache(16, ttl) },
+		"sqlite": func(t *testing.T, ttl time.Duration) sampleResultStore {
+			return newSQLSampleCache(ctx, testSQLiteConn(t), ttl, 0)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := open(t, time.Minute)
+			now := time.Now().Truncate(time.Second)
+			entry := cachedSampleResult{Result: "Wrong Answer", DurationMs: 12, FailIdx: 1, Output: "3", Expect: "4", CachedAt: now}
+			c.Set("k1", "sum", entry)
+			c.Set("k2", "sum", cachedSampleResult{Result: "Accepted", CachedAt: now})
+			c.Set("k3", "mul", cachedSampleResult{Result: "Accepted", CachedAt: now})
+
+			got, ok := c.Get("k1")
+			if !ok || got.Result != entry.Result || got.DurationMs != 12 || got.FailIdx != 1 || got.Output != "3" || got.Expect != "4" || !got.CachedAt.Equal(now) {
+				t.Fatalf("Get(k1) = %+v, %v", got, ok)
+			}
+			if _, ok := c.Get("missing"); ok {
+				t.Error("Get(missing) hit")
+			}
+
+			// replacing an entry keeps one row per key
+			c.Set("k1", "sum", cachedSampleResult{Result: "Accepted", CachedAt: now})
+			if got, _ := c.Get("k1"); got.Result != "Accepted" {
+				t.Errorf("Get(k1) after Set = %q", got.Result)
+			}
+
+			if err := c.InvalidateChallenge("sum"); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range map[string]bool{"k1": false, "k2": false, "k3": true} {
+				if _, ok := c.Get(key); ok != want {
+					t.Errorf("after invalidating sum, Get(%s) hit = %v, want %v", key, ok, want)
+				}
+			}
+
+			// expired entries miss
+			c.Set("old", "sum", cachedSampleResult{Result: "Accepted", CachedAt: now.Add(-2 * time.Minute)})
+			if _, ok := c.Get("old"); ok {
+				t.Error("an expired entry hit")
+			}
+		})
+	}
+}
+
+func TestSampleCacheDisabled(t *testing.T) {
+	for name, c := range map[string]sampleResultStore{
+		"no entries": newMemorySampleCache(0, time.Minute),
+		"no ttl":     newMemorySampleCache(16, 0),
+		"sqlite":     newSQLSampleCache(context.Background(), testSQLiteConn(t), 0, 0),
+	} {
+		c.Set("k", "sum", cachedSampleResult{Result: "Accepted", CachedAt: time.Now()})
+		if _, ok := c.Get("k"); ok {
+			t.Errorf("%s: a disabled cache kept an entry", name)
+		}
+	}
+}
+
+func TestMemorySampleCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newMemorySampleCache(2, time.Minute)
+	now := time.Now()
+	c.Set("a", "sum", cachedSampleResult{CachedAt: now})
+	c.Set("b", "sum", cachedSampleResult{CachedAt: now})
+	c.Get("a")
+	c.Set("c", "sum", cachedSampleResult{CachedAt: now})
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("Get(%s) hit = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestSQLSampleCacheCleanup(t *testing.T) {
+	conn := testSQLiteConn(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newSQLSampleCache(ctx, conn, time.Minute, 10*time.Millisecond)
+	c.Set("old", "sum", cachedSampleResult{CachedAt: time.Now().Add(-time.Hour)})
+	c.Set("new", "sum", cachedSampleResult{CachedAt: time.Now()})
+	count := func() int {
+		var n int
+		if err := conn.QueryRow(`SELECT COUNT(*) FROM sample_result_cache`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("cleanup did not delete the expired entry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// once ctx is done the loop stops deleting
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	c.Set("old2", "sum", cachedSampleResult{CachedAt: time.Now().Add(-time.Hour)})
+	time.Sleep(50 * time.Millisecond)
+	if n := count(); n != 2 {
+		t.Errorf("%d entries after shutdown, want 2", n)
+	}
+}
+
+func TestMakeSampleResultCacheKey(t *testing.T) {
+	base := makeSampleResultCacheKey(1, 2, "python", "print(1)")
+	for _, other := range []string{
+		makeSampleResultCacheKey(3, 2, "python", "print(1)"),
+		makeSampleResultCacheKey(1, 3, "python", "print(1)"),
+		makeSampleResultCacheKey(1, 2, "ruby", "print(1)"),
+		makeSampleResultCacheKey(1, 2, "python", "print(2)"),
+	} {
+		if other == base {
+			t.Errorf("keys collide: %s", base)
+		}
+	}
+	if again := makeSampleResultCacheKey(1, 2, "python", "print(1)"); again != base {
+		t.Errorf("key is not stable: %s, %s", base, again)
+	}
+}
@@ -1,159 +1,123 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"time"
+
+	"goexe/internal/runnerclient"
 )
 
 // RunnerRequest is sent to the sandbox runner service
-type RunnerRequest struct {
-	Language  string `json:"language"`
-	Code      string `json:"code"`
-	Input     string `json:"input,omitempty"`
-	Want      string `json:"want,omitempty"`
-	Challenge string `json:"challenge,omitempty"`
-	Mode      string `json:"mode,omitempty"`
-	Sandbox   string `json:"sandbox,omitempty"`
-}
+type RunnerRequest = runnerclient.Request
 
 // RunnerResponse is returned from the sandbox runner service
-// RunnerResponse is returned from the sandbox runner service
-type RunnerResponse struct {
-	Result      string `json:"result"`
-	Output      string `json:"output,omitempty"`
-	DurationMs  int    `json:"duration_ms,omitempty"`
-	FailedIndex int    `json:"failed_index,omitempty"`
-	Expected    string `json:"expected,omitempty"`
-}
+type RunnerResponse = runnerclient.Response
 
 // ChallengeMeta is fetched from runner for UI rendering
-type ChallengeMeta struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Samples     []struct {
-		Input  string `json:"input"`
-		Output string `json:"output"`
-	} `json:"samples"`
+type ChallengeMeta = runnerclient.ChallengeMeta
+
+// RunnerClient is the part of *runnerclient.Client the web app uses.
+type RunnerClient interface {
+	Run(ctx context.Context, req RunnerRequest) (RunnerResponse, error)
+	RunJob(ctx context.Context, req RunnerRequest, webhookURL string, poll time.Duration) (RunnerResponse, error)
+	ChallengeMeta(ctx context.Context, name string) (ChallengeMeta, error)
+	ParseWebhook(r *http.Request) (runnerclient.JobStatus, error)
+	NotifyJob(id string) bool
+	SetEndpoints(list []runnerclient.Endpoint)
+	Ping(ctx context.Context) error
+	Close()
 }
 
-func getRunnerHTTPClient() http.Client {
-	httpTimeoutMs := 40000
-	if v := os.Getenv("RUNNER_HTTP_TIMEOUT_MS"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			httpTimeoutMs = n
-		}
+// newRunnerClient builds the runner client from RUNNER_* env vars
+func newRunnerClient() RunnerClient {
+	c, err := runnerclient.New(runnerclient.ConfigFromEnv())
+	if err != nil {
+		slog.Error("runner client init failed", "err", err)
+		os.Exit(1)
 	}
-	return http.Client{Timeout: time.Duration(httpTimeoutMs) * time.Millisecond}
+	return c
+}
+
+// runJudgeJob runs req as an asynchronous runner job, so long judge runs are
+// not cut off by RUNNER_HTTP_TIMEOUT_MS. Completion arrives through the
+// RUNNER_WEBHOOK_URL callback when configured, with polling every
+// RUNNER_JOB_POLL_MS as the fallback.
+func (s *server) runJudgeJob(ctx context.Context, req RunnerRequest) (RunnerResponse, error) {
+	poll := time.Duration(envIntWithClamp("RUNNER_JOB_POLL_MS", 500, 50, 10000)) * time.Millisecond
+	return s.runner.RunJob(ctx, req, strings.TrimSpace(os.Getenv("RUNNER_WEBHOOK_URL")), poll)
 }
 
-func fetchChallengeMeta(name string) (ChallengeMeta, error) {
-	client := getRunnerHTTPClient()
-	endpoint := "http://runner:9000/challenge?name=" + url.QueryEscape(name)
-	resp, err := client.Get(endpoint)
+// runnerWebhookHandler receives job completion callbacks from runners and
+// wakes the worker waiting for that job
+func (s *server) runnerWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := s.runner.ParseWebhook(r)
 	if err != nil {
-		return ChallengeMeta{}, err
+		writeJSONError(w, http.StatusUnauthorized, "invalid webhook")
+		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return ChallengeMeta{}, fmt.Errorf("runner returned %d", resp.StatusCode)
-	}
-	var meta ChallengeMeta
-	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
-		return ChallengeMeta{}, err
-	}
-	return meta, nil
+	s.runner.NotifyJob(st.ID)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// executeSubmission sends the code and test case to the sandbox runner service
-// executeSubmission sends code to the sandbox runner service and returns result and duration
-func executeSubmission(id int64, challenge, language, code string) (string, int, int, string, string) {
+func (s *server) fetchChallengeMeta(ctx context.Context, name string) (ChallengeMeta, error) {
+	return s.runner.ChallengeMeta(ctx, name)
+}
+
+// executeSubmission sends code to the sandbox runner service and returns result and duration.
+// A non-nil error means no runner accepted the job, or ctx was cancelled
+// before it finished, so it can be retried later.
+func (s *server) executeSubmission(ctx context.Context, id int64, challenge, language, code string) (string, int, int, string, string, error) {
 	normalized, ok := normalizeLanguage(language)
 	if !ok {
-		log.Printf("[submission %d] Unsupported language: %s", id, language)
-		return "Unsupported language", 0, -1, "", ""
+		slog.WarnContext(ctx, "unsupported language", "submission", id, "language", language)
+		return "Unsupported language", 0, -1, "", "", nil
 	}
-	// Runner HTTP timeout: default 40s, overridable by RUNNER_HTTP_TIMEOUT_MS
-	httpTimeoutMs := 40000
-	if v := os.Getenv("RUNNER_HTTP_TIMEOUT_MS"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			httpTimeoutMs = n
-		}
-	}
-	client := http.Client{Timeout: time.Duration(httpTimeoutMs) * time.Millisecond}
-	url := "http://runner:9000/run"
-	reqBody := RunnerRequest{
+	rr, err := s.runJudgeJob(ctx, RunnerRequest{
 		Language:  normalized,
 		Code:      code,
 		Challenge: challenge,
 		Mode:      "judge",
-	}
-	buf, _ := json.Marshal(reqBody)
-	resp, err := client.Post(url, "application/json", bytes.NewReader(buf))
+	})
 	if err != nil {
-		log.Printf("[submission %d] Runner request failed: %v", id, err)
-		return "Runtime Error", 0, -1, "", ""
-	}
-	defer resp.Body.Close()
-	var rr RunnerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
-		log.Printf("[submission %d] Runner decode failed: %v", id, err)
-		return "Runtime Error", 0, -1, "", ""
-	}
-	// Normalize failing index: only set for WA/RE/TLE; otherwise -1
-	if rr.Result != "Wrong Answer" && rr.Result != "Runtime Error" && rr.Result != "Time Limit Exceeded" {
-		rr.FailedIndex = -1
+		slog.ErrorContext(ctx, "runner request failed", "submission", id, "err", err)
+		if runnerclient.IsUnavailable(err) || ctx.Err() != nil {
+			return "", 0, -1, "", "", err
+		}
+		return "Runtime Error", 0, -1, "", "", nil
 	}
-	return rr.Result, rr.DurationMs, rr.FailedIndex, rr.Output, rr.Expected
+	return rr.Result, rr.DurationMs, rr.FailedIndex, rr.Output, rr.Expected, nil
 }
 
 // executeSample runs only sample tests and returns detailed failure info for UI testing
-func executeSample(id int64, challenge, language, code string) (string, int, int, string, string) {
+func (s *server) executeSample(ctx context.Context, id int64, challenge, language, code string) (string, int, int, string, string) {
 	normalized, ok := normalizeLanguage(language)
 	if !ok {
-		log.Printf("[test %d] Unsupported language: %s", id, language)
+		slog.WarnContext(ctx, "unsupported language", "test", id, "language", language)
 		return "Unsupported language", 0, -1, "", ""
 	}
-	httpTimeoutMs := 40000
-	if v := os.Getenv("RUNNER_HTTP_TIMEOUT_MS"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			httpTimeoutMs = n
-		}
-	}
-	client := http.Client{Timeout: time.Duration(httpTimeoutMs) * time.Millisecond}
-	url := "http://runner:9000/run"
-	reqBody := RunnerRequest{
+	rr, err := s.runJudgeJob(ctx, RunnerRequest{
 		Language:  normalized,
 		Code:      code,
 		Challenge: challenge,
 		Mode:      "sample",
-	}
-	buf, _ := json.Marshal(reqBody)
-	resp, err := client.Post(url, "application/json", bytes.NewReader(buf))
+	})
 	if err != nil {
-		log.Printf("[test %d] Runner request failed: %v", id, err)
-		return "Runtime Error", 0, -1, "", ""
-	}
-	defer resp.Body.Close()
-	var rr RunnerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
-		log.Printf("[test %d] Runner decode failed: %v", id, err)
+		slog.ErrorContext(ctx, "runner request failed", "test", id, "err", err)
 		return "Runtime Error", 0, -1, "", ""
 	}
-	if rr.Result != "Wrong Answer" && rr.Result != "Runtime Error" && rr.Result != "Time Limit Exceeded" {
-		rr.FailedIndex = -1
-	}
 	return rr.Result, rr.DurationMs, rr.FailedIndex, rr.Output, rr.Expected
 }
 
-func executeDebugRun(language, code, input, sandbox string) (RunnerResponse, error) {
+func (s *server) executeDebugRun(ctx context.Context, language, code, input, sandbox string) (RunnerResponse, error) {
 	normalized, ok := normalizeLanguage(language)
 	if !ok {
 		return RunnerResponse{}, fmt.Errorf("unsupported language")
@@ -167,32 +131,10 @@ func executeDebugRun(language, code, input, sandbox string) (RunnerResponse, err
 	default:
 		return RunnerResponse{}, fmt.Errorf("unsupported sandbox mode")
 	}
-	httpTimeoutMs := 40000
-	if v := os.Getenv("RUNNER_HTTP_TIMEOUT_MS"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			httpTimeoutMs = n
-		}
-	}
-	client := http.Client{Timeout: time.Duration(httpTimeoutMs) * time.Millisecond}
-	url := "http://runner:9000/run"
-	reqBody := RunnerRequest{
+	return s.runner.Run(ctx, RunnerRequest{
 		Language: normalized,
 		Code:     code,
 		Input:    input,
 		Sandbox:  mode,
-	}
-	buf, _ := json.Marshal(reqBody)
-	resp, err := client.Post(url, "application/json", bytes.NewReader(buf))
-	if err != nil {
-		return RunnerResponse{}, err
-	}
-	defer resp.Body.Close()
-	var rr RunnerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
-		return RunnerResponse{}, err
-	}
-	if rr.Result != "Wrong Answer" && rr.Result != "Runtime Error" && rr.Result != "Time Limit Exceeded" {
-		rr.FailedIndex = -1
-	}
-	return rr, nil
+	})
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"goexe/internal/runnerclient"
+)
+
+// server holds what the handlers depend on, so tests can swap in fakes.
+type server struct {
+	store     Store
+	runner    RunnerClient
+	templates *template.Template
+	sessions  *sessionManager
+	pow       *powManager
+	limiter   *requestRateLimiter
+	samples   sampleResultStore
+}
+
+// parseTemplates loads the page templates from templates/.
+func parseTemplates() *template.Template {
+	return template.Must(template.New("").Option("missingkey=zero").Funcs(template.FuncMap{
+		"add": func(a, b int) int { return a + b },
+		// untruncated and truncated split off the runner's truncation
+		// marker, so pages can show it apart from the output
+		"untruncated": func(s string) string {
+			out, _ := runnerclient.SplitTruncated(s)
+			return out
+		},
+		"truncated": func(s string) bool {
+			_, cut := runnerclient.SplitTruncated(s)
+			return cut
+		},
+	}).ParseGlob("templates/*.html"))
+}
+
+// routes registers every page and API handler on a new mux.
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	// Serve static assets
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	// Route handlers
+	mux.HandleFunc("/", s.indexHandler)
+	mux.HandleFunc("/register", s.registerHandler)
+	mux.HandleFunc("/login", s.loginHandler)
+	mux.HandleFunc("/logout", s.logoutHandler)
+	mux.HandleFunc("/challenges/", s.challengeHandler)
+	mux.HandleFunc("/submit", s.submitHandler)
+	mux.HandleFunc("/test", s.testHandler)
+	mux.HandleFunc("/api/test", s.apiTestHandler)
+	mux.HandleFunc("/api/pow", s.apiPowChallengeHandler)
+	mux.HandleFunc("/api/admin/debug", s.apiAdminDebugHandler)
+	mux.HandleFunc("/api/challenges", s.apiChallengeHandler)
+	mux.HandleFunc("/api/submissions", s.apiSubmissionCreateHandler)
+	mux.HandleFunc("/api/submissions/", s.apiSubmissionDetailHandler)
+	mux.HandleFunc("/submissions", s.submissionsHandler)
+	mux.HandleFunc("/scoreboard", s.scoreboardHandler)
+	mux.HandleFunc("/users", s.usersHandler)
+	mux.HandleFunc("/writer", s.writerDashboardHandler)
+	mux.HandleFunc("/writer/challenges/new", s.writerNewChallengeHandler)
+	mux.HandleFunc("/admin/users", s.adminUsersHandler)
+	mux.HandleFunc("/admin/users/", s.adminUserDetailHandler)
+	mux.HandleFunc("/admin/debug", s.adminDebugHandler)
+
+	// Admin upload is disabled for now (hidden tests live only in runner)
+
+	// Submission detail route
+	mux.HandleFunc("/submission/", s.submissionDetailHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+	// Runner job completion callbacks
+	mux.HandleFunc("/internal/runner/jobs/webhook", s.runnerWebhookHandler)
+	return mux
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"goexe/internal/runnerclient"
+)
+
+// Store is everything the web app keeps in its database. Lookups that find
+// nothing return sql.ErrNoRows.
+type Store interface {
+	// Challenges
+	GetChallengeSummaries(page, perPage int) ([]ChallengeSummary, int, error)
+	SearchChallenges(query string, limit int) ([]ChallengeSummary, error)
+	GetSampleCases(name string) ([]TestCase, error)
+	GetChallengeForEdit(id int) (*ChallengeDetail, error)
+	GetChallengeIDByName(name string) (int, error)
+	SetChallengeVisibility(id int, public bool) error
+	CreateChallenge(userID int, name, description string, points int, publish bool, samples, hidden []TestCase) (int, error)
+	ImportChallenge(ownerID int, name, description string, points int, samples, hidden []TestCase) error
+	UpdateChallengeWithTests(name, description string, points int, sampleTests, judgeTests []TestCase) error
+
+	// Users
+	CreateUser(username, password string, isWriter bool) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	GetUserByID(userID int) (*User, error)
+	GetAllUsersForAdmin() ([]User, error)
+	GetUsersPaginated(page, perPage int) ([]User, int, error)
+	SearchUsers(query string, limit int) ([]User, error)
+	SetUserWriterFlag(userID int, isWriter bool) error
+
+	// Submissions and the judge queue
+	CreateSubmission(ctx context.Context, sub Submission) (int, error)
+	CountPendingSubmissions() (int, error)
+	CountQueuedSubmissions() (pending, running int, err error)
+	ClaimNextPending() (pendingJob, bool, error)
+	RequeueSubmission(id int) error
+	UpdateSubmissionAfterRun(ctx context.Context, id int, result string, durationMs, failIdx int, lastOut, expect string) error
+	EnsureSolve(userID int, challenge string, at time.Time) error
+	GetScoreboard() ([]ScoreEntry, error)
+	GetSubmissionsByUser(userID int) ([]Submission, error)
+	GetSubmissionsByChallenge(challenge string) ([]ChallengeSubmission, error)
+	GetSubmissionDetail(subID int) (SubmissionDetail, error)
+	GetSubmissionStatusByID(subID int) (Submission, error)
+
+	// Runner registry
+	ListLiveRunners(stale time.Duration) ([]runnerclient.Endpoint, error)
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// dialect covers what PostgreSQL and SQLite do differently.
+type dialect interface {
+	// bind adapts a query written with PostgreSQL $N placeholders, and its
+	// arguments, to the driver.
+	bind(query string, args []any) (string, []any)
+	// claimNextPending moves the row picked by nextPendingQuery from
+	// Pending to Running without racing other workers.
+	claimNextPending(conn *dbConn) (pendingJob, bool, error)
+	// purgeJudgeCases deletes a challenge's hidden cases inside tx.
+	purgeJudgeCases(tx *dbTx, challenge string) error
+	isUniqueViolation(err error) bool
+
+	// migrationsDir names the embedded migrations for this dialect.
+	migrationsDir() string
+	// lockMigrations keeps concurrent migrators apart; the returned func
+	// releases the lock.
+	lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error)
+	hasTable(ctx context.Context, conn *sql.Conn, name string) (bool, error)
+	// schemaMigrationsDDL creates the schema_migrations table if missing.
+	schemaMigrationsDDL() string
+}
+
+// dbConn is a connection pool and the dialect it speaks. Queries go through
+// the dialect, so callers write them once for PostgreSQL.
+type dbConn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *dbConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = c.dialect.bind(query, args)
+	return c.DB.ExecContext(ctx, query, args...)
+}
+
+func (c *dbConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = c.dialect.bind(query, args)
+	return c.DB.QueryContext(ctx, query, args...)
+}
+
+func (c *dbConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = c.dialect.bind(query, args)
+	return c.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (c *dbConn) Begin() (*dbTx, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, dialect: c.dialect}, nil
+}
+
+// dbTx is a transaction on a dbConn.
+type dbTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *dbTx) Exec(query string, args ...any) (sql.Result, error) {
+	query, args = t.dialect.bind(query, args)
+	return t.Tx.Exec(query, args...)
+}
+
+func (t *dbTx) Query(query string, args ...any) (*sql.Rows, error) {
+	query, args = t.dialect.bind(query, args)
+	return t.Tx.Query(query, args...)
+}
+
+func (t *dbTx) QueryRow(query string, args ...any) *sql.Row {
+	query, args = t.dialect.bind(query, args)
+	return t.Tx.QueryRow(query, args...)
+}
+
+// dbDriver returns DB_DRIVER: "postgres" (default) or "sqlite".
+func dbDriver() string {
+	driver := strings.ToLower(strings.TrimSpace(os.Getenv("DB_DRIVER")))
+	if driver == "" {
+		return "postgres"
+	}
+	return driver
+}
+
+// sqlitePath returns SQLITE_PATH, the database file of the sqlite driver.
+func sqlitePath() string {
+	if path := strings.TrimSpace(os.Getenv("SQLITE_PATH")); path != "" {
+		return path
+	}
+	return "data/goexe.db"
+}
+
+// initDB opens the database selected by DB_DRIVER. With
+// "sqlite" the whole app state lives in the file at SQLITE_PATH, which suits
+// single-node instances and tests; the schema is created on open.
+func initDB() *dbConn {
+	var conn *dbConn
+	var err error
+	switch driver := dbDriver(); driver {
+	case "postgres":
+		conn, err = openPostgres()
+	case "sqlite":
+		conn, err = openSQLite(sqlitePath())
+	default:
+		slog.Error("DB connection failed: unsupported DB_DRIVER", "driver", driver)
+		os.Exit(1)
+	}
+	if err != nil {
+		slog.Error("DB connection failed", "driver", dbDriver(), "err", err)
+		os.Exit(1)
+	}
+	configurePool(conn.DB)
+	// Wait for DB to be ready (up to ~10s)
+	var pingErr error
+	for i := 0; i < 10; i++ {
+		pingErr = conn.Ping()
+		if pingErr == nil {
+			break
+		}
+		slog.Info("waiting for DB to be ready", "attempt", i+1, "max_attempts", 10, "err", pingErr)
+		time.Sleep(1 * time.Second)
+	}
+	if pingErr != nil {
+		slog.Error("DB ping failed after retries", "err", pingErr)
+		os.Exit(1)
+	}
+	return conn
+}
+
+// configurePool applies DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME_MINUTES.
+func configurePool(pool *sql.DB) {
+	maxOpen := 20
+	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
+		if n, e := strconv.Atoi(v); e == nil && n > 0 {
+			maxOpen = n
+		}
+	}
+	maxIdle := 10
+	if v := os.Getenv("DB_MAX_IDLE_CONNS"); v != "" {
+		if n, e := strconv.Atoi(v); e == nil && n >= 0 {
+			maxIdle = n
+		}
+	}
+	lifeMin := 15
+	if v := os.Getenv("DB_CONN_MAX_LIFETIME_MINUTES"); v != "" {
+		if n, e := strconv.Atoi(v); e == nil && n > 0 {
+			lifeMin = n
+		}
+	}
+	pool.SetMaxOpenConns(maxOpen)
+	pool.SetMaxIdleConns(maxIdle)
+	pool.SetConnMaxLifetime(time.Duration(lifeMin) * time.Minute)
+}
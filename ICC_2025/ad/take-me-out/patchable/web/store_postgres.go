@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresDialect is the production backend. Queries are already written
+// for it.
+type postgresDialect struct{}
+
+// openPostgres connects as DB_USER (the app_web role by default).
+func openPostgres() (*dbConn, error) {
+	user := os.Getenv("DB_USER")
+	if user == "" {
+		user = "app_web"
+	}
+	password, err := webDBPassword()
+	if err != nil {
+		return nil, fmt.Errorf("derive DB password: %w", err)
+	}
+	return openPostgresAs(user, password)
+}
+
+func openPostgresAs(user, password string) (*dbConn, error) {
+	pool, err := sql.Open("postgres", postgresConnString(user, password))
+	if err != nil {
+		return nil, err
+	}
+	return &dbConn{DB: pool, dialect: postgresDialect{}}, nil
+}
+
+// postgresConnString builds a DSN for user from DB_HOST, DB_PORT and DB_NAME.
+func postgresConnString(user, password string) string {
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		host = "db"
+	}
+	port := os.Getenv("DB_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, user, password, dbName)
+}
+
+// webDBPassword derives the web role's password from the flag file at
+// DB_PASSWORD_FLAG_PATH.
+func webDBPassword() (string, error) {
+	flagPath := strings.TrimSpace(os.Getenv("DB_PASSWORD_FLAG_PATH"))
+	if flagPath == "" {
+		flagPath = "/flag1"
+	}
+	password, err := hashFileSHA256(flagPath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", flagPath, err)
+	}
+	return password, nil
+}
+
+func hashFileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum[:]), nil
+}
+
+func (postgresDialect) bind(query string, args []any) (string, []any) {
+	return query, args
+}
+
+// claimNextPending locks the chosen row with SKIP LOCKED, so concurrent
+// workers (on any replica) each get a different submission.
+func (postgresDialect) claimNextPending(conn *dbConn) (pendingJob, bool, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return pendingJob{}, false, err
+	}
+	defer func() {
+		// ensure rollback on early returns
+		_ = tx.Rollback()
+	}()
+	job, ok, err := scanPendingJob(tx.QueryRow(`
+        SELECT ` + pendingJobColumns + ` FROM submissions
+        WHERE id = (` + nextPendingQuery + `
+        FOR UPDATE OF s SKIP LOCKED)`))
+	if err != nil || !ok {
+		return job, false, err
+	}
+	if _, err := tx.Exec(`UPDATE submissions SET result = 'Running', claimed_at = $2 WHERE id = $1 AND result = 'Pending'`, job.ID, time.Now()); err != nil {
+		return pendingJob{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return pendingJob{}, false, err
+	}
+	return job, true, nil
+}
+
+// purgeJudgeCases goes through a SECURITY DEFINER function: the web role may
+// insert judge cases but not delete them.
+func (postgresDialect) purgeJudgeCases(tx *dbTx, challenge string) error {
+	_, err := tx.Exec(`SELECT purge_judge_cases($1)`, challenge)
+	return err
+}
+
+func (postgresDialect) isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (postgresDialect) migrationsDir() string { return "migrations/postgres" }
+
+// lockMigrations takes a session-level advisory lock, so replicas that start
+// together do not apply the same migration twice.
+func (postgresDialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}, nil
+}
+
+// schemaMigrationsDDL matches db/init/01-schema.sh, which migrates the
+// database before the web service first starts.
+func (postgresDialect) schemaMigrationsDDL() string {
+	return `
+            CREATE TABLE IF NOT EXISTS schema_migrations (
+              version BIGINT PRIMARY KEY,
+              name TEXT NOT NULL,
+              applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+            )`
+}
+
+func (postgresDialect) hasTable(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDialect keeps the whole app in one file for single-node instances
+// and tests. SQLite has a single writer: every transaction starts with
+// BEGIN IMMEDIATE (_txlock), and writers queue on the busy timeout instead
+// of failing.
+type sqliteDialect struct {
+	// path is the database file; migrators lock a file next to it
+	path string
+}
+
+// openSQLite opens (creating if needed) the database file at path and brings
+// its schema up to date. The runner reads test cases from the same file.
+func openSQLite(path string) (*dbConn, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	pool, err := sql.Open("sqlite3", sqliteDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	conn := &dbConn{DB: pool, dialect: sqliteDialect{path: path}}
+	// There is no separate schema owner to run migrations as, so they are
+	// applied here rather than through DB_AUTO_MIGRATE
+	migrations, err := loadMigrations(conn.dialect)
+	if err == nil {
+		m := &migrator{db: conn, migrations: migrations, out: io.Discard}
+		err = m.Up(context.Background(), 0)
+	}
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func sqliteDSN(path string) string {
+	return "file:" + path + "?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate"
+}
+
+// sqlitePlaceholder matches PostgreSQL-style $N placeholders.
+var sqlitePlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// bind rewrites $N to ?N: SQLite numbers $N parameters by first appearance,
+// not by N. Times are stored as text, so they are converted to UTC to keep
+// them comparable.
+func (sqliteDialect) bind(query string, args []any) (string, []any) {
+	query = sqlitePlaceholder.ReplaceAllString(query, "?$1")
+	bound := make([]any, len(args))
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = t.UTC()
+		}
+		bound[i] = arg
+	}
+	return query, bound
+}
+
+// claimNextPending picks and updates the row in one statement; with a
+// single writer no other worker can claim it in between.
+func (sqliteDialect) claimNextPending(conn *dbConn) (pendingJob, bool, error) {
+	return scanPendingJob(conn.QueryRow(`
+        UPDATE submissions SET result = 'Running', claimed_at = $1
+        WHERE id = (`+nextPendingQuery+`) AND result = 'Pending'
+        RETURNING `+pendingJobColumns, time.Now()))
+}
+
+// purgeJudgeCases deletes directly: SQLite has no roles to restrict.
+func (sqliteDialect) purgeJudgeCases(tx *dbTx, challenge string) error {
+	_, err := tx.Exec(`DELETE FROM judge_cases WHERE challenge = $1`, challenge)
+	return err
+}
+
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+func (sqliteDialect) migrationsDir() string { return "migrations/sqlite" }
+
+// lockMigrations holds an exclusive flock on path+".migrate.lock" while
+// migrating. SQLite has no session lock that outlives a transaction, and the
+// migrator reads schema_migrations before it starts applying, so without it
+// a second migrator would try the same migrations and fail on their rows.
+// The kernel drops the lock if the process dies.
+func (d sqliteDialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	f, err := os.OpenFile(d.path+".migrate.lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (sqliteDialect) schemaMigrationsDDL() string {
+	return `
+            CREATE TABLE IF NOT EXISTS schema_migrations (
+              version BIGINT PRIMARY KEY,
+              name TEXT NOT NULL,
+              applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+            )`
+}
+
+func (sqliteDialect) hasTable(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var n int
+	err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	return n > 0, err
+}
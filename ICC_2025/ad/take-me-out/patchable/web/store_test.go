@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"goexe/internal/tracing"
+)
+
+func TestSQLiteBind(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("KST", 9*3600))
+	query, args := sqliteDialect{}.bind(`SELECT $2, $1 WHERE a = $2 AND b > $10`, []any{"x", at})
+	if query != `SELECT ?2, ?1 WHERE a = ?2 AND b > ?10` {
+		t.Errorf("query %s", query)
+	}
+	if args[0] != "x" || args[1] != at.UTC() || args[1].(time.Time).Location() != time.UTC {
+		t.Errorf("args %v", args)
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	st := newSQLStore(testSQLiteConn(t))
+	for _, name := range []string{"carol", "Bob", "alice"} {
+		if _, err := st.CreateUser(name, "pw-"+name, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.CreateUser("alice", "again", true); !errors.Is(err, ErrUserExists) {
+		t.Errorf("duplicate user: %v", err)
+	}
+
+	u, err := st.GetUserByUsername("Bob")
+	if err != nil || u.Password != "pw-Bob" || u.IsAdmin || u.IsWriter {
+		t.Fatalf("GetUserByUsername = %+v, %v", u, err)
+	}
+	if err := st.SetUserWriterFlag(u.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if u, err = st.GetUserByID(u.ID); err != nil || !u.IsWriter {
+		t.Errorf("after SetUserWriterFlag: %+v, %v", u, err)
+	}
+	if _, err := st.GetUserByUsername("nobody"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing user by name: %v", err)
+	}
+	if _, err := st.GetUserByID(9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing user by id: %v", err)
+	}
+
+	page, total, err := st.GetUsersPaginated(2, 2)
+	if err != nil || total != 3 || len(page) != 1 || page[0].Username != "carol" {
+		t.Errorf("page 2: %+v of %d, %v", page, total, err)
+	}
+	if page, _, _ = st.GetUsersPaginated(1, 2); len(page) != 2 || page[0].Username != "alice" || page[1].Username != "Bob" {
+		t.Errorf("page 1 is not case-insensitively ordered: %+v", page)
+	}
+	found, err := st.SearchUsers("BO", 10)
+	if err != nil || len(found) != 1 || found[0].Username != "Bob" {
+		t.Errorf("SearchUsers = %+v, %v", found, err)
+	}
+	if found, _ = st.SearchUsers("  ", 10); found != nil {
+		t.Errorf("blank search found %+v", found)
+	}
+}
+
+func TestStoreChallenges(t *testing.T) {
+	st := newSQLStore(testSQLiteConn(t))
+	owner, err := st.CreateUser("writer", "pw", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []TestCase{{Input: "1 2", Output: "3"}, {Input: "2 2", Output: "4"}}
+	hidden := []TestCase{{Input: "5 5", Output: "10"}}
+	id, err := st.CreateChallenge(owner.ID, "sum", "Add two NUMBERS", 100, true, samples, hidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateChallenge(owner.ID, "sum", "again", 1, true, nil, nil); !errors.Is(err, errDuplicateChallenge) {
+		t.Errorf("duplicate challenge: %v", err)
+	}
+	if _, err := st.CreateChallenge(owner.ID, "draft", "Not yet", 50, false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list, total, err := st.GetChallengeSummaries(1, 10)
+	if err != nil || total != 1 || len(list) != 1 || list[0].ID != id {
+		t.Errorf("summaries list the private challenge: %+v of %d, %v", list, total, err)
+	}
+	found, err := st.SearchChallenges("numbers", 10)
+	if err != nil || len(found) != 1 || found[0].Name != "sum" || found[0].Preview == "" {
+		t.Errorf("SearchChallenges by description = %+v, %v", found, err)
+	}
+	if found, _ = st.SearchChallenges("yet", 10); len(found) != 0 {
+		t.Errorf("search found a private challenge: %+v", found)
+	}
+
+	got, err := st.GetSampleCases("sum")
+	if err != nil || len(got) != 2 || got[1].Input != "2 2" || got[1].Index != 1 || !got[0].IsSample {
+		t.Errorf("GetSampleCases = %+v, %v", got, err)
+	}
+	detail, err := st.GetChallengeForEdit(id)
+	if err != nil || detail.Name != "sum" || detail.CreatedBy == nil || *detail.CreatedBy != owner.ID || !detail.IsPublic {
+		t.Errorf("GetChallengeForEdit = %+v, %v", detail, err)
+	}
+	if _, err := st.GetChallengeForEdit(9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing challenge: %v", err)
+	}
+	if _, err := st.GetChallengeIDByName("nope"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing challenge by name: %v", err)
+	}
+
+	judgeCases := func() int {
+		var n int
+		if err := st.db.QueryRow(`SELECT COUNT(*) FROM judge_cases WHERE challenge = $1`, "sum").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	// nil hidden cases keep the existing ones, a non-nil list replaces them
+	if err := st.UpdateChallengeWithTests("sum", "Add", 120, samples[:1], nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := judgeCases(); n != 1 {
+		t.Errorf("%d judge cases after an update without hidden cases", n)
+	}
+	if err := st.UpdateChallengeWithTests("sum", "Add", 120, samples[:1], append(hidden, TestCase{Input: "0 0", Output: "0"})); err != nil {
+		t.Fatal(err)
+	}
+	if n := judgeCases(); n != 2 {
+		t.Errorf("%d judge cases after replacing them, want 2", n)
+	}
+	if got, _ := st.GetSampleCases("sum"); len(got) != 1 {
+		t.Errorf("%d sample cases after replacing them, want 1", len(got))
+	}
+
+	if err := st.SetChallengeVisibility(id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _ := st.GetChallengeSummaries(1, 10); total != 0 {
+		t.Errorf("%d public challenges after hiding the only one", total)
+	}
+
+	// importing an existing challenge keeps its metadata and reports the
+	// cases that collide
+	if err := st.ImportChallenge(owner.ID, "sum", "Imported", 1, samples, nil); err == nil {
+		t.Error("import over existing sample cases reported no error")
+	}
+	if detail, _ := st.GetChallengeForEdit(id); detail.Points != 120 {
+		t.Errorf("import changed points to %d", detail.Points)
+	}
+}
+
+func TestStoreSubmissions(t *testing.T) {
+	st := newSQLStore(testSQLiteConn(t))
+	alice, _ := st.CreateUser("alice", "pw", false)
+	bob, _ := st.CreateUser("bob", "pw", false)
+	chalID, err := st.CreateChallenge(alice.ID, "sum", "Add", 100, true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateChallenge(alice.ID, "mul", "Multiply", 50, true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("KST", 9*3600))
+	id, err := st.CreateSubmission(tracing.WithTraceparent(context.Background(), parent), Submission{
+		UserID: bob.ID, Challenge: "sum", Language: "python", Code: "print(3)", Result: "Pending", CreatedAt: created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := st.db.QueryRow(`SELECT trace_parent FROM submissions WHERE id = $1`, id).Scan(&stored); err != nil || stored != parent {
+		t.Errorf("stored trace %q, %v", stored, err)
+	}
+	if pending, running, err := st.CountQueuedSubmissions(); pending != 1 || running != 0 || err != nil {
+		t.Errorf("queued %d/%d, %v", pending, running, err)
+	}
+
+	if err := st.UpdateSubmissionAfterRun(context.Background(), id, "Wrong Answer", 42, 3, "4", "3"); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := st.GetSubmissionStatusByID(id)
+	if err != nil || sub.Result != "Wrong Answer" || sub.DurationMs != 42 || sub.FailCaseIdx != 3 ||
+		sub.LastOutput != "4" || sub.ExpectedOut != "3" || sub.ChallengeID != chalID || !sub.CreatedAt.Equal(created) {
+		t.Errorf("GetSubmissionStatusByID = %+v, %v", sub, err)
+	}
+	detail, err := st.GetSubmissionDetail(id)
+	if err != nil || detail.Username != "bob" || detail.Got != "4" || detail.CreatedAt != created.UTC().Format(time.RFC1123) {
+		t.Errorf("GetSubmissionDetail = %+v, %v", detail, err)
+	}
+	if _, err := st.GetSubmissionDetail(9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing submission: %v", err)
+	}
+	if subs, err := st.GetSubmissionsByUser(bob.ID); err != nil || len(subs) != 1 || subs[0].ChallengeID != chalID {
+		t.Errorf("GetSubmissionsByUser = %+v, %v", subs, err)
+	}
+	if subs, err := st.GetSubmissionsByChallenge("sum"); err != nil || len(subs) != 1 || subs[0].Username != "bob" {
+		t.Errorf("GetSubmissionsByChallenge = %+v, %v", subs, err)
+	}
+
+	// a solve counts once, however often it is recorded
+	for i := 0; i < 2; i++ {
+		if err := st.EnsureSolve(bob.ID, "sum", created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.EnsureSolve(alice.ID, "mul", created); err != nil {
+		t.Fatal(err)
+	}
+	board, err := st.GetScoreboard()
+	want := []ScoreEntry{{"bob", 100, 1}, {"alice", 50, 1}}
+	if err != nil || len(board) != len(want) {
+		t.Fatalf("GetScoreboard = %+v, %v", board, err)
+	}
+	for i := range want {
+		if board[i] != want[i] {
+			t.Errorf("scoreboard row %d = %+v, want %+v", i, board[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests from the web tier are signed with a per-caller shared secret:
+//
+//	X-Runner-Caller:    caller id
+//	X-Runner-Timestamp: unix seconds
+//	X-Runner-Nonce:     random, single use
+//	X-Runner-Signature: hex HMAC-SHA256 over
+//	                    method \n path?query \n timestamp \n nonce \n hex(sha256(body))
+//
+// The runner answers with X-Runner-Signature over
+// nonce \n status \n hex(sha256(body)) using the same secret, so the caller
+// knows the verdict came from a runner holding its key.
+const (
+	headerCaller    = "X-Runner-Caller"
+	headerTimestamp = "X-Runner-Timestamp"
+	headerNonce     = "X-Runner-Nonce"
+	headerSignature = "X-Runner-Signature"
+)
+
+const maxSignedBodyBytes = 8 << 20
+
+type callerKey struct{}
+
+// runnerAuth holds caller secrets and the nonce replay window.
+type runnerAuth struct {
+	keys         map[string][]byte
+	debugCallers map[string]bool
+	maxSkew      time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+var auth *runnerAuth
+
+// initRunnerAuth loads RUNNER_AUTH_KEYS ("caller=secret,..."). Callers listed
+// in RUNNER_DEBUG_CALLERS may request sandbox modes other than "default".
+// Without keys it fails, unless RUNNER_AUTH_DISABLED is set for local
+// development; the API is then unauthenticated and refuses the debug modes.
+func initRunnerAuth() error {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(os.Getenv("RUNNER_AUTH_KEYS"), ",") {
+		caller, secret, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		caller, secret = strings.TrimSpace(caller), strings.TrimSpace(secret)
+		if !ok || caller == "" || secret == "" {
+			continue
+		}
+		keys[caller] = []byte(secret)
+	}
+	if len(keys) == 0 {
+		if !envBool("RUNNER_AUTH_DISABLED") {
+			return errors.New("RUNNER_AUTH_KEYS is not set (set RUNNER_AUTH_DISABLED=true to run without authentication)")
+		}
+		slog.Warn("runner auth: disabled, the API accepts unauthenticated requests and only the default sandbox modes")
+		return nil
+	}
+	debug := make(map[string]bool)
+	for _, c := range strings.Split(os.Getenv("RUNNER_DEBUG_CALLERS"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			debug[c] = true
+		}
+	}
+	auth = &runnerAuth{
+		keys:         keys,
+		debugCallers: debug,
+		maxSkew:      time.Duration(envInt("RUNNER_AUTH_MAX_SKEW_SECONDS", 30)) * time.Second,
+		nonces:       make(map[string]time.Time),
+	}
+	slog.Info("runner auth: caller keys loaded", "callers", len(keys))
+	return nil
+}
+
+// envBool reports whether key is set to a true value.
+func envBool(key string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// apiError is the body of every rejected runner API request.
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// callerFromContext returns the authenticated caller, or "" when auth is off.
+func callerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// sandboxModeAllowed reports whether the request's caller may pick mode. The
+// debug modes need an authenticated caller listed in RUNNER_DEBUG_CALLERS.
+func sandboxModeAllowed(ctx context.Context, mode string) bool {
+	mode = strings.TrimSpace(mode)
+	// unprivileged only takes privileges away, so anyone may ask for it
+	if mode == "" || mode == "default" || mode == "unprivileged" {
+		return true
+	}
+	return auth != nil && auth.debugCallers[callerFromContext(ctx)]
+}
+
+// requireSigned verifies the request signature before calling next and signs
+// the response. It is a no-op when auth is disabled.
+func requireSigned(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+		if err != nil || len(body) > maxSignedBodyBytes {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+			return
+		}
+		caller := r.Header.Get(headerCaller)
+		nonce := r.Header.Get(headerNonce)
+		secret, status, code, msg := auth.verify(r, caller, nonce, body, time.Now())
+		if secret == nil {
+			slog.Warn("runner auth: request rejected", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "caller", caller, "reason", msg)
+			writeAPIError(w, status, code, msg)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), callerKey{}, caller))
+		sw := &signingWriter{header: make(http.Header), status: http.StatusOK}
+		next(sw, r)
+		for k, v := range sw.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(headerSignature, signResponse(secret, nonce, sw.status, sw.body.Bytes()))
+		w.WriteHeader(sw.status)
+		w.Write(sw.body.Bytes())
+	}
+}
+
+func (a *runnerAuth) verify(r *http.Request, caller, nonce string, body []byte, now time.Time) ([]byte, int, string, string) {
+	secret, ok := a.keys[caller]
+	if !ok {
+		return nil, http.StatusUnauthorized, "unknown_caller", "unknown or missing caller"
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(headerTimestamp), 10, 64)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "bad_timestamp", "missing or malformed timestamp"
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, http.StatusUnauthorized, "stale_request", "timestamp outside the allowed window"
+	}
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return nil, http.StatusUnauthorized, "bad_nonce", "missing or malformed nonce"
+	}
+	got, err := hex.DecodeString(r.Header.Get(headerSignature))
+	if err != nil {
+		return nil, http.StatusUnauthorized, "bad_signature", "malformed signature"
+	}
+	want := signRequest(secret, r.Method, r.URL.RequestURI(), ts, nonce, body)
+	if !hmac.Equal(got, want) {
+		return nil, http.StatusUnauthorized, "bad_signature", "signature mismatch"
+	}
+	if !a.useNonce(caller+":"+nonce, now) {
+		return nil, http.StatusUnauthorized, "replayed_request", "nonce already used"
+	}
+	return secret, 0, "", ""
+}
+
+// useNonce records nonce and reports false if it was seen within the window.
+func (a *runnerAuth) useNonce(key string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, exp := range a.nonces {
+		if now.After(exp) {
+			delete(a.nonces, k)
+		}
+	}
+	if _, seen := a.nonces[key]; seen {
+		return false
+	}
+	a.nonces[key] = now.Add(2 * a.maxSkew)
+	return true
+}
+
+func signRequest(secret []byte, method, uri string, ts int64, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+uri+"\n"+strconv.FormatInt(ts, 10)+"\n"+nonce+"\n"+hex.EncodeToString(sum[:]))
+	return mac.Sum(nil)
+}
+
+func signResponse(secret []byte, nonce string, status int, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, nonce+"\n"+strconv.Itoa(status)+"\n"+hex.EncodeToString(sum[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingWriter buffers a handler's response so it can be signed.
+type signingWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (s *signingWriter) Header() http.Header { return s.header }
+
+func (s *signingWriter) WriteHeader(status int) {
+	if !s.wrote {
+		s.status = status
+		s.wrote = true
+	}
+}
+
+func (s *signingWriter) Write(p []byte) (int, error) {
+	s.wrote = true
+	return s.body.Write(p)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withAuth installs a for the duration of the test.
+func withAuth(t *testing.T, a *runnerAuth) {
+	t.Helper()
+	old := auth
+	auth = a
+	t.Cleanup(func() { auth = old })
+}
+
+func testAuth() *runnerAuth {
+	return &runnerAuth{
+		keys:         map[string][]byte{"web": []byte("web-secret"), "web-debug": []byte("debug-secret")},
+		debugCallers: map[string]bool{"web-debug": true},
+		maxSkew:      30 * time.Second,
+		nonces:       make(map[string]time.Time),
+	}
+}
+
+// signedRequest builds a request signed the way runnerclient signs it.
+func signedRequest(method, uri, caller string, secret []byte, ts time.Time, nonce, body string) *http.Request {
+	r := httptest.NewRequest(method, uri, strings.NewReader(body))
+	r.Header.Set(headerCaller, caller)
+	r.Header.Set(headerTimestamp, strconv.FormatInt(ts.Unix(), 10))
+	r.Header.Set(headerNonce, nonce)
+	r.Header.Set(headerSignature, hex.EncodeToString(signRequest(secret, method, uri, ts.Unix(), nonce, []byte(body))))
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("web-secret")
+	const nonce = "0123456789abcdef"
+	for _, tc := range []struct {
+		name   string
+		req    func() *http.Request
+		caller string
+		code   string
+	}{
+		{"valid", func() *http.Request {
+			return signedRequest("POST", "/run", "web", secret, now, nonce, `{"code":"x"}`)
+		}, "web", ""},
+		{"query is signed", func() *http.Request {
+			r := signedRequest("GET", "/challenge?name=sum", "web", secret, now, nonce, "")
+			r.URL.RawQuery = "name=other"
+			return r
+		}, "web", "bad_signature"},
+		{"unknown caller", func() *http.Request {
+			return signedRequest("POST", "/run", "mallory", secret, now, nonce, "")
+		}, "mallory", "unknown_caller"},
+		{"wrong secret", func() *http.Request {
+			return signedRequest("POST", "/run", "web", []byte("guess"), now, nonce, "")
+		}, "web", "bad_signature"},
+		{"tampered body", func() *http.Request {
+			r := signedRequest("POST", "/run", "web", secret, now, nonce, `{"code":"x"}`)
+			r.Body = http.NoBody
+			return r
+		}, "web", "bad_signature"},
+		{"clock behind by the window", func() *http.Request {
+			return signedRequest("POST", "/run", "web", secret, now.Add(-30*time.Second), nonce, "")
+		}, "web", ""},
+		{"clock behind past the window", func() *http.Request {
+			return signedRequest("POST", "/run", "web", secret, now.Add(-31*time.Second), nonce, "")
+		}, "web", "stale_request"},
+		{"clock ahead past the window", func() *http.Request {
+			return signedRequest("POST", "/run", "web", secret, now.Add(31*time.Second), nonce, "")
+		}, "web", "stale_request"},
+		{"missing timestamp", func() *http.Request {
+			r := signedRequest("POST", "/run", "web", secret, now, nonce, "")
+			r.Header.Del(headerTimestamp)
+			return r
+		}, "web", "bad_timestamp"},
+		{"short nonce", func() *http.Request {
+			return signedRequest("POST", "/run", "web", secret, now, "abc", "")
+		}, "web", "bad_nonce"},
+		{"malformed signature", func() *http.Request {
+			r := signedRequest("POST", "/run", "web", secret, now, nonce, "")
+			r.Header.Set(headerSignature, "zz")
+			return r
+		}, "web", "bad_signature"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := testAuth()
+			r := tc.req()
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, status, code, _ := a.verify(r, tc.caller, r.Header.Get(headerNonce), body, now)
+			if code != tc.code {
+				t.Fatalf("verify = %q, want %q", code, tc.code)
+			}
+			if tc.code == "" && got == nil || tc.code != "" && (got != nil || status != http.StatusUnauthorized) {
+				t.Errorf("verify returned secret %q with status %d", got, status)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsReplay(t *testing.T) {
+	a := testAuth()
+	now := time.Unix(1700000000, 0)
+	const nonce = "0123456789abcdef"
+	send := func(caller string, secret []byte, at time.Time) string {
+		r := signedRequest("POST", "/run", caller, secret, at, nonce, "{}")
+		_, _, code, _ := a.verify(r, caller, nonce, []byte("{}"), at)
+		return code
+	}
+	if code := send("web", []byte("web-secret"), now); code != "" {
+		t.Fatalf("first request: %q", code)
+	}
+	if code := send("web", []byte("web-secret"), now.Add(time.Second)); code != "replayed_request" {
+		t.Errorf("replay: %q, want replayed_request", code)
+	}
+	// nonces are per caller
+	if code := send("web-debug", []byte("debug-secret"), now); code != "" {
+		t.Errorf("same nonce from another caller: %q", code)
+	}
+	// a nonce is forgotten only once its timestamp is out of the window
+	if code := send("web", []byte("web-secret"), now.Add(2*a.maxSkew+time.Second)); code != "" {
+		t.Errorf("nonce reused after the window: %q", code)
+	}
+}
+
+func TestRequireSigned(t *testing.T) {
+	withAuth(t, testAuth())
+	handler := requireSigned(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(callerFromContext(r.Context())))
+	})
+
+	const nonce = "fedcba9876543210"
+	rec := httptest.NewRecorder()
+	handler(rec, signedRequest("POST", "/run", "web", []byte("web-secret"), time.Now(), nonce, "{}"))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "web" {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if want := signResponse([]byte("web-secret"), nonce, http.StatusAccepted, []byte("web")); rec.Header().Get(headerSignature) != want {
+		t.Errorf("response signature %q, want %q", rec.Header().Get(headerSignature), want)
+	}
+	if rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("handler headers were dropped: %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/run", strings.NewReader("{}")))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get(headerSignature) != "" {
+		t.Errorf("unsigned request: got %d", rec.Code)
+	}
+}
+
+func TestSandboxModeAllowed(t *testing.T) {
+	as := func(caller string) context.Context {
+		return context.WithValue(context.Background(), callerKey{}, caller)
+	}
+	for _, tc := range []struct {
+		auth   *runnerAuth
+		caller string
+		mode   string
+		want   bool
+	}{
+		{nil, "", "", true},
+		{nil, "", "default", true},
+		{nil, "", "unprivileged", true},
+		{nil, "", "nsjail_only", false},
+		{nil, "", "none", false},
+		{testAuth(), "web", "default", true},
+		{testAuth(), "web", "unprivileged", true},
+		{testAuth(), "web", "nsjail_only", false},
+		{testAuth(), "web-debug", "nsjail_only", true},
+	} {
+		withAuth(t, tc.auth)
+		if got := sandboxModeAllowed(as(tc.caller), tc.mode); got != tc.want {
+			t.Errorf("auth %v, caller %q, mode %q: allowed %v, want %v", tc.auth != nil, tc.caller, tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestJobVisibleTo(t *testing.T) {
+	j := &job{caller: "web"}
+	for caller, want := range map[string]bool{"web": true, "web-debug": false, "": false} {
+		r := httptest.NewRequest("GET", "/jobs/x", nil)
+		r = r.WithContext(context.WithValue(r.Context(), callerKey{}, caller))
+		if got := jobVisibleTo(j, r); got != want {
+			t.Errorf("job of web visible to %q: %v, want %v", caller, got, want)
+		}
+	}
+}
+
+func TestInitRunnerAuth(t *testing.T) {
+	withAuth(t, nil)
+	for _, tc := range []struct {
+		keys, disabled string
+		wantErr        bool
+		callers        int
+	}{
+		{"", "", true, 0},
+		{" , =x, y=", "", true, 0},
+		{"", "true", false, 0},
+		{"web=a, web-debug = b", "", false, 2},
+	} {
+		auth = nil
+		t.Setenv("RUNNER_AUTH_KEYS", tc.keys)
+		t.Setenv("RUNNER_AUTH_DISABLED", tc.disabled)
+		t.Setenv("RUNNER_DEBUG_CALLERS", "web-debug")
+		err := initRunnerAuth()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("RUNNER_AUTH_KEYS=%q: err %v", tc.keys, err)
+			continue
+		}
+		if tc.callers == 0 {
+			if auth != nil {
+				t.Errorf("RUNNER_AUTH_KEYS=%q enabled auth", tc.keys)
+			}
+			continue
+		}
+		if auth == nil || len(auth.keys) != tc.callers || string(auth.keys["web-debug"]) != "b" || !auth.debugCallers["web-debug"] {
+			t.Errorf("RUNNER_AUTH_KEYS=%q: auth %+v", tc.keys, auth)
+		}
+	}
+}
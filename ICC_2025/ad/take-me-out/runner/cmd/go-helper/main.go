@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"time"
 
 	"goexe-runner/internal/gohelper"
+	"goexe-runner/internal/logging"
+	"goexe-runner/internal/tracing"
 )
 
 type helperPayload struct {
@@ -17,7 +20,8 @@ type helperPayload struct {
 }
 
 func main() {
-	log.SetFlags(0)
+	logging.Setup("go-helper")
+	shutdownTracing := tracing.Setup("go-helper")
 
 	mode := flag.String("mode", "", "execution mode")
 	globalTimeout := flag.Int("global-timeout", 0, "global timeout in milliseconds")
@@ -25,28 +29,35 @@ func main() {
 	codeFile := flag.String("code-file", "", "path to source code file")
 	testsFile := flag.String("tests-file", "", "path to JSON tests file")
 	sandboxEnv := flag.String("sandbox-env", "", "path to sandbox env directory")
+	unprivileged := flag.Bool("unprivileged", false, "run the program without capabilities, in a user namespace")
+	requestID := flag.String("request-id", "", "request ID to tag log lines with")
+	traceParent := flag.String("traceparent", "", "W3C trace context of the calling span")
 	flag.Parse()
+	ctx := tracing.WithTraceparent(context.Background(), *traceParent)
+	if logging.ValidRequestID(*requestID) {
+		ctx = logging.WithRequestID(ctx, *requestID)
+	}
 
 	if *codeFile == "" || *testsFile == "" {
-		fatalJSON("missing --code-file or --tests-file")
+		fatalJSON(ctx, "missing --code-file or --tests-file")
 	}
 
 	code, err := os.ReadFile(*codeFile)
 	if err != nil {
-		fatalJSON(fmt.Sprintf("failed to read code file: %v", err))
+		fatalJSON(ctx, fmt.Sprintf("failed to read code file: %v", err))
 	}
 
 	testBytes, err := os.ReadFile(*testsFile)
 	if err != nil {
-		fatalJSON(fmt.Sprintf("failed to read tests file: %v", err))
+		fatalJSON(ctx, fmt.Sprintf("failed to read tests file: %v", err))
 	}
 
 	var payload helperPayload
 	if err := json.Unmarshal(testBytes, &payload); err != nil {
-		fatalJSON(fmt.Sprintf("failed to decode tests: %v", err))
+		fatalJSON(ctx, fmt.Sprintf("failed to decode tests: %v", err))
 	}
 	if len(payload.Tests) == 0 {
-		fatalJSON("no tests provided")
+		fatalJSON(ctx, "no tests provided")
 	}
 
 	req := gohelper.Request{
@@ -55,12 +66,17 @@ func main() {
 		GlobalTimeoutMs: *globalTimeout,
 		OutputLimit:     *outputLimit,
 		SandboxEnv:      *sandboxEnv,
+		Unprivileged:    *unprivileged,
 		Tests:           payload.Tests,
 	}
 
-	resp := gohelper.Execute(context.Background(), req)
+	resp := gohelper.Execute(ctx, req)
+	flushCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	shutdownTracing(flushCtx)
+	cancel()
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
-		log.Fatalf("go helper: failed to encode response: %v", err)
+		slog.ErrorContext(ctx, "go helper: failed to encode response", "err", err)
+		os.Exit(1)
 	}
 }
 
@@ -73,8 +89,8 @@ func firstNonEmpty(values ...string) string {
 	return ""
 }
 
-func fatalJSON(msg string) {
-	log.Printf("go helper: %s", msg)
+func fatalJSON(ctx context.Context, msg string) {
+	slog.ErrorContext(ctx, "go helper: "+msg)
 	resp := gohelper.Response{Result: "Internal Error", Output: msg, FailedIndex: -1}
 	_ = json.NewEncoder(os.Stdout).Encode(resp)
 	os.Exit(1)
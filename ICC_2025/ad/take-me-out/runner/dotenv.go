@@ -2,7 +2,7 @@ package main
 
 import (
 	"bufio"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 )
@@ -11,7 +11,7 @@ func loadDotEnv() {
 	f, err := os.Open(".env")
 	if err != nil {
 		if !os.IsNotExist(err) {
-			log.Printf("failed to open .env: %v", err)
+			slog.Warn("failed to open .env", "err", err)
 		}
 		return
 	}
@@ -45,6 +45,6 @@ func loadDotEnv() {
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		log.Printf("failed to scan .env: %v", err)
+		slog.Warn("failed to scan .env", "err", err)
 	}
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goexe-runner/internal/sandbox"
+)
+
+// The hostile programs in testdata/escape try one escape each run, named on
+// stdin, and print "ESCAPE: ..." when it works. A seccomp kill therefore
+// only ends the attempt that caused it.
+var (
+	escapePrograms = []struct{ language, file string }{
+		{"c", "escape.c"},
+		{"python", "escape.py"},
+		{"ruby", "escape.rb"},
+		{"go", "escape.go"},
+	}
+	escapeModes    = []string{"default", "nsjail_only", "unprivileged"}
+	escapeAttempts = []string{"flags", "host", "network", "ptrace", "write", "privileges"}
+)
+
+// TestSandboxEscapes runs every hostile program through execute in every
+// sandbox mode. It needs the sandbox environments and backend, and skips the
+// languages that are not installed.
+func TestSandboxEscapes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs real sandboxes")
+	}
+	t.Setenv("RUNNER_GLOBAL_TIMEOUT_MS", "30000")
+
+	var tokenBytes [8]byte
+	if _, err := rand.Read(tokenBytes[:]); err != nil {
+		t.Fatal(err)
+	}
+	token := "escape-" + hex.EncodeToString(tokenBytes[:])
+
+	// what must never show up in a program's output: the flags and a
+	// host file outside every runroot
+	canary := filepath.Join(t.TempDir(), "canary")
+	secrets := []string{"canary-" + token}
+	if err := os.WriteFile(canary, []byte(secrets[0]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range []string{"/flag1", "/flag2"} {
+		if data, err := os.ReadFile(flag); err == nil && strings.TrimSpace(string(data)) != "" {
+			secrets = append(secrets, strings.TrimSpace(string(data)))
+		}
+	}
+
+	// a listener on the host's loopback, which no jail may reach
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// runroots are reused, as in production, so persistence is tested
+	// across pooled runroots
+	sandbox.EnableRunRootPool(sandbox.RunRootPoolConfig{Size: 1, MaxUses: 50})
+	t.Cleanup(sandbox.DrainRunRootPool)
+
+	for _, prog := range escapePrograms {
+		t.Run(prog.language, func(t *testing.T) {
+			if err := sandbox.CheckEnvironment(prog.language); err != nil {
+				t.Skipf("sandbox unavailable: %v", err)
+			}
+			if prog.language == "go" {
+				helper := os.Getenv("GO_HELPER_PATH")
+				if helper == "" {
+					helper = "/usr/local/bin/go-helper"
+				}
+				if _, err := os.Stat(helper); err != nil {
+					t.Skipf("go-helper unavailable: %v", err)
+				}
+			}
+			code, err := os.ReadFile(filepath.Join("testdata", "escape", prog.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mode := range escapeModes {
+				if prog.language == "go" && mode == "nsjail_only" {
+					continue
+				}
+				t.Run(mode, func(t *testing.T) {
+					run := func(t *testing.T, attempt string) {
+						t.Helper()
+						ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+						defer cancel()
+						resp := execute(ctx, RunRequest{
+							Language: prog.language,
+							Code:     string(code),
+							Input:    fmt.Sprintf("%s %s %s %d\n", attempt, token, canary, port),
+							Sandbox:  mode,
+						})
+						checkEscape(t, resp, secrets)
+					}
+					for _, attempt := range escapeAttempts {
+						t.Run(attempt, func(t *testing.T) { run(t, attempt) })
+					}
+					t.Run("persist", func(t *testing.T) {
+						run(t, "persist-write")
+						// past the detached child's sleep
+						time.Sleep(4 * time.Second)
+						run(t, "persist-read")
+					})
+				})
+			}
+			if envRoot := escapeEnvRoot(prog.language); envRoot != "" {
+				for _, dir := range []string{"", "bin", "usr/bin", "etc"} {
+					if _, err := os.Stat(filepath.Join(envRoot, dir, token)); err == nil {
+						t.Errorf("wrote %s into the runtime environment", filepath.Join(dir, token))
+					}
+				}
+			}
+		})
+	}
+	if n := accepted.Load(); n > 0 {
+		t.Errorf("jails reached the host's loopback %d times", n)
+	}
+}
+
+// checkEscape fails t when resp shows an escape, or shows that the program
+// never got to try one.
+func checkEscape(t *testing.T, resp RunResponse, secrets []string) {
+	t.Helper()
+	switch resp.Result {
+	case "Compile Error", "Internal Error", "Unsupported sandbox mode":
+		t.Fatalf("program did not run: %s: %s", resp.Result, resp.Output)
+	}
+	if strings.Contains(resp.Output, "ESCAPE") {
+		t.Errorf("%s", resp.Output)
+	}
+	for _, s := range secrets {
+		if strings.Contains(resp.Output, s) {
+			t.Errorf("output leaks a secret: %s", resp.Output)
+		}
+	}
+	if resp.Result != "Security Violation" && !strings.Contains(resp.Output, "done") {
+		t.Errorf("program did not finish: %s: %s", resp.Result, resp.Output)
+	}
+}
+
+// escapeEnvRoot is the host directory of language's runtime environment.
+func escapeEnvRoot(language string) string {
+	base := os.Getenv("SANDBOX_ENVS_DIR")
+	if base == "" {
+		base = "/opt/sandbox-envs"
+	}
+	root, err := filepath.EvalSymlinks(filepath.Join(base, language))
+	if err != nil {
+		return ""
+	}
+	return root
+}
@@ -4,21 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
-)
 
-const (
-	helperLogClip        = 4096
-	helperTimeoutGraceMs = 3000
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe-runner/internal/logging"
+	"goexe-runner/internal/tracing"
 )
 
+const helperTimeoutGraceMs = 3000
+
 type helperTest struct {
 	Input    string `json:"input"`
 	Output   string `json:"output"`
@@ -30,13 +32,6 @@ type helperPayload struct {
 	Tests []helperTest `json:"tests"`
 }
 
-func clipForLog(s string) string {
-	if len(s) <= helperLogClip {
-		return s
-	}
-	return s[:helperLogClip] + fmt.Sprintf("... (truncated %d bytes)", len(s)-helperLogClip)
-}
-
 func helperExitCode(err error) int {
 	if err == nil {
 		return 0
@@ -47,7 +42,7 @@ func helperExitCode(err error) int {
 	return -1
 }
 
-func executeGoViaHelper(req RunRequest) RunResponse {
+func executeGoViaHelper(parent context.Context, req RunRequest, unprivileged bool) RunResponse {
 	globalLimitMs := 30000
 	if v := os.Getenv("RUNNER_GLOBAL_TIMEOUT_MS"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
@@ -102,14 +97,14 @@ func executeGoViaHelper(req RunRequest) RunResponse {
 
 	jobDir, err := os.MkdirTemp("", "gohelper-")
 	if err != nil {
-		log.Printf("go helper client: mkdtemp failed: %v", err)
+		slog.ErrorContext(parent, "go helper client: mkdtemp failed", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 	defer os.RemoveAll(jobDir)
 
 	codePath := filepath.Join(jobDir, "code.go")
 	if err := os.WriteFile(codePath, []byte(req.Code), 0o600); err != nil {
-		log.Printf("go helper client: writing code failed: %v", err)
+		slog.ErrorContext(parent, "go helper client: writing code failed", "err", err)
 		return sanitize(RunResponse{Result: "Internal Error"})
 	}
 
@@ -120,10 +115,10 @@ func executeGoViaHelper(req RunRequest) RunResponse {
 	payload := helperPayload{Mode: mode, Tests: hTests}
 	testsPath := filepath.Join(jobDir, "tests.json")
 	if data, err := json.Marshal(payload); err != nil {
-		log.Printf("go helper client: marshal tests failed: %v", err)
+		slog.ErrorContext(parent, "go helper client: marshal tests failed", "err", err)
 		return sanitize(RunResponse{Result: "Internal Error"})
 	} else if err := os.WriteFile(testsPath, data, 0o600); err != nil {
-		log.Printf("go helper client: write tests failed: %v", err)
+		slog.ErrorContext(parent, "go helper client: write tests failed", "err", err)
 		return sanitize(RunResponse{Result: "Internal Error"})
 	}
 
@@ -139,42 +134,59 @@ func executeGoViaHelper(req RunRequest) RunResponse {
 	if sandboxEnv != "" {
 		args = append(args, "--sandbox-env", sandboxEnv)
 	}
+	if unprivileged {
+		args = append(args, "--unprivileged")
+	}
+	if id := logging.RequestID(parent); id != "" {
+		args = append(args, "--request-id", id)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(globalLimitMs+helperTimeoutGraceMs)*time.Millisecond)
+	ctx, cancel := context.WithTimeout(parent, time.Duration(globalLimitMs+helperTimeoutGraceMs)*time.Millisecond)
 	defer cancel()
+	// the helper's own spans hang off this one
+	ctx, span := tracer.Start(ctx, "go_helper", trace.WithAttributes(attribute.String("mode", mode)))
+	defer span.End()
+	if tp := tracing.Traceparent(ctx); tp != "" {
+		args = append(args, "--traceparent", tp)
+	}
 
 	cmd := exec.CommandContext(ctx, helperPath, args...)
 	cmd.Env = os.Environ()
-	var combined strings.Builder
-	cmd.Stdout = &combined
-	cmd.Stderr = &combined
+	// the helper writes its verdict to stdout and its own log lines to stderr
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
+	helperStart := time.Now()
 	err = cmd.Run()
+	metricGoHelperSeconds.WithLabelValues(mode).Observe(time.Since(helperStart).Seconds())
 	exitCode := helperExitCode(err)
-	rawOutput := combined.String()
+	tracing.RecordError(span, err)
+	rawOutput := stdout.String()
+	relayHelperLogs(parent, stderr.String())
 
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			log.Printf("go helper client: helper timed out waiting for completion")
+			slog.ErrorContext(parent, "go helper client: helper timed out waiting for completion")
 			return sanitize(RunResponse{Result: "Internal Error"})
 		}
-		log.Printf("go helper client: command failed err=%v exit=%d output=%s", err, exitCode, clipForLog(rawOutput))
-		if combined.Len() == 0 {
+		slog.ErrorContext(parent, "go helper client: command failed", "err", err, "exit", exitCode, logging.Output("output", rawOutput))
+		if stdout.Len() == 0 {
 			return sanitize(RunResponse{Result: "Internal Error"})
 		}
 	}
 
-	if combined.Len() == 0 {
+	if stdout.Len() == 0 {
 		return sanitize(RunResponse{Result: "Internal Error"})
 	}
 
 	var resp RunResponse
 	resp, err = parseHelperResponse(rawOutput)
 	if err != nil {
-		log.Printf("go helper client: failed parsing helper output: %v payload=%s", err, clipForLog(rawOutput))
+		slog.ErrorContext(parent, "go helper client: failed parsing helper output", "err", err, logging.Output("output", rawOutput))
 		return sanitize(RunResponse{Result: "Internal Error"})
 	}
-	if resp.Result == "Compile Error" || (resp.Result != "Wrong Answer" && resp.Result != "Runtime Error" && resp.Result != "Time Limit Exceeded") {
+	if !caseVerdicts[resp.Result] {
 		resp.FailedIndex = -1
 	}
 	return sanitize(resp)
@@ -207,3 +219,25 @@ func parseHelperResponse(raw string) (RunResponse, error) {
 	}
 	return resp, errors.New("no JSON payload detected")
 }
+
+// relayHelperLogs passes the helper's stderr on to the runner's log. The
+// helper logs JSON lines that already carry the request ID, so those are
+// copied through as they are; anything else, such as a panic trace, is
+// wrapped in a single clipped runner log line.
+func relayHelperLogs(ctx context.Context, stderr string) {
+	var other []string
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "{") && json.Valid([]byte(line)) {
+			os.Stderr.WriteString(line + "\n")
+			continue
+		}
+		other = append(other, line)
+	}
+	if len(other) > 0 {
+		slog.WarnContext(ctx, "go helper client: unstructured helper output", logging.Output("output", strings.Join(other, "\n")))
+	}
+}
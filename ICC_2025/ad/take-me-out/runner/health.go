@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"goexe-runner/internal/sandbox"
+)
+
+// healthReport is the JSON body of /healthz and /readyz. Checks only say
+// "ok" or "fail"; why a check failed is logged, not served.
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthzHandler handles GET /healthz: the process is up and serving. It
+// stays healthy while draining so the web tier keeps polling running jobs.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthReport{Status: "ok"})
+}
+
+// readyzHandler handles GET /readyz: the runner can take new work. That
+// needs the test data DB and a usable sandbox environment for every
+// language in RUNNER_LANGUAGES, and fails as soon as draining starts.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	checks := make(map[string]string)
+	ok := true
+	record := func(name string, err error) {
+		checks[name] = "ok"
+		if err != nil {
+			slog.WarnContext(r.Context(), "readiness check failed", "check", name, "err", err)
+			checks[name] = "fail"
+			ok = false
+		}
+	}
+	if draining.Load() {
+		checks["draining"] = "fail"
+		ok = false
+	}
+	if rdb != nil {
+		record("db", rdb.PingContext(ctx))
+	}
+	for _, lang := range runnerLanguages() {
+		record("sandbox_"+lang, checkSandboxEnv(lang))
+	}
+	rep := healthReport{Status: "ok", Checks: checks}
+	if !ok {
+		rep.Status = "unavailable"
+	}
+	writeHealth(w, rep)
+}
+
+func writeHealth(w http.ResponseWriter, rep healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if rep.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
+
+// sandboxChecks caches sandbox.CheckEnvironment results for a few seconds;
+// it stats several files per language and readiness probes can be frequent.
+var sandboxChecks struct {
+	mu      sync.Mutex
+	results map[string]sandboxCheck
+}
+
+type sandboxCheck struct {
+	err error
+	at  time.Time
+}
+
+func checkSandboxEnv(lang string) error {
+	sandboxChecks.mu.Lock()
+	defer sandboxChecks.mu.Unlock()
+	if c, ok := sandboxChecks.results[lang]; ok && time.Since(c.at) < 10*time.Second {
+		return c.err
+	}
+	err := sandbox.CheckEnvironment(lang)
+	if sandboxChecks.results == nil {
+		sandboxChecks.results = make(map[string]sandboxCheck)
+	}
+	sandboxChecks.results[lang] = sandboxCheck{err: err, at: time.Now()}
+	return err
+}
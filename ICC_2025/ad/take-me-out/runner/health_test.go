@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyzHidesFailureDetails(t *testing.T) {
+	envs := t.TempDir() + "/missing-envs"
+	t.Setenv("SANDBOX_ENVS_DIR", envs)
+	t.Setenv("RUNNER_LANGUAGES", "python")
+	sandboxChecks.mu.Lock()
+	sandboxChecks.results = nil
+	sandboxChecks.mu.Unlock()
+	draining.Store(true)
+	defer draining.Store(false)
+
+	rec := httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+	var rep healthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != "unavailable" || rep.Checks["draining"] != "fail" || rep.Checks["sandbox_python"] != "fail" {
+		t.Errorf("report %+v", rep)
+	}
+	if strings.Contains(rec.Body.String(), envs) {
+		t.Errorf("body leaks the sandbox path: %s", rec.Body)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	draining.Store(true)
+	defer draining.Store(false)
+	rec := httptest.NewRecorder()
+	healthzHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("draining runner: healthz %d %v", rec.Code, rec.Header())
+	}
+}
@@ -4,25 +4,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sys/unix"
 
+	"goexe-runner/internal/logging"
 	sandbox "goexe-runner/internal/sandbox"
+	"goexe-runner/internal/tracing"
 )
 
 const (
 	compileTimeout         = 2 * time.Second
-	compileLogLimit        = 4096
 	defaultExecTimeLimitMs = 1000
 )
 
+var tracer = otel.Tracer("goexe-runner/internal/gohelper")
+
 // TestCase represents a single input/output pair for execution.
 type TestCase struct {
 	Input    string `json:"input"`
@@ -37,7 +42,10 @@ type Request struct {
 	GlobalTimeoutMs int
 	OutputLimit     int
 	SandboxEnv      string
-	Tests           []TestCase
+	// Unprivileged runs the compiled program without capabilities, in a
+	// user namespace; compiling still goes through chroot-run.
+	Unprivileged bool
+	Tests        []TestCase
 }
 
 // Response mirrors the runner's RunResponse payload.
@@ -112,9 +120,9 @@ func Execute(ctx context.Context, req Request) Response {
 		_ = os.Setenv("SANDBOX_ENVS_DIR", "/opt/sandbox-envs")
 	}
 
-	buildRR, err := sandbox.PrepareRunRootWithOptions("go", sandbox.PrepareRunRootOptions{ForGoBuilder: true})
+	buildRR, err := sandbox.PrepareRunRootContext(ctx, "go", sandbox.PrepareRunRootOptions{ForGoBuilder: true})
 	if err != nil {
-		log.Printf("go helper: prepare build runroot failed: %v", err)
+		slog.ErrorContext(ctx, "go helper: prepare build runroot failed", "err", err)
 		return sanitize(Response{Result: "Internal Error"})
 	}
 	defer buildRR.Cleanup()
@@ -122,14 +130,9 @@ func Execute(ctx context.Context, req Request) Response {
 	buildWorkspaceHost := buildRR.WorkspaceHost
 	buildWorkspaceInside := buildRR.WorkspaceDir()
 
-	if err := resetDir(filepath.Join(buildWorkspaceHost, ".runner"), 0o755); err != nil {
-		log.Printf("go helper: failed to prepare build capture dir: %v", err)
-		return sanitize(Response{Result: "Internal Error"})
-	}
-
 	codeHostPath := filepath.Join(buildWorkspaceHost, "code.go")
 	if err := os.WriteFile(codeHostPath, []byte(req.Code), 0o644); err != nil {
-		log.Printf("go helper: failed to write code: %v", err)
+		slog.ErrorContext(ctx, "go helper: failed to write code", "err", err)
 		return sanitize(Response{Result: "Internal Error"})
 	}
 
@@ -139,7 +142,7 @@ func Execute(ctx context.Context, req Request) Response {
 	}
 	for _, dir := range cacheDirs {
 		if err := os.MkdirAll(dir, 0o777); err != nil {
-			log.Printf("go helper: failed to prepare go cache dir %s: %v", dir, err)
+			slog.WarnContext(ctx, "go helper: failed to prepare go cache dir", "dir", dir, "err", err)
 			return sanitize(Response{Result: "Internal Error"})
 		}
 	}
@@ -153,9 +156,6 @@ func Execute(ctx context.Context, req Request) Response {
 		defer globalCancel()
 	}
 
-	compileStdoutHost, compileStderrHost, compileStdoutInside, compileStderrInside := capturePaths(buildWorkspaceHost, buildWorkspaceInside, "compile")
-	removeFiles(compileStdoutHost, compileStderrHost)
-
 	compileArgs := []string{
 		"env",
 		"GOCACHE=/tmp/go-build-cache",
@@ -164,46 +164,40 @@ func Execute(ctx context.Context, req Request) Response {
 		"-o", filepath.Join(buildWorkspaceInside, "code"),
 		filepath.Join(buildWorkspaceInside, "code.go"),
 	}
-	compileCmd := buildCaptureCommand(compileArgs, compileStdoutInside, compileStderrInside)
+	compileCmd := buildRunCommand(compileArgs)
 	compileCtx, compileCancel := context.WithTimeout(globalCtx, compileTimeout)
+	_, compileSpan := tracer.Start(globalCtx, "compile", trace.WithAttributes(attribute.String("language", "go")))
 	compileRes, compileErr := sandbox.RunInChroot(compileCtx, buildRR, buildWorkspaceInside, []string{"/bin/sh", "-c", compileCmd}, "", buildGoCompileLimits(outLimit), true)
+	tracing.RecordError(compileSpan, compileErr)
+	compileSpan.End()
 	compileCancel()
 
-	compileStdout, stdoutErr := readFileLimited(compileStdoutHost, outLimit)
-	if stdoutErr != nil {
-		log.Printf("go helper: failed to read compile stdout: %v", stdoutErr)
-	}
-	compileStderr, stderrErr := readFileLimited(compileStderrHost, outLimit)
-	if stderrErr != nil {
-		log.Printf("go helper: failed to read compile stderr: %v", stderrErr)
-	}
-	removeFiles(compileStdoutHost, compileStderrHost)
-	summary := combineOutputs(compileStdout, compileStderr)
-	if summary == "" {
-		summary = combineOutputs(compileRes.Stdout, compileRes.Stderr)
-	}
-	summary = strings.TrimSpace(summary)
+	summary := sandbox.MarkTruncated(combineOutputs(compileRes.Stdout, compileRes.Stderr), compileRes.OutputExceeded || compileRes.StderrTruncated)
 
+	if compileRes.SeccompViolation {
+		slog.WarnContext(ctx, "go helper: seccomp policy killed compile", "syscall", compileRes.Syscall)
+		return sanitize(Response{Result: "Security Violation", Output: "blocked system call: " + compileRes.Syscall, FailedIndex: -1})
+	}
 	if compileErr != nil {
 		if errors.Is(compileCtx.Err(), context.DeadlineExceeded) {
-			log.Printf("go helper: compile deadline exceeded after %s", compileTimeout)
+			slog.InfoContext(ctx, "go helper: compile deadline exceeded", "timeout", compileTimeout)
 		}
 		if summary == "" {
 			summary = compileErr.Error()
 		}
-		log.Printf("go helper: compile failed: %s", clipForLog(summary, compileLogLimit))
+		slog.DebugContext(ctx, "go helper: compile failed", logging.Output("output", summary))
 		return sanitize(Response{Result: "Compile Error", Output: summary, FailedIndex: -1})
 	}
 
 	binaryHostPath := filepath.Join(buildWorkspaceHost, "code")
 	if _, statErr := os.Stat(binaryHostPath); statErr != nil {
-		log.Printf("go helper: compiled binary missing: %v", statErr)
+		slog.ErrorContext(ctx, "go helper: compiled binary missing", "err", statErr)
 		return sanitize(Response{Result: "Internal Error"})
 	}
 
-	runRR, err := sandbox.PrepareRunRootWithOptions("go", sandbox.PrepareRunRootOptions{ForCBuilder: true})
+	runRR, err := sandbox.PrepareRunRootContext(ctx, "go", sandbox.PrepareRunRootOptions{ForCBuilder: true})
 	if err != nil {
-		log.Printf("go helper: prepare runtime runroot failed: %v", err)
+		slog.ErrorContext(ctx, "go helper: prepare runtime runroot failed", "err", err)
 		return sanitize(Response{Result: "Internal Error"})
 	}
 	defer runRR.Cleanup()
@@ -214,20 +208,11 @@ func Execute(ctx context.Context, req Request) Response {
 
 	runtimeBinaryHostPath := filepath.Join(runWorkspaceHost, "code")
 	if err := sandbox.CopyFile(binaryHostPath, runtimeBinaryHostPath, 0o755); err != nil {
-		log.Printf("go helper: failed to copy binary into runtime workspace: %v", err)
+		slog.ErrorContext(ctx, "go helper: failed to copy binary into runtime workspace", "err", err)
 		return sanitize(Response{Result: "Internal Error"})
 	}
 	if err := os.Chmod(runtimeBinaryHostPath, 0o755); err != nil {
-		log.Printf("go helper: failed to chmod runtime binary: %v", err)
-		return sanitize(Response{Result: "Internal Error"})
-	}
-	if _, err := sandbox.RunOnHost(context.Background(), "", []string{"/usr/sbin/setcap", "cap_sys_chroot+ep", runtimeBinaryHostPath}, "", sandbox.RLimits{OutputLimit: 4096}); err != nil {
-		log.Printf("go helper: setcap failed: %v", err)
-		return sanitize(Response{Result: "Internal Error"})
-	}
-
-	if err := resetDir(filepath.Join(runWorkspaceHost, ".runner"), 0o755); err != nil {
-		log.Printf("go helper: failed to reset runtime capture dir: %v", err)
+		slog.ErrorContext(ctx, "go helper: failed to chmod runtime binary", "err", err)
 		return sanitize(Response{Result: "Internal Error"})
 	}
 
@@ -251,7 +236,9 @@ func Execute(ctx context.Context, req Request) Response {
 	}
 
 	runLim := buildGoRunLimits(execLimit, globalLimitMs, outLimit)
+	runLim.Unprivileged = req.Unprivileged
 	argv := []string{insidePath(filepath.Join(runWorkspaceInside, "code"))}
+	limits := sandbox.TimeLimitsFor(execLimit)
 	totalDuration := 0
 	trim := func(s string) string { return strings.TrimSpace(s) }
 	lastStdout := ""
@@ -265,60 +252,62 @@ func Execute(ctx context.Context, req Request) Response {
 			return sanitize(Response{Result: "Time Limit Exceeded", DurationMs: totalDuration, FailedIndex: i, Expected: expected})
 		}
 
-		execCtx, cancel := context.WithTimeout(globalCtx, time.Duration(execLimit)*time.Millisecond)
-		start := time.Now()
-		stdoutHost, stderrHost, stdoutInside, stderrInside := capturePaths(runWorkspaceHost, runWorkspaceInside, fmt.Sprintf("test-%d", i))
-		removeFiles(stdoutHost, stderrHost)
-		runCmd := buildCaptureCommand(argv, stdoutInside, stderrInside)
+		execCtx, cancel := context.WithTimeout(globalCtx, limits.Deadline())
+		runCmd := buildRunCommand(argv)
 		shellPath := "/env/bin/sh"
-		runRes, err := sandbox.RunInChroot(execCtx, runRR, runWorkspaceInside, []string{shellPath, "-c", runCmd}, tc.Input, runLim, false)
-		duration := int(time.Since(start).Milliseconds())
+		matcher := sandbox.NewOutputMatcher(tc.Output)
+		_, testSpan := tracer.Start(globalCtx, "test", trace.WithAttributes(attribute.String("language", "go"), attribute.Int("test.index", i)))
+		runRes, err := sandbox.RunInChrootStream(execCtx, runRR, runWorkspaceInside, []string{shellPath, "-c", runCmd}, tc.Input, runLim, false, matcher)
+		tracing.RecordError(testSpan, err)
+		testSpan.End()
+		runRes.TrimSetup()
+		duration := int(runRes.ProgramTime().Milliseconds())
 		cancel()
 		totalDuration += duration
 
-		runStdout, outErr := readFileLimited(stdoutHost, outLimit)
-		if outErr != nil {
-			log.Printf("go helper: failed to read run stdout: %v", outErr)
-		}
-		runStderr, errErr := readFileLimited(stderrHost, outLimit)
-		if errErr != nil {
-			log.Printf("go helper: failed to read run stderr: %v", errErr)
-		}
-		removeFiles(stdoutHost, stderrHost)
-
-		trimmedStdout := trim(runStdout)
+		trimmedStdout := sandbox.MarkTruncated(trim(runRes.Stdout), runRes.OutputExceeded)
 		lastStdout = trimmedStdout
-		combined := combineOutputs(runStdout, runStderr)
-		if combined == "" {
-			combined = trim(runRes.Stdout + "\n" + runRes.Stderr)
+		combined := sandbox.MarkTruncated(combineOutputs(runRes.Stdout, runRes.Stderr), runRes.OutputExceeded || runRes.StderrTruncated)
+		if verdict := timeVerdict(limits, runRes, execCtx, globalCtx); verdict != "" {
+			expected := ""
+			if revealExpected {
+				expected = tc.Output
+			}
+			return sanitize(Response{Result: verdict, Output: trimmedStdout, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
 		}
-		if err != nil {
-			if errors.Is(execCtx.Err(), context.DeadlineExceeded) || errors.Is(globalCtx.Err(), context.DeadlineExceeded) {
-				expected := ""
-				if revealExpected {
-					expected = tc.Output
-				}
-				return sanitize(Response{Result: "Time Limit Exceeded", Output: trimmedStdout, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
+		if runRes.OOMKilled {
+			expected := ""
+			if revealExpected {
+				expected = tc.Output
 			}
-			log.Printf("go helper: runtime error: %v output=%s", err, combined)
+			return sanitize(Response{Result: "Memory Limit Exceeded", Output: trimmedStdout, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
+		}
+		if runRes.ScratchExceeded || runRes.OutputExceeded {
 			expected := ""
 			if revealExpected {
 				expected = tc.Output
 			}
-			return sanitize(Response{Result: "Runtime Error", Output: combined, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
+			return sanitize(Response{Result: "Output Limit Exceeded", Output: trimmedStdout, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
 		}
-
-		if errors.Is(execCtx.Err(), context.DeadlineExceeded) || errors.Is(globalCtx.Err(), context.DeadlineExceeded) {
+		if runRes.SeccompViolation {
+			slog.WarnContext(ctx, "go helper: seccomp policy killed submission", "test", i, "syscall", runRes.Syscall)
+			expected := ""
+			if revealExpected {
+				expected = tc.Output
+			}
+			return sanitize(Response{Result: "Security Violation", Output: "blocked system call: " + runRes.Syscall, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
+		}
+		if err != nil {
+			slog.DebugContext(ctx, "go helper: runtime error", "test", i, "err", err, logging.Output("output", combined))
 			expected := ""
 			if revealExpected {
 				expected = tc.Output
 			}
-			return sanitize(Response{Result: "Time Limit Exceeded", Output: trimmedStdout, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
+			return sanitize(Response{Result: "Runtime Error", Output: combined, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
 		}
 
-		expectedTrim := trim(tc.Output)
 		if singleMode {
-			if expectedTrim != "" && trim(trimmedStdout) != expectedTrim {
+			if trim(tc.Output) != "" && !matcher.Matched() {
 				expected := ""
 				if revealExpected {
 					expected = tc.Output
@@ -326,7 +315,7 @@ func Execute(ctx context.Context, req Request) Response {
 				return sanitize(Response{Result: "Wrong Answer", Output: trimmedStdout, DurationMs: totalDuration, FailedIndex: i, Expected: expected})
 			}
 		} else {
-			if trim(trimmedStdout) != expectedTrim {
+			if !matcher.Matched() {
 				expected := ""
 				if revealExpected {
 					expected = tc.Output
@@ -336,7 +325,7 @@ func Execute(ctx context.Context, req Request) Response {
 		}
 
 		if err := sandbox.ResetChrootTmp(runRR); err != nil {
-			log.Printf("go helper: failed to reset tmp: %v", err)
+			slog.ErrorContext(ctx, "go helper: failed to reset tmp", "err", err)
 			return sanitize(Response{Result: "Internal Error"})
 		}
 	}
@@ -348,6 +337,30 @@ func Execute(ctx context.Context, req Request) Response {
 	return sanitize(resp)
 }
 
+// timeVerdict names the verdict for a run that overran its time limits, or
+// returns "" if it did not.
+func timeVerdict(limits sandbox.TimeLimits, res sandbox.RunResult, execCtx, globalCtx context.Context) string {
+	if errors.Is(globalCtx.Err(), context.DeadlineExceeded) {
+		return "Time Limit Exceeded"
+	}
+	switch limits.Check(res, errors.Is(execCtx.Err(), context.DeadlineExceeded)) {
+	case sandbox.CPULimitExceeded:
+		return "Time Limit Exceeded"
+	case sandbox.WallLimitExceeded:
+		return "Idleness Limit Exceeded"
+	}
+	return ""
+}
+
+// envLimit reads a resource limit from key, falling back to def unless the
+// variable holds a positive number.
+func envLimit(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
 func buildGoCompileLimits(outputLimit int) sandbox.RLimits {
 	toBytes := func(mb int) int { return mb * 1024 * 1024 }
 
@@ -371,6 +384,10 @@ func buildGoCompileLimits(outputLimit int) sandbox.RLimits {
 	if n, err := strconv.Atoi(os.Getenv("RUN_LIMIT_COMPILE_NOFILE")); err == nil && n > 0 {
 		nofile = n
 	}
+	// the Go toolchain needs more than the C compiler
+	memory := toBytes(envLimit("RUN_LIMIT_COMPILE_MEMORY_MB", 1024))
+	pids := envLimit("RUN_LIMIT_COMPILE_PIDS", 128)
+	cpuPercent := envLimit("RUN_LIMIT_COMPILE_CPU_PERCENT", 100)
 
 	return sandbox.RLimits{
 		CPUSeconds:  cpuSeconds,
@@ -379,6 +396,10 @@ func buildGoCompileLimits(outputLimit int) sandbox.RLimits {
 		NProc:       nproc,
 		NOFile:      nofile,
 		OutputLimit: outputLimit,
+		MemoryBytes: memory,
+		Pids:        pids,
+		CPUPercent:  cpuPercent,
+		Seccomp:     sandbox.SeccompPolicyFor("go", sandbox.PhaseCompile),
 	}
 }
 
@@ -412,47 +433,34 @@ func buildGoRunLimits(execLimit, globalLimitMs, outputLimit int) sandbox.RLimits
 	if n, err := strconv.Atoi(os.Getenv("RUN_LIMIT_NOFILE")); err == nil && n > 0 {
 		nofile = n
 	}
+	memory := toBytes(envLimit("RUN_LIMIT_MEMORY_MB", 256))
+	pids := envLimit("RUN_LIMIT_PIDS", 64)
+	cpuPercent := envLimit("RUN_LIMIT_CPU_PERCENT", 100)
+	scratch := toBytes(envLimit("RUN_LIMIT_SCRATCH_MB", 64))
+	scratchInodes := envLimit("RUN_LIMIT_SCRATCH_INODES", 1024)
 
 	return sandbox.RLimits{
-		CPUSeconds:  cpuSeconds,
-		ASBytes:     asLimit,
-		FSizeBytes:  fsizeLimit,
-		NProc:       nproc,
-		NOFile:      nofile,
-		OutputLimit: outputLimit,
+		CPUSeconds:    cpuSeconds,
+		ASBytes:       asLimit,
+		FSizeBytes:    fsizeLimit,
+		NProc:         nproc,
+		NOFile:        nofile,
+		OutputLimit:   outputLimit,
+		MemoryBytes:   memory,
+		Pids:          pids,
+		CPUPercent:    cpuPercent,
+		Seccomp:       sandbox.SeccompPolicyFor("go", sandbox.PhaseRun),
+		ScratchBytes:  scratch,
+		ScratchInodes: scratchInodes,
 	}
 }
 
-func capturePaths(hostWork, workdir, base string) (stdoutHost, stderrHost, stdoutInside, stderrInside string) {
-	stdoutHost = filepath.Join(hostWork, ".runner", base+".stdout")
-	stderrHost = filepath.Join(hostWork, ".runner", base+".stderr")
-	stdoutInside = filepath.Join(workdir, ".runner", base+".stdout")
-	stderrInside = filepath.Join(workdir, ".runner", base+".stderr")
-	return
-}
-
-func removeFiles(paths ...string) {
-	for _, p := range paths {
-		if p == "" {
-			continue
-		}
-		_ = os.Remove(p)
-	}
-}
-
-func buildCaptureCommand(argv []string, stdoutPath, stderrPath string) string {
-	var sb strings.Builder
-	sb.WriteString("rm -f ")
-	sb.WriteString(shellQuote(stdoutPath))
-	sb.WriteByte(' ')
-	sb.WriteString(shellQuote(stderrPath))
-	sb.WriteString(" && exec ")
-	sb.WriteString(joinShellArgs(argv))
-	sb.WriteString(" > ")
-	sb.WriteString(shellQuote(stdoutPath))
-	sb.WriteString(" 2> ")
-	sb.WriteString(shellQuote(stderrPath))
-	return sb.String()
+// buildRunCommand runs argv between reports on sandbox.StatusFD of its
+// start and of the scratch tmpfs it left; the program does not inherit the
+// descriptor.
+func buildRunCommand(argv []string) string {
+	return fmt.Sprintf("echo %s >&%d && { %s %d>&-; rc=$?; %s >&%d; exit $rc; }",
+		sandbox.StatusStart, sandbox.StatusFD, joinShellArgs(argv), sandbox.StatusFD, sandbox.ScratchReport, sandbox.StatusFD)
 }
 
 func joinShellArgs(argv []string) string {
@@ -473,30 +481,6 @@ func shellQuote(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
 }
 
-func readFileLimited(path string, limit int) (string, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "", nil
-		}
-		return "", err
-	}
-	if limit > 0 && len(data) > limit {
-		data = data[:limit]
-	}
-	return string(data), nil
-}
-
-func resetDir(path string, perm fs.FileMode) error {
-	if err := os.RemoveAll(path); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	if err := os.MkdirAll(path, perm); err != nil {
-		return err
-	}
-	return os.Chmod(path, perm)
-}
-
 func combineOutputs(stdout, stderr string) string {
 	s := strings.TrimSpace(stdout)
 	t := strings.TrimSpace(stderr)
@@ -508,10 +492,3 @@ func combineOutputs(stdout, stderr string) string {
 	}
 	return strings.TrimSpace(s + "\n" + t)
 }
-
-func clipForLog(s string, limit int) string {
-	if limit <= 0 || len(s) <= limit {
-		return s
-	}
-	return s[:limit] + "... (truncated)"
-}
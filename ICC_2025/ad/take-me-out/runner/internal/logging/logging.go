@@ -0,0 +1,145 @@
+// Package logging configures structured JSON logging through log/slog and
+// carries a request ID in contexts, so one submission can be followed from
+// the web tier through the runner, the sandbox and the go helper. It also
+// holds the helpers every log line uses for user code and program output,
+// which must never be logged in full.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HeaderRequestID carries the request ID between services.
+const HeaderRequestID = "X-Request-ID"
+
+// defaultClip bounds program output and tool stderr in log lines.
+const defaultClip = 512
+
+var clipLimit = defaultClip
+
+// Setup installs a JSON slog handler on stderr as the default logger. The
+// level comes from LOG_LEVEL (debug, info, warn, error; default info) and
+// LOG_CLIP_BYTES bounds clipped values. Lines written through the standard
+// log package go through the same handler at info level.
+func Setup(service string) {
+	SetupWriter(os.Stderr, service)
+}
+
+// SetupWriter is Setup with an explicit destination.
+func SetupWriter(w io.Writer, service string) {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("LOG_CLIP_BYTES"))); err == nil && n > 0 {
+		clipLimit = n
+	}
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: levelFromEnv()})
+	logger := slog.New(contextHandler{h})
+	if service != "" {
+		logger = logger.With("service", service)
+	}
+	slog.SetDefault(logger)
+}
+
+func levelFromEnv() slog.Level {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL"))) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// contextHandler adds the request ID found in the record's context.
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying id. An empty id leaves ctx unchanged.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 16-byte hex ID.
+func NewRequestID() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// ValidRequestID accepts IDs supplied by callers: 1-64 characters from
+// [A-Za-z0-9._-], so they are safe to echo in headers and log lines.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Clip shortens s to LOG_CLIP_BYTES, noting how much was cut.
+func Clip(s string) string {
+	if len(s) <= clipLimit {
+		return s
+	}
+	cut := clipLimit
+	// do not split a UTF-8 sequence
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + fmt.Sprintf("...(%d bytes truncated)", len(s)-cut)
+}
+
+// Output is a log attribute for program or tool output, clipped.
+func Output(key, s string) slog.Attr {
+	return slog.String(key, Clip(s))
+}
+
+// Code is a log attribute standing in for user source code: its size and a
+// short hash, enough to tell submissions apart without logging the code.
+func Code(code string) slog.Attr {
+	sum := sha256.Sum256([]byte(code))
+	return slog.Group("code", slog.Int("bytes", len(code)), slog.String("sha256", hex.EncodeToString(sum[:8])))
+}
@@ -0,0 +1,117 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Backend starts the jails RunInChroot and LaunchInteractive describe with
+// an NsjailConfig. The caller owns the returned command: it wires stdio,
+// places it in a cgroup, runs it, and calls release once it has exited.
+type Backend interface {
+	// Name is the backend's SANDBOX_BACKEND value.
+	Name() string
+	// Available reports why the backend cannot start jails on this host.
+	Available() error
+	// Command returns the command that runs cfg.Argv in the jail cfg
+	// describes. ctx kills the jail.
+	Command(ctx context.Context, cfg *NsjailConfig) (cmd *exec.Cmd, release func(), err error)
+}
+
+// Backends are the sandbox backends, the default first.
+func Backends() []Backend {
+	return []Backend{nsjailBackend{}, bwrapBackend{}}
+}
+
+var (
+	backendOnce sync.Once
+	backend     Backend
+	backendErr  error
+)
+
+// BackendNamed returns the backend SANDBOX_BACKEND value name selects;
+// empty selects nsjail.
+func BackendNamed(name string) (Backend, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return Backends()[0], nil
+	}
+	var names []string
+	for _, b := range Backends() {
+		if b.Name() == name {
+			return b, nil
+		}
+		names = append(names, b.Name())
+	}
+	return nil, fmt.Errorf("unknown sandbox backend %q (SANDBOX_BACKEND is one of %s)", name, strings.Join(names, ", "))
+}
+
+func loadBackend() {
+	backend, backendErr = BackendNamed(os.Getenv("SANDBOX_BACKEND"))
+	if backendErr != nil {
+		backend = unknownBackend{backendErr}
+	}
+}
+
+// InitBackend selects the backend SANDBOX_BACKEND names. The runner calls
+// it at startup and refuses to start on an unknown name.
+func InitBackend() error {
+	backendOnce.Do(loadBackend)
+	return backendErr
+}
+
+// CurrentBackend is the backend SANDBOX_BACKEND names, nsjail by default.
+// Under an unknown name every run fails rather than falling back to
+// another backend.
+func CurrentBackend() Backend {
+	backendOnce.Do(loadBackend)
+	return backend
+}
+
+// unknownBackend stands in for a SANDBOX_BACKEND that names no backend.
+type unknownBackend struct{ err error }
+
+func (b unknownBackend) Name() string     { return "unknown" }
+func (b unknownBackend) Available() error { return b.err }
+
+func (b unknownBackend) Command(context.Context, *NsjailConfig) (*exec.Cmd, func(), error) {
+	return nil, nil, b.err
+}
+
+// checkExecutable reports why path cannot be run.
+func checkExecutable(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() || st.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// nsjailBackend runs jails with nsjail, from the config rendered to a file.
+type nsjailBackend struct{}
+
+func (nsjailBackend) Name() string { return "nsjail" }
+
+func (nsjailBackend) Available() error {
+	return checkExecutable(nsjailBinary())
+}
+
+func (nsjailBackend) Command(ctx context.Context, cfg *NsjailConfig) (*exec.Cmd, func(), error) {
+	if cfg == nil || len(cfg.Argv) == 0 {
+		return nil, nil, errors.New("no argv provided")
+	}
+	configPath, err := writeNsjailConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("prepare nsjail config: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, nsjailBinary(), "--config", configPath)
+	return cmd, func() { _ = os.Remove(configPath) }, nil
+}
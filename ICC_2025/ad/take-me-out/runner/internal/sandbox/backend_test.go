@@ -0,0 +1,199 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The conformance suite runs hostile shell scripts through every backend
+// that is available on the host. It needs the sandbox environments
+// (SANDBOX_ENVS_DIR) and skips without them.
+
+// conformanceLimits are the run limits of a judged submission.
+var conformanceLimits = RLimits{
+	CPUSeconds:    2,
+	ASBytes:       512 << 20,
+	FSizeBytes:    16 << 20,
+	NProc:         64,
+	NOFile:        64,
+	OutputLimit:   4096,
+	MemoryBytes:   256 << 20,
+	Pids:          32,
+	CPUPercent:    100,
+	Seccomp:       SeccompRun,
+	Unprivileged:  true,
+	ScratchBytes:  8 << 20,
+	ScratchInodes: 256,
+}
+
+// conformanceGrace is how long a jail may outlive its context.
+const conformanceGrace = 3 * time.Second
+
+type conformanceRun struct {
+	res     RunResult
+	err     error
+	elapsed time.Duration
+}
+
+func runScript(t *testing.T, b Backend, rr *RunRoot, script string, lim RLimits, timeout time.Duration) conformanceRun {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	res, err := runInChroot(ctx, b, rr, rr.WorkspaceDir(), []string{"/bin/bash", "-c", script}, "", lim, false, nil)
+	run := conformanceRun{res: res, err: err, elapsed: time.Since(start)}
+	if run.elapsed > timeout+conformanceGrace {
+		t.Errorf("jail ran %v, past its %v deadline", run.elapsed, timeout)
+	}
+	return run
+}
+
+func TestBackendConformance(t *testing.T) {
+	for _, b := range Backends() {
+		t.Run(b.Name(), func(t *testing.T) {
+			if err := b.Available(); err != nil {
+				t.Skipf("backend unavailable: %v", err)
+			}
+			rr, err := PrepareRunRoot("base")
+			if err != nil {
+				t.Skipf("no sandbox environment: %v", err)
+			}
+			t.Cleanup(rr.Cleanup)
+			if run := runScript(t, b, rr, "echo ok", conformanceLimits, 5*time.Second); run.err != nil || strings.TrimSpace(run.res.Stdout) != "ok" {
+				t.Fatalf("trivial run failed: %v: %q %q", run.err, run.res.Stdout, run.res.Stderr)
+			}
+
+			t.Run("fork bomb", func(t *testing.T) {
+				// as root without a pids cgroup nothing would stop it
+				if CgroupsAvailable() != nil && os.Getuid() == 0 {
+					t.Skip("needs cgroups or an unprivileged uid")
+				}
+				runScript(t, b, rr, `f() { f | f & }; f; sleep 2`, conformanceLimits, 5*time.Second)
+				run := runScript(t, b, rr, "echo ok", conformanceLimits, 5*time.Second)
+				if run.err != nil || strings.TrimSpace(run.res.Stdout) != "ok" {
+					t.Errorf("sandbox unusable after a fork bomb: %v: %q", run.err, run.res.Stderr)
+				}
+			})
+
+			t.Run("network", func(t *testing.T) {
+				// without the seccomp policy, so the network namespace
+				// alone has to hold
+				lim := conformanceLimits
+				lim.Seccomp = ""
+				run := runScript(t, b, rr, `
+for addr in 1.1.1.1/53 8.8.8.8/53 127.0.0.1/9000; do
+  (exec 3<>/dev/tcp/$addr) 2>/dev/null && echo "connected $addr"
+done
+echo done`, lim, 10*time.Second)
+				if strings.Contains(run.res.Stdout, "connected") || !strings.Contains(run.res.Stdout, "done") {
+					t.Errorf("network reachable: %q", run.res.Stdout)
+				}
+			})
+
+			t.Run("file escape", func(t *testing.T) {
+				secret := filepath.Join(t.TempDir(), "secret")
+				if err := os.WriteFile(secret, []byte("host-secret-4b1d"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				run := runScript(t, b, rr, `
+cat `+secret+` /../../..`+secret+` /proc/1/root`+secret+` 2>/dev/null
+cd / && cd .. && cat .`+secret+` 2>/dev/null
+for d in / /env /workspace /env/workspace; do echo x > $d/escaped; done 2>/dev/null
+echo done`, conformanceLimits, 5*time.Second)
+				if strings.Contains(run.res.Stdout, "host-secret") {
+					t.Errorf("read a host file: %q", run.res.Stdout)
+				}
+				for _, p := range []string{
+					filepath.Join(rr.Root, "escaped"),
+					filepath.Join(rr.WorkHost, "escaped"),
+					filepath.Join(rr.WorkspaceHost, "escaped"),
+				} {
+					if _, err := os.Stat(p); err == nil {
+						t.Errorf("wrote %s", p)
+					}
+				}
+			})
+
+			t.Run("timeout", func(t *testing.T) {
+				for _, script := range []string{"while :; do :; done", "sleep 60"} {
+					run := runScript(t, b, rr, script, conformanceLimits, time.Second)
+					if run.err == nil {
+						t.Errorf("%q finished without an error", script)
+					}
+				}
+			})
+
+			t.Run("output flood", func(t *testing.T) {
+				run := runScript(t, b, rr, "yes", conformanceLimits, 3*time.Second)
+				if n := len(run.res.Stdout); n > conformanceLimits.OutputLimit {
+					t.Errorf("kept %d bytes of output, limit %d", n, conformanceLimits.OutputLimit)
+				}
+				if !run.res.OutputExceeded || run.err == nil {
+					t.Errorf("flood not reported: %v", run.err)
+				}
+				if run.elapsed >= 3*time.Second {
+					t.Errorf("flood ran to its deadline instead of being killed at the limit")
+				}
+			})
+		})
+	}
+}
+
+func TestBwrapArgsGolden(t *testing.T) {
+	hostIDs = func() (int, int) { return 10001, 10001 }
+	t.Cleanup(func() { hostIDs = func() (int, int) { return os.Getuid(), os.Getgid() } })
+	rr := testRunRoot(t)
+	c, err := NsjailConfigFor(rr, "/workspace", []string{"/bin/sh", "-c", "exec ./prog > out"}, conformanceLimits, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := bwrapArgs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args = append(prlimitArgs(c.Rlimits), args...)
+	got := strings.NewReplacer(rr.EnvRoot, "$ENV", rr.Root, "$ROOT").Replace(strings.Join(args, "\n") + "\n")
+	golden := filepath.Join("testdata", "bwrap", "run.args")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("arguments differ from %s:\n%s", golden, got)
+	}
+}
+
+func TestBackendNamed(t *testing.T) {
+	for name, want := range map[string]string{"": "nsjail", "nsjail": "nsjail", " BWrap ": "bwrap"} {
+		b, err := BackendNamed(name)
+		if err != nil || b.Name() != want {
+			t.Errorf("BackendNamed(%q) = %v, %v, want %s", name, b, err, want)
+		}
+	}
+	if b, err := BackendNamed("docker"); err == nil || b != nil {
+		t.Errorf("unknown backend accepted: %v", b)
+	}
+}
+
+func TestUnknownBackendRefusesRuns(t *testing.T) {
+	_, err := BackendNamed("docker")
+	b := unknownBackend{err}
+	if b.Available() == nil {
+		t.Error("an unknown backend is available")
+	}
+	if cmd, _, err := b.Command(context.Background(), &NsjailConfig{Argv: []string{"/bin/true"}}); err == nil || cmd != nil {
+		t.Error("an unknown backend started a jail")
+	}
+}
@@ -0,0 +1,192 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// bwrapBackend runs jails with bubblewrap. bwrap sets no_new_privs and
+// creates the user namespace itself when it is not setuid, so the
+// chroot-run helper only works with CAP_SYS_CHROOT inside that namespace.
+// The rest of the config maps onto bwrap options, with three differences:
+// rlimits are set by prlimit around bwrap, nsjail's time limit becomes a
+// context deadline, and tmpfs mounts take a size but no inode limit.
+type bwrapBackend struct{}
+
+func bwrapBinary() string {
+	if p := os.Getenv("BWRAP_PATH"); p != "" {
+		return p
+	}
+	return "/usr/bin/bwrap"
+}
+
+func (bwrapBackend) Name() string { return "bwrap" }
+
+func (bwrapBackend) Available() error {
+	if err := checkExecutable(bwrapBinary()); err != nil {
+		return err
+	}
+	return checkExecutable(prlimitPath)
+}
+
+const prlimitPath = "/usr/bin/prlimit"
+
+// bwrapSeccompFD is the descriptor the seccomp filter is passed on, past
+// the ones runInChroot attaches.
+const bwrapSeccompFD = StatusFD + 1
+
+func (bwrapBackend) Command(ctx context.Context, cfg *NsjailConfig) (*exec.Cmd, func(), error) {
+	if cfg == nil || len(cfg.Argv) == 0 {
+		return nil, nil, errors.New("no argv provided")
+	}
+	args, err := bwrapArgs(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {}
+	if cfg.TimeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.TimeLimit)
+		release = cancel
+	}
+	argv := append(prlimitArgs(cfg.Rlimits), bwrapBinary())
+	cmd := exec.CommandContext(ctx, prlimitPath, append(argv, args...)...)
+	if cfg.Seccomp != "" {
+		filter, err := seccompFilterBytes(cfg.Seccomp, cfg.SeccompChroot)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		// a filter is far smaller than the pipe buffer
+		_, err = w.Write(filter)
+		w.Close()
+		if err != nil {
+			r.Close()
+			release()
+			return nil, nil, err
+		}
+		setExtraFile(cmd, bwrapSeccompFD, r)
+		cancel := release
+		release = func() {
+			r.Close()
+			cancel()
+		}
+	}
+	return cmd, release, nil
+}
+
+// prlimitArgs are the prlimit options setting rl; HARD and SOFT keep the
+// runner's own limits.
+func prlimitArgs(rl NsjailRlimits) []string {
+	args := []string{}
+	for _, l := range []struct {
+		opt   string
+		r     Rlimit
+		scale int
+	}{
+		{"--as", rl.AS, 1 << 20},
+		{"--cpu", rl.CPU, 1},
+		{"--fsize", rl.FSize, 1 << 20},
+		{"--nofile", rl.NOFile, 1},
+		{"--nproc", rl.NProc, 1},
+	} {
+		switch l.r.Type {
+		case "":
+			args = append(args, fmt.Sprintf("%s=%d", l.opt, l.r.Value*l.scale))
+		case "INF":
+			args = append(args, l.opt+"=unlimited")
+		}
+	}
+	return append(args, "--")
+}
+
+// bwrapArgs translates cfg to bwrap's command line, up to and including
+// the command.
+func bwrapArgs(cfg *NsjailConfig) ([]string, error) {
+	args := []string{"--die-with-parent", "--new-session"}
+	ns := cfg.Namespaces
+	if !ns.Mount {
+		return nil, errors.New("bwrap always creates a mount namespace")
+	}
+	for _, n := range []struct {
+		on   bool
+		flag string
+	}{
+		{ns.User, "--unshare-user"},
+		{ns.Net, "--unshare-net"},
+		{ns.PID, "--unshare-pid"},
+		{ns.IPC, "--unshare-ipc"},
+		{ns.UTS, "--unshare-uts"},
+		{ns.Cgroup, "--unshare-cgroup-try"},
+	} {
+		if n.on {
+			args = append(args, n.flag)
+		}
+	}
+	if cfg.UIDMap != nil {
+		args = append(args, "--uid", strconv.Itoa(cfg.UIDMap.Inside))
+	}
+	if cfg.GIDMap != nil {
+		args = append(args, "--gid", strconv.Itoa(cfg.GIDMap.Inside))
+	}
+	args = append(args, "--cap-drop", "ALL")
+	if cfg.KeepCaps {
+		for _, c := range cfg.Caps {
+			args = append(args, "--cap-add", c)
+		}
+	}
+	if !cfg.KeepEnv {
+		args = append(args, "--clearenv")
+	}
+	for _, e := range cfg.Env {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad environment entry %q", e)
+		}
+		args = append(args, "--setenv", k, v)
+	}
+	for _, m := range cfg.Mounts {
+		switch m.FSType {
+		case "":
+			opt := "--ro-bind"
+			if m.RW {
+				opt = "--bind"
+			}
+			args = append(args, opt, m.Src, m.Dst)
+		case "tmpfs":
+			for _, o := range strings.Split(m.Options, ",") {
+				k, v, _ := strings.Cut(o, "=")
+				switch k {
+				case "size":
+					args = append(args, "--size", v)
+				case "mode":
+					args = append(args, "--perms", v)
+				}
+			}
+			args = append(args, "--tmpfs", m.Dst)
+		default:
+			return nil, fmt.Errorf("bwrap cannot mount %s at %s", m.FSType, m.Dst)
+		}
+	}
+	if cfg.MountProc {
+		args = append(args, "--proc", "/proc")
+	}
+	if cfg.Cwd != "" {
+		args = append(args, "--chdir", cfg.Cwd)
+	}
+	if cfg.Seccomp != "" {
+		args = append(args, "--seccomp", strconv.Itoa(bwrapSeccompFD))
+	}
+	args = append(args, "--")
+	return append(args, cfg.Argv...), nil
+}
@@ -0,0 +1,264 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cgroupFSRoot is where the unified (v2) hierarchy is mounted.
+const cgroupFSRoot = "/sys/fs/cgroup"
+
+// cgroupControllers are enabled for the per-run cgroups.
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// cgroupPeriodUs is the cpu.max period; the quota is a share of it.
+const cgroupPeriodUs = 100000
+
+var (
+	cgroupOnce   sync.Once
+	cgroupParent string
+	cgroupErr    error
+)
+
+// CgroupsAvailable reports whether runs are placed in cgroups, and why not.
+// The first call sets the cgroups up; the runner makes it at startup, before
+// it starts any other process.
+func CgroupsAvailable() error {
+	_, err := sandboxCgroupParent()
+	return err
+}
+
+// sandboxCgroupParent returns the cgroup under which each run gets its own
+// child. SANDBOX_CGROUP names it explicitly ("off" disables cgroups); by
+// default it is the runner's own cgroup, after the runner has moved itself
+// into a "runner" leaf so the controllers can be enabled for children.
+func sandboxCgroupParent() (string, error) {
+	cgroupOnce.Do(func() {
+		cgroupParent, cgroupErr = setupCgroupParent()
+		// Helpers the runner starts (go-helper) inherit the outcome instead
+		// of moving processes around again
+		if cgroupErr != nil {
+			slog.Warn("sandbox: cgroup v2 limits disabled", "err", cgroupErr)
+			os.Setenv("SANDBOX_CGROUP", "off")
+		} else {
+			slog.Info("sandbox: cgroup v2 limits enabled", "cgroup", cgroupParent)
+			os.Setenv("SANDBOX_CGROUP", cgroupParent)
+		}
+	})
+	return cgroupParent, cgroupErr
+}
+
+func setupCgroupParent() (string, error) {
+	setting := strings.TrimSpace(os.Getenv("SANDBOX_CGROUP"))
+	switch strings.ToLower(setting) {
+	case "off", "0", "false", "none":
+		return "", errors.New("disabled by SANDBOX_CGROUP")
+	case "", "auto":
+		setting = ""
+	}
+	if _, err := os.Stat(filepath.Join(cgroupFSRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 not mounted at %s: %w", cgroupFSRoot, err)
+	}
+	parent := setting
+	if parent == "" {
+		own, err := ownCgroup()
+		if err != nil {
+			return "", err
+		}
+		parent = filepath.Join(cgroupFSRoot, own)
+		// A cgroup with processes in it cannot hand controllers to its
+		// children, so the runner moves into a leaf of its own
+		if err := evacuateCgroup(parent, filepath.Join(parent, "runner")); err != nil {
+			return "", err
+		}
+	} else if !filepath.IsAbs(parent) {
+		parent = filepath.Join(cgroupFSRoot, parent)
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	enable := make([]string, 0, len(cgroupControllers))
+	for _, c := range cgroupControllers {
+		enable = append(enable, "+"+c)
+	}
+	if err := writeCgroupFile(parent, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return "", fmt.Errorf("enable controllers in %s: %w", parent, err)
+	}
+	return parent, nil
+}
+
+// ownCgroup returns the runner's cgroup path relative to the v2 root.
+func ownCgroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("no cgroup v2 entry in /proc/self/cgroup")
+}
+
+// evacuateCgroup moves every process in from into the child cgroup to.
+func evacuateCgroup(from, to string) error {
+	if err := os.MkdirAll(to, 0o755); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(from, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(data)) {
+		if err := writeCgroupFile(to, "cgroup.procs", pid); err != nil {
+			return fmt.Errorf("move pid %s into %s: %w", pid, to, err)
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644)
+}
+
+// runCgroup is the cgroup of a single sandboxed execution. nsjail is started
+// directly inside it, so the limits cover the jail and everything it spawns.
+type runCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newRunCgroup creates a cgroup for one run and applies the memory, pids and
+// cpu limits from lim. It returns nil when cgroups are unavailable.
+func newRunCgroup(lim RLimits) (*runCgroup, error) {
+	parent, err := sandboxCgroupParent()
+	if err != nil {
+		return nil, nil
+	}
+	path, err := os.MkdirTemp(parent, "run-")
+	if err != nil {
+		return nil, err
+	}
+	cg := &runCgroup{path: path}
+	settings := []struct{ file, value string }{
+		{"memory.max", cgroupLimit(lim.MemoryBytes)},
+		// Swapping would hide memory use from memory.max
+		{"memory.swap.max", "0"},
+		{"pids.max", cgroupLimit(lim.Pids)},
+		{"cpu.max", cpuMax(lim.CPUPercent)},
+	}
+	for _, s := range settings {
+		err := writeCgroupFile(path, s.file, s.value)
+		if err != nil && !(s.file == "memory.swap.max" && errors.Is(err, os.ErrNotExist)) {
+			cg.remove()
+			return nil, fmt.Errorf("set %s: %w", s.file, err)
+		}
+	}
+	cg.dir, err = os.Open(path)
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// deferToCgroup lifts the address-space and per-UID process rlimits where
+// the cgroup enforces the real limit instead: RLIMIT_AS misjudges runtimes
+// that reserve large virtual ranges (Go, the JVM), and RLIMIT_NPROC counts
+// every process of the sandbox UID, not just this run.
+func (c *NsjailConfig) deferToCgroup(lim RLimits) {
+	if lim.MemoryBytes > 0 {
+		c.Rlimits.AS = Rlimit{Type: "HARD"}
+	}
+	if lim.Pids > 0 {
+		c.Rlimits.NProc = Rlimit{Type: "HARD"}
+	}
+}
+
+func cgroupLimit(v int) string {
+	if v <= 0 {
+		return "max"
+	}
+	return strconv.Itoa(v)
+}
+
+func cpuMax(percent int) string {
+	if percent <= 0 {
+		return fmt.Sprintf("max %d", cgroupPeriodUs)
+	}
+	return fmt.Sprintf("%d %d", cgroupPeriodUs*percent/100, cgroupPeriodUs)
+}
+
+// fd is passed to clone3 (SysProcAttr.CgroupFD) to start nsjail in the
+// cgroup.
+func (cg *runCgroup) fd() int {
+	return int(cg.dir.Fd())
+}
+
+// usage reads back what the run consumed. Files missing on older kernels
+// (memory.peak needs 5.19) leave their fields zero.
+func (cg *runCgroup) usage(res *RunResult) {
+	if v, err := readCgroupInt(cg.path, "memory.peak"); err == nil {
+		res.MemoryPeakBytes = v
+	}
+	stat := readCgroupKeyed(cg.path, "cpu.stat")
+	res.CPUTime = time.Duration(stat["usage_usec"]) * time.Microsecond
+	res.OOMKilled = readCgroupKeyed(cg.path, "memory.events")["oom_kill"] > 0
+	res.PidsLimitHit = readCgroupKeyed(cg.path, "pids.events")["max"] > 0
+}
+
+// remove kills whatever is left in the cgroup and deletes it.
+func (cg *runCgroup) remove() {
+	if cg.dir != nil {
+		_ = cg.dir.Close()
+	}
+	_ = writeCgroupFile(cg.path, "cgroup.kill", "1")
+	var err error
+	// rmdir fails with EBUSY until the killed processes are reaped
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(cg.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	slog.Warn("sandbox: cgroup cleanup failed", "cgroup", cg.path, "err", err)
+}
+
+func readCgroupInt(dir, name string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readCgroupKeyed parses a flat-keyed file such as cpu.stat ("key value"
+// per line).
+func readCgroupKeyed(dir, name string) map[string]int64 {
+	out := make(map[string]int64)
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return out
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			out[key] = n
+		}
+	}
+	return out
+}
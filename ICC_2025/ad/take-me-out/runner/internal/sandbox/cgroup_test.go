@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupLimit(t *testing.T) {
+	for v, want := range map[int]string{-1: "max", 0: "max", 1: "1", 64: "64", 256 << 20: "268435456"} {
+		if got := cgroupLimit(v); got != want {
+			t.Errorf("cgroupLimit(%d) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestCPUMax(t *testing.T) {
+	for percent, want := range map[int]string{
+		-5:  "max 100000",
+		0:   "max 100000",
+		1:   "1000 100000",
+		50:  "50000 100000",
+		100: "100000 100000",
+		// more than one CPU
+		250: "250000 100000",
+	} {
+		if got := cpuMax(percent); got != want {
+			t.Errorf("cpuMax(%d) = %q, want %q", percent, got, want)
+		}
+	}
+}
+
+func TestDeferToCgroup(t *testing.T) {
+	c := &NsjailConfig{Rlimits: NsjailRlimits{AS: Rlimit{Value: 512}, NProc: Rlimit{Value: 64}}}
+	c.deferToCgroup(RLimits{})
+	if c.Rlimits.AS.Value != 512 || c.Rlimits.NProc.Value != 64 {
+		t.Errorf("without cgroup limits the rlimits changed: %+v", c.Rlimits)
+	}
+	c.deferToCgroup(RLimits{MemoryBytes: 256 << 20, Pids: 32})
+	if c.Rlimits.AS != (Rlimit{Type: "HARD"}) || c.Rlimits.NProc != (Rlimit{Type: "HARD"}) {
+		t.Errorf("rlimits not lifted: %+v", c.Rlimits)
+	}
+}
+
+func TestRunCgroupUsage(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"memory.peak":   "1048576\n",
+		"cpu.stat":      "usage_usec 250000\nuser_usec 200000\nsystem_usec 50000\nnr_periods 0\n",
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"pids.events":   "max 0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var res RunResult
+	(&runCgroup{path: dir}).usage(&res)
+	if res.MemoryPeakBytes != 1<<20 || res.CPUTime != 250*time.Millisecond || !res.OOMKilled || res.PidsLimitHit {
+		t.Errorf("usage %+v", res)
+	}
+
+	// kernels without memory.peak, or a cgroup already gone, leave zeros
+	res = RunResult{}
+	(&runCgroup{path: filepath.Join(dir, "missing")}).usage(&res)
+	if res.MemoryPeakBytes != 0 || res.CPUTime != 0 || res.OOMKilled || res.PidsLimitHit {
+		t.Errorf("usage of a missing cgroup %+v", res)
+	}
+}
+
+func TestReadCgroupKeyed(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "stat"), []byte("a 1\nbroken\nb  2 \nc x\n"), 0o644)
+	got := readCgroupKeyed(dir, "stat")
+	if len(got) != 2 || got["a"] != 1 || got["b"] != 2 {
+		t.Errorf("readCgroupKeyed = %v", got)
+	}
+}
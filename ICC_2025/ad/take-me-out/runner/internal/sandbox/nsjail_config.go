@@ -1,41 +1,416 @@
 package sandbox
 
 import (
-	_ "embed"
+	"errors"
+	"fmt"
 	"os"
-	"sync"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-//go:embed nsjail_config.cfg
-var nsjailConfigData string
+// NsjailConfig is everything nsjail is told about one run. RunInChroot and
+// LaunchInteractive render it to nsjail's protobuf text format (config.proto)
+// and pass nothing else on nsjail's command line, so String shows exactly
+// what a run was started with.
+type NsjailConfig struct {
+	Cwd string
+	// TimeLimit is nsjail's own wall-clock limit, a backstop behind the
+	// caller's context; zero means none.
+	TimeLimit time.Duration
 
-var (
-	configOnce sync.Once
-	configPath string
-	configErr  error
-)
+	Namespaces Namespaces
+	// KeepCaps keeps Caps inside the jail; otherwise nsjail drops all
+	// capabilities.
+	KeepCaps          bool
+	Caps              []string
+	DisableNoNewPrivs bool
+	// UIDMap and GIDMap map one id into a user namespace; nil keeps the
+	// caller's ids.
+	UIDMap *IDMap
+	GIDMap *IDMap
+
+	// KeepEnv passes nsjail's own environment on; Env is added to it.
+	KeepEnv bool
+	Env     []string
+
+	Rlimits NsjailRlimits
+	// Mounts are made in order; the first one is the jail's root.
+	Mounts    []Mount
+	MountProc bool
+	IfaceNoLo bool
+	// Seccomp names the seccomp policy; empty runs unfiltered.
+	// SeccompChroot exempts chroot(2) for the chroot-run helper.
+	Seccomp       string
+	SeccompChroot bool
+
+	// LogFD is the descriptor nsjail logs to, kept apart from the
+	// program's stderr; zero logs to stderr. PassFDs stay open in the
+	// jail. runInChroot attaches pipes to both.
+	LogFD   int
+	PassFDs []int
+
+	// Argv is the command; nsjail resolves Argv[0] inside the jail.
+	Argv []string
+}
+
+// Namespaces selects the namespaces nsjail creates.
+type Namespaces struct {
+	User, Net, Mount, PID, IPC, UTS, Cgroup bool
+}
+
+// IDMap maps Inside, an id in the jail, to Outside, an id on the host.
+type IDMap struct {
+	Inside, Outside int
+}
+
+// Mount is a bind mount when FSType is empty and a fresh filesystem (tmpfs)
+// otherwise.
+type Mount struct {
+	Src     string
+	Dst     string
+	FSType  string
+	Options string
+	RW      bool
+}
+
+// Rlimit is a process resource limit. Type "" applies Value; "SOFT",
+// "HARD" and "INF" take nsjail's current soft or hard limit or lift it.
+type Rlimit struct {
+	Value int
+	Type  string
+}
+
+// NsjailRlimits are in nsjail's units: MiB for AS and FSize, seconds for
+// CPU.
+type NsjailRlimits struct {
+	AS, CPU, FSize, NOFile, NProc Rlimit
+}
+
+// defaultNsjailRlimits apply where RLimits leaves a field zero.
+var defaultNsjailRlimits = NsjailRlimits{
+	AS:     Rlimit{Value: 2048},
+	CPU:    Rlimit{Value: 5},
+	FSize:  Rlimit{Value: 262144},
+	NOFile: Rlimit{Value: 2048},
+	NProc:  Rlimit{Value: 2048},
+}
+
+// rlimitsFor converts lim's process limits to nsjail's units.
+func rlimitsFor(lim RLimits) NsjailRlimits {
+	mib := func(b int) int { return (b + 1<<20 - 1) >> 20 }
+	r := defaultNsjailRlimits
+	if lim.CPUSeconds > 0 {
+		r.CPU = Rlimit{Value: lim.CPUSeconds}
+	}
+	if lim.ASBytes > 0 {
+		r.AS = Rlimit{Value: mib(lim.ASBytes)}
+	} else if lim.ASBytes < 0 {
+		r.AS = Rlimit{Type: "INF"}
+	}
+	if lim.FSizeBytes > 0 {
+		r.FSize = Rlimit{Value: mib(lim.FSizeBytes)}
+	}
+	if lim.NProc > 0 {
+		r.NProc = Rlimit{Value: lim.NProc}
+	}
+	if lim.NOFile > 0 {
+		r.NOFile = Rlimit{Value: lim.NOFile}
+	}
+	return r
+}
+
+// hostIDs are the runner's uid and gid, which unprivileged runs map to.
+var hostIDs = func() (int, int) { return os.Getuid(), os.Getgid() }
+
+// NsjailConfigFor is the configuration RunInChroot starts argv with, apart
+// from what depends on the run's context and cgroup: the time limit, and
+// rlimits a cgroup enforces instead.
+func NsjailConfigFor(rr *RunRoot, workdir string, argv []string, lim RLimits, useChrootRunner bool) (*NsjailConfig, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("no argv provided")
+	}
+	if rr == nil {
+		return nil, errors.New("runroot not prepared")
+	}
+	if lim.Unprivileged && useChrootRunner {
+		return nil, errors.New("unprivileged runs cannot use the chroot-run helper")
+	}
+	if workdir == "" {
+		workdir = rr.WorkDir()
+	}
+	pathEnv := "PATH=/.runner:/usr/local/bin:/usr/bin:/bin"
+	if !useChrootRunner {
+		pathEnv = "PATH=/.runner:/env/usr/local/bin:/env/usr/bin:/env/bin:/usr/local/bin:/usr/bin:/bin"
+	}
+	c := baseNsjailConfig(rr, pathEnv)
+	c.Cwd = "/"
+	c.Rlimits = rlimitsFor(lim)
+	if lim.Unprivileged {
+		// nsjail drops every capability and sets no_new_privs by itself.
+		// The jail's nobody maps to the runner's own uid so it can write the
+		// workspace the runner prepared; the chroot itself is mounted
+		// read-only, leaving only the workspace and tmp bind mounts writable
+		uid, gid := hostIDs()
+		c.Namespaces.User = true
+		c.KeepCaps = false
+		c.Caps = nil
+		c.DisableNoNewPrivs = false
+		c.UIDMap = &IDMap{Inside: unprivilegedID, Outside: uid}
+		c.GIDMap = &IDMap{Inside: unprivilegedID, Outside: gid}
+	}
+	if lim.Seccomp != "" {
+		if _, ok := seccompPolicies[lim.Seccomp]; !ok {
+			return nil, fmt.Errorf("unknown seccomp policy %q", lim.Seccomp)
+		}
+		c.Seccomp, c.SeccompChroot = lim.Seccomp, useChrootRunner
+	}
+	if !useChrootRunner && rr.EnvRoot != "" {
+		for _, dir := range []string{"bin", "lib", "lib64", "usr", "etc"} {
+			hostPath := filepath.Join(rr.EnvRoot, dir)
+			if st, err := os.Stat(hostPath); err == nil && st.IsDir() {
+				_ = ensureDirWithPerm(filepath.Join(rr.Root, dir), 0o755)
+				c.Mounts = append(c.Mounts, Mount{Src: hostPath, Dst: "/" + dir})
+			}
+		}
+	}
+	c.addRunRootMounts(rr, lim)
+	c.LogFD, c.PassFDs = jailLogFD, []int{StatusFD}
+
+	innerWorkdir := strings.TrimSpace(workdir)
+	if innerWorkdir == "" {
+		innerWorkdir = "/"
+	} else if !strings.HasPrefix(innerWorkdir, "/") {
+		innerWorkdir = "/" + innerWorkdir
+	}
+	if useChrootRunner {
+		chrootDest := rr.WorkDir()
+		if chrootDest == "" {
+			chrootDest = "/"
+		}
+		binPath := rr.ChrootBin
+		if binPath == "" {
+			binPath = chrootRunPath
+		}
+		c.Argv = append([]string{binPath, chrootDest, innerWorkdir, "--"}, argv...)
+	} else {
+		// execute directly under nsjail root with desired cwd
+		c.Cwd = innerWorkdir
+		c.Argv = append([]string(nil), argv...)
+	}
+	return c, nil
+}
+
+// baseNsjailConfig is the jail every run starts from: fresh namespaces
+// without a network, the runroot as a read-only root, and CAP_SYS_CHROOT
+// for the chroot-run helper.
+func baseNsjailConfig(rr *RunRoot, pathEnv string) *NsjailConfig {
+	return &NsjailConfig{
+		Namespaces: Namespaces{
+			Net: true, Mount: true, PID: true, IPC: true, UTS: true, Cgroup: true,
+		},
+		KeepCaps:          true,
+		Caps:              []string{"CAP_SYS_CHROOT"},
+		DisableNoNewPrivs: true,
+		KeepEnv:           true,
+		Env: []string{
+			pathEnv,
+			"HOME=/tmp",
+			"TMPDIR=/tmp",
+			"LANG=C.UTF-8",
+			"LD=/usr/bin/x86_64-linux-gnu-ld",
+		},
+		Rlimits:   defaultNsjailRlimits,
+		Mounts:    []Mount{{Src: rr.Root, Dst: "/"}},
+		IfaceNoLo: true,
+	}
+}
+
+// addRunRootMounts binds the runroot's workspace and tmp. With a scratch
+// limit the program writes only to tmpfs; its workspace is read-only.
+func (c *NsjailConfig) addRunRootMounts(rr *RunRoot, lim RLimits) {
+	scratch := scratchLimited(lim)
+	var tmpDests []string
+	for _, m := range rr.mounts {
+		dest := strings.TrimPrefix(m.target, rr.Root)
+		if !strings.HasPrefix(dest, "/") {
+			dest = "/" + dest
+		}
+		dest = filepath.Clean(dest)
+		rw := !m.readOnly
+		if scratch {
+			switch m.host {
+			case rr.TmpHost:
+				tmpDests = append(tmpDests, dest)
+				continue
+			case rr.WorkspaceHost:
+				rw = false
+			}
+		}
+		c.Mounts = append(c.Mounts, Mount{Src: m.host, Dst: dest, RW: rw})
+	}
+	if scratch {
+		c.Mounts = append(c.Mounts, scratchMounts(rr, lim, tmpDests)...)
+	}
+}
+
+// deadlineTimeLimit sets TimeLimit a second past d, so nsjail only steps in
+// when the caller failed to stop the run.
+func (c *NsjailConfig) deadlineTimeLimit(d time.Duration) {
+	if d > 0 {
+		c.TimeLimit = d + time.Second
+	}
+}
+
+// String renders c in nsjail's protobuf text format.
+func (c *NsjailConfig) String() string {
+	var sb strings.Builder
+	field := func(name string, v any) {
+		switch v := v.(type) {
+		case string:
+			fmt.Fprintf(&sb, "%s: %s\n", name, protoQuote(v))
+		default:
+			fmt.Fprintf(&sb, "%s: %v\n", name, v)
+		}
+	}
+	sb.WriteString("mode: ONCE\n")
+	if c.Cwd != "" {
+		field("cwd", c.Cwd)
+	}
+	field("time_limit", int((c.TimeLimit+time.Second-1)/time.Second))
+	if c.LogFD > 0 {
+		field("log_fd", c.LogFD)
+	}
+	for _, fd := range c.PassFDs {
+		field("pass_fd", fd)
+	}
+	sb.WriteString("\n")
+
+	field("clone_newuser", c.Namespaces.User)
+	field("clone_newnet", c.Namespaces.Net)
+	field("clone_newns", c.Namespaces.Mount)
+	field("clone_newpid", c.Namespaces.PID)
+	field("clone_newipc", c.Namespaces.IPC)
+	field("clone_newuts", c.Namespaces.UTS)
+	field("clone_newcgroup", c.Namespaces.Cgroup)
+	field("keep_caps", c.KeepCaps)
+	for _, cp := range c.Caps {
+		field("cap", cp)
+	}
+	field("disable_no_new_privs", c.DisableNoNewPrivs)
+	for _, idm := range []struct {
+		name string
+		m    *IDMap
+	}{{"uidmap", c.UIDMap}, {"gidmap", c.GIDMap}} {
+		if idm.m != nil {
+			fmt.Fprintf(&sb, "%s {\n  inside_id: %s\n  outside_id: %s\n  count: 1\n}\n",
+				idm.name, protoQuote(strconv.Itoa(idm.m.Inside)), protoQuote(strconv.Itoa(idm.m.Outside)))
+		}
+	}
+	sb.WriteString("\n")
+
+	field("keep_env", c.KeepEnv)
+	for _, e := range c.Env {
+		field("envar", e)
+	}
+	sb.WriteString("\n")
+
+	for _, rl := range []struct {
+		name string
+		r    Rlimit
+	}{
+		{"rlimit_as", c.Rlimits.AS},
+		{"rlimit_cpu", c.Rlimits.CPU},
+		{"rlimit_fsize", c.Rlimits.FSize},
+		{"rlimit_nofile", c.Rlimits.NOFile},
+		{"rlimit_nproc", c.Rlimits.NProc},
+	} {
+		if rl.r.Type != "" {
+			fmt.Fprintf(&sb, "%s_type: %s\n", rl.name, rl.r.Type)
+			continue
+		}
+		field(rl.name, rl.r.Value)
+	}
+	sb.WriteString("\n")
+
+	field("iface_no_lo", c.IfaceNoLo)
+	field("mount_proc", c.MountProc)
+	for _, m := range c.Mounts {
+		sb.WriteString("mount {\n")
+		if m.FSType == "" {
+			fmt.Fprintf(&sb, "  src: %s\n", protoQuote(m.Src))
+		}
+		fmt.Fprintf(&sb, "  dst: %s\n", protoQuote(m.Dst))
+		if m.FSType == "" {
+			sb.WriteString("  is_bind: true\n")
+		} else {
+			fmt.Fprintf(&sb, "  fstype: %s\n", protoQuote(m.FSType))
+		}
+		if m.Options != "" {
+			fmt.Fprintf(&sb, "  options: %s\n", protoQuote(m.Options))
+		}
+		fmt.Fprintf(&sb, "  rw: %t\n}\n", m.RW)
+	}
+
+	// NsjailConfigFor has checked the policy name
+	if program, err := seccompProgram(c.Seccomp, c.SeccompChroot); c.Seccomp != "" && err == nil {
+		sb.WriteString("\n")
+		field("seccomp_string", program)
+	}
+
+	if len(c.Argv) > 0 {
+		sb.WriteString("\nexec_bin {\n")
+		fmt.Fprintf(&sb, "  path: %s\n", protoQuote(c.Argv[0]))
+		for _, a := range c.Argv[1:] {
+			fmt.Fprintf(&sb, "  arg: %s\n", protoQuote(a))
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+// protoQuote quotes s as a protobuf text format string. Anything outside
+// printable ASCII is written as octal escapes, byte by byte.
+func protoQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if b < 0x20 || b >= 0x7f {
+				fmt.Fprintf(&sb, `\%03o`, b)
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
 
-func nsjailConfigPath() (string, error) {
-	configOnce.Do(func() {
-		f, err := os.CreateTemp("", "nsjail-config-*.cfg")
-		if err != nil {
-			configErr = err
-			return
-		}
-		if _, err := f.WriteString(nsjailConfigData); err != nil {
-			_ = f.Close()
-			configErr = err
-			return
-		}
-		if err := f.Close(); err != nil {
-			configErr = err
-			return
-		}
-		if err := os.Chmod(f.Name(), 0o644); err != nil {
-			configErr = err
-			return
-		}
-		configPath = f.Name()
-	})
-	return configPath, configErr
+// writeNsjailConfig renders c to a temporary file for nsjail's --config.
+func writeNsjailConfig(c *NsjailConfig) (string, error) {
+	f, err := os.CreateTemp("", "nsjail-*.cfg")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(c.String()); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
 }
@@ -0,0 +1,141 @@
+package sandbox
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden nsjail configs in testdata")
+
+// testRunRoot lays out a runroot the way prepareRunRoot does, with a
+// runtime environment holding usr and etc.
+func testRunRoot(t *testing.T) *RunRoot {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	envRoot := filepath.Join(base, "env")
+	for _, dir := range []string{"usr", "etc"} {
+		if err := os.MkdirAll(filepath.Join(envRoot, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rr := &RunRoot{
+		Root:              root,
+		WorkInside:        "/env",
+		WorkHost:          filepath.Join(root, "env"),
+		WorkspaceInside:   "/workspace",
+		WorkspaceRelative: "/workspace",
+		WorkspaceHost:     filepath.Join(root, "workspace"),
+		TmpInside:         "/tmp",
+		TmpHost:           filepath.Join(root, "tmp"),
+		ChrootBin:         "/.runner/chroot-run",
+		EnvRoot:           envRoot,
+	}
+	rr.mounts = []bindMount{
+		{host: filepath.Join(envRoot, "usr"), target: filepath.Join(rr.WorkHost, "usr"), readOnly: true},
+		{host: rr.WorkspaceHost, target: rr.WorkspaceHost},
+		{host: rr.WorkspaceHost, target: filepath.Join(rr.WorkHost, "workspace")},
+		{host: rr.TmpHost, target: filepath.Join(rr.WorkHost, "tmp")},
+		{host: "/dev/null", target: filepath.Join(rr.WorkHost, "dev/null")},
+	}
+	return rr
+}
+
+func TestNsjailConfigGolden(t *testing.T) {
+	hostIDs = func() (int, int) { return 10001, 10001 }
+	t.Cleanup(func() { hostIDs = func() (int, int) { return os.Getuid(), os.Getgid() } })
+
+	runLim := RLimits{
+		CPUSeconds:  2,
+		ASBytes:     1 << 30,
+		FSizeBytes:  16 << 20,
+		NProc:       64,
+		NOFile:      128,
+		MemoryBytes: 256 << 20,
+		Pids:        64,
+	}
+	tests := []struct {
+		name   string
+		config func(rr *RunRoot) (*NsjailConfig, error)
+	}{
+		{"defaults", func(rr *RunRoot) (*NsjailConfig, error) {
+			return NsjailConfigFor(rr, "", []string{"/bin/true"}, RLimits{}, false)
+		}},
+		{"compile", func(rr *RunRoot) (*NsjailConfig, error) {
+			lim := runLim
+			lim.CPUSeconds = 10
+			lim.Seccomp = SeccompCompile
+			return NsjailConfigFor(rr, "/workspace", []string{"/bin/sh", "-c", "cc -O2 main.c"}, lim, true)
+		}},
+		{"run", func(rr *RunRoot) (*NsjailConfig, error) {
+			lim := runLim
+			lim.Seccomp = SeccompRun
+			lim.ScratchBytes = 64 << 20
+			lim.ScratchInodes = 1024
+			c, err := NsjailConfigFor(rr, "/workspace", []string{"/bin/sh", "-c", "exec ./prog > out"}, lim, false)
+			if err == nil {
+				c.deferToCgroup(lim)
+				c.deadlineTimeLimit(2500 * time.Millisecond)
+			}
+			return c, err
+		}},
+		{"unprivileged", func(rr *RunRoot) (*NsjailConfig, error) {
+			lim := runLim
+			lim.Unprivileged = true
+			return NsjailConfigFor(rr, "/env/workspace", []string{"/usr/bin/python3", "code.py"}, lim, false)
+		}},
+		{"interactive", func(rr *RunRoot) (*NsjailConfig, error) {
+			return InteractiveNsjailConfig(rr, rr.WorkspaceHost, []string{"/bin/sh"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := testRunRoot(t)
+			c, err := tt.config(rr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.NewReplacer(rr.EnvRoot, "$ENV", rr.Root, "$ROOT").Replace(c.String())
+			golden := filepath.Join("testdata", "nsjail", tt.name+".cfg")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("config differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestNsjailConfigRejectsUnprivilegedChrootRunner(t *testing.T) {
+	rr := testRunRoot(t)
+	if _, err := NsjailConfigFor(rr, "", []string{"/bin/true"}, RLimits{Unprivileged: true}, true); err == nil {
+		t.Fatal("unprivileged run with the chroot-run helper was accepted")
+	}
+}
+
+func TestProtoQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":         `"plain"`,
+		"a \"b\" \\ c":  `"a \"b\" \\ c"`,
+		"line\nnext\tx": `"line\nnext\tx"`,
+		"\x01é":         `"\001\303\251"`,
+	} {
+		if got := protoQuote(in); got != want {
+			t.Errorf("protoQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
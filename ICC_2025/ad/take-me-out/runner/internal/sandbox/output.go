@@ -0,0 +1,134 @@
+package sandbox
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TruncationMarker ends an output that was cut at its limit. The web UI
+// recognises it, so it has to stay in step with runnerclient's copy.
+const TruncationMarker = "\n[output truncated]"
+
+// MarkTruncated appends TruncationMarker to s when truncated is set.
+func MarkTruncated(s string, truncated bool) string {
+	if !truncated {
+		return s
+	}
+	return s + TruncationMarker
+}
+
+// outputCapture is the runner's end of a jail's stdout or stderr. It keeps
+// the first max bytes, passes them on to tee as they arrive, and calls
+// overflow on the first byte past max, which kills the jail: a program
+// cannot make the runner hold or read more than the limit, however much it
+// prints. A max of zero keeps everything.
+type outputCapture struct {
+	mu       sync.Mutex
+	buf      []byte
+	max      int
+	tee      io.Writer
+	overflow func()
+	exceeded bool
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(p)
+	if c.exceeded {
+		return n, nil
+	}
+	if c.max > 0 && len(c.buf)+len(p) > c.max {
+		p = p[:c.max-len(c.buf)]
+		c.exceeded = true
+		if c.overflow != nil {
+			c.overflow()
+		}
+	}
+	c.buf = append(c.buf, p...)
+	if c.tee != nil && len(p) > 0 {
+		// the tee only observes the output; its errors must not look like
+		// the program failing to write
+		_, _ = c.tee.Write(p)
+	}
+	return n, nil
+}
+
+// Exceeded reports whether the output went past the limit.
+func (c *outputCapture) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exceeded
+}
+
+func (c *outputCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return string(c.buf)
+}
+
+// OutputMatcher compares a program's output with the expected output as it
+// is written, ignoring leading and trailing white space as
+// strings.TrimSpace does, so the output never has to be held whole.
+type OutputMatcher struct {
+	want     string
+	pos      int
+	started  bool
+	mismatch bool
+	// partial holds a rune split across writes.
+	partial []byte
+}
+
+// NewOutputMatcher returns a matcher for the expected output want.
+func NewOutputMatcher(want string) *OutputMatcher {
+	return &OutputMatcher{want: strings.TrimSpace(want)}
+}
+
+func (m *OutputMatcher) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(m.partial) > 0 {
+		p = append(m.partial, p...)
+		m.partial = nil
+	}
+	for len(p) > 0 && !m.mismatch {
+		if !utf8.FullRune(p) {
+			m.partial = append([]byte(nil), p...)
+			break
+		}
+		r, size := utf8.DecodeRune(p)
+		m.next(r, p[:size])
+		p = p[size:]
+	}
+	return n, nil
+}
+
+func (m *OutputMatcher) next(r rune, b []byte) {
+	space := r != utf8.RuneError && unicode.IsSpace(r)
+	switch {
+	case !m.started && space:
+	case m.pos < len(m.want):
+		m.started = true
+		if !strings.HasPrefix(m.want[m.pos:], string(b)) {
+			m.mismatch = true
+			return
+		}
+		m.pos += len(b)
+	case !space:
+		// past the end of want only trailing white space may follow
+		m.mismatch = true
+	}
+}
+
+// Matched reports whether everything written so far equals the expected
+// output.
+func (m *OutputMatcher) Matched() bool {
+	for _, b := range m.partial {
+		// an incomplete rune at the end is invalid, never white space
+		m.next(utf8.RuneError, []byte{b})
+	}
+	m.partial = nil
+	return !m.mismatch && m.pos == len(m.want)
+}
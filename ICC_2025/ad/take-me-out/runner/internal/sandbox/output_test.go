@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		want   string
+		writes []string
+		match  bool
+	}{
+		{"", nil, true},
+		{"", []string{" \n\t"}, true},
+		{"", []string{"x"}, false},
+		{"42", []string{"42"}, true},
+		{"42\n", []string{"\n  42", "\n\n"}, true},
+		{"1 2 3", []string{"1 ", "2", " 3\n"}, true},
+		{"1 2 3", []string{"1  2 3"}, false},
+		{"1 2 3", []string{"1 2"}, false},
+		{"1 2", []string{"1 2 3"}, false},
+		{"héllo", []string{"h\xc3", "\xa9llo"}, true},
+		{"h", []string{"h\xc3"}, false},
+		{"a", []string{"a "}, true},
+	} {
+		m := NewOutputMatcher(tc.want)
+		for _, w := range tc.writes {
+			m.Write([]byte(w))
+		}
+		if got := m.Matched(); got != tc.match {
+			t.Errorf("want %q, output %q: matched %v", tc.want, strings.Join(tc.writes, ""), got)
+		}
+	}
+}
+
+func TestOutputCapture(t *testing.T) {
+	killed := 0
+	var seen strings.Builder
+	c := &outputCapture{max: 8, tee: &seen, overflow: func() { killed++ }}
+	for _, w := range []string{"hello", " world", "!"} {
+		if n, err := c.Write([]byte(w)); n != len(w) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", w, n, err)
+		}
+	}
+	if c.String() != "hello wo" || seen.String() != "hello wo" {
+		t.Errorf("kept %q, passed on %q", c.String(), seen.String())
+	}
+	if !c.Exceeded() || killed != 1 {
+		t.Errorf("exceeded %v, overflow called %d times", c.Exceeded(), killed)
+	}
+}
@@ -0,0 +1,271 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Every job of a language gets an identical runroot, and building one (a
+// temp tree, placeholders for every env entry, a setcap'd chroot-run copy)
+// costs more than a short sample test. The runner therefore keeps a few
+// built runroots per language and hands them out again after wiping their
+// workspace and tmp. Only the writable bind mounts change during a run; the
+// chroot itself is mounted read-only.
+
+// RunRootPoolConfig sizes the runroot pool.
+type RunRootPoolConfig struct {
+	// Size is how many idle runroots are kept per language and options.
+	Size int
+	// MaxUses is how many jobs a runroot serves before it is rebuilt.
+	MaxUses int
+}
+
+// RunRootPoolConfigFromEnv reads SANDBOX_POOL_SIZE (default 2, 0 disables
+// the pool) and SANDBOX_POOL_MAX_USES (default 50).
+func RunRootPoolConfigFromEnv() RunRootPoolConfig {
+	cfg := RunRootPoolConfig{Size: 2, MaxUses: 50}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SANDBOX_POOL_SIZE"))); err == nil && n >= 0 {
+		cfg.Size = n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SANDBOX_POOL_MAX_USES"))); err == nil && n > 0 {
+		cfg.MaxUses = n
+	}
+	return cfg
+}
+
+type runRootPool struct {
+	cfg  RunRootPoolConfig
+	mu   sync.Mutex
+	idle map[string][]*pooledRunRoot
+}
+
+// pooledRunRoot is a runroot the pool owns between leases.
+type pooledRunRoot struct {
+	rr       *RunRoot
+	language string
+	uses     int
+	destroy  func()
+}
+
+var (
+	poolMu sync.Mutex
+	pool   *runRootPool
+
+	metricRunRootPool = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sandbox_runroot_pool_total",
+		Help: "Runroot requests by language and whether the pool served them.",
+	}, []string{"language", "outcome"})
+	metricRunRootPoolDiscards = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sandbox_runroot_pool_discards_total",
+		Help: "Pooled runroots thrown away, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(poolIdleCollector{})
+}
+
+var poolIdleDesc = prometheus.NewDesc("sandbox_runroot_pool_idle", "Idle pooled runroots by language.", []string{"language"}, nil)
+
+// poolIdleCollector counts the idle pooled runroots at scrape time.
+type poolIdleCollector struct{}
+
+func (poolIdleCollector) Describe(ch chan<- *prometheus.Desc) { ch <- poolIdleDesc }
+
+func (poolIdleCollector) Collect(ch chan<- prometheus.Metric) {
+	p := currentPool()
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	counts := make(map[string]int)
+	for _, entries := range p.idle {
+		for _, e := range entries {
+			counts[e.language]++
+		}
+	}
+	p.mu.Unlock()
+	for lang, n := range counts {
+		ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(n), lang)
+	}
+}
+
+// EnableRunRootPool makes PrepareRunRoot* reuse runroots. Only long-lived
+// processes (the runner) should enable it: runroots left idle at exit are
+// only removed by DrainRunRootPool.
+func EnableRunRootPool(cfg RunRootPoolConfig) {
+	if cfg.Size <= 0 || strings.EqualFold(os.Getenv("SANDBOX_KEEP_RUNROOT"), "1") {
+		return
+	}
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if pool == nil {
+		pool = &runRootPool{cfg: cfg, idle: make(map[string][]*pooledRunRoot)}
+		slog.Info("sandbox: runroot pool enabled", "size", cfg.Size, "max_uses", cfg.MaxUses)
+	}
+}
+
+func currentPool() *runRootPool {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	return pool
+}
+
+// WarmRunRootPool builds runroots for language and opts until the pool holds
+// its configured number of idle ones.
+func WarmRunRootPool(language string, opts PrepareRunRootOptions) error {
+	p := currentPool()
+	if p == nil {
+		return nil
+	}
+	key := poolKey(language, opts)
+	for {
+		p.mu.Lock()
+		n := len(p.idle[key])
+		p.mu.Unlock()
+		if n >= p.cfg.Size {
+			return nil
+		}
+		rr, err := prepareRunRoot(language, opts)
+		if err != nil {
+			return err
+		}
+		entry := &pooledRunRoot{rr: rr, language: language, destroy: rr.cleanup}
+		if !p.put(key, entry) {
+			entry.destroy()
+			return nil
+		}
+	}
+}
+
+// DrainRunRootPool removes every idle runroot and disables the pool.
+func DrainRunRootPool() {
+	poolMu.Lock()
+	p := pool
+	pool = nil
+	poolMu.Unlock()
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, entries := range idle {
+		for _, e := range entries {
+			e.destroy()
+		}
+	}
+}
+
+// poolKey identifies interchangeable runroots: the options decide which
+// flag placeholders and mounts a runroot has.
+func poolKey(language string, opts PrepareRunRootOptions) string {
+	return fmt.Sprintf("%s|go=%t|c=%t|flags=%s", language, opts.ForGoBuilder, opts.ForCBuilder, strings.Join(opts.FlagDestinations, ","))
+}
+
+// get hands out a healthy idle runroot for key, or nil.
+func (p *runRootPool) get(key string) *pooledRunRoot {
+	for {
+		p.mu.Lock()
+		entries := p.idle[key]
+		if len(entries) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		e := entries[len(entries)-1]
+		p.idle[key] = entries[:len(entries)-1]
+		p.mu.Unlock()
+		if err := e.check(); err != nil {
+			metricRunRootPoolDiscards.WithLabelValues("unhealthy").Inc()
+			slog.Warn("sandbox: discarding unhealthy pooled runroot", "root", e.rr.Root, "err", err)
+			e.destroy()
+			continue
+		}
+		return e
+	}
+}
+
+// put parks e for reuse and reports whether the pool had room for it.
+func (p *runRootPool) put(key string, e *pooledRunRoot) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idle == nil || len(p.idle[key]) >= p.cfg.Size {
+		return false
+	}
+	p.idle[key] = append(p.idle[key], e)
+	return true
+}
+
+// lease hands e out as rr. Cleanup returns it to the pool once; a runroot
+// that has served MaxUses jobs, or cannot be wiped, is removed instead.
+func (p *runRootPool) lease(key string, e *pooledRunRoot) *RunRoot {
+	e.uses++
+	var once sync.Once
+	e.rr.cleanup = func() {
+		once.Do(func() {
+			switch {
+			case e.uses >= p.cfg.MaxUses:
+				metricRunRootPoolDiscards.WithLabelValues("max_uses").Inc()
+			case e.reset() != nil:
+				metricRunRootPoolDiscards.WithLabelValues("reset_failed").Inc()
+			case p.put(key, e):
+				return
+			default:
+				metricRunRootPoolDiscards.WithLabelValues("pool_full").Inc()
+			}
+			e.destroy()
+		})
+	}
+	return e.rr
+}
+
+// reset wipes what the last job could write: the workspace and tmp.
+func (e *pooledRunRoot) reset() error {
+	if err := clearDirectory(e.rr.WorkspaceHost); err != nil {
+		slog.Warn("sandbox: failed to wipe pooled workspace", "root", e.rr.Root, "err", err)
+		return err
+	}
+	if err := ensureDirWithPerm(e.rr.WorkspaceHost, 0o755); err != nil {
+		return err
+	}
+	if err := ResetChrootTmp(e.rr); err != nil {
+		slog.Warn("sandbox: failed to wipe pooled tmp", "root", e.rr.Root, "err", err)
+		return err
+	}
+	return nil
+}
+
+// check verifies that a pooled runroot is still complete and built from the
+// current runtime environment.
+func (e *pooledRunRoot) check() error {
+	for _, dir := range []string{e.rr.Root, e.rr.WorkHost, e.rr.WorkspaceHost, e.rr.TmpHost} {
+		st, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(e.rr.Root, strings.TrimPrefix(e.rr.ChrootBin, "/"))); err != nil {
+		return err
+	}
+	envRoot, err := envRootFor(e.language)
+	if err != nil {
+		return err
+	}
+	if envRoot != e.rr.EnvRoot {
+		return errors.New("runtime environment moved")
+	}
+	return nil
+}
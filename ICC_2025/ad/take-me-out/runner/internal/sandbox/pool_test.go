@@ -0,0 +1,208 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testEnvs points SANDBOX_ENVS_DIR at a runtime environment for python
+// complete enough for envRootFor.
+func testEnvs(t *testing.T) string {
+	t.Helper()
+	envs := t.TempDir()
+	for _, f := range []string{"usr/bin/gcc", "usr/bin/python3", "bin/sh"} {
+		path := filepath.Join(envs, "base", f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("base", filepath.Join(envs, "python")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SANDBOX_ENVS_DIR", envs)
+	return filepath.Join(envs, "base")
+}
+
+// testPooledRunRoot builds a runroot the pool accepts as healthy; destroyed
+// counts how often it was removed.
+func testPooledRunRoot(t *testing.T, envRoot string, destroyed *int) *pooledRunRoot {
+	t.Helper()
+	rr := testRunRoot(t)
+	rr.EnvRoot = envRoot
+	for _, dir := range []string{rr.WorkHost, rr.WorkspaceHost, rr.TmpHost, filepath.Join(rr.Root, ".runner")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rr.Root, strings.TrimPrefix(rr.ChrootBin, "/")), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return &pooledRunRoot{rr: rr, language: "python", destroy: func() { *destroyed++ }}
+}
+
+func TestRunRootPoolConfigFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		size, uses string
+		want       RunRootPoolConfig
+	}{
+		{"", "", RunRootPoolConfig{Size: 2, MaxUses: 50}},
+		{"0", "1", RunRootPoolConfig{Size: 0, MaxUses: 1}},
+		{"8", "200", RunRootPoolConfig{Size: 8, MaxUses: 200}},
+		{"-1", "0", RunRootPoolConfig{Size: 2, MaxUses: 50}},
+		{"many", "often", RunRootPoolConfig{Size: 2, MaxUses: 50}},
+	} {
+		t.Setenv("SANDBOX_POOL_SIZE", tc.size)
+		t.Setenv("SANDBOX_POOL_MAX_USES", tc.uses)
+		if got := RunRootPoolConfigFromEnv(); got != tc.want {
+			t.Errorf("size %q, uses %q: %+v, want %+v", tc.size, tc.uses, got, tc.want)
+		}
+	}
+}
+
+func TestPoolKey(t *testing.T) {
+	keys := map[string]bool{}
+	for _, opts := range []PrepareRunRootOptions{
+		{},
+		{ForGoBuilder: true},
+		{ForCBuilder: true},
+		{FlagDestinations: []string{"/flag2"}},
+		{FlagDestinations: []string{"/flag2", "/workspace/flag2"}},
+	} {
+		keys[poolKey("python", opts)] = true
+	}
+	keys[poolKey("ruby", PrepareRunRootOptions{})] = true
+	if len(keys) != 6 {
+		t.Errorf("runroots with different options share a key: %v", keys)
+	}
+	if poolKey("c", PrepareRunRootOptions{ForCBuilder: true}) != poolKey("c", PrepareRunRootOptions{ForCBuilder: true}) {
+		t.Error("equal options give different keys")
+	}
+}
+
+func TestRunRootPoolLease(t *testing.T) {
+	envRoot := testEnvs(t)
+	var destroyed int
+	p := &runRootPool{cfg: RunRootPoolConfig{Size: 1, MaxUses: 2}, idle: map[string][]*pooledRunRoot{}}
+	e := testPooledRunRoot(t, envRoot, &destroyed)
+	const key = "python|test"
+
+	rr := p.lease(key, e)
+	// what a job leaves behind
+	os.WriteFile(filepath.Join(rr.WorkspaceHost, "code.py"), []byte("print(1)"), 0o644)
+	os.MkdirAll(filepath.Join(rr.TmpHost, "left", "over"), 0o755)
+	rr.Cleanup()
+	rr.Cleanup()
+	if destroyed != 0 || len(p.idle[key]) != 1 {
+		t.Fatalf("after the first job: destroyed %d, idle %d", destroyed, len(p.idle[key]))
+	}
+	for _, dir := range []string{rr.WorkspaceHost, rr.TmpHost} {
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s not wiped: %v", dir, entries)
+		}
+	}
+	if st, err := os.Stat(rr.TmpHost); err != nil || st.Mode()&os.ModeSticky == 0 || st.Mode().Perm() != 0o777 {
+		t.Errorf("tmp mode after reset: %v, %v", st.Mode(), err)
+	}
+
+	if got := p.get(key); got != e {
+		t.Fatal("the pooled runroot was not handed out again")
+	}
+	before := testutil.ToFloat64(metricRunRootPoolDiscards.WithLabelValues("max_uses"))
+	p.lease(key, e).Cleanup()
+	if destroyed != 1 || len(p.idle[key]) != 0 {
+		t.Errorf("after MaxUses jobs: destroyed %d, idle %d", destroyed, len(p.idle[key]))
+	}
+	if got := testutil.ToFloat64(metricRunRootPoolDiscards.WithLabelValues("max_uses")) - before; got != 1 {
+		t.Errorf("max_uses discards went up by %v", got)
+	}
+}
+
+func TestRunRootPoolFull(t *testing.T) {
+	envRoot := testEnvs(t)
+	var destroyed int
+	p := &runRootPool{cfg: RunRootPoolConfig{Size: 1, MaxUses: 10}, idle: map[string][]*pooledRunRoot{}}
+	a, b := testPooledRunRoot(t, envRoot, &destroyed), testPooledRunRoot(t, envRoot, &destroyed)
+	ra, rb := p.lease("k", a), p.lease("k", b)
+	ra.Cleanup()
+	rb.Cleanup()
+	if destroyed != 1 || len(p.idle["k"]) != 1 || p.idle["k"][0] != a {
+		t.Errorf("destroyed %d, idle %v", destroyed, p.idle["k"])
+	}
+	// other keys have their own room
+	if !p.put("other", testPooledRunRoot(t, envRoot, &destroyed)) {
+		t.Error("no room under another key")
+	}
+}
+
+func TestRunRootPoolDiscardsUnhealthy(t *testing.T) {
+	envRoot := testEnvs(t)
+	for name, spoil := range map[string]func(rr *RunRoot){
+		"workspace gone":    func(rr *RunRoot) { os.RemoveAll(rr.WorkspaceHost) },
+		"tmp is a file":     func(rr *RunRoot) { os.RemoveAll(rr.TmpHost); os.WriteFile(rr.TmpHost, nil, 0o644) },
+		"chroot-run gone":   func(rr *RunRoot) { os.Remove(filepath.Join(rr.Root, ".runner/chroot-run")) },
+		"environment moved": func(rr *RunRoot) { rr.EnvRoot = "/elsewhere" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var destroyed int
+			p := &runRootPool{cfg: RunRootPoolConfig{Size: 2, MaxUses: 10}, idle: map[string][]*pooledRunRoot{}}
+			healthy, spoilt := testPooledRunRoot(t, envRoot, &destroyed), testPooledRunRoot(t, envRoot, &destroyed)
+			p.put("k", healthy)
+			p.put("k", spoilt)
+			spoil(spoilt.rr)
+			if got := p.get("k"); got != healthy || destroyed != 1 {
+				t.Errorf("got the spoilt runroot %v, destroyed %d", got == spoilt, destroyed)
+			}
+			if got := p.get("k"); got != nil {
+				t.Error("pool not empty")
+			}
+		})
+	}
+}
+
+func TestEnableAndDrainRunRootPool(t *testing.T) {
+	envRoot := testEnvs(t)
+	t.Cleanup(DrainRunRootPool)
+
+	EnableRunRootPool(RunRootPoolConfig{Size: 0, MaxUses: 10})
+	if currentPool() != nil {
+		t.Fatal("a pool of size 0 was enabled")
+	}
+	t.Setenv("SANDBOX_KEEP_RUNROOT", "1")
+	EnableRunRootPool(RunRootPoolConfig{Size: 2, MaxUses: 10})
+	if currentPool() != nil {
+		t.Fatal("the pool was enabled with SANDBOX_KEEP_RUNROOT=1")
+	}
+	t.Setenv("SANDBOX_KEEP_RUNROOT", "")
+	EnableRunRootPool(RunRootPoolConfig{Size: 2, MaxUses: 10})
+	p := currentPool()
+	if p == nil {
+		t.Fatal("pool not enabled")
+	}
+
+	var destroyed int
+	p.put(poolKey("python", PrepareRunRootOptions{}), testPooledRunRoot(t, envRoot, &destroyed))
+	p.put(poolKey("python", PrepareRunRootOptions{ForGoBuilder: true}), testPooledRunRoot(t, envRoot, &destroyed))
+	if err := testutil.CollectAndCompare(poolIdleCollector{}, strings.NewReader(`
+# HELP sandbox_runroot_pool_idle Idle pooled runroots by language.
+# TYPE sandbox_runroot_pool_idle gauge
+sandbox_runroot_pool_idle{language="python"} 2
+`)); err != nil {
+		t.Error(err)
+	}
+
+	DrainRunRootPool()
+	if destroyed != 2 || currentPool() != nil {
+		t.Errorf("drain destroyed %d, pool %v", destroyed, currentPool())
+	}
+	// a runroot returned after the drain is removed, not parked
+	if p.put("k", testPooledRunRoot(t, envRoot, &destroyed)) {
+		t.Error("a drained pool took a runroot back")
+	}
+}
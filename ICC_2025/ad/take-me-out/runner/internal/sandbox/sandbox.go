@@ -6,13 +6,23 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe-runner/internal/logging"
+	"goexe-runner/internal/tracing"
 )
 
 const chrootRunPath = "/usr/local/bin/chroot-run"
@@ -83,8 +93,77 @@ func PrepareRunRoot(language string) (*RunRoot, error) {
 	return PrepareRunRootWithOptions(language, PrepareRunRootOptions{})
 }
 
+var (
+	tracer = otel.Tracer("goexe-runner/internal/sandbox")
+
+	metricRunRootPrepare = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sandbox_runroot_prepare_total",
+		Help: "Runroot preparations by language and outcome.",
+	}, []string{"language", "outcome"})
+	metricRunRootPrepareSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sandbox_runroot_prepare_seconds",
+		Help:    "Runroot preparation duration.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"language"})
+	metricRunRootCleanupFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sandbox_runroot_cleanup_failures_total",
+		Help: "Runroots that could not be removed.",
+	})
+)
+
 // PrepareRunRootWithOptions constructs a per-run chroot using bind mounts instead of copying the rootfs.
+// With the runroot pool enabled it hands out a pooled runroot when one is idle.
 func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*RunRoot, error) {
+	p := currentPool()
+	key := poolKey(language, opts)
+	if p != nil {
+		if e := p.get(key); e != nil {
+			metricRunRootPool.WithLabelValues(language, "hit").Inc()
+			return p.lease(key, e), nil
+		}
+		metricRunRootPool.WithLabelValues(language, "miss").Inc()
+	}
+	start := time.Now()
+	rr, err := prepareRunRoot(language, opts)
+	metricRunRootPrepareSeconds.WithLabelValues(language).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metricRunRootPrepare.WithLabelValues(language, "error").Inc()
+		return nil, err
+	}
+	metricRunRootPrepare.WithLabelValues(language, "ok").Inc()
+	if p != nil {
+		return p.lease(key, &pooledRunRoot{rr: rr, language: language, destroy: rr.cleanup}), nil
+	}
+	return rr, nil
+}
+
+// PrepareRunRootContext is PrepareRunRootWithOptions recorded as a trace
+// span under ctx, so slow bind-mount setup shows up in the judge trace.
+func PrepareRunRootContext(ctx context.Context, language string, opts PrepareRunRootOptions) (*RunRoot, error) {
+	_, span := tracer.Start(ctx, "sandbox.prepare_runroot", trace.WithAttributes(attribute.String("language", language)))
+	defer span.End()
+	rr, err := PrepareRunRootWithOptions(language, opts)
+	tracing.RecordError(span, err)
+	return rr, err
+}
+
+// CheckEnvironment reports whether runs in language can be set up: its
+// runtime environment is present and complete and the sandbox backend is
+// installed.
+func CheckEnvironment(language string) error {
+	if _, err := envRootFor(language); err != nil {
+		return err
+	}
+	b := CurrentBackend()
+	if err := b.Available(); err != nil {
+		return fmt.Errorf("%s not available: %w", b.Name(), err)
+	}
+	return nil
+}
+
+// envRootFor resolves and checks the runtime environment of language under
+// SANDBOX_ENVS_DIR.
+func envRootFor(language string) (string, error) {
 	baseEnv := strings.TrimSpace(os.Getenv("SANDBOX_ENVS_DIR"))
 	if baseEnv == "" {
 		baseEnv = "/opt/sandbox-envs"
@@ -94,7 +173,7 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 		envRoot = real
 	}
 	if st, err := os.Stat(envRoot); err != nil || !st.IsDir() {
-		return nil, fmt.Errorf("runtime environment not found: %s", envRoot)
+		return "", fmt.Errorf("runtime environment not found: %s", envRoot)
 	}
 	criticalPaths := []string{
 		filepath.Join(envRoot, "usr/bin/gcc"),
@@ -103,9 +182,24 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 	}
 	for _, p := range criticalPaths {
 		if _, err := os.Stat(p); err != nil {
-			return nil, fmt.Errorf("runtime environment incomplete: missing %s (%v)", p, err)
+			return "", fmt.Errorf("runtime environment incomplete: missing %s (%v)", p, err)
 		}
 	}
+	return envRoot, nil
+}
+
+func nsjailBinary() string {
+	if p := os.Getenv("NSJAIL_PATH"); p != "" {
+		return p
+	}
+	return "/usr/bin/nsjail"
+}
+
+func prepareRunRoot(language string, opts PrepareRunRootOptions) (*RunRoot, error) {
+	envRoot, err := envRootFor(language)
+	if err != nil {
+		return nil, err
+	}
 
 	runRootsDir := strings.TrimSpace(os.Getenv("SANDBOX_RUNROOT_DIR"))
 	if runRootsDir == "" {
@@ -128,7 +222,10 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 	rr.WorkspaceInside = "/workspace"
 	rr.WorkspaceRelative = rr.WorkspaceInside
 	cleanup := func() {
-		_ = os.RemoveAll(parent)
+		if err := os.RemoveAll(parent); err != nil {
+			metricRunRootCleanupFailures.Inc()
+			slog.Warn("sandbox: runroot cleanup failed", "root", parent, "err", err)
+		}
 	}
 	rr.cleanup = cleanup
 
@@ -150,7 +247,7 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 		return nil, err
 	}
 	rr.TmpHost = filepath.Join(rr.Root, strings.TrimPrefix(rr.TmpDir(), "/"))
-	if err := ensureDirWithPerm(rr.TmpHost, 0o1777); err != nil {
+	if err := ensureDirWithPerm(rr.TmpHost, os.ModeSticky|0o777); err != nil {
 		cleanup()
 		return nil, err
 	}
@@ -183,7 +280,7 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 	}
 	mounts = append(mounts, bindMount{host: rr.WorkspaceHost, target: envWorkspaceTarget, readOnly: false})
 	envTmpTarget := filepath.Join(rr.WorkHost, "tmp")
-	if err := ensureDirWithPerm(envTmpTarget, 0o1777); err != nil {
+	if err := ensureDirWithPerm(envTmpTarget, os.ModeSticky|0o777); err != nil {
 		cleanup()
 		return nil, err
 	}
@@ -222,7 +319,7 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 				mounts = append(mounts, bindMount{host: "/flag2", target: target, readOnly: true})
 			}
 		} else if !os.IsNotExist(err) {
-			log.Printf("sandbox: failed mounting /flag2: %v", err)
+			slog.Error("sandbox: failed mounting /flag2", "err", err)
 			return nil, err
 		}
 	}
@@ -251,7 +348,7 @@ func PrepareRunRootWithOptions(language string, opts PrepareRunRootOptions) (*Ru
 
 	if strings.EqualFold(os.Getenv("SANDBOX_KEEP_RUNROOT"), "1") {
 		rr.cleanup = func() {}
-		log.Printf("sandbox: keeping runroot at %s", parent)
+		slog.Info("sandbox: keeping runroot", "root", parent)
 	} else {
 		rr.cleanup = cleanup
 	}
@@ -332,7 +429,7 @@ func buildDeviceMounts(root string) []bindMount {
 		}
 		target := filepath.Join(root, strings.TrimPrefix(host, "/"))
 		if err := ensurePlaceholder(target, false, 0o666); err != nil {
-			log.Printf("sandbox: failed to prepare device mount %s: %v", target, err)
+			slog.Warn("sandbox: failed to prepare device mount", "target", target, "err", err)
 			continue
 		}
 		mounts = append(mounts, bindMount{host: host, target: target, readOnly: false})
@@ -366,128 +463,77 @@ func dedupeAndSortMounts(mounts []bindMount) []bindMount {
 // RunInChroot executes argv within the given chroot/workdir applying resource limits via nsjail.
 // When useChrootRunner is false, the command is executed directly under nsjail without invoking chroot-run.
 type RunResult struct {
-	Stdout string
-	Stderr string
+	// Stdout and Stderr are the program's, each cut at lim.OutputLimit;
+	// nsjail logs elsewhere. StderrTruncated is set when stderr was cut.
+	Stdout          string
+	Stderr          string
+	StderrTruncated bool
+	// WallTime is how long nsjail ran; TrimSetup narrows it to the program.
+	WallTime time.Duration
+	// CPUTime is read from the run's cgroup, or else from nsjail's rusage
+	// when nsjail exited by itself; zero when neither is available.
+	CPUTime time.Duration
+	// Read back from the run's cgroup; zero when cgroups are unavailable.
+	// The figures include nsjail itself, which adds a few MB of memory.
+	MemoryPeakBytes int64
+	OOMKilled       bool
+	PidsLimitHit    bool
+	// Set when the seccomp policy killed the program; Syscall names the
+	// call it made.
+	SeccompViolation bool
+	Syscall          string
+	// ScratchExceeded is set when the program filled a scratch tmpfs,
+	// going by the StatusScratch report of the command it ran in.
+	ScratchExceeded bool
+	// OutputExceeded is set when the program wrote more than
+	// lim.OutputLimit bytes to stdout and was killed for it.
+	OutputExceeded bool
+
+	// started is when the program reported StatusStart, exited when the
+	// jail exited.
+	started, exited time.Time
 }
 
 func RunInChroot(ctx context.Context, rr *RunRoot, workdir string, argv []string, stdin string, lim RLimits, useChrootRunner bool) (RunResult, error) {
-	if len(argv) == 0 {
-		return RunResult{}, errors.New("no argv provided")
-	}
-	if rr == nil {
-		return RunResult{}, errors.New("runroot not prepared")
-	}
-	nsjailPath := os.Getenv("NSJAIL_PATH")
-	if nsjailPath == "" {
-		nsjailPath = "/usr/bin/nsjail"
-	}
-	configPath, err := nsjailConfigPath()
+	return runInChroot(ctx, CurrentBackend(), rr, workdir, argv, stdin, lim, useChrootRunner, nil)
+}
+
+// RunInChrootStream is RunInChroot that also writes the program's stdout to
+// stdout as it arrives, up to lim.OutputLimit bytes.
+func RunInChrootStream(ctx context.Context, rr *RunRoot, workdir string, argv []string, stdin string, lim RLimits, useChrootRunner bool, stdout io.Writer) (RunResult, error) {
+	return runInChroot(ctx, CurrentBackend(), rr, workdir, argv, stdin, lim, useChrootRunner, stdout)
+}
+
+func runInChroot(ctx context.Context, b Backend, rr *RunRoot, workdir string, argv []string, stdin string, lim RLimits, useChrootRunner bool, stdout io.Writer) (RunResult, error) {
+	cfg, err := NsjailConfigFor(rr, workdir, argv, lim, useChrootRunner)
 	if err != nil {
-		return RunResult{}, fmt.Errorf("prepare nsjail config: %w", err)
+		return RunResult{}, err
 	}
-	if workdir == "" {
-		workdir = rr.WorkDir()
-	}
-
-	pathEnv := "PATH=/.runner:/usr/local/bin:/usr/bin:/bin"
-	if !useChrootRunner {
-		pathEnv = "PATH=/.runner:/env/usr/local/bin:/env/usr/bin:/env/bin:/usr/local/bin:/usr/bin:/bin"
+	if deadline, ok := ctx.Deadline(); ok {
+		cfg.deadlineTimeLimit(time.Until(deadline))
 	}
 	debugDirsEnv := strings.TrimSpace(os.Getenv("SANDBOX_DEBUG_DIRS"))
 	debugDepthEnv := strings.TrimSpace(os.Getenv("SANDBOX_DEBUG_DIR_DEPTH"))
-	nsArgs := []string{
-		"--config", configPath,
-		"--chroot", rr.Root,
-		"--cwd", "/",
-		"--keep_caps",
-		"--cap", "CAP_SYS_CHROOT",
-		"--disable_no_new_privs",
-		"--keep_env",
-		"--env", pathEnv,
-		"--env", "HOME=/tmp",
-		"--env", "TMPDIR=/tmp",
-		"--env", "LANG=C.UTF-8",
-		"--env", "LD=/usr/bin/x86_64-linux-gnu-ld",
-	}
-	if !useChrootRunner && rr.EnvRoot != "" {
-		type mountSpec struct {
-			subdir string
-			dest   string
-		}
-		extra := []mountSpec{
-			{subdir: "bin", dest: "/bin"},
-			{subdir: "lib", dest: "/lib"},
-			{subdir: "lib64", dest: "/lib64"},
-			{subdir: "usr", dest: "/usr"},
-			{subdir: "etc", dest: "/etc"},
-		}
-		for _, ms := range extra {
-			hostPath := filepath.Join(rr.EnvRoot, ms.subdir)
-			if st, err := os.Stat(hostPath); err == nil && st.IsDir() {
-				destPath := filepath.Join(rr.Root, strings.TrimPrefix(ms.dest, "/"))
-				_ = ensureDirWithPerm(destPath, 0o755)
-				nsArgs = append(nsArgs, "--bindmount_ro", fmt.Sprintf("%s:%s", hostPath, ms.dest))
-			}
-		}
-	}
-	cwdIndex := -1
-	for i := 0; i < len(nsArgs); i++ {
-		if nsArgs[i] == "--cwd" && i+1 < len(nsArgs) {
-			cwdIndex = i + 1
-			break
-		}
+	cg, err := newRunCgroup(lim)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("prepare cgroup: %w", err)
 	}
-	for _, m := range rr.mounts {
-		option := "--bindmount"
-		if m.readOnly {
-			option = "--bindmount_ro"
-		}
-		dest := strings.TrimPrefix(m.target, rr.Root)
-		if !strings.HasPrefix(dest, "/") {
-			dest = "/" + dest
-		}
-		dest = filepath.Clean(dest)
-		nsArgs = append(nsArgs, option, fmt.Sprintf("%s:%s", m.host, dest))
+	if cg != nil {
+		defer cg.remove()
+		cfg.deferToCgroup(lim)
 	}
-	chrootDest := rr.WorkDir()
-	if chrootDest == "" {
-		chrootDest = "/"
-	}
-	innerWorkdir := strings.TrimSpace(workdir)
-	if innerWorkdir == "" {
-		innerWorkdir = "/"
-	} else if !strings.HasPrefix(innerWorkdir, "/") {
-		innerWorkdir = "/" + innerWorkdir
-	}
-	nsArgs = append(nsArgs, "--")
-	if useChrootRunner {
-		binPath := rr.ChrootBin
-		if binPath == "" {
-			binPath = chrootRunPath
-		}
-		cmdArgs := []string{binPath, chrootDest, innerWorkdir, "--"}
-		cmdArgs = append(cmdArgs, argv...)
-		nsArgs = append(nsArgs, cmdArgs...)
-	} else {
-		// execute directly under nsjail root with desired cwd
-		if cwdIndex >= 0 {
-			if innerWorkdir != "" && innerWorkdir != "/" {
-				nsArgs[cwdIndex] = innerWorkdir
-			} else {
-				nsArgs[cwdIndex] = "/"
-			}
-		}
-		nsArgs = append(nsArgs, argv...)
+	// cancelled when the output passes its limit
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
+	cmd, release, err := b.Command(runCtx, cfg)
+	if err != nil {
+		return RunResult{}, err
 	}
-
-	cmd := exec.CommandContext(ctx, nsjailPath, nsArgs...)
-	cmd.Env = []string{
-		pathEnv,
-		"HOME=/tmp",
-		"TMPDIR=/tmp",
-		"LANG=C.UTF-8",
-		"LD=/usr/bin/x86_64-linux-gnu-ld",
+	defer release()
+	if strings.EqualFold(os.Getenv("SANDBOX_DUMP_NSJAIL_CONFIG"), "1") {
+		slog.InfoContext(ctx, "sandbox: nsjail config", "backend", b.Name(), "config", cfg.String())
 	}
+	cmd.Env = append([]string(nil), cfg.Env...)
 	if debugDirsEnv != "" {
 		cmd.Env = append(cmd.Env, "CHROOT_RUN_DEBUG_DIRS="+debugDirsEnv)
 	}
@@ -497,40 +543,74 @@ func RunInChroot(ctx context.Context, rr *RunRoot, workdir string, argv []string
 	if stdin != "" {
 		cmd.Stdin = strings.NewReader(stdin)
 	}
-	stdoutBuf := &safeCapBuffer{max: lim.OutputLimit}
-	stderrBuf := &safeCapBuffer{max: lim.OutputLimit}
+	// past the limit stdout kills the jail, while stderr is dropped: a
+	// compiler's warnings must not fail the build
+	stdoutBuf := &outputCapture{max: lim.OutputLimit, tee: stdout, overflow: kill}
+	stderrBuf := &outputCapture{max: lim.OutputLimit}
 	cmd.Stdout = stdoutBuf
 	cmd.Stderr = stderrBuf
-	runErr := cmd.Run()
+	if cg != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: cg.fd()}
+	}
+	pipes, err := attachJailPipes(cmd, cfg)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("prepare jail pipes: %w", err)
+	}
+	started := time.Now()
+	runErr := cmd.Start()
+	pipes.closeWriters()
+	if runErr == nil {
+		runErr = cmd.Wait()
+	}
+	exited := time.Now()
+	pipes.wait()
+	jailLog := strings.TrimSpace(pipes.log.String())
 
 	result := RunResult{
-		Stdout: stdoutBuf.String(),
-		Stderr: strings.TrimSpace(stderrBuf.String()),
+		Stdout:          stdoutBuf.String(),
+		Stderr:          stderrBuf.String(),
+		StderrTruncated: stderrBuf.Exceeded(),
+		WallTime:        exited.Sub(started),
+		OutputExceeded:  stdoutBuf.Exceeded(),
+		started:         pipes.started,
+		exited:          exited,
+	}
+	if cg != nil {
+		cg.usage(&result)
+	} else if cmd.ProcessState != nil && runCtx.Err() == nil {
+		// nsjail's rusage covers the program it reaped; a killed nsjail
+		// never reaped it
+		result.CPUTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	}
+	if scratchLimited(lim) {
+		result.ScratchExceeded = pipes.scratchFull
+	}
+	if lim.Seccomp != "" && runErr != nil {
+		result.SeccompViolation, result.Syscall = seccompViolation(jailLog, runErr)
 	}
 	if runErr != nil {
-		log.Printf("sandbox: nsjail/chroot-run failed: %v (cmd=%s %s)", runErr, nsjailPath, strings.Join(nsArgs, " "))
-		if errStr := strings.TrimSpace(stderrBuf.String()); errStr != "" {
-			log.Printf("sandbox: nsjail stderr: %s", errStr)
-		}
+		// a non-zero exit is usually the submitted program failing, so the
+		// config and jail output are only worth logging when debugging
+		slog.DebugContext(ctx, "sandbox: nsjail/chroot-run failed",
+			"backend", b.Name(),
+			"err", runErr,
+			"config", logging.Clip(cfg.String()),
+			logging.Output("log", jailLog),
+			logging.Output("stderr", result.Stderr))
 	}
 	return result, runErr
 }
 
-func LaunchInteractive(rr *RunRoot, workdir string, argv []string) error {
+// InteractiveNsjailConfig is the configuration LaunchInteractive starts argv
+// with: the chroot-run helper in the runroot, without limits beyond the
+// defaults. Host paths under the workspace are mapped into the jail.
+func InteractiveNsjailConfig(rr *RunRoot, workdir string, argv []string) (*NsjailConfig, error) {
 	if rr == nil {
-		return errors.New("runroot is required")
+		return nil, errors.New("runroot is required")
 	}
 	if len(argv) == 0 {
 		argv = []string{"/bin/sh"}
 	}
-	nsjailPath := os.Getenv("NSJAIL_PATH")
-	if nsjailPath == "" {
-		nsjailPath = "/usr/bin/nsjail"
-	}
-	configPath, err := nsjailConfigPath()
-	if err != nil {
-		return fmt.Errorf("prepare nsjail config: %w", err)
-	}
 	mapHostPath := func(p string) string {
 		if p == "" {
 			return p
@@ -579,62 +659,39 @@ func LaunchInteractive(rr *RunRoot, workdir string, argv []string) error {
 	} else if !strings.HasPrefix(insideWorkdir, "/") {
 		insideWorkdir = "/" + insideWorkdir
 	}
-	pathEnv := "PATH=/.runner:/usr/local/bin:/usr/bin:/bin"
-	debugDirsEnv := strings.TrimSpace(os.Getenv("SANDBOX_DEBUG_DIRS"))
-	debugDepthEnv := strings.TrimSpace(os.Getenv("SANDBOX_DEBUG_DIR_DEPTH"))
-	nsArgs := []string{
-		"--config", configPath,
-		"--chroot", rr.Root,
-		"--cwd", insideWorkdir,
-		"--keep_caps",
-		"--cap", "CAP_SYS_CHROOT",
-		"--disable_no_new_privs",
-		"--keep_env",
-		"--env", pathEnv,
-		"--env", "HOME=/tmp",
-		"--env", "TMPDIR=/tmp",
-		"--env", "LANG=C.UTF-8",
-		"--env", "LD=/usr/bin/x86_64-linux-gnu-ld",
-	}
-	for _, m := range rr.mounts {
-		option := "--bindmount"
-		if m.readOnly {
-			option = "--bindmount_ro"
-		}
-		dest := strings.TrimPrefix(m.target, rr.Root)
-		if !strings.HasPrefix(dest, "/") {
-			dest = "/" + dest
-		}
-		dest = filepath.Clean(dest)
-		nsArgs = append(nsArgs, option, fmt.Sprintf("%s:%s", m.host, dest))
+	cfg := baseNsjailConfig(rr, "PATH=/.runner:/usr/local/bin:/usr/bin:/bin")
+	cfg.Cwd = insideWorkdir
+	cfg.addRunRootMounts(rr, RLimits{})
+	binPath := rr.ChrootBin
+	if binPath == "" {
+		binPath = chrootRunPath
 	}
-	mappedArgv := make([]string, len(argv))
-	for i, a := range argv {
+	cfg.Argv = []string{binPath, chrootDest, insideWorkdir, "--"}
+	for _, a := range argv {
 		m := mapHostPath(a)
 		if m == "" {
 			m = a
 		}
-		mappedArgv[i] = m
-	}
-	binPath := rr.ChrootBin
-	if binPath == "" {
-		binPath = chrootRunPath
+		cfg.Argv = append(cfg.Argv, m)
 	}
-	cmdArgs := []string{binPath, chrootDest, insideWorkdir, "--"}
-	cmdArgs = append(cmdArgs, mappedArgv...)
-
-	nsArgs = append(nsArgs, "--")
-	nsArgs = append(nsArgs, cmdArgs...)
+	return cfg, nil
+}
 
-	cmd := exec.Command(nsjailPath, nsArgs...)
+func LaunchInteractive(rr *RunRoot, workdir string, argv []string) error {
+	cfg, err := InteractiveNsjailConfig(rr, workdir, argv)
+	if err != nil {
+		return err
+	}
+	b := CurrentBackend()
+	cmd, release, err := b.Command(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+	debugDirsEnv := strings.TrimSpace(os.Getenv("SANDBOX_DEBUG_DIRS"))
+	debugDepthEnv := strings.TrimSpace(os.Getenv("SANDBOX_DEBUG_DIR_DEPTH"))
 	env := os.Environ()
-	env = append(env,
-		pathEnv,
-		"HOME=/tmp",
-		"TMPDIR=/tmp",
-		"LANG=C.UTF-8",
-		"LD=/usr/bin/x86_64-linux-gnu-ld",
-	)
+	env = append(env, cfg.Env...)
 	if debugDirsEnv != "" {
 		env = append(env, "CHROOT_RUN_DEBUG_DIRS="+debugDirsEnv)
 	}
@@ -650,21 +707,48 @@ func LaunchInteractive(rr *RunRoot, workdir string, argv []string) error {
 	cmd.Stderr = os.Stderr
 	runErr := cmd.Run()
 	if runErr != nil {
-		log.Printf("sandbox: interactive nsjail/chroot-run failed: %v (cmd=%s %s)", runErr, nsjailPath, strings.Join(nsArgs, " "))
+		slog.Error("sandbox: interactive nsjail/chroot-run failed", "backend", b.Name(), "err", runErr, "config", logging.Clip(cfg.String()))
 	}
 	return runErr
 }
 
 // RLimits defines rlimit values applied inside the sandbox.
 type RLimits struct {
-	CPUSeconds  int
-	ASBytes     int
-	FSizeBytes  int
-	NProc       int
-	NOFile      int
+	CPUSeconds int
+	ASBytes    int
+	FSizeBytes int
+	NProc      int
+	NOFile     int
+	// OutputLimit caps what is kept of each of stdout and stderr. Zero
+	// means no limit. RunInChroot kills the program only for overflowing
+	// stdout; extra stderr is dropped and marked by StderrTruncated.
+	// RunOnHost kills the command when either stream overflows.
 	OutputLimit int
+	// Applied by RunInChroot through the run's cgroup (memory.max,
+	// pids.max, cpu.max) when cgroup v2 is available; zero means no limit.
+	// CPUPercent is a share of one CPU.
+	MemoryBytes int
+	Pids        int
+	CPUPercent  int
+	// Seccomp names the seccomp policy (see SeccompPolicyFor); empty runs
+	// unfiltered.
+	Seccomp string
+	// Unprivileged runs the command with no capabilities, with
+	// no_new_privs and in a user namespace. It excludes the chroot-run
+	// helper, which needs CAP_SYS_CHROOT.
+	Unprivileged bool
+	// ScratchBytes and ScratchInodes cap what the program may write: /tmp
+	// becomes a tmpfs of that size, the workspace is mounted read-only,
+	// and RunInChroot reports a tmpfs the program filled, as its command
+	// told on StatusFD, as ScratchExceeded. Zero means no limit.
+	ScratchBytes  int
+	ScratchInodes int
 }
 
+// unprivilegedID is the uid and gid of unprivileged runs inside their user
+// namespace.
+const unprivilegedID = 65534
+
 // RunOnHost executes argv on the host namespace (no chroot) applying rlimits.
 func RunOnHost(ctx context.Context, workdir string, argv []string, stdin string, lim RLimits) (string, error) {
 	if len(argv) == 0 {
@@ -690,6 +774,8 @@ func RunOnHost(ctx context.Context, workdir string, argv []string, stdin string,
 	}
 	pr = append(pr, "--")
 	pr = append(pr, argv...)
+	ctx, kill := context.WithCancel(ctx)
+	defer kill()
 	cmd := exec.CommandContext(ctx, pr[0], pr[1:]...)
 	if workdir == "" {
 		workdir = "/"
@@ -704,8 +790,8 @@ func RunOnHost(ctx context.Context, workdir string, argv []string, stdin string,
 	if stdin != "" {
 		cmd.Stdin = strings.NewReader(stdin)
 	}
-	stdoutBuf := &safeCapBuffer{max: lim.OutputLimit}
-	stderrBuf := &safeCapBuffer{max: lim.OutputLimit}
+	stdoutBuf := &outputCapture{max: lim.OutputLimit, overflow: kill}
+	stderrBuf := &outputCapture{max: lim.OutputLimit, overflow: kill}
 	cmd.Stdout = stdoutBuf
 	cmd.Stderr = stderrBuf
 	err := cmd.Run()
@@ -721,48 +807,6 @@ func RunOnHost(ctx context.Context, workdir string, argv []string, stdin string,
 	return outStr, err
 }
 
-func shellQuote(s string) string {
-	if s == "" {
-		return "''"
-	}
-	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
-}
-
-// safeCapBuffer stores up to max bytes, discarding the rest, concurrency-safe.
-type safeCapBuffer struct {
-	mu   sync.Mutex
-	buf  []byte
-	max  int
-	over bool
-}
-
-func (b *safeCapBuffer) Write(p []byte) (int, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	if b.max <= 0 {
-		b.buf = append(b.buf, p...)
-		return len(p), nil
-	}
-	remain := b.max - len(b.buf)
-	if remain > 0 {
-		if len(p) <= remain {
-			b.buf = append(b.buf, p...)
-		} else {
-			b.buf = append(b.buf, p[:remain]...)
-			b.over = true
-		}
-	} else {
-		b.over = true
-	}
-	return len(p), nil
-}
-
-func (b *safeCapBuffer) String() string {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	return string(b.buf)
-}
-
 // CopyFile copies a regular file, creating parent directories as needed.
 func CopyFile(src, dst string, perm fs.FileMode) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
@@ -798,7 +842,7 @@ func ResetChrootTmp(rr *RunRoot) error {
 	if err := clearDirectory(rr.TmpHost); err != nil {
 		return err
 	}
-	return os.Chmod(rr.TmpHost, 0o1777)
+	return os.Chmod(rr.TmpHost, os.ModeSticky|0o777)
 }
 
 func ensureDirWithPerm(path string, perm fs.FileMode) error {
@@ -829,7 +873,7 @@ func clearDirectory(path string) error {
 	entries, err := os.ReadDir(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return ensureDirWithPerm(path, 0o1777)
+			return ensureDirWithPerm(path, os.ModeSticky|0o777)
 		}
 		return err
 	}
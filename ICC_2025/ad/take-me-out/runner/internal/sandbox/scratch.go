@@ -0,0 +1,60 @@
+package sandbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scratchLimited reports whether lim confines the run's writes.
+func scratchLimited(lim RLimits) bool {
+	return lim.ScratchBytes > 0 || lim.ScratchInodes > 0
+}
+
+// scratchMounts mounts a size- and inode-limited tmpfs over every /tmp of
+// the jail. They are all a scratch-limited run can write to.
+func scratchMounts(rr *RunRoot, lim RLimits, tmpDests []string) []Mount {
+	opts := []string{"mode=1777"}
+	if lim.ScratchBytes > 0 {
+		opts = append(opts, fmt.Sprintf("size=%d", lim.ScratchBytes))
+	}
+	if lim.ScratchInodes > 0 {
+		opts = append(opts, fmt.Sprintf("nr_inodes=%d", lim.ScratchInodes))
+	}
+	var mounts []Mount
+	seen := make(map[string]bool)
+	for _, dest := range append([]string{rr.TmpDir()}, tmpDests...) {
+		if seen[dest] {
+			continue
+		}
+		seen[dest] = true
+		mounts = append(mounts, Mount{Dst: dest, FSType: "tmpfs", Options: strings.Join(opts, ","), RW: true})
+	}
+	return mounts
+}
+
+// StatusScratch starts the lines the command wrapping a program writes to
+// StatusFD once the program exited, one per tmpfs it could see: the
+// directory, its free blocks and its free inodes. The tmpfs lives in the
+// jail's mount namespace, out of the runner's reach once the jail is gone.
+const StatusScratch = "scratch"
+
+// ScratchReport is the shell command that writes the StatusScratch lines
+// to its stdout. With the chroot-run helper the program's /tmp is the
+// runroot's /env/tmp; without it both are visible.
+const ScratchReport = `for d in /tmp /env/tmp; do [ -d "$d" ] && stat -f -c "` + StatusScratch + ` $d %a %d" "$d"; done`
+
+// scratchFull parses a StatusScratch line and reports whether it shows a
+// tmpfs without a free block or inode; the program ran into ENOSPC there.
+func scratchFull(line string) (full, ok bool) {
+	f := strings.Fields(line)
+	if len(f) != 4 || f[0] != StatusScratch {
+		return false, false
+	}
+	blocks, err1 := strconv.ParseUint(f[2], 10, 64)
+	inodes, err2 := strconv.ParseUint(f[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		return false, false
+	}
+	return blocks == 0 || inodes == 0, true
+}
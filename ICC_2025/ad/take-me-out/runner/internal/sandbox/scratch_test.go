@@ -0,0 +1,77 @@
+package sandbox
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestScratchMounts(t *testing.T) {
+	rr := testRunRoot(t)
+	lim := RLimits{ScratchBytes: 8 << 20, ScratchInodes: 256}
+	mounts := scratchMounts(rr, lim, []string{"/env/tmp", rr.TmpDir()})
+	if len(mounts) != 2 || mounts[0].Dst != rr.TmpDir() || mounts[1].Dst != "/env/tmp" {
+		t.Fatalf("mounts %+v", mounts)
+	}
+	for _, m := range mounts {
+		if m.FSType != "tmpfs" || m.Src != "" || !m.RW || m.Options != "mode=1777,size=8388608,nr_inodes=256" {
+			t.Errorf("mount %+v", m)
+		}
+	}
+	if m := scratchMounts(rr, RLimits{ScratchInodes: 16}, nil); len(m) != 1 || m[0].Options != "mode=1777,nr_inodes=16" {
+		t.Errorf("inode limit only: %+v", m)
+	}
+}
+
+func TestScratchLimitedMounts(t *testing.T) {
+	rr := testRunRoot(t)
+	c := &NsjailConfig{}
+	c.addRunRootMounts(rr, RLimits{ScratchBytes: 1 << 20})
+	tmpfs := 0
+	for _, m := range c.Mounts {
+		if m.RW && (strings.HasPrefix(m.Src, rr.WorkspaceHost) || m.Src == rr.TmpHost) {
+			t.Errorf("a scratch-limited run can write to %s (%s)", m.Dst, m.Src)
+		}
+		if m.FSType == "tmpfs" {
+			tmpfs++
+		}
+	}
+	if tmpfs == 0 {
+		t.Error("no scratch tmpfs")
+	}
+}
+
+func TestScratchFull(t *testing.T) {
+	for _, tc := range []struct {
+		line     string
+		full, ok bool
+	}{
+		{"scratch /tmp 2048 1000", false, true},
+		{"scratch /tmp 0 1000", true, true},
+		{"scratch /env/tmp 2048 0", true, true},
+		{"scratch /tmp", false, false},
+		{"scratch /tmp many 0", false, false},
+		{"start", false, false},
+		{"scrap /tmp 0 0", false, false},
+	} {
+		if full, ok := scratchFull(tc.line); full != tc.full || ok != tc.ok {
+			t.Errorf("scratchFull(%q) = %v, %v, want %v, %v", tc.line, full, ok, tc.full, tc.ok)
+		}
+	}
+}
+
+func TestScratchReport(t *testing.T) {
+	if _, err := exec.LookPath("stat"); err != nil {
+		t.Skip("no stat")
+	}
+	out, _ := exec.Command("sh", "-c", ScratchReport).Output()
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], StatusScratch+" /tmp ") {
+		t.Fatalf("report %q", out)
+	}
+	for _, line := range lines {
+		if _, ok := scratchFull(line); !ok {
+			t.Errorf("report line %q does not parse", line)
+		}
+	}
+}
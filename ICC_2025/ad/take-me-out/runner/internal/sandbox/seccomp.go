@@ -0,0 +1,165 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Phase is the step of a submission a sandboxed command belongs to.
+type Phase string
+
+const (
+	PhaseCompile Phase = "compile"
+	PhaseRun     Phase = "run"
+)
+
+// Seccomp policy names, passed in RLimits.Seccomp. The empty name runs
+// without a filter.
+const (
+	SeccompCompile = "compile"
+	SeccompRun     = "run"
+)
+
+// seccompDenied are killed in every phase: kernel administration, other
+// processes' memory, and the syscalls jail escapes are built from.
+var seccompDenied = []string{
+	"ptrace", "process_vm_readv", "process_vm_writev", "kcmp",
+	"mount", "umount2", "pivot_root", "unshare", "setns",
+	"bpf", "perf_event_open", "userfaultfd",
+	"kexec_load", "kexec_file_load", "init_module", "finit_module", "delete_module",
+	"reboot", "swapon", "swapoff", "acct", "quotactl", "syslog",
+	"keyctl", "add_key", "request_key",
+	"open_by_handle_at", "name_to_handle_at", "fanotify_init", "lookup_dcookie",
+	"settimeofday", "clock_settime", "clock_adjtime", "adjtimex",
+	"iopl", "ioperm", "vhangup",
+	"io_uring_setup", "io_uring_enter", "io_uring_register",
+}
+
+// seccompPolicy is what a policy adds to the common deny list.
+type seccompPolicy struct {
+	// kill are syscalls that kill the program.
+	kill []string
+	// unixSocketsOnly kills socket(2) for every domain but AF_UNIX.
+	unixSocketsOnly bool
+}
+
+// seccompPolicies by name. Compiling runs toolchains with their many helper
+// processes, so it only gets the common deny list; programs under test
+// additionally lose network sockets and personality (which can switch ASLR
+// off).
+var seccompPolicies = map[string]seccompPolicy{
+	SeccompCompile: {},
+	SeccompRun:     {kill: []string{"personality"}, unixSocketsOnly: true},
+}
+
+// seccompCloneNamespaces are the CLONE_NEW* flags; creating namespaces is
+// left to the sandbox. clone3 hides its flags from the filter, so it fails
+// with ENOSYS and the C library falls back to clone.
+const seccompCloneNamespaces = 0x7e020000
+
+// SeccompPolicyFor returns the policy for language in phase: the compile or
+// run policy unless SANDBOX_SECCOMP_<LANGUAGE>_<PHASE> names another one.
+// SANDBOX_SECCOMP=off disables filtering altogether, and "off" as a
+// per-language value disables it for that language and phase.
+func SeccompPolicyFor(language string, phase Phase) string {
+	if isOff(os.Getenv("SANDBOX_SECCOMP")) {
+		return ""
+	}
+	key := "SANDBOX_SECCOMP_" + strings.ToUpper(language) + "_" + strings.ToUpper(string(phase))
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if isOff(v) {
+			return ""
+		}
+		name := strings.ToLower(v)
+		if _, ok := seccompPolicies[name]; ok {
+			return name
+		}
+		slog.Warn("sandbox: unknown seccomp policy, using the default", "env", key, "policy", v)
+	}
+	if phase == PhaseCompile {
+		return SeccompCompile
+	}
+	return SeccompRun
+}
+
+func isOff(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "off", "0", "false", "none":
+		return true
+	}
+	return false
+}
+
+// seccompDeniedFor is the common deny list. chroot joins it unless the
+// chroot-run helper needs it to enter the runroot.
+func seccompDeniedFor(allowChroot bool) []string {
+	denied := append([]string(nil), seccompDenied...)
+	if !allowChroot {
+		denied = append(denied, "chroot")
+	}
+	return denied
+}
+
+// seccompProgram renders the kafel program nsjail compiles for policy.
+func seccompProgram(policy string, allowChroot bool) (string, error) {
+	p, ok := seccompPolicies[policy]
+	if !ok {
+		return "", fmt.Errorf("unknown seccomp policy %q", policy)
+	}
+	var sb strings.Builder
+	sb.WriteString("POLICY goexe_base {\n  KILL {\n    ")
+	sb.WriteString(strings.Join(seccompDeniedFor(allowChroot), ", "))
+	fmt.Fprintf(&sb, ",\n    clone(flags) { (flags & %#x) != 0 }\n  }\n", seccompCloneNamespaces)
+	sb.WriteString("  ERRNO(38) { clone3 }\n}\n")
+	use := "goexe_base"
+	var rules []string
+	if p.unixSocketsOnly {
+		rules = append(rules, "socket(domain) { domain != 1 }")
+	}
+	rules = append(rules, p.kill...)
+	if len(rules) > 0 {
+		name := "goexe_" + strings.ReplaceAll(policy, "-", "_")
+		fmt.Fprintf(&sb, "POLICY %s {\n  KILL {\n    %s\n  }\n}\n", name, strings.Join(rules, ",\n    "))
+		use += ", " + name
+	}
+	sb.WriteString("USE " + use + " DEFAULT ALLOW\n")
+	return sb.String(), nil
+}
+
+// nsjail logs the syscall of a process it reaped with SIGSYS; the wording
+// differs between versions.
+var (
+	seccompViolationLog = regexp.MustCompile(`(?i)seccomp violation|killed by SIGSYS|signal: Bad system call`)
+	seccompSyscallLog   = regexp.MustCompile(`(?:Syscall number|si_syscall|SiSyscall):\s*(\d+)`)
+)
+
+// seccompViolation reports whether the run was stopped by the seccomp
+// filter, judging by nsjail's log and its exit status (128+SIGSYS
+// when the jailed process died of the signal), and names the syscall when
+// nsjail logged it.
+func seccompViolation(jailLog string, runErr error) (bool, string) {
+	violated := seccompViolationLog.MatchString(jailLog)
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) && exitErr.ExitCode() == 128+int(syscall.SIGSYS) {
+		violated = true
+	}
+	if !violated {
+		return false, ""
+	}
+	m := seccompSyscallLog.FindStringSubmatch(jailLog)
+	if m == nil {
+		return true, "unknown"
+	}
+	n, _ := strconv.Atoi(m[1])
+	if name, ok := syscallNames[n]; ok {
+		return true, name
+	}
+	return true, "syscall " + m[1]
+}
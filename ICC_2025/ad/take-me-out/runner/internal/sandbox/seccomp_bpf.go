@@ -0,0 +1,124 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Backends without kafel (bubblewrap) load the seccomp policies as classic
+// BPF. seccompFilter compiles the same rules seccompProgram renders for
+// nsjail; it only knows x86_64, and kills anything else.
+
+// Offsets into struct seccomp_data; args[0] is read as its low 32 bits.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+var (
+	syscallNumbersOnce sync.Once
+	syscallNumbers     map[string]int
+)
+
+func syscallNumber(name string) (int, bool) {
+	syscallNumbersOnce.Do(func() {
+		syscallNumbers = make(map[string]int, len(syscallNames))
+		for nr, n := range syscallNames {
+			syscallNumbers[n] = nr
+		}
+	})
+	nr, ok := syscallNumbers[name]
+	return nr, ok
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// seccompFilter compiles policy to a BPF program.
+func seccompFilter(policy string, allowChroot bool) ([]unix.SockFilter, error) {
+	p, ok := seccompPolicies[policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown seccomp policy %q", policy)
+	}
+	const (
+		ld    = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq   = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge   = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset  = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		ret   = unix.BPF_RET | unix.BPF_K
+		kill  = unix.SECCOMP_RET_KILL_PROCESS
+		allow = unix.SECCOMP_RET_ALLOW
+	)
+	prog := []unix.SockFilter{
+		bpfStmt(ld, seccompDataArch),
+		bpfJump(jeq, unix.AUDIT_ARCH_X86_64, 1, 0),
+		bpfStmt(ret, kill),
+		bpfStmt(ld, seccompDataNr),
+		// x32 syscall numbers
+		bpfJump(jge, 0x40000000, 0, 1),
+		bpfStmt(ret, kill),
+	}
+	// every rule is a comparison against the syscall number that skips its
+	// body, and a body that returns
+	rule := func(name string, body ...unix.SockFilter) error {
+		nr, ok := syscallNumber(name)
+		if !ok {
+			return fmt.Errorf("seccomp: unknown syscall %q", name)
+		}
+		prog = append(prog, bpfJump(jeq, uint32(nr), 0, uint8(len(body))))
+		prog = append(prog, body...)
+		return nil
+	}
+	killed := append(seccompDeniedFor(allowChroot), p.kill...)
+	for _, name := range killed {
+		if err := rule(name, bpfStmt(ret, kill)); err != nil {
+			return nil, err
+		}
+	}
+	if err := rule("clone",
+		bpfStmt(ld, seccompDataArg0),
+		bpfJump(jset, seccompCloneNamespaces, 0, 1),
+		bpfStmt(ret, kill),
+		bpfStmt(ret, allow),
+	); err != nil {
+		return nil, err
+	}
+	if err := rule("clone3", bpfStmt(ret, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS))); err != nil {
+		return nil, err
+	}
+	if p.unixSocketsOnly {
+		if err := rule("socket",
+			bpfStmt(ld, seccompDataArg0),
+			bpfJump(jeq, unix.AF_UNIX, 1, 0),
+			bpfStmt(ret, kill),
+			bpfStmt(ret, allow),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return append(prog, bpfStmt(ret, allow)), nil
+}
+
+// seccompFilterBytes is policy's filter as the raw struct sock_filter array
+// bubblewrap reads from its --seccomp descriptor.
+func seccompFilterBytes(policy string, allowChroot bool) ([]byte, error) {
+	prog, err := seccompFilter(policy, allowChroot)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, prog); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
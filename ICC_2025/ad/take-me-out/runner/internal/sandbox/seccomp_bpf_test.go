@@ -0,0 +1,85 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestSeccompFilterChild is the process TestSeccompFilter loads the run
+// filter into; it makes the call SECCOMP_FILTER_TEST_CALL names.
+func TestSeccompFilterChild(t *testing.T) {
+	call := os.Getenv("SECCOMP_FILTER_TEST_CALL")
+	if call == "" {
+		t.Skip("helper process")
+	}
+	prog, err := seccompFilter(SeccompRun, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.LockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		t.Fatal(errno)
+	}
+	switch call {
+	case "getpid":
+		unix.Getpid()
+	case "unix-socket":
+		fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unix.Close(fd)
+	case "inet-socket":
+		unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	case "personality":
+		unix.Syscall(unix.SYS_PERSONALITY, 0xffffffff, 0, 0)
+	case "unshare":
+		unix.Unshare(unix.CLONE_NEWNS)
+	case "chroot":
+		unix.Chroot("/")
+	case "clone3":
+		if _, _, errno := unix.Syscall(unix.SYS_CLONE3, 0, 0, 0); errno != unix.ENOSYS {
+			t.Fatalf("clone3: %v, want ENOSYS", errno)
+		}
+	}
+}
+
+func TestSeccompFilter(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the filter is for x86_64")
+	}
+	for call, killed := range map[string]bool{
+		"getpid":      false,
+		"unix-socket": false,
+		"clone3":      false,
+		"inet-socket": true,
+		"personality": true,
+		"unshare":     true,
+		"chroot":      true,
+	} {
+		t.Run(call, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestSeccompFilterChild$")
+			cmd.Env = append(os.Environ(), "SECCOMP_FILTER_TEST_CALL="+call)
+			out, err := cmd.CombinedOutput()
+			var exitErr *exec.ExitError
+			sigsys := errors.As(err, &exitErr) && exitErr.Sys().(syscall.WaitStatus).Signal() == syscall.SIGSYS
+			switch {
+			case killed && !sigsys:
+				t.Errorf("%s was not killed: %v\n%s", call, err, out)
+			case !killed && err != nil:
+				t.Errorf("%s failed: %v\n%s", call, err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompPolicyFor(t *testing.T) {
+	for _, tc := range []struct {
+		name, global, override string
+		phase                  Phase
+		want                   string
+	}{
+		{"compile default", "", "", PhaseCompile, SeccompCompile},
+		{"run default", "", "", PhaseRun, SeccompRun},
+		{"globally off", "off", "", PhaseRun, ""},
+		{"globally off beats an override", "false", "compile", PhaseRun, ""},
+		{"override", "", "Compile", PhaseRun, SeccompCompile},
+		{"override off", "", "none", PhaseRun, ""},
+		{"unknown override", "", "lenient", PhaseRun, SeccompRun},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_SECCOMP", tc.global)
+			t.Setenv("SANDBOX_SECCOMP_PYTHON_"+strings.ToUpper(string(tc.phase)), tc.override)
+			if got := SeccompPolicyFor("python", tc.phase); got != tc.want {
+				t.Errorf("SeccompPolicyFor = %q, want %q", got, tc.want)
+			}
+			// overrides are per language
+			if tc.global == "" {
+				if got := SeccompPolicyFor("java", tc.phase); got != SeccompPolicyFor("cpp", tc.phase) || got == "" {
+					t.Errorf("another language got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestSeccompProgram(t *testing.T) {
+	run, err := seccompProgram(SeccompRun, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"POLICY goexe_base {",
+		"ptrace, ",
+		", chroot,\n",
+		"clone(flags) { (flags & 0x7e020000) != 0 }",
+		"ERRNO(38) { clone3 }",
+		"POLICY goexe_run {\n  KILL {\n    socket(domain) { domain != 1 },\n    personality\n  }\n}",
+		"USE goexe_base, goexe_run DEFAULT ALLOW\n",
+	} {
+		if !strings.Contains(run, want) {
+			t.Errorf("run policy lacks %q:\n%s", want, run)
+		}
+	}
+
+	compile, err := seccompProgram(SeccompCompile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(compile, "chroot") || strings.Contains(compile, "socket") || strings.Contains(compile, "personality") {
+		t.Errorf("compile policy with chroot allowed kills too much:\n%s", compile)
+	}
+	if !strings.HasSuffix(compile, "USE goexe_base DEFAULT ALLOW\n") {
+		t.Errorf("compile policy uses more than the base:\n%s", compile)
+	}
+
+	if _, err := seccompProgram("lenient", false); err == nil {
+		t.Error("unknown policy rendered")
+	}
+}
+
+// filterKills reports whether prog compares the syscall number against nr
+// and returns KILL right after the match.
+func filterKills(prog []unix.SockFilter, nr int) bool {
+	for i, ins := range prog[:len(prog)-1] {
+		if ins.Code == unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K && ins.K == uint32(nr) && ins.Jt == 0 {
+			next := prog[i+1]
+			return next.Code == unix.BPF_RET|unix.BPF_K && next.K == unix.SECCOMP_RET_KILL_PROCESS
+		}
+	}
+	return false
+}
+
+func TestSeccompFilterRules(t *testing.T) {
+	for name := range seccompPolicies {
+		for _, allowChroot := range []bool{false, true} {
+			prog, err := seccompFilter(name, allowChroot)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if last := prog[len(prog)-1]; last.K != unix.SECCOMP_RET_ALLOW {
+				t.Errorf("%s: the default is %#x, not allow", name, last.K)
+			}
+			for _, denied := range seccompDenied {
+				nr, _ := syscallNumber(denied)
+				if !filterKills(prog, nr) {
+					t.Errorf("%s: %s is not killed", name, denied)
+				}
+			}
+			chroot, _ := syscallNumber("chroot")
+			if filterKills(prog, chroot) == allowChroot {
+				t.Errorf("%s: chroot killed %v with allowChroot %v", name, !allowChroot, allowChroot)
+			}
+			personality, _ := syscallNumber("personality")
+			if filterKills(prog, personality) != (name == SeccompRun) {
+				t.Errorf("%s: personality handled wrongly", name)
+			}
+			// bubblewrap reads struct sock_filter, 8 bytes each
+			raw, err := seccompFilterBytes(name, allowChroot)
+			if err != nil || len(raw) != 8*len(prog) {
+				t.Errorf("%s: %d filter bytes for %d instructions, %v", name, len(raw), len(prog), err)
+			}
+		}
+	}
+	if _, err := seccompFilter("lenient", false); err == nil {
+		t.Error("unknown policy compiled")
+	}
+}
+
+func TestSeccompViolation(t *testing.T) {
+	sigsys := exec.Command("sh", "-c", "exit 159").Run()
+	for _, tc := range []struct {
+		name, log string
+		err       error
+		violated  bool
+		syscall   string
+	}{
+		{"clean", "[I] pid=12 exited with status: 0", nil, false, ""},
+		{"other failure", "", errors.New("exit status 1"), false, ""},
+		{"named", "[W][2025-03-01T12:00:00+0000][1] pid=12 seccomp violation, Syscall number: 101", nil, true, "ptrace"},
+		{"si_syscall", "killed by SIGSYS si_syscall: 41", nil, true, "socket"},
+		{"number unknown to the table", "seccomp violation Syscall number: 9999", nil, true, "syscall 9999"},
+		{"exit status only", "", sigsys, true, "unknown"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			violated, name := seccompViolation(tc.log, tc.err)
+			if violated != tc.violated || name != tc.syscall {
+				t.Errorf("seccompViolation = %v, %q, want %v, %q", violated, name, tc.violated, tc.syscall)
+			}
+		})
+	}
+}
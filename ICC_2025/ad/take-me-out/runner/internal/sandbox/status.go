@@ -0,0 +1,129 @@
+package sandbox
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Descriptors a jail started by runInChroot gets past stdio.
+const (
+	// jailLogFD carries nsjail's log, so a run's stderr is the program's
+	// alone.
+	jailLogFD = 3
+	// StatusFD is where the command wrapping a run's program reports on
+	// the run: StatusStart right before it starts the program and
+	// StatusScratch lines after it exited. The program must not inherit
+	// it, or it could report for itself.
+	StatusFD = 4
+)
+
+// StatusStart marks the program's start on StatusFD; TrimSetup counts the
+// program's wall time from its arrival.
+const StatusStart = "start"
+
+// jailLogLimit caps how much of nsjail's log a run keeps.
+const jailLogLimit = 64 << 10
+
+// setExtraFile makes f descriptor fd of cmd's process.
+func setExtraFile(cmd *exec.Cmd, fd int, f *os.File) {
+	for len(cmd.ExtraFiles) <= fd-3 {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, nil)
+	}
+	cmd.ExtraFiles[fd-3] = f
+}
+
+// jailPipes are the runner's ends of the pipes on a jail's log and status
+// descriptors.
+type jailPipes struct {
+	log     outputCapture
+	started time.Time
+	// scratchFull is set when a StatusScratch line showed a full tmpfs.
+	scratchFull bool
+
+	readers, writers []*os.File
+	done             sync.WaitGroup
+}
+
+// attachJailPipes gives cmd a pipe on cfg.LogFD and one on StatusFD when
+// cfg passes it on.
+func attachJailPipes(cmd *exec.Cmd, cfg *NsjailConfig) (*jailPipes, error) {
+	p := &jailPipes{log: outputCapture{max: jailLogLimit}}
+	attach := func(fd int, read func(io.Reader)) error {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		setExtraFile(cmd, fd, w)
+		p.readers, p.writers = append(p.readers, r), append(p.writers, w)
+		p.done.Add(1)
+		go func() {
+			defer p.done.Done()
+			read(r)
+			// whatever follows is not worth keeping, but must not block
+			// the writer
+			_, _ = io.Copy(io.Discard, r)
+		}()
+		return nil
+	}
+	if cfg.LogFD > 0 {
+		if err := attach(cfg.LogFD, func(r io.Reader) { _, _ = io.Copy(&p.log, r) }); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	if slices.Contains(cfg.PassFDs, StatusFD) {
+		if err := attach(StatusFD, p.readStatus); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// readStatus records when the first StatusStart arrives and whether any
+// StatusScratch line shows a full tmpfs.
+func (p *jailPipes) readStatus(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == StatusStart && p.started.IsZero() {
+			p.started = time.Now()
+		}
+		if full, ok := scratchFull(line); ok && full {
+			p.scratchFull = true
+		}
+	}
+}
+
+// closeWriters hands the write ends over to the jail.
+func (p *jailPipes) closeWriters() {
+	for _, w := range p.writers {
+		w.Close()
+	}
+	p.writers = nil
+}
+
+// wait reads the pipes to their end once the jail has exited. The jail's
+// processes die with it; the deadline only guards against one that did
+// not.
+func (p *jailPipes) wait() {
+	for _, r := range p.readers {
+		_ = r.SetReadDeadline(time.Now().Add(time.Second))
+	}
+	p.done.Wait()
+	p.close()
+}
+
+func (p *jailPipes) close() {
+	p.closeWriters()
+	for _, r := range p.readers {
+		r.Close()
+	}
+	p.readers = nil
+}
@@ -0,0 +1,73 @@
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestSetExtraFile(t *testing.T) {
+	cmd := exec.Command("true")
+	f := os.NewFile(0, "f")
+	setExtraFile(cmd, 5, f)
+	if len(cmd.ExtraFiles) != 3 || cmd.ExtraFiles[2] != f || cmd.ExtraFiles[0] != nil {
+		t.Fatalf("ExtraFiles %v", cmd.ExtraFiles)
+	}
+	setExtraFile(cmd, 3, f)
+	if len(cmd.ExtraFiles) != 3 || cmd.ExtraFiles[0] != f {
+		t.Errorf("ExtraFiles %v", cmd.ExtraFiles)
+	}
+}
+
+func TestJailPipes(t *testing.T) {
+	cfg := &NsjailConfig{LogFD: jailLogFD, PassFDs: []int{StatusFD}}
+	cmd := exec.Command("sh", "-c", `
+echo '[I] pid=12 seccomp violation' >&3
+sleep 0.2
+echo start >&4
+echo start >&4
+echo program output
+echo scratch /tmp 0 12 >&4
+echo scratch /env/tmp 100 12 >&4`)
+	pipes, err := attachJailPipes(cmd, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	launched := time.Now()
+	out, err := cmd.Output()
+	pipes.closeWriters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipes.wait()
+	if string(out) != "program output\n" {
+		t.Errorf("stdout %q", out)
+	}
+	if got := pipes.log.String(); got != "[I] pid=12 seccomp violation\n" {
+		t.Errorf("log %q", got)
+	}
+	if !pipes.scratchFull {
+		t.Error("the full tmpfs went unnoticed")
+	}
+	if d := pipes.started.Sub(launched); d < 200*time.Millisecond || d > 5*time.Second {
+		t.Errorf("start reported %v after launch", d)
+	}
+}
+
+func TestJailPipesWithoutStatus(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo start; [ -e /proc/self/fd/4 ] && echo status passed")
+	pipes, err := attachJailPipes(cmd, &NsjailConfig{LogFD: jailLogFD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	pipes.closeWriters()
+	pipes.wait()
+	if err != nil && len(out) == 0 {
+		t.Fatal(err)
+	}
+	if string(out) != "start\n" || !pipes.started.IsZero() || pipes.scratchFull {
+		t.Errorf("stdout %q, started %v", out, pipes.started)
+	}
+}
@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setupAllowance is added to a run's deadline for what nsjail does before
+// the program starts (namespaces, mounts, seccomp); it is not charged to the
+// program.
+const setupAllowance = 500 * time.Millisecond
+
+// TimeLimits bounds a single program run. CPU is the time limit proper;
+// Wall only catches programs that sit idle, blocked on input or sleeping.
+type TimeLimits struct {
+	CPU  time.Duration
+	Wall time.Duration
+}
+
+// TimeOutcome is how a run fared against its TimeLimits.
+type TimeOutcome int
+
+const (
+	WithinTimeLimits TimeOutcome = iota
+	CPULimitExceeded
+	WallLimitExceeded
+)
+
+// TimeLimitsFor returns the limits for a CPU limit of cpuMs. The wall limit
+// comes from RUN_LIMIT_WALL_MS and defaults to twice the CPU limit.
+func TimeLimitsFor(cpuMs int) TimeLimits {
+	wallMs := 2 * cpuMs
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("RUN_LIMIT_WALL_MS"))); err == nil && n > 0 {
+		wallMs = n
+	}
+	return TimeLimits{
+		CPU:  time.Duration(cpuMs) * time.Millisecond,
+		Wall: time.Duration(wallMs) * time.Millisecond,
+	}
+}
+
+// Deadline is how long to let RunInChroot run before cancelling it.
+func (l TimeLimits) Deadline() time.Duration {
+	return l.Wall + setupAllowance
+}
+
+// Check classifies res. timedOut means the run was cancelled at Deadline.
+// A cancelled run without a CPU figure (no cgroup, and nsjail never reported
+// its usage) is taken to have been busy.
+func (l TimeLimits) Check(res RunResult, timedOut bool) TimeOutcome {
+	switch {
+	case l.CPU > 0 && res.CPUTime > l.CPU:
+		return CPULimitExceeded
+	case timedOut && res.CPUTime == 0:
+		return CPULimitExceeded
+	case timedOut, l.Wall > 0 && res.WallTime > l.Wall:
+		return WallLimitExceeded
+	}
+	return WithinTimeLimits
+}
+
+// TrimSetup narrows WallTime to the program itself, counting from the
+// StatusStart its jailed command reported on StatusFD. A run that reported
+// no start keeps its WallTime.
+func (r *RunResult) TrimSetup() {
+	if r.exited.IsZero() || r.started.IsZero() {
+		return
+	}
+	if wall := r.exited.Sub(r.started); wall >= 0 && wall < r.WallTime {
+		r.WallTime = wall
+	}
+}
+
+// ProgramTime is the time charged to the program: its CPU time, or its wall
+// time when no CPU figure is available.
+func (r RunResult) ProgramTime() time.Duration {
+	if r.CPUTime > 0 {
+		return r.CPUTime
+	}
+	return r.WallTime
+}
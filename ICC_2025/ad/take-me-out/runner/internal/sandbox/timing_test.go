@@ -0,0 +1,82 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeLimitsFor(t *testing.T) {
+	t.Setenv("RUN_LIMIT_WALL_MS", "")
+	l := TimeLimitsFor(1500)
+	if l.CPU != 1500*time.Millisecond || l.Wall != 3*time.Second || l.Deadline() != 3*time.Second+setupAllowance {
+		t.Errorf("default limits %+v, deadline %v", l, l.Deadline())
+	}
+	t.Setenv("RUN_LIMIT_WALL_MS", "10000")
+	if l := TimeLimitsFor(1500); l.Wall != 10*time.Second {
+		t.Errorf("RUN_LIMIT_WALL_MS ignored: %+v", l)
+	}
+	for _, bad := range []string{"0", "-5", "lots"} {
+		t.Setenv("RUN_LIMIT_WALL_MS", bad)
+		if l := TimeLimitsFor(1500); l.Wall != 3*time.Second {
+			t.Errorf("RUN_LIMIT_WALL_MS=%s: %+v", bad, l)
+		}
+	}
+}
+
+func TestTimeLimitsCheck(t *testing.T) {
+	l := TimeLimits{CPU: time.Second, Wall: 2 * time.Second}
+	for _, tc := range []struct {
+		name     string
+		res      RunResult
+		timedOut bool
+		want     TimeOutcome
+	}{
+		{"within", RunResult{CPUTime: 900 * time.Millisecond, WallTime: time.Second}, false, WithinTimeLimits},
+		{"busy loop", RunResult{CPUTime: 1100 * time.Millisecond, WallTime: 1100 * time.Millisecond}, false, CPULimitExceeded},
+		{"cpu over and cancelled", RunResult{CPUTime: 1900 * time.Millisecond}, true, CPULimitExceeded},
+		{"cancelled without a cpu figure", RunResult{WallTime: 2500 * time.Millisecond}, true, CPULimitExceeded},
+		{"sleeping, cancelled", RunResult{CPUTime: 10 * time.Millisecond}, true, WallLimitExceeded},
+		{"sleeping past the wall limit", RunResult{CPUTime: 10 * time.Millisecond, WallTime: 2100 * time.Millisecond}, false, WallLimitExceeded},
+		// reaching a limit is not exceeding it
+		{"exactly at the limits", RunResult{CPUTime: time.Second, WallTime: 2 * time.Second}, false, WithinTimeLimits},
+	} {
+		if got := l.Check(tc.res, tc.timedOut); got != tc.want {
+			t.Errorf("%s: Check = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := (TimeLimits{}).Check(RunResult{CPUTime: time.Hour, WallTime: time.Hour}, false); got != WithinTimeLimits {
+		t.Errorf("zero limits: %v", got)
+	}
+}
+
+func TestProgramTime(t *testing.T) {
+	if got := (RunResult{CPUTime: time.Second, WallTime: 3 * time.Second}).ProgramTime(); got != time.Second {
+		t.Errorf("ProgramTime with CPU time = %v", got)
+	}
+	if got := (RunResult{WallTime: 3 * time.Second}).ProgramTime(); got != 3*time.Second {
+		t.Errorf("ProgramTime without CPU time = %v", got)
+	}
+}
+
+func TestTrimSetup(t *testing.T) {
+	exited := time.Now()
+	// nsjail started 400ms before the program and exited 100ms after it started
+	res := RunResult{WallTime: 500 * time.Millisecond, started: exited.Add(-100 * time.Millisecond), exited: exited}
+	res.TrimSetup()
+	if res.WallTime != 100*time.Millisecond {
+		t.Errorf("trimmed WallTime %v, want 100ms", res.WallTime)
+	}
+
+	for name, res := range map[string]RunResult{
+		"no exit time":        {WallTime: 500 * time.Millisecond, started: exited},
+		"no start reported":   {WallTime: 500 * time.Millisecond, exited: exited},
+		"start after exit":    {WallTime: 500 * time.Millisecond, started: exited.Add(time.Millisecond), exited: exited},
+		"start before launch": {WallTime: 50 * time.Millisecond, started: exited.Add(-100 * time.Millisecond), exited: exited},
+	} {
+		want := res.WallTime
+		res.TrimSetup()
+		if res.WallTime != want {
+			t.Errorf("%s: WallTime changed to %v", name, res.WallTime)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	sampledParent   = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	unsampledParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+)
+
+func TestWithTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		name, tp string
+		want     string
+	}{
+		{"sampled", sampledParent, sampledParent},
+		{"not sampled", unsampledParent, unsampledParent},
+		{"surrounding space", " " + sampledParent + " ", sampledParent},
+		{"empty", "", ""},
+		{"too few fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", ""},
+		{"short trace id", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", ""},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", ""},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", ""},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := WithTraceparent(context.Background(), tc.tp)
+			if got := Traceparent(ctx); got != tc.want {
+				t.Errorf("Traceparent = %q, want %q", got, tc.want)
+			}
+			if sc := trace.SpanContextFromContext(ctx); tc.want != "" && !sc.IsRemote() {
+				t.Error("the parent is not marked remote")
+			}
+		})
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	in := make(http.Header)
+	in.Set(HeaderTraceparent, sampledParent)
+	ctx := Extract(context.Background(), in)
+
+	out := make(http.Header)
+	Inject(ctx, out)
+	if got := out.Get(HeaderTraceparent); got != sampledParent {
+		t.Errorf("injected %q, want %q", got, sampledParent)
+	}
+
+	empty := make(http.Header)
+	Inject(context.Background(), empty)
+	if len(empty) != 0 {
+		t.Errorf("injected without a trace: %v", empty)
+	}
+}
+
+func TestSpansContinueRemoteParent(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+
+	ctx := WithTraceparent(context.Background(), sampledParent)
+	ctx, span := tracer.Start(ctx, "job")
+	child := Traceparent(ctx)
+	RecordError(span, nil)
+	span.End()
+
+	_, other := tracer.Start(WithTraceparent(context.Background(), unsampledParent), "dropped")
+	other.End()
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans recorded, want only the sampled one", len(spans))
+	}
+	s := spans[0]
+	if s.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || s.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("span %s has parent %s", s.SpanContext().TraceID(), s.Parent().SpanID())
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + s.SpanContext().SpanID().String() + "-01"; child != want {
+		t.Errorf("child traceparent %q, want %q", child, want)
+	}
+	if s.Status().Code != codes.Unset {
+		t.Errorf("a nil error set status %v", s.Status())
+	}
+}
+
+func TestRecordError(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	_, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test").Start(context.Background(), "fails")
+	RecordError(span, errors.New("boom"))
+	span.End()
+	s := rec.Ended()[0]
+	if s.Status().Code != codes.Error || s.Status().Description != "boom" || len(s.Events()) != 1 {
+		t.Errorf("status %v, events %v", s.Status(), s.Events())
+	}
+}
+
+func TestNoopTracerPassesContextAlong(t *testing.T) {
+	// without an exporter the global provider is a no-op; the trace context
+	// received from upstream must still reach the next hop
+	ctx := WithTraceparent(context.Background(), sampledParent)
+	ctx, span := noop.NewTracerProvider().Tracer("test").Start(ctx, "job")
+	span.End()
+	if got := Traceparent(ctx); got != sampledParent {
+		t.Errorf("Traceparent = %q, want %q", got, sampledParent)
+	}
+}
+
+func TestSetupExportsSpans(t *testing.T) {
+	oldProvider, oldPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	Setup("test")(context.Background())
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		t.Fatal("Setup without an endpoint installed an SDK provider")
+	}
+
+	exports := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		exports <- r.URL.Path
+	}))
+	defer srv.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	shutdown := Setup("test")
+	_, span := otel.Tracer("test").Start(context.Background(), "exported")
+	span.End()
+	shutdown(context.Background())
+	select {
+	case path := <-exports:
+		if path != "/v1/traces" {
+			t.Errorf("exported to %s", path)
+		}
+	default:
+		t.Error("shutdown did not flush the span")
+	}
+}
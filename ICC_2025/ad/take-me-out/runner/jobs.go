@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe-runner/internal/logging"
+	"goexe-runner/internal/tracing"
+)
+
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobRunning   jobState = "running"
+	jobDone      jobState = "done"
+	jobCancelled jobState = "cancelled"
+)
+
+// job is one run request moving through the worker pool. Synchronous /run
+// requests and asynchronous /jobs share it; only the latter are kept in
+// the jobs table for later lookup.
+type job struct {
+	id      string
+	seq     uint64
+	caller  string
+	req     RunRequest
+	webhook string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	span    trace.Span
+	created time.Time
+
+	mu         sync.Mutex
+	state      jobState
+	resp       RunResponse
+	testsDone  int
+	testsTotal int
+	finishedAt time.Time
+}
+
+// jobStatus is the JSON view of a job for GET /jobs/{id} and webhooks.
+type jobStatus struct {
+	ID            string       `json:"id"`
+	State         jobState     `json:"state"`
+	QueuePosition int          `json:"queue_position,omitempty"`
+	TestsDone     int          `json:"tests_done"`
+	TestsTotal    int          `json:"tests_total"`
+	Result        *RunResponse `json:"result,omitempty"`
+}
+
+var (
+	errQueueFull = errors.New("job queue full")
+
+	tracer = otel.Tracer("goexe-runner")
+
+	enqueuedJobs atomic.Uint64
+	dequeuedJobs atomic.Uint64
+
+	enqueueMu sync.Mutex
+	jobsMu    sync.Mutex
+	jobs      = make(map[string]*job)
+)
+
+func newJob(parent context.Context, caller string, req RunRequest) *job {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	id := hex.EncodeToString(raw)
+	ctx := tracing.WithTraceparent(logging.WithRequestID(parent, req.RequestID), req.TraceParent)
+	ctx, span := tracer.Start(ctx, "runner.job", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("job.id", id),
+		attribute.String("runner.id", runnerID()),
+		attribute.String("language", req.Language),
+		attribute.String("challenge", req.Challenge),
+		attribute.String("mode", req.Mode)))
+	ctx, cancel := context.WithCancel(ctx)
+	slog.DebugContext(ctx, "job created", "job", id, "caller", caller, "language", req.Language, "challenge", req.Challenge, "mode", req.Mode, logging.Code(req.Code))
+	return &job{
+		id:      id,
+		caller:  caller,
+		req:     req,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		span:    span,
+		created: time.Now(),
+		state:   jobQueued,
+	}
+}
+
+// enqueue waits up to RUNNER_ENQUEUE_TIMEOUT_MS for a queue slot.
+func enqueue(ctx context.Context, j *job) error {
+	wait := time.Duration(envInt("RUNNER_ENQUEUE_TIMEOUT_MS", 2000)) * time.Millisecond
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		if tryEnqueue(j) {
+			return nil
+		}
+		select {
+		case <-time.After(enqueueRetryInterval):
+		case <-timer.C:
+			return errQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// enqueueRetryInterval is how often enqueue tries again for a slot.
+const enqueueRetryInterval = 10 * time.Millisecond
+
+// tryEnqueue queues j if there is a free slot. The sequence number is taken
+// under the send so queue positions follow channel order; the lock is never
+// held while waiting, so one caller cannot hold up another past its deadline.
+func tryEnqueue(j *job) bool {
+	enqueueMu.Lock()
+	defer enqueueMu.Unlock()
+	j.seq = enqueuedJobs.Add(1)
+	select {
+	case jobQueue <- j:
+		return true
+	default:
+		enqueuedJobs.Add(^uint64(0))
+		return false
+	}
+}
+
+// start moves a queued job to running; false means it was cancelled first.
+func (j *job) start() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != jobQueued || j.ctx.Err() != nil {
+		return false
+	}
+	j.state = jobRunning
+	_, wait := tracer.Start(j.ctx, "runner.queue_wait", trace.WithTimestamp(j.created))
+	wait.End()
+	return true
+}
+
+func (j *job) finish(resp RunResponse) {
+	j.mu.Lock()
+	if j.state != jobCancelled {
+		j.state = jobDone
+		j.resp = resp
+	}
+	j.finishedAt = time.Now()
+	j.span.SetAttributes(attribute.String("job.state", string(j.state)), attribute.String("result", j.resp.Result))
+	j.mu.Unlock()
+	j.span.End()
+	j.cancel()
+	close(j.done)
+	if j.webhook != "" {
+		go deliverWebhook(j)
+	}
+}
+
+// abort cancels the job. A queued job is finished right away; a running one
+// has its context cancelled, which kills its sandbox processes, and is
+// finished by its worker once the runroot has been cleaned up.
+func (j *job) abort() {
+	j.mu.Lock()
+	prev := j.state
+	if prev == jobQueued || prev == jobRunning {
+		j.state = jobCancelled
+	}
+	j.mu.Unlock()
+	j.cancel()
+	if prev == jobQueued {
+		j.finish(RunResponse{})
+	}
+}
+
+func (j *job) setProgress(done, total int) {
+	j.mu.Lock()
+	j.testsDone, j.testsTotal = done, total
+	j.mu.Unlock()
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := jobStatus{ID: j.id, State: j.state, TestsDone: j.testsDone, TestsTotal: j.testsTotal}
+	switch j.state {
+	case jobQueued:
+		if pos := int(j.seq) - int(dequeuedJobs.Load()); pos > 0 {
+			st.QueuePosition = pos
+		}
+	case jobDone:
+		resp := j.resp
+		st.Result = &resp
+	}
+	return st
+}
+
+type progressKey struct{}
+
+// withProgress lets execute report per-test progress back to its job.
+func withProgress(ctx context.Context, report func(done, total int)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+func reportProgress(ctx context.Context, done, total int) {
+	if report, ok := ctx.Value(progressKey{}).(func(int, int)); ok {
+		report(done, total)
+	}
+}
+
+// waitForIdle blocks until no job is queued or running, or ctx expires.
+// Asynchronous jobs outlive their HTTP request, so draining has to wait for
+// them separately from the server shutdown.
+func waitForIdle(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for len(jobQueue) > 0 || runningJobs.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// abortUnfinishedJobs cancels every asynchronous job still queued or
+// running. Their callers see the cancelled state (or the webhook) and can
+// resubmit elsewhere.
+func abortUnfinishedJobs() int {
+	jobsMu.Lock()
+	pending := make([]*job, 0, len(jobs))
+	for _, j := range jobs {
+		pending = append(pending, j)
+	}
+	jobsMu.Unlock()
+	n := 0
+	for _, j := range pending {
+		j.mu.Lock()
+		unfinished := j.state == jobQueued || j.state == jobRunning
+		j.mu.Unlock()
+		if unfinished {
+			j.abort()
+			n++
+		}
+	}
+	return n
+}
+
+func registerJob(j *job) {
+	jobsMu.Lock()
+	jobs[j.id] = j
+	jobsMu.Unlock()
+}
+
+func lookupJob(id string) (*job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	return j, ok
+}
+
+// reapJobs forgets jobs that finished more than retention ago, checking every
+// interval until ctx is done.
+func reapJobs(ctx context.Context, retention, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		forgetFinishedJobs(time.Now().Add(-retention))
+	}
+}
+
+func forgetFinishedJobs(cutoff time.Time) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for id, j := range jobs {
+		j.mu.Lock()
+		expired := !j.finishedAt.IsZero() && j.finishedAt.Before(cutoff)
+		j.mu.Unlock()
+		if expired {
+			delete(jobs, id)
+		}
+	}
+}
+
+// jobRequest is the body of POST /jobs.
+type jobRequest struct {
+	RunRequest
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// createJobHandler handles POST /jobs: it queues the job and returns its id
+// immediately with 202 Accepted.
+func createJobHandler(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "invalid JSON body")
+		return
+	}
+	if !sandboxModeAllowed(r.Context(), req.Sandbox) {
+		writeAPIError(w, http.StatusForbidden, "sandbox_mode_forbidden", "caller may not use sandbox mode "+strconv.Quote(req.Sandbox))
+		return
+	}
+	fillRequestContext(w, r, &req.RunRequest)
+	if req.WebhookURL != "" && !webhookAllowed(req.WebhookURL) {
+		writeAPIError(w, http.StatusBadRequest, "webhook_not_allowed", "webhook URL is not in RUNNER_WEBHOOK_ALLOWED_PREFIXES")
+		return
+	}
+	if jobQueue == nil {
+		rejectRun(w, "not-ready")
+		return
+	}
+	if draining.Load() {
+		rejectRun(w, "draining")
+		return
+	}
+	j := newJob(context.Background(), callerFromContext(r.Context()), req.RunRequest)
+	j.webhook = req.WebhookURL
+	registerJob(j)
+	if err := enqueue(r.Context(), j); err != nil {
+		jobsMu.Lock()
+		delete(jobs, j.id)
+		jobsMu.Unlock()
+		j.cancel()
+		if errors.Is(err, errQueueFull) {
+			rejectRun(w, "busy")
+			return
+		}
+		writeAPIError(w, http.StatusRequestTimeout, "cancelled", "request cancelled")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+j.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.status())
+}
+
+// getJobHandler handles GET /jobs/{id}
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := lookupJob(r.PathValue("id"))
+	if !ok || !jobVisibleTo(j, r) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown job")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.status())
+}
+
+// deleteJobHandler handles DELETE /jobs/{id}: it cancels the job, killing
+// its sandbox processes if it is already running.
+func deleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := lookupJob(r.PathValue("id"))
+	if !ok || !jobVisibleTo(j, r) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown job")
+		return
+	}
+	j.abort()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.status())
+}
+
+// jobVisibleTo keeps callers from reading or cancelling each other's jobs.
+// Without auth every job belongs to the anonymous caller.
+func jobVisibleTo(j *job, r *http.Request) bool {
+	return j.caller == callerFromContext(r.Context())
+}
+
+// webhookAllowed only lets jobs call back to URLs under one of the
+// comma-separated RUNNER_WEBHOOK_ALLOWED_PREFIXES; without any, webhooks are
+// disabled so the runner cannot be used to reach arbitrary hosts.
+func webhookAllowed(target string) bool {
+	for _, prefix := range strings.Split(os.Getenv("RUNNER_WEBHOOK_ALLOWED_PREFIXES"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverWebhook POSTs the final job status to the job's webhook, retrying a
+// few times. With auth enabled the body is signed with the submitting
+// caller's secret: X-Runner-Signature = HMAC(timestamp \n hex(sha256(body))).
+func deliverWebhook(j *job) {
+	body, err := json.Marshal(j.status())
+	if err != nil {
+		return
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	backoff := time.Second
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		req, err := http.NewRequest(http.MethodPost, j.webhook, bytes.NewReader(body))
+		if err != nil {
+			slog.ErrorContext(j.ctx, "job webhook request", "job", j.id, "err", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if auth != nil {
+			if secret, ok := auth.keys[j.caller]; ok {
+				ts := strconv.FormatInt(time.Now().Unix(), 10)
+				sum := sha256.Sum256(body)
+				mac := hmac.New(sha256.New, secret)
+				io.WriteString(mac, ts+"\n"+hex.EncodeToString(sum[:]))
+				req.Header.Set(headerCaller, j.caller)
+				req.Header.Set(headerTimestamp, ts)
+				req.Header.Set(headerSignature, hex.EncodeToString(mac.Sum(nil)))
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			slog.WarnContext(j.ctx, "job webhook delivery failed", "job", j.id, "attempt", attempt+1, "err", err)
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode < 500 {
+			return
+		}
+		slog.WarnContext(j.ctx, "job webhook rejected", "job", j.id, "attempt", attempt+1, "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withJobs swaps in an empty job table for the duration of the test.
+func withJobs(t *testing.T, js ...*job) {
+	t.Helper()
+	jobsMu.Lock()
+	old := jobs
+	jobs = make(map[string]*job)
+	for _, j := range js {
+		jobs[j.id] = j
+	}
+	jobsMu.Unlock()
+	t.Cleanup(func() {
+		jobsMu.Lock()
+		jobs = old
+		jobsMu.Unlock()
+	})
+}
+
+func TestForgetFinishedJobs(t *testing.T) {
+	now := time.Now()
+	withJobs(t,
+		&job{id: "running", state: jobRunning},
+		&job{id: "recent", state: jobDone, finishedAt: now.Add(-time.Minute)},
+		&job{id: "old", state: jobDone, finishedAt: now.Add(-time.Hour)},
+	)
+	forgetFinishedJobs(now.Add(-10 * time.Minute))
+	for id, want := range map[string]bool{"running": true, "recent": true, "old": false} {
+		if _, ok := lookupJob(id); ok != want {
+			t.Errorf("job %s kept = %v, want %v", id, ok, want)
+		}
+	}
+}
+
+func TestReapJobsStopsWithContext(t *testing.T) {
+	withJobs(t, &job{id: "old", state: jobDone, finishedAt: time.Now().Add(-time.Hour)})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		reapJobs(ctx, time.Minute, 5*time.Millisecond)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := lookupJob("old"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reapJobs did not forget the finished job")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reapJobs kept running after its context was cancelled")
+	}
+}
+
+func TestWebhookAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		prefixes, target string
+		want             bool
+	}{
+		{"", "http://web:8080/internal/runner/jobs/webhook", false},
+		{" , ", "http://web:8080/internal/runner/jobs/webhook", false},
+		{"http://web:8080/internal/", "http://web:8080/internal/runner/jobs/webhook", true},
+		{"http://other/, http://web:8080/internal/", "http://web:8080/internal/runner/jobs/webhook", true},
+		{"http://web:8080/internal/", "http://web:8080.evil/internal/", false},
+		{"http://web:8080/internal/", "http://169.254.169.254/latest/meta-data/", false},
+	} {
+		t.Setenv("RUNNER_WEBHOOK_ALLOWED_PREFIXES", tc.prefixes)
+		if got := webhookAllowed(tc.target); got != tc.want {
+			t.Errorf("prefixes %q, target %q: allowed %v, want %v", tc.prefixes, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestDeliverWebhookSignsStatus(t *testing.T) {
+	withAuth(t, testAuth())
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	j := &job{id: "j1", caller: "web", state: jobDone, webhook: srv.URL, ctx: context.Background(), resp: RunResponse{Result: "Accepted"}}
+	deliverWebhook(j)
+	r, body := <-got, <-bodies
+
+	var st jobStatus
+	if err := json.Unmarshal(body, &st); err != nil || st.ID != "j1" || st.State != jobDone || st.Result == nil || st.Result.Result != "Accepted" {
+		t.Fatalf("webhook body %s (%v)", body, err)
+	}
+	if r.Header.Get(headerCaller) != "web" {
+		t.Errorf("caller header %q", r.Header.Get(headerCaller))
+	}
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte("web-secret"))
+	io.WriteString(mac, r.Header.Get(headerTimestamp)+"\n"+hex.EncodeToString(sum[:]))
+	if want := hex.EncodeToString(mac.Sum(nil)); r.Header.Get(headerSignature) != want {
+		t.Errorf("signature %q, want %q", r.Header.Get(headerSignature), want)
+	}
+}
+
+func TestEnqueueWaitersKeepTheirDeadlines(t *testing.T) {
+	old := jobQueue
+	jobQueue = make(chan *job, 1)
+	t.Cleanup(func() { jobQueue = old })
+	jobQueue <- &job{id: "filler"}
+	t.Setenv("RUNNER_ENQUEUE_TIMEOUT_MS", "5000")
+
+	first := &job{id: "first"}
+	queued := make(chan error, 1)
+	go func() { queued <- enqueue(context.Background(), first) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// a second caller gives up on its own deadline while the first waits
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := enqueue(ctx, &job{id: "second"}); err != context.DeadlineExceeded {
+		t.Errorf("enqueue = %v, want the deadline", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("second caller waited %v", d)
+	}
+
+	<-jobQueue
+	if err := <-queued; err != nil {
+		t.Fatalf("first caller: %v", err)
+	}
+	if j := <-jobQueue; j != first {
+		t.Errorf("queued %q", j.id)
+	}
+}
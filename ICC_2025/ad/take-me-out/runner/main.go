@@ -7,26 +7,35 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexe-runner/internal/logging"
 	sandbox "goexe-runner/internal/sandbox"
+	"goexe-runner/internal/tracing"
 )
 
 // RunRequest defines the JSON request for code execution
 type RunRequest struct {
-	Language  string `json:"language"`
-	Code      string `json:"code"`
-	Input     string `json:"input"`
-	Want      string `json:"want"`
-	Challenge string `json:"challenge,omitempty"`
-	Mode      string `json:"mode,omitempty"`
-	Sandbox   string `json:"sandbox,omitempty"`
+	Language    string `json:"language"`
+	Code        string `json:"code"`
+	Input       string `json:"input"`
+	Want        string `json:"want"`
+	Challenge   string `json:"challenge,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	Sandbox     string `json:"sandbox,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 // RunResponse defines the JSON response
@@ -38,6 +47,17 @@ type RunResponse struct {
 	Expected    string `json:"expected,omitempty"`
 }
 
+// caseVerdicts are the results that point at a failing test case.
+var caseVerdicts = map[string]bool{
+	"Wrong Answer":            true,
+	"Runtime Error":           true,
+	"Time Limit Exceeded":     true,
+	"Memory Limit Exceeded":   true,
+	"Security Violation":      true,
+	"Idleness Limit Exceeded": true,
+	"Output Limit Exceeded":   true,
+}
+
 func sanitizeRunResponse(req RunRequest, resp RunResponse) RunResponse {
 	if strings.TrimSpace(req.Challenge) == "" {
 		return resp
@@ -61,13 +81,7 @@ func (s *stringSliceFlag) Set(value string) error {
 	return nil
 }
 
-type job struct {
-	req  RunRequest
-	resp chan RunResponse
-	ctx  context.Context
-}
-
-var jobQueue chan job
+var jobQueue chan *job
 
 func initWorkerPool() {
 	if jobQueue != nil {
@@ -81,28 +95,62 @@ func initWorkerPool() {
 	if queueSize <= 0 {
 		queueSize = workerCount * 4
 	}
-	jobQueue = make(chan job, queueSize)
+	poolWorkers, poolQueueSize = workerCount, queueSize
+	jobQueue = make(chan *job, queueSize)
 	for i := 0; i < workerCount; i++ {
 		go worker(jobQueue)
 	}
-	log.Printf("Runner worker pool started (workers=%d, queue=%d)", workerCount, queueSize)
+	slog.Info("runner worker pool started", "workers", workerCount, "queue", queueSize)
 }
 
-func worker(queue <-chan job) {
+func worker(queue <-chan *job) {
 	for j := range queue {
-		select {
-		case <-j.ctx.Done():
+		dequeuedJobs.Add(1)
+		if !j.start() {
 			continue
-		default:
-		}
-		resp := execute(j.req)
-		select {
-		case j.resp <- resp:
-		case <-j.ctx.Done():
 		}
+		runningJobs.Add(1)
+		start := time.Now()
+		resp := execute(withProgress(j.ctx, j.setProgress), j.req)
+		runningJobs.Add(-1)
+		slog.InfoContext(j.ctx, "job finished",
+			"job", j.id,
+			"caller", j.caller,
+			"language", j.req.Language,
+			"challenge", j.req.Challenge,
+			"mode", j.req.Mode,
+			"result", resp.Result,
+			"elapsed_ms", time.Since(start).Milliseconds())
+		metricRuns.WithLabelValues(j.req.Language, j.req.Mode, resp.Result).Inc()
+		j.finish(resp)
 	}
 }
 
+// fillRequestContext settles the ID the run is logged under (the one in the
+// body, else the X-Request-ID header, else a new one, echoed back in the
+// response) and the trace parent (the body, else the traceparent header).
+func fillRequestContext(w http.ResponseWriter, r *http.Request, req *RunRequest) {
+	if !logging.ValidRequestID(req.RequestID) {
+		req.RequestID = r.Header.Get(logging.HeaderRequestID)
+	}
+	if !logging.ValidRequestID(req.RequestID) {
+		req.RequestID = logging.NewRequestID()
+	}
+	w.Header().Set(logging.HeaderRequestID, req.RequestID)
+	if req.TraceParent == "" {
+		req.TraceParent = r.Header.Get(tracing.HeaderTraceparent)
+	}
+}
+
+// envLimit reads a resource limit from key, falling back to def unless the
+// variable holds a positive number.
+func envLimit(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
 func envInt(key string, fallback int) int {
 	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
@@ -116,30 +164,42 @@ func envInt(key string, fallback int) int {
 func runHandler(w http.ResponseWriter, r *http.Request) {
 	var req RunRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "invalid JSON body")
+		return
+	}
+	if !sandboxModeAllowed(r.Context(), req.Sandbox) {
+		writeAPIError(w, http.StatusForbidden, "sandbox_mode_forbidden", "caller may not use sandbox mode "+strconv.Quote(req.Sandbox))
 		return
 	}
+	fillRequestContext(w, r, &req)
 	if jobQueue == nil {
-		http.Error(w, "Runner not ready", http.StatusServiceUnavailable)
+		rejectRun(w, "not-ready")
+		return
+	}
+	if draining.Load() {
+		rejectRun(w, "draining")
 		return
 	}
 	ctx := r.Context()
-	respCh := make(chan RunResponse, 1)
-	j := job{req: req, resp: respCh, ctx: ctx}
+	j := newJob(ctx, callerFromContext(ctx), req)
+	defer j.cancel()
 
-	select {
-	case jobQueue <- j:
-	case <-ctx.Done():
-		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+	// Wait briefly for a queue slot, then let the web tier try another runner
+	if err := enqueue(ctx, j); err != nil {
+		if errors.Is(err, errQueueFull) {
+			rejectRun(w, "busy")
+			return
+		}
+		writeAPIError(w, http.StatusRequestTimeout, "cancelled", "request cancelled")
 		return
 	}
 
 	select {
-	case resp := <-respCh:
+	case <-j.done:
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+		json.NewEncoder(w).Encode(j.status().Result)
 	case <-ctx.Done():
-		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+		writeAPIError(w, http.StatusRequestTimeout, "cancelled", "request cancelled")
 	}
 }
 
@@ -147,43 +207,76 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 func challengeMetaHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "missing challenge name")
 		return
 	}
 	meta, ok := getChallengeMeta(name)
 	if !ok {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown challenge")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(meta)
 }
 
-// execute compiles (if needed) and runs code inside an isolated chroot sandbox
-func execute(req RunRequest) RunResponse {
-	sandboxMode := strings.TrimSpace(req.Sandbox)
-	if sandboxMode == "" {
-		sandboxMode = "default"
+// unprivilegedByDefault reports whether a run of req.Language, judge or
+// sample, uses the unprivileged sandbox when the request does not pick one.
+// RUNNER_UNPRIVILEGED_LANGUAGES lists those languages (default: all of them;
+// "none" keeps every run on the default sandbox).
+func unprivilegedByDefault(req RunRequest) bool {
+	langs, ok := os.LookupEnv("RUNNER_UNPRIVILEGED_LANGUAGES")
+	if !ok {
+		return true
+	}
+	for _, l := range strings.Split(langs, ",") {
+		if strings.EqualFold(strings.TrimSpace(l), req.Language) {
+			return true
+		}
+	}
+	return false
+}
+
+// sandboxModeFor resolves the sandbox mode req runs in. "default" (or none)
+// becomes "unprivileged" unless unprivilegedByDefault says otherwise. It
+// reports false for a mode it does not know, and for nsjail_only with go,
+// which the go helper cannot run.
+func sandboxModeFor(req RunRequest) (string, bool) {
+	mode := strings.TrimSpace(req.Sandbox)
+	switch mode {
+	case "", "default":
+		if unprivilegedByDefault(req) {
+			return "unprivileged", true
+		}
+		return "default", true
+	case "unprivileged":
+		return mode, true
+	case "nsjail_only":
+		return mode, req.Language != "go"
 	}
-	if sandboxMode != "default" && sandboxMode != "nsjail_only" {
+	return mode, false
+}
+
+// execute compiles (if needed) and runs code inside an isolated chroot sandbox.
+// Cancelling ctx (the client went away) aborts the run.
+func execute(ctx context.Context, req RunRequest) RunResponse {
+	sandboxMode, ok := sandboxModeFor(req)
+	if !ok {
 		return RunResponse{Result: "Unsupported sandbox mode"}
 	}
+	unprivileged := sandboxMode == "unprivileged"
 	if req.Language == "go" {
-		if sandboxMode != "default" {
-			return RunResponse{Result: "Unsupported sandbox mode"}
-		}
-		return executeGoViaHelper(req)
+		return executeGoViaHelper(ctx, req, unprivileged)
 	}
-	defaultUseChrootRunner := sandboxMode != "nsjail_only"
+	defaultUseChrootRunner := sandboxMode == "default"
 	if req.Language == "c" {
-		return executeCTwoStage(req, false)
+		return executeCTwoStage(ctx, req, false, unprivileged)
 	}
 	useChrootRunner := defaultUseChrootRunner
 
 	// create a per-run workdir inside shared base rootfs, then chroot
-	rr, err := sandbox.PrepareRunRoot(req.Language)
+	rr, err := sandbox.PrepareRunRootContext(ctx, req.Language, sandbox.PrepareRunRootOptions{})
 	if err != nil {
-		log.Printf("Failed to prepare sandbox: %v", err)
+		slog.ErrorContext(ctx, "failed to prepare sandbox", "language", req.Language, "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 	defer rr.Cleanup()
@@ -199,13 +292,7 @@ func execute(req RunRequest) RunResponse {
 	workspaceRel := rr.WorkspaceRel()
 	src := filepath.Join(hostWork, "code"+ext)
 	if err := ioutil.WriteFile(src, []byte(req.Code), 0644); err != nil {
-		log.Printf("Failed to write code: %v", err)
-		return RunResponse{Result: "Internal Error"}
-	}
-
-	captureDir := filepath.Join(hostWork, ".runner")
-	if err := resetDir(captureDir, 0o755); err != nil {
-		log.Printf("Failed to prepare capture dir: %v", err)
+		slog.ErrorContext(ctx, "failed to write code", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 
@@ -219,7 +306,7 @@ func execute(req RunRequest) RunResponse {
 			globalLimitMs = n
 		}
 	}
-	globalCtx, globalCancel := context.WithTimeout(context.Background(), time.Duration(globalLimitMs)*time.Millisecond)
+	globalCtx, globalCancel := context.WithTimeout(ctx, time.Duration(globalLimitMs)*time.Millisecond)
 	defer globalCancel()
 
 	// resource limits
@@ -261,7 +348,12 @@ func execute(req RunRequest) RunResponse {
 			}
 			return 512
 		}(),
-		OutputLimit: outLimit,
+		MemoryBytes:  toBytes(envLimit("RUN_LIMIT_COMPILE_MEMORY_MB", 512)),
+		Pids:         envLimit("RUN_LIMIT_COMPILE_PIDS", 128),
+		CPUPercent:   envLimit("RUN_LIMIT_COMPILE_CPU_PERCENT", 100),
+		OutputLimit:  outLimit,
+		Seccomp:      sandbox.SeccompPolicyFor(req.Language, sandbox.PhaseCompile),
+		Unprivileged: unprivileged,
 	}
 	// run limits derived from exec limit
 	execCpu := (execLimit+999)/1000 + 1
@@ -300,7 +392,14 @@ func execute(req RunRequest) RunResponse {
 			}
 			return 128
 		}(),
-		OutputLimit: outLimit,
+		MemoryBytes:   toBytes(envLimit("RUN_LIMIT_MEMORY_MB", 256)),
+		Pids:          envLimit("RUN_LIMIT_PIDS", 64),
+		CPUPercent:    envLimit("RUN_LIMIT_CPU_PERCENT", 100),
+		ScratchBytes:  toBytes(envLimit("RUN_LIMIT_SCRATCH_MB", 64)),
+		ScratchInodes: envLimit("RUN_LIMIT_SCRATCH_INODES", 1024),
+		OutputLimit:   outLimit,
+		Seccomp:       sandbox.SeccompPolicyFor(req.Language, sandbox.PhaseRun),
+		Unprivileged:  unprivileged,
 	}
 	insidePath := func(path string) string {
 		if strings.HasPrefix(path, hostWork) {
@@ -339,27 +438,22 @@ func execute(req RunRequest) RunResponse {
 	switch req.Language {
 	case "c":
 		gccPath := mapToolPath("/usr/bin/gcc")
-		compileStdoutHost, compileStderrHost, compileStdoutInside, compileStderrInside := capturePaths(hostWork, workdir, "compile")
-		removeFiles(compileStdoutHost, compileStderrHost)
 		compileArgs := []string{
 			gccPath,
 			filepath.Join(workdir, "code.c"),
 			"-O2", "-pipe", "-static", "-s", "-lm",
 			"-o", filepath.Join(workdir, "code"),
 		}
-		compileCmd := buildCaptureCommand(compileArgs, compileStdoutInside, compileStderrInside)
+		compileCmd := buildRunCommand(compileArgs)
+		compileStart := time.Now()
+		_, compileSpan := tracer.Start(globalCtx, "compile", trace.WithAttributes(attribute.String("language", req.Language)))
 		compileRes, err := sandbox.RunInChroot(globalCtx, rr, workdir, []string{shellPath, "-c", compileCmd}, "", comp, useChrootRunner)
-		compileStdout, stdoutErr := readFileLimited(compileStdoutHost, outLimit)
-		if stdoutErr != nil {
-			log.Printf("Failed to read compile stdout: %v", stdoutErr)
-		}
-		compileStderr, stderrErr := readFileLimited(compileStderrHost, outLimit)
-		if stderrErr != nil {
-			log.Printf("Failed to read compile stderr: %v", stderrErr)
-		}
-		summary := combineOutput(compileStdout, compileStderr)
-		if summary == "" {
-			summary = combineOutput(compileRes.Stdout, compileRes.Stderr)
+		tracing.RecordError(compileSpan, err)
+		compileSpan.End()
+		metricCompileSeconds.WithLabelValues(req.Language).Observe(time.Since(compileStart).Seconds())
+		summary := sandbox.MarkTruncated(combineOutput(compileRes.Stdout, compileRes.Stderr), compileRes.OutputExceeded || compileRes.StderrTruncated)
+		if compileRes.SeccompViolation {
+			return securityViolation(ctx, req, compileRes)
 		}
 		if err != nil {
 			if summary == "" {
@@ -367,14 +461,8 @@ func execute(req RunRequest) RunResponse {
 			}
 			return RunResponse{Result: "Compile Error", Output: summary}
 		}
-		removeFiles(compileStdoutHost, compileStderrHost)
 		// Ensure executable for nobody
 		_ = os.Chmod(filepath.Join(hostWork, "code"), 0755)
-		// Grant chroot capability to compiled binary (setcap outside chroot)
-		if out2, err2 := sandbox.RunOnHost(globalCtx, "", []string{"/usr/sbin/setcap", "cap_sys_chroot+ep", filepath.Join(hostWork, "code")}, "", comp); err2 != nil {
-			log.Printf("setcap failed: %v, output: %s", err2, out2)
-			return RunResponse{Result: "Internal Error"}
-		}
 	}
 
 	// build argv
@@ -397,10 +485,38 @@ func execute(req RunRequest) RunResponse {
 	return runProgramWithTests(req, rr, hostWork, workdir, useChrootRunner, shellPath, argv, runLim, outLimit, execLimit, globalCtx)
 }
 
-func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
-	buildRR, err := sandbox.PrepareRunRootWithOptions("c", sandbox.PrepareRunRootOptions{FlagDestinations: []string{"/flag2", "/env/flag2"}})
+// Runroot options of the two C stages; pooled runroots are kept per options.
+var (
+	cBuildRunRootOptions = sandbox.PrepareRunRootOptions{FlagDestinations: []string{"/flag2", "/env/flag2"}}
+	cRunRootOptions      = sandbox.PrepareRunRootOptions{ForCBuilder: true}
+)
+
+// warmRunRootPool fills the runroot pool for every installed language. Go
+// jobs build their runroots in go-helper processes, which do not pool.
+func warmRunRootPool() {
+	warm := []struct {
+		language string
+		opts     sandbox.PrepareRunRootOptions
+	}{
+		{"python", sandbox.PrepareRunRootOptions{}},
+		{"ruby", sandbox.PrepareRunRootOptions{}},
+		{"c", cBuildRunRootOptions},
+		{"c", cRunRootOptions},
+	}
+	for _, w := range warm {
+		if sandbox.CheckEnvironment(w.language) != nil {
+			continue
+		}
+		if err := sandbox.WarmRunRootPool(w.language, w.opts); err != nil {
+			slog.Warn("failed to warm runroot pool", "language", w.language, "err", err)
+		}
+	}
+}
+
+func executeCTwoStage(ctx context.Context, req RunRequest, useChrootRunner, unprivileged bool) RunResponse {
+	buildRR, err := sandbox.PrepareRunRootContext(ctx, "c", cBuildRunRootOptions)
 	if err != nil {
-		log.Printf("Failed to prepare C compile sandbox: %v", err)
+		slog.ErrorContext(ctx, "failed to prepare C compile sandbox", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 	defer buildRR.Cleanup()
@@ -409,12 +525,7 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 	buildEnvWorkspaceInside := filepath.Join("/env", strings.TrimPrefix(buildRR.WorkspaceRel(), "/"))
 	src := filepath.Join(buildHostWork, "code.c")
 	if err := ioutil.WriteFile(src, []byte(req.Code), 0644); err != nil {
-		log.Printf("Failed to write C source: %v", err)
-		return RunResponse{Result: "Internal Error"}
-	}
-	buildCaptureDir := filepath.Join(buildHostWork, ".runner")
-	if err := resetDir(buildCaptureDir, 0o755); err != nil {
-		log.Printf("Failed to prepare C compile capture dir: %v", err)
+		slog.ErrorContext(ctx, "failed to write C source", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 
@@ -425,7 +536,7 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 			globalLimitMs = n
 		}
 	}
-	globalCtx, globalCancel := context.WithTimeout(context.Background(), time.Duration(globalLimitMs)*time.Millisecond)
+	globalCtx, globalCancel := context.WithTimeout(ctx, time.Duration(globalLimitMs)*time.Millisecond)
 	defer globalCancel()
 
 	toBytes := func(mb int) int { return mb * 1024 * 1024 }
@@ -466,7 +577,12 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 			}
 			return 512
 		}(),
-		OutputLimit: outLimit,
+		MemoryBytes:  toBytes(envLimit("RUN_LIMIT_COMPILE_MEMORY_MB", 512)),
+		Pids:         envLimit("RUN_LIMIT_COMPILE_PIDS", 128),
+		CPUPercent:   envLimit("RUN_LIMIT_COMPILE_CPU_PERCENT", 100),
+		OutputLimit:  outLimit,
+		Seccomp:      sandbox.SeccompPolicyFor(req.Language, sandbox.PhaseCompile),
+		Unprivileged: unprivileged,
 	}
 	execCpu := (execLimit+999)/1000 + 1
 	if globalLimitMs/1000 > 0 && execCpu > (globalLimitMs/1000) {
@@ -503,7 +619,14 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 			}
 			return 128
 		}(),
-		OutputLimit: outLimit,
+		MemoryBytes:   toBytes(envLimit("RUN_LIMIT_MEMORY_MB", 256)),
+		Pids:          envLimit("RUN_LIMIT_PIDS", 64),
+		CPUPercent:    envLimit("RUN_LIMIT_CPU_PERCENT", 100),
+		ScratchBytes:  toBytes(envLimit("RUN_LIMIT_SCRATCH_MB", 64)),
+		ScratchInodes: envLimit("RUN_LIMIT_SCRATCH_INODES", 1024),
+		OutputLimit:   outLimit,
+		Seccomp:       sandbox.SeccompPolicyFor(req.Language, sandbox.PhaseRun),
+		Unprivileged:  unprivileged,
 	}
 
 	compileUseChrootRunner := false
@@ -521,28 +644,22 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 		compileShellPath = "/env/bin/sh"
 	}
 
-	compileStdoutHost, compileStderrHost, compileStdoutInside, compileStderrInside := capturePaths(buildHostWork, buildEnvWorkspaceInside, "compile")
-	removeFiles(compileStdoutHost, compileStderrHost)
 	compileArgs := []string{
 		mapToolPath("/usr/bin/gcc"),
 		filepath.Join(buildEnvWorkspaceInside, "code.c"),
 		"-O2", "-pipe", "-static", "-s", "-lm",
 		"-o", filepath.Join(buildEnvWorkspaceInside, "code"),
 	}
-	compileCmd := buildCaptureCommand(compileArgs, compileStdoutInside, compileStderrInside)
+	compileCmd := buildRunCommand(compileArgs)
+	compileStart := time.Now()
+	_, compileSpan := tracer.Start(globalCtx, "compile", trace.WithAttributes(attribute.String("language", req.Language)))
 	compileRes, compileErr := sandbox.RunInChroot(globalCtx, buildRR, buildEnvWorkspaceInside, []string{compileShellPath, "-c", compileCmd}, "", comp, compileUseChrootRunner)
-	compileStdout, stdoutErr := readFileLimited(compileStdoutHost, outLimit)
-	if stdoutErr != nil {
-		log.Printf("Failed to read C compile stdout: %v", stdoutErr)
-	}
-	compileStderr, stderrErr := readFileLimited(compileStderrHost, outLimit)
-	if stderrErr != nil {
-		log.Printf("Failed to read C compile stderr: %v", stderrErr)
-	}
-	removeFiles(compileStdoutHost, compileStderrHost)
-	summary := combineOutput(compileStdout, compileStderr)
-	if summary == "" {
-		summary = combineOutput(compileRes.Stdout, compileRes.Stderr)
+	tracing.RecordError(compileSpan, compileErr)
+	compileSpan.End()
+	metricCompileSeconds.WithLabelValues(req.Language).Observe(time.Since(compileStart).Seconds())
+	summary := sandbox.MarkTruncated(combineOutput(compileRes.Stdout, compileRes.Stderr), compileRes.OutputExceeded || compileRes.StderrTruncated)
+	if compileRes.SeccompViolation {
+		return securityViolation(ctx, req, compileRes)
 	}
 	if compileErr != nil {
 		if summary == "" {
@@ -552,9 +669,9 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 	}
 	_ = os.Chmod(filepath.Join(buildHostWork, "code"), 0755)
 
-	runRR, err := sandbox.PrepareRunRootWithOptions("c", sandbox.PrepareRunRootOptions{ForCBuilder: true})
+	runRR, err := sandbox.PrepareRunRootContext(ctx, "c", cRunRootOptions)
 	if err != nil {
-		log.Printf("Failed to prepare C run sandbox: %v", err)
+		slog.ErrorContext(ctx, "failed to prepare C run sandbox", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 	defer runRR.Cleanup()
@@ -562,22 +679,13 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 	runHostWork := runRR.WorkspaceHost
 	runWorkdir := runRR.WorkspaceDir()
 	runWorkspaceRel := runRR.WorkspaceRel()
-	runCaptureDir := filepath.Join(runHostWork, ".runner")
-	if err := resetDir(runCaptureDir, 0o755); err != nil {
-		log.Printf("Failed to prepare C run capture dir: %v", err)
-		return RunResponse{Result: "Internal Error"}
-	}
 	runtimeBinaryHost := filepath.Join(runHostWork, "code")
 	if err := sandbox.CopyFile(filepath.Join(buildHostWork, "code"), runtimeBinaryHost, 0o755); err != nil {
-		log.Printf("Failed to copy C binary into runtime sandbox: %v", err)
+		slog.ErrorContext(ctx, "failed to copy C binary into runtime sandbox", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 	if err := os.Chmod(runtimeBinaryHost, 0755); err != nil {
-		log.Printf("Failed to chmod C runtime binary: %v", err)
-		return RunResponse{Result: "Internal Error"}
-	}
-	if out2, err2 := sandbox.RunOnHost(globalCtx, "", []string{"/usr/sbin/setcap", "cap_sys_chroot+ep", runtimeBinaryHost}, "", comp); err2 != nil {
-		log.Printf("setcap failed for C runtime binary: %v, output: %s", err2, out2)
+		slog.ErrorContext(ctx, "failed to chmod C runtime binary", "err", err)
 		return RunResponse{Result: "Internal Error"}
 	}
 
@@ -609,6 +717,7 @@ func executeCTwoStage(req RunRequest, useChrootRunner bool) RunResponse {
 }
 
 func runProgramWithTests(req RunRequest, rr *sandbox.RunRoot, hostWork, workdir string, useChrootRunner bool, shellPath string, argv []string, runLim sandbox.RLimits, outLimit int, execLimit int, globalCtx context.Context) RunResponse {
+	limits := sandbox.TimeLimitsFor(execLimit)
 	if strings.TrimSpace(req.Challenge) != "" {
 		tests := getRunnerTests(req.Challenge, req.Mode)
 		if len(tests) == 0 {
@@ -616,46 +725,56 @@ func runProgramWithTests(req RunRequest, rr *sandbox.RunRoot, hostWork, workdir
 		}
 		total := 0
 		for i, tc := range tests {
-			execCtx, execCancel := context.WithTimeout(globalCtx, time.Duration(execLimit)*time.Millisecond)
+			reportProgress(globalCtx, i, len(tests))
+			execCtx, execCancel := context.WithTimeout(globalCtx, limits.Deadline())
 			start := time.Now()
-			stdoutHost, stderrHost, stdoutInside, stderrInside := capturePaths(hostWork, workdir, fmt.Sprintf("test-%d", i))
-			removeFiles(stdoutHost, stderrHost)
-			runCmd := buildCaptureCommand(argv, stdoutInside, stderrInside)
-			runRes, err := sandbox.RunInChroot(execCtx, rr, workdir, []string{shellPath, "-c", runCmd}, tc.Input, runLim, useChrootRunner)
-			dur := int(time.Since(start).Milliseconds())
+			runCmd := buildRunCommand(argv)
+			matcher := sandbox.NewOutputMatcher(tc.Output)
+			_, testSpan := tracer.Start(globalCtx, "test", trace.WithAttributes(attribute.String("language", req.Language), attribute.Int("test.index", i)))
+			runRes, err := sandbox.RunInChrootStream(execCtx, rr, workdir, []string{shellPath, "-c", runCmd}, tc.Input, runLim, useChrootRunner, matcher)
+			tracing.RecordError(testSpan, err)
+			testSpan.End()
+			runRes.TrimSetup()
+			dur := int(runRes.ProgramTime().Milliseconds())
+			metricTestRunSeconds.WithLabelValues(req.Language).Observe(time.Since(start).Seconds())
+			if runRes.MemoryPeakBytes > 0 {
+				metricTestMemoryPeakBytes.WithLabelValues(req.Language).Observe(float64(runRes.MemoryPeakBytes))
+			}
 			execCancel()
 			total += dur
-			runStdout, outErr := readFileLimited(stdoutHost, outLimit)
-			if outErr != nil {
-				log.Printf("Failed to read run stdout: %v", outErr)
-			}
-			runStderr, errErr := readFileLimited(stderrHost, outLimit)
-			if errErr != nil {
-				log.Printf("Failed to read run stderr: %v", errErr)
-			}
-			removeFiles(stdoutHost, stderrHost)
-			combined := combineOutput(runStdout, runStderr)
-			if execCtx.Err() == context.DeadlineExceeded || globalCtx.Err() == context.DeadlineExceeded {
-				if combined == "" {
-					combined = strings.TrimSpace(runRes.Stdout)
+			combined := sandbox.MarkTruncated(combineOutput(runRes.Stdout, runRes.Stderr), runRes.OutputExceeded || runRes.StderrTruncated)
+			if verdict := timeVerdict(limits, runRes, execCtx, globalCtx); verdict != "" {
+				if req.Mode == "sample" {
+					return sanitizeRunResponse(req, RunResponse{Result: verdict, Output: combined, DurationMs: total, FailedIndex: i, Expected: tc.Output})
 				}
+				return sanitizeRunResponse(req, RunResponse{Result: verdict, Output: combined, DurationMs: total, FailedIndex: i})
+			}
+			if runRes.OOMKilled {
 				if req.Mode == "sample" {
-					return sanitizeRunResponse(req, RunResponse{Result: "Time Limit Exceeded", Output: combined, DurationMs: total, FailedIndex: i, Expected: tc.Output})
+					return sanitizeRunResponse(req, RunResponse{Result: "Memory Limit Exceeded", Output: combined, DurationMs: total, FailedIndex: i, Expected: tc.Output})
 				}
-				return sanitizeRunResponse(req, RunResponse{Result: "Time Limit Exceeded", Output: combined, DurationMs: total, FailedIndex: i})
+				return sanitizeRunResponse(req, RunResponse{Result: "Memory Limit Exceeded", Output: combined, DurationMs: total, FailedIndex: i})
 			}
-			trimmed := strings.TrimSpace(runStdout)
-			if err != nil {
-				if combined == "" {
-					combined = combineOutput(runRes.Stdout, runRes.Stderr)
+			if runRes.ScratchExceeded || runRes.OutputExceeded {
+				if req.Mode == "sample" {
+					return sanitizeRunResponse(req, RunResponse{Result: "Output Limit Exceeded", Output: combined, DurationMs: total, FailedIndex: i, Expected: tc.Output})
 				}
-				log.Printf("Runtime error: %v, output: %s", err, combined)
+				return sanitizeRunResponse(req, RunResponse{Result: "Output Limit Exceeded", Output: combined, DurationMs: total, FailedIndex: i})
+			}
+			if runRes.SeccompViolation {
+				resp := securityViolation(globalCtx, req, runRes)
+				resp.DurationMs, resp.FailedIndex = total, i
+				return sanitizeRunResponse(req, resp)
+			}
+			if err != nil {
+				slog.DebugContext(globalCtx, "runtime error", "test", i, "err", err, logging.Output("output", combined))
 				if req.Mode == "sample" {
 					return sanitizeRunResponse(req, RunResponse{Result: "Runtime Error", Output: combined, DurationMs: total, FailedIndex: i, Expected: tc.Output})
 				}
 				return sanitizeRunResponse(req, RunResponse{Result: "Runtime Error", Output: combined, DurationMs: total, FailedIndex: i})
 			}
-			if strings.TrimSpace(trimmed) != strings.TrimSpace(tc.Output) {
+			if !matcher.Matched() {
+				trimmed := strings.TrimSpace(runRes.Stdout)
 				if req.Mode == "sample" {
 					return sanitizeRunResponse(req, RunResponse{Result: "Wrong Answer", Output: trimmed, DurationMs: total, FailedIndex: i, Expected: tc.Output})
 				}
@@ -663,47 +782,50 @@ func runProgramWithTests(req RunRequest, rr *sandbox.RunRoot, hostWork, workdir
 			}
 			_ = sandbox.ResetChrootTmp(rr)
 		}
+		reportProgress(globalCtx, len(tests), len(tests))
 		return sanitizeRunResponse(req, RunResponse{Result: "Success", Output: "", DurationMs: total})
 	}
 
-	execCtx, execCancel := context.WithTimeout(globalCtx, time.Duration(execLimit)*time.Millisecond)
+	execCtx, execCancel := context.WithTimeout(globalCtx, limits.Deadline())
 	defer execCancel()
 	start := time.Now()
-	stdoutHost, stderrHost, stdoutInside, stderrInside := capturePaths(hostWork, workdir, "single")
-	removeFiles(stdoutHost, stderrHost)
-	runCmd := buildCaptureCommand(argv, stdoutInside, stderrInside)
-	runRes, execErr := sandbox.RunInChroot(execCtx, rr, workdir, []string{shellPath, "-c", runCmd}, req.Input, runLim, useChrootRunner)
-	durationMs := int(time.Since(start).Milliseconds())
-	runStdout, outErr := readFileLimited(stdoutHost, outLimit)
-	if outErr != nil {
-		log.Printf("Failed to read run stdout: %v", outErr)
-	}
-	runStderr, errErr := readFileLimited(stderrHost, outLimit)
-	if errErr != nil {
-		log.Printf("Failed to read run stderr: %v", errErr)
-	}
-	removeFiles(stdoutHost, stderrHost)
-	combined := combineOutput(runStdout, runStderr)
-	if execCtx.Err() == context.DeadlineExceeded || globalCtx.Err() == context.DeadlineExceeded {
-		if combined == "" {
-			combined = strings.TrimSpace(runRes.Stdout)
-		}
-		return sanitizeRunResponse(req, RunResponse{Result: "Time Limit Exceeded", Output: combined, DurationMs: durationMs})
-	}
-	output := strings.TrimSpace(runStdout)
+	runCmd := buildRunCommand(argv)
+	matcher := sandbox.NewOutputMatcher(req.Want)
+	_, testSpan := tracer.Start(globalCtx, "test", trace.WithAttributes(attribute.String("language", req.Language)))
+	runRes, execErr := sandbox.RunInChrootStream(execCtx, rr, workdir, []string{shellPath, "-c", runCmd}, req.Input, runLim, useChrootRunner, matcher)
+	tracing.RecordError(testSpan, execErr)
+	testSpan.End()
+	runRes.TrimSetup()
+	durationMs := int(runRes.ProgramTime().Milliseconds())
+	metricTestRunSeconds.WithLabelValues(req.Language).Observe(time.Since(start).Seconds())
+	if runRes.MemoryPeakBytes > 0 {
+		metricTestMemoryPeakBytes.WithLabelValues(req.Language).Observe(float64(runRes.MemoryPeakBytes))
+	}
+	combined := sandbox.MarkTruncated(combineOutput(runRes.Stdout, runRes.Stderr), runRes.OutputExceeded || runRes.StderrTruncated)
+	if verdict := timeVerdict(limits, runRes, execCtx, globalCtx); verdict != "" {
+		return sanitizeRunResponse(req, RunResponse{Result: verdict, Output: combined, DurationMs: durationMs})
+	}
+	output := strings.TrimSpace(runRes.Stdout)
+	if runRes.OOMKilled {
+		return sanitizeRunResponse(req, RunResponse{Result: "Memory Limit Exceeded", Output: combined, DurationMs: durationMs})
+	}
+	if runRes.ScratchExceeded || runRes.OutputExceeded {
+		return sanitizeRunResponse(req, RunResponse{Result: "Output Limit Exceeded", Output: combined, DurationMs: durationMs})
+	}
+	if runRes.SeccompViolation {
+		resp := securityViolation(globalCtx, req, runRes)
+		resp.DurationMs = durationMs
+		return sanitizeRunResponse(req, resp)
+	}
 	if execErr != nil {
-		if combined == "" {
-			combined = combineOutput(runRes.Stdout, runRes.Stderr)
-		}
-		log.Printf("Runtime error: %v, output: %s", execErr, combined)
+		slog.DebugContext(globalCtx, "runtime error", "err", execErr, logging.Output("output", combined))
 		if req.Mode == "sample" {
 			return sanitizeRunResponse(req, RunResponse{Result: "Runtime Error", Output: combined, DurationMs: durationMs})
 		}
 		return sanitizeRunResponse(req, RunResponse{Result: "Runtime Error", Output: combined, DurationMs: durationMs})
 	}
-	wantTrim := strings.TrimSpace(req.Want)
-	if wantTrim != "" {
-		if strings.TrimSpace(output) == wantTrim {
+	if strings.TrimSpace(req.Want) != "" {
+		if matcher.Matched() {
 			return sanitizeRunResponse(req, RunResponse{Result: "Success", Output: output, DurationMs: durationMs})
 		}
 		return sanitizeRunResponse(req, RunResponse{Result: "Wrong Answer", Output: output, DurationMs: durationMs})
@@ -711,7 +833,31 @@ func runProgramWithTests(req RunRequest, rr *sandbox.RunRoot, hostWork, workdir
 	return sanitizeRunResponse(req, RunResponse{Result: "Success", Output: output, DurationMs: durationMs})
 }
 
-func runSandboxShell(lang, command string, args []string, workdir string, keep bool) error {
+// timeVerdict names the verdict for a run that overran its time limits, or
+// returns "" if it did not. Running out of the global budget counts against
+// the time limit.
+func timeVerdict(limits sandbox.TimeLimits, res sandbox.RunResult, execCtx, globalCtx context.Context) string {
+	if globalCtx.Err() == context.DeadlineExceeded {
+		return "Time Limit Exceeded"
+	}
+	switch limits.Check(res, execCtx.Err() == context.DeadlineExceeded) {
+	case sandbox.CPULimitExceeded:
+		return "Time Limit Exceeded"
+	case sandbox.WallLimitExceeded:
+		return "Idleness Limit Exceeded"
+	}
+	return ""
+}
+
+// securityViolation is the verdict for a run the seccomp policy stopped;
+// the output names the blocked system call.
+func securityViolation(ctx context.Context, req RunRequest, res sandbox.RunResult) RunResponse {
+	metricSecurityViolations.WithLabelValues(req.Language, res.Syscall).Inc()
+	slog.WarnContext(ctx, "seccomp policy killed submission", "language", req.Language, "syscall", res.Syscall)
+	return RunResponse{Result: "Security Violation", Output: "blocked system call: " + res.Syscall, FailedIndex: -1}
+}
+
+func runSandboxShell(lang, command string, args []string, workdir string, keep, dumpConfig bool) error {
 	lang = strings.TrimSpace(lang)
 	if lang == "" {
 		return errors.New("language is required")
@@ -724,54 +870,39 @@ func runSandboxShell(lang, command string, args []string, workdir string, keep b
 		return err
 	}
 	if keep {
-		log.Printf("sandbox shell: keeping runroot at %s", rr.Root)
+		slog.Info("sandbox shell: keeping runroot", "root", rr.Root)
 	} else {
 		defer rr.Cleanup()
 	}
 	if workdir == "" {
 		workdir = rr.WorkspaceDir()
 	}
-	log.Printf("sandbox shell: language=%s workdir=%s host_work=%s", lang, workdir, rr.WorkspaceHost)
 	argv := append([]string{command}, args...)
+	if dumpConfig {
+		cfg, err := sandbox.InteractiveNsjailConfig(rr, workdir, argv)
+		if err != nil {
+			return err
+		}
+		fmt.Print(cfg.String())
+		return nil
+	}
+	slog.Info("sandbox shell", "language", lang, "workdir", workdir, "host_work", rr.WorkspaceHost)
 	if err := sandbox.LaunchInteractive(rr, workdir, argv); err != nil {
 		return err
 	}
 	if keep {
-		log.Printf("sandbox shell: runroot retained at %s", rr.Root)
+		slog.Info("sandbox shell: runroot retained", "root", rr.Root)
 	}
 	return nil
 }
 
-func capturePaths(hostWork, workdir, base string) (stdoutHost, stderrHost, stdoutInside, stderrInside string) {
-	stdoutHost = filepath.Join(hostWork, ".runner", base+".stdout")
-	stderrHost = filepath.Join(hostWork, ".runner", base+".stderr")
-	stdoutInside = filepath.Join(workdir, ".runner", base+".stdout")
-	stderrInside = filepath.Join(workdir, ".runner", base+".stderr")
-	return
-}
-
-func removeFiles(paths ...string) {
-	for _, p := range paths {
-		if p == "" {
-			continue
-		}
-		_ = os.Remove(p)
-	}
-}
-
-func buildCaptureCommand(argv []string, stdoutPath, stderrPath string) string {
-	var sb strings.Builder
-	sb.WriteString("rm -f ")
-	sb.WriteString(shellQuote(stdoutPath))
-	sb.WriteByte(' ')
-	sb.WriteString(shellQuote(stderrPath))
-	sb.WriteString(" && exec ")
-	sb.WriteString(joinShellArgs(argv))
-	sb.WriteString(" > ")
-	sb.WriteString(shellQuote(stdoutPath))
-	sb.WriteString(" 2> ")
-	sb.WriteString(shellQuote(stderrPath))
-	return sb.String()
+// buildRunCommand runs argv between two reports on sandbox.StatusFD: its
+// start, for RunResult.TrimSetup, and once it exited how full the scratch
+// tmpfs are. The program does not inherit the descriptor, and its exit
+// status is the command's.
+func buildRunCommand(argv []string) string {
+	return fmt.Sprintf("echo %s >&%d && { %s %d>&-; rc=$?; %s >&%d; exit $rc; }",
+		sandbox.StatusStart, sandbox.StatusFD, joinShellArgs(argv), sandbox.StatusFD, sandbox.ScratchReport, sandbox.StatusFD)
 }
 
 func joinShellArgs(argv []string) string {
@@ -792,30 +923,6 @@ func shellQuote(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
 }
 
-func readFileLimited(path string, limit int) (string, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "", nil
-		}
-		return "", err
-	}
-	if limit > 0 && len(data) > limit {
-		data = data[:limit]
-	}
-	return string(data), nil
-}
-
-func resetDir(path string, perm os.FileMode) error {
-	if err := os.RemoveAll(path); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	if err := os.MkdirAll(path, perm); err != nil {
-		return err
-	}
-	return os.Chmod(path, perm)
-}
-
 func combineOutput(stdout, stderr string) string {
 	s := strings.TrimSpace(stdout)
 	t := strings.TrimSpace(stderr)
@@ -830,6 +937,8 @@ func combineOutput(stdout, stderr string) string {
 
 func main() {
 	loadDotEnv()
+	logging.Setup("runner")
+	shutdownTracing := tracing.Setup("runner")
 
 	var shellArgsFlag stringSliceFlag
 	shellMode := flag.Bool("sandbox-shell", false, "launch an interactive sandbox shell and exit")
@@ -837,32 +946,115 @@ func main() {
 	shellCmd := flag.String("sandbox-shell-cmd", "/work/bin/sh", "command to execute inside the sandbox shell")
 	shellWorkdir := flag.String("sandbox-shell-workdir", "", "working directory inside the sandbox root")
 	shellKeep := flag.Bool("sandbox-shell-keep", false, "retain sandbox runroot after the shell exits")
+	shellDumpConfig := flag.Bool("sandbox-shell-dump-config", false, "print the sandbox shell's nsjail config instead of launching it")
 	flag.Var(&shellArgsFlag, "sandbox-shell-arg", "additional argument for the sandbox shell command (repeatable)")
 	flag.Parse()
 
-	if *shellMode || *shellLang != "" {
+	if *shellMode || *shellLang != "" || *shellDumpConfig {
 		lang := strings.TrimSpace(*shellLang)
 		if lang == "" {
 			lang = "base"
 		}
 		args := append([]string{}, []string(shellArgsFlag)...)
-		if err := runSandboxShell(lang, *shellCmd, args, *shellWorkdir, *shellKeep); err != nil {
-			log.Fatalf("sandbox shell failed: %v", err)
+		if err := runSandboxShell(lang, *shellCmd, args, *shellWorkdir, *shellKeep, *shellDumpConfig); err != nil {
+			slog.Error("sandbox shell failed", "err", err)
+			os.Exit(1)
 		}
 		return
 	}
 
 	if flag.NArg() > 0 {
-		log.Fatalf("unexpected arguments: %v", flag.Args())
+		slog.Error("unexpected arguments", "args", flag.Args())
+		os.Exit(1)
 	}
 
+	if err := initRunnerAuth(); err != nil {
+		slog.Error("runner auth init failed", "err", err)
+		os.Exit(1)
+	}
+	if err := sandbox.InitBackend(); err != nil {
+		slog.Error("sandbox backend init failed", "err", err)
+		os.Exit(1)
+	}
+	// Set up the per-run cgroups before the runner starts other processes
+	sandbox.CgroupsAvailable()
 	initRunnerDB()
 	seedInitialChallenges()
 	initWorkerPool()
-	http.HandleFunc("/run", runHandler)
-	http.HandleFunc("/challenge", challengeMetaHandler)
-	log.Println("Runner listening on :9000")
-	log.Fatal(http.ListenAndServe(":9000", nil))
+	sandbox.EnableRunRootPool(sandbox.RunRootPoolConfigFromEnv())
+	go warmRunRootPool()
+	http.HandleFunc("/run", requireSigned(runHandler))
+	http.HandleFunc("/challenge", requireSigned(challengeMetaHandler))
+	http.HandleFunc("/capacity", requireSigned(capacityHandler))
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("GET /healthz", healthzHandler)
+	http.HandleFunc("GET /readyz", readyzHandler)
+	http.HandleFunc("POST /jobs", requireSigned(createJobHandler))
+	http.HandleFunc("GET /jobs/{id}", requireSigned(getJobHandler))
+	http.HandleFunc("DELETE /jobs/{id}", requireSigned(deleteJobHandler))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go reapJobs(ctx, time.Duration(envInt("RUNNER_JOB_RETENTION_SECONDS", 600))*time.Second, time.Minute)
+	regCtx, stopRegistration := context.WithCancel(context.Background())
+	go runRegistration(regCtx)
+
+	// /run answers only once the job is done, so the write timeout has to
+	// cover a whole synchronous run
+	srv := &http.Server{
+		Addr:              ":9000",
+		ReadHeaderTimeout: time.Duration(envInt("RUNNER_HTTP_READ_HEADER_TIMEOUT_SECONDS", 10)) * time.Second,
+		ReadTimeout:       time.Duration(envInt("RUNNER_HTTP_READ_TIMEOUT_SECONDS", 30)) * time.Second,
+		WriteTimeout:      time.Duration(envInt("RUNNER_HTTP_WRITE_TIMEOUT_SECONDS", 180)) * time.Second,
+		IdleTimeout:       time.Duration(envInt("RUNNER_HTTP_IDLE_TIMEOUT_SECONDS", 120)) * time.Second,
+	}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		// Drain: refuse new jobs, tell the web tier, then wait for running ones
+		slog.Info("runner draining")
+		draining.Store(true)
+		publishCapacity()
+		drainTimeout := time.Duration(envInt("RUNNER_DRAIN_TIMEOUT_SECONDS", 60)) * time.Second
+		drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		// keep serving status polls until queued and running jobs finish
+		waitForIdle(drainCtx)
+		if drainCtx.Err() != nil {
+			// Past the deadline: cancel what is left so callers requeue it,
+			// and give the workers a moment to clean up their runroots
+			n := abortUnfinishedJobs()
+			slog.Warn("runner drain deadline passed, cancelling unfinished jobs", "jobs", n, "timeout", drainTimeout)
+			graceCtx, cancelGrace := context.WithTimeout(context.Background(), 10*time.Second)
+			waitForIdle(graceCtx)
+			cancelGrace()
+		}
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			// synchronous /run requests still open: closing their
+			// connections cancels their jobs and the web tier retries them
+			slog.Warn("runner shutdown", "err", err)
+			srv.Close()
+			closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+			waitForIdle(closeCtx)
+			cancelClose()
+		}
+	}()
+	slog.Info("runner listening", "addr", ":9000")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("runner server failed", "err", err)
+		os.Exit(1)
+	}
+	<-drained
+	sandbox.DrainRunRootPool()
+	stopRegistration()
+	unregisterRunner()
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownTracing(flushCtx)
+	cancelFlush()
+	slog.Info("runner stopped")
 }
 
 // resetChrootTmp clears and recreates /tmp inside a runroot with sticky bit
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"goexe-runner/internal/sandbox"
+)
+
+func TestSandboxModeFor(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		langs     *string
+		req       RunRequest
+		want      string
+		supported bool
+	}{
+		{"judge run", nil, RunRequest{Language: "c", Mode: "judge"}, "unprivileged", true},
+		{"sample run", nil, RunRequest{Language: "python", Mode: "sample"}, "unprivileged", true},
+		{"no mode", nil, RunRequest{Language: "ruby"}, "unprivileged", true},
+		{"explicit default", nil, RunRequest{Language: "c", Sandbox: "default"}, "unprivileged", true},
+		{"language listed", ptr("c, Python"), RunRequest{Language: "python", Mode: "sample"}, "unprivileged", true},
+		{"language not listed", ptr("c"), RunRequest{Language: "python", Mode: "judge"}, "default", true},
+		{"none listed", ptr("none"), RunRequest{Language: "c", Mode: "judge"}, "default", true},
+		{"asks for unprivileged", ptr("none"), RunRequest{Language: "c", Sandbox: "unprivileged"}, "unprivileged", true},
+		{"nsjail_only", nil, RunRequest{Language: "c", Sandbox: "nsjail_only"}, "nsjail_only", true},
+		{"nsjail_only for go", nil, RunRequest{Language: "go", Sandbox: "nsjail_only"}, "nsjail_only", false},
+		{"unknown mode", nil, RunRequest{Language: "c", Sandbox: "host"}, "host", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.langs != nil {
+				t.Setenv("RUNNER_UNPRIVILEGED_LANGUAGES", *tc.langs)
+			} else {
+				t.Setenv("RUNNER_UNPRIVILEGED_LANGUAGES", "")
+				os.Unsetenv("RUNNER_UNPRIVILEGED_LANGUAGES")
+			}
+			mode, ok := sandboxModeFor(tc.req)
+			if mode != tc.want || ok != tc.supported {
+				t.Errorf("sandboxModeFor = %q, %v, want %q, %v", mode, ok, tc.want, tc.supported)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+func TestBuildRunCommand(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cmd := exec.Command("sh", "-c", buildRunCommand([]string{"sh", "-c", "echo \"$1\"; [ -e /proc/self/fd/4 ] && echo inherited >&4; exit 3", "prog", "it's me"}))
+	cmd.ExtraFiles = make([]*os.File, sandbox.StatusFD-2)
+	cmd.ExtraFiles[sandbox.StatusFD-3] = w
+	out, err := cmd.Output()
+	w.Close()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("the program's exit status was lost: %v", err)
+	}
+	status, _ := io.ReadAll(r)
+	lines := strings.Split(strings.TrimSpace(string(status)), "\n")
+	if string(out) != "it's me\n" || lines[0] != sandbox.StatusStart {
+		t.Fatalf("stdout %q, status %q", out, status)
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, sandbox.StatusScratch+" ") {
+			t.Errorf("status line %q", line)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "runner_runs_total",
+		Help: "Completed runs by language, mode and verdict.",
+	}, []string{"language", "mode", "result"})
+	metricCompileSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "runner_compile_seconds",
+		Help:    "Compile step duration.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"language"})
+	metricTestRunSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "runner_test_run_seconds",
+		Help:    "Per-test program run duration.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"language"})
+	metricTestMemoryPeakBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "runner_test_memory_peak_bytes",
+		Help:    "Per-test peak memory of the run's cgroup.",
+		Buckets: []float64{1 << 20, 4 << 20, 16 << 20, 64 << 20, 128 << 20, 256 << 20, 512 << 20, 1 << 30},
+	}, []string{"language"})
+	metricSecurityViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "runner_security_violations_total",
+		Help: "Runs killed by the seccomp policy, by language and syscall.",
+	}, []string{"language", "syscall"})
+	metricGoHelperSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "runner_go_helper_seconds",
+		Help:    "Go helper duration (compile and all tests).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mode"})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "runner_workers", Help: "Worker pool size."},
+		func() float64 { return float64(poolWorkers) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "runner_workers_busy", Help: "Workers currently executing a job."},
+		func() float64 { return float64(runningJobs.Load()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "runner_job_queue_length", Help: "Jobs waiting in jobQueue."},
+		func() float64 { return float64(len(jobQueue)) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "runner_job_queue_capacity", Help: "jobQueue capacity."},
+		func() float64 { return float64(cap(jobQueue)) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "runner_draining", Help: "1 while the runner drains before shutdown."},
+		func() float64 {
+			if draining.Load() {
+				return 1
+			}
+			return 0
+		})
+	prometheus.MustRegister(dbStatsCollector{})
+}
+
+var (
+	dbConnectionsDesc = prometheus.NewDesc("runner_db_connections",
+		"Database pool connections by state.", []string{"state"}, nil)
+	dbWaitCountDesc = prometheus.NewDesc("runner_db_wait_count",
+		"Total connections waited for (sql.DBStats.WaitCount).", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc("runner_db_wait_duration_seconds",
+		"Total time spent waiting for connections.", nil, nil)
+)
+
+// dbStatsCollector reports the rdb pool statistics at scrape time.
+type dbStatsCollector struct{}
+
+func (dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbConnectionsDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+}
+
+func (dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if rdb == nil {
+		return
+	}
+	st := rdb.Stats()
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.OpenConnections), "open")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(st.MaxOpenConnections), "max_open")
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(st.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, st.WaitDuration.Seconds())
+}
+
+// metricsHandler serves /metrics to scrapers that send METRICS_TOKEN as a
+// bearer token. Without a token configured the endpoint is disabled, since
+// the runner port is reachable from every container on its network.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSpace(os.Getenv("METRICS_TOKEN"))
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	promhttp.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, r)
+	return rec
+}
+
+func TestMetricsHandlerRequiresToken(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "")
+	if rec := scrape(t, "Bearer anything"); rec.Code != http.StatusNotFound {
+		t.Errorf("without METRICS_TOKEN: got %d, want 404", rec.Code)
+	}
+
+	t.Setenv("METRICS_TOKEN", "scrape-secret")
+	for _, auth := range []string{"", "Bearer wrong", "scrape-secret", "Basic scrape-secret"} {
+		if rec := scrape(t, auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: got %d, want 401", auth, rec.Code)
+		}
+	}
+	if rec := scrape(t, "Bearer scrape-secret"); rec.Code != http.StatusOK {
+		t.Errorf("with the token: got %d, want 200", rec.Code)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "scrape-secret")
+	metricRuns.WithLabelValues("c", "default", `Runtime "Error"`+"\n"+`a\b`).Inc()
+	metricCompileSeconds.WithLabelValues("c").Observe(0.02)
+
+	rec := scrape(t, "Bearer scrape-secret")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE runner_runs_total counter",
+		`runner_runs_total{language="c",mode="default",result="Runtime \"Error\"\na\\b"} 1`,
+		"# TYPE runner_compile_seconds histogram",
+		`runner_compile_seconds_bucket{language="c",le="0.025"} 1`,
+		`runner_compile_seconds_bucket{language="c",le="+Inf"} 1`,
+		`runner_compile_seconds_count{language="c"} 1`,
+		"# TYPE runner_job_queue_capacity gauge",
+		"# TYPE runner_draining gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition lacks %q", want)
+		}
+	}
+}
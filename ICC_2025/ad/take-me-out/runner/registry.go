@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// capacityInfo is what a runner advertises to the web tier, both through
+// GET /capacity and through its row in runner_nodes.
+type capacityInfo struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url,omitempty"`
+	Workers   int      `json:"workers"`
+	QueueSize int      `json:"queue_size"`
+	Queued    int      `json:"queued"`
+	Running   int      `json:"running"`
+	Languages []string `json:"languages"`
+	Draining  bool     `json:"draining"`
+}
+
+var (
+	poolWorkers   int
+	poolQueueSize int
+	runningJobs   atomic.Int64
+	draining      atomic.Bool
+)
+
+// runnerID identifies this instance in the registry (RUNNER_ID, else hostname).
+func runnerID() string {
+	if id := strings.TrimSpace(os.Getenv("RUNNER_ID")); id != "" {
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "runner"
+	}
+	return host
+}
+
+// runnerLanguages lists the languages this instance accepts (RUNNER_LANGUAGES).
+func runnerLanguages() []string {
+	raw := getenv("RUNNER_LANGUAGES", "c,go,python,ruby")
+	var langs []string
+	for _, l := range strings.Split(raw, ",") {
+		if l = strings.ToLower(strings.TrimSpace(l)); l != "" {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+func currentCapacity() capacityInfo {
+	queued := 0
+	if jobQueue != nil {
+		queued = len(jobQueue)
+	}
+	return capacityInfo{
+		ID:        runnerID(),
+		URL:       strings.TrimSpace(os.Getenv("RUNNER_ADVERTISE_URL")),
+		Workers:   poolWorkers,
+		QueueSize: poolQueueSize,
+		Queued:    queued,
+		Running:   int(runningJobs.Load()),
+		Languages: runnerLanguages(),
+		Draining:  draining.Load(),
+	}
+}
+
+// capacityHandler handles GET /capacity
+func capacityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentCapacity())
+}
+
+// rejectRun answers a /run request that was not accepted. The header tells
+// the web tier that the job never started, so it may be sent elsewhere.
+func rejectRun(w http.ResponseWriter, reason string) {
+	w.Header().Set("X-Runner-Rejected", reason)
+	w.Header().Set("Retry-After", "1")
+	writeAPIError(w, http.StatusServiceUnavailable, "runner_"+strings.ReplaceAll(reason, "-", "_"), "runner unavailable: "+reason)
+}
+
+// runRegistration keeps this runner's row in runner_nodes fresh until ctx is
+// cancelled. Registration is enabled by RUNNER_ADVERTISE_URL, the base URL
+// the web tier should use to reach this instance.
+func runRegistration(ctx context.Context) {
+	if !registrationEnabled() {
+		return
+	}
+	every := time.Duration(envInt("RUNNER_HEARTBEAT_MS", 2000)) * time.Millisecond
+	if every <= 0 {
+		every = 2 * time.Second
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		publishCapacity()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func registrationEnabled() bool {
+	return strings.TrimSpace(os.Getenv("RUNNER_ADVERTISE_URL")) != "" && rdb != nil
+}
+
+func publishCapacity() {
+	if !registrationEnabled() {
+		return
+	}
+	info := currentCapacity()
+	_, err := rdb.Exec(`
+        INSERT INTO runner_nodes(id, url, workers, queue_size, queued, running, languages, draining, last_seen)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
+        ON CONFLICT (id) DO UPDATE SET
+          url = EXCLUDED.url,
+          workers = EXCLUDED.workers,
+          queue_size = EXCLUDED.queue_size,
+          queued = EXCLUDED.queued,
+          running = EXCLUDED.running,
+          languages = EXCLUDED.languages,
+          draining = EXCLUDED.draining,
+          last_seen = EXCLUDED.last_seen`,
+		info.ID, info.URL, info.Workers, info.QueueSize, info.Queued, info.Running, strings.Join(info.Languages, ","), info.Draining, time.Now().UTC())
+	if err != nil {
+		slog.Warn("runner registry: heartbeat failed", "err", err)
+	}
+}
+
+func unregisterRunner() {
+	if !registrationEnabled() {
+		return
+	}
+	if _, err := rdb.Exec(`DELETE FROM runner_nodes WHERE id = $1`, runnerID()); err != nil {
+		slog.Warn("runner registry: unregister failed", "err", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRunnerLanguages(t *testing.T) {
+	for raw, want := range map[string][]string{
+		"":             {"c", "go", "python", "ruby"},
+		"Python, ruby": {"python", "ruby"},
+		" c ,, go ,":   {"c", "go"},
+		"PYTHON":       {"python"},
+	} {
+		t.Setenv("RUNNER_LANGUAGES", raw)
+		if got := runnerLanguages(); !reflect.DeepEqual(got, want) {
+			t.Errorf("RUNNER_LANGUAGES=%q: %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestCapacityHandler(t *testing.T) {
+	t.Setenv("RUNNER_ID", "runner-1")
+	t.Setenv("RUNNER_ADVERTISE_URL", "http://runner-1:9000")
+	t.Setenv("RUNNER_LANGUAGES", "python")
+	oldWorkers, oldQueue := poolWorkers, poolQueueSize
+	poolWorkers, poolQueueSize = 4, 8
+	runningJobs.Add(2)
+	draining.Store(true)
+	t.Cleanup(func() {
+		poolWorkers, poolQueueSize = oldWorkers, oldQueue
+		runningJobs.Add(-2)
+		draining.Store(false)
+	})
+
+	rec := httptest.NewRecorder()
+	capacityHandler(rec, httptest.NewRequest(http.MethodGet, "/capacity", nil))
+	var got capacityInfo
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := capacityInfo{
+		ID:        "runner-1",
+		URL:       "http://runner-1:9000",
+		Workers:   4,
+		QueueSize: 8,
+		Running:   2,
+		Languages: []string{"python"},
+		Draining:  true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("capacity = %+v, want %+v", got, want)
+	}
+}
+
+func TestRejectRun(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rejectRun(rec, "queue-full")
+	var body apiError
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("X-Runner-Rejected") != "queue-full" || body.Error.Code != "runner_queue_full" {
+		t.Errorf("got %d, rejected %q, code %q", rec.Code, rec.Header().Get("X-Runner-Rejected"), body.Error.Code)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	_ "embed"
 	"errors"
-	"gopkg.in/yaml.v3"
-	"log"
+	"log/slog"
+	"os"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 //go:embed challenges.yaml
@@ -40,24 +42,28 @@ func parseSeedChallenges(data []byte) ([]seedChallenge, error) {
 func seedInitialChallenges() {
 	items, err := parseSeedChallenges(embeddedChallengeData)
 	if err != nil {
-		log.Fatalf("runner: failed to load embedded challenges: %v", err)
+		slog.Error("runner: failed to load embedded challenges", "err", err)
+		os.Exit(1)
 	}
 	tx, err := rdb.Begin()
 	if err != nil {
-		log.Fatalf("runner: begin seed transaction failed: %v", err)
+		slog.Error("runner: begin seed transaction failed", "err", err)
+		os.Exit(1)
 	}
 	defer func() {
 		_ = tx.Rollback()
 	}()
 	for _, ch := range items {
 		if err := seedOneChallenge(tx, ch); err != nil {
-			log.Fatalf("runner: seed challenge %s failed: %v", ch.Name, err)
+			slog.Error("runner: seed challenge failed", "challenge", ch.Name, "err", err)
+			os.Exit(1)
 		}
 	}
 	if err := tx.Commit(); err != nil {
-		log.Fatalf("runner: commit seed transaction failed: %v", err)
+		slog.Error("runner: commit seed transaction failed", "err", err)
+		os.Exit(1)
 	}
-	log.Printf("runner: loaded %d built-in challenges", len(items))
+	slog.Info("runner: loaded built-in challenges", "challenges", len(items))
 }
 
 func seedOneChallenge(tx *sql.Tx, ch seedChallenge) error {
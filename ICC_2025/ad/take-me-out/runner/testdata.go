@@ -4,11 +4,13 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"fmt"
-	_ "github.com/lib/pq"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 type runnerTest struct {
@@ -22,37 +24,58 @@ type challengeMeta struct {
 	Samples     []struct{ Input, Output string } `json:"samples"`
 }
 
+// rdb is the test data DB shared with the web app: PostgreSQL by default,
+// or with RUNNER_DB_DRIVER=sqlite (DB_DRIVER as fallback) the web's SQLite
+// file at SQLITE_PATH. Queries use $N placeholders in ascending order of
+// first appearance, which both drivers bind the same way. SQLite needs a cgo
+// build of the runner.
 var rdb *sql.DB
 
 // init read-only DB connection for runner
 func initRunnerDB() {
-	host := getenv("RUNNER_DB_HOST", getenv("DB_HOST", "db"))
-	port := getenv("RUNNER_DB_PORT", getenv("DB_PORT", "5432"))
-	user := getenv("RUNNER_DB_USER", getenv("DB_USER", "app_runner"))
-	pass := deriveRunnerDBPassword()
-	name := getenv("RUNNER_DB_NAME", getenv("DB_NAME", "postgres"))
-	ssl := getenv("DB_SSLMODE", "disable")
-	dsn := "host=" + host + " port=" + port + " user=" + user + " password=" + pass + " dbname=" + name + " sslmode=" + ssl
 	var err error
-	rdb, err = sql.Open("postgres", dsn)
+	switch driver := strings.ToLower(getenv("RUNNER_DB_DRIVER", getenv("DB_DRIVER", "postgres"))); driver {
+	case "postgres":
+		rdb, err = sql.Open("postgres", runnerPostgresDSN())
+	case "sqlite":
+		rdb, err = sql.Open("sqlite3", "file:"+getenv("SQLITE_PATH", "data/goexe.db")+"?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate")
+	default:
+		err = fmt.Errorf("unsupported RUNNER_DB_DRIVER %q", driver)
+	}
 	if err != nil {
-		log.Fatalf("runner DB open failed: %v", err)
+		slog.Error("runner DB open failed", "err", err)
+		os.Exit(1)
 	}
-	// wait until DB is ready (similar to web)
+	// wait until DB is ready (similar to web); on SQLite that means the web
+	// app has created the schema
 	var pingErr error
 	for i := 0; i < 10; i++ {
 		pingErr = rdb.Ping()
+		if pingErr == nil {
+			pingErr = rdb.QueryRow(`SELECT COUNT(*) FROM challenges`).Scan(new(int))
+		}
 		if pingErr == nil {
 			break
 		}
-		log.Printf("runner: waiting for DB to be ready (%d/10): %v", i+1, pingErr)
+		slog.Info("runner: waiting for DB to be ready", "attempt", i+1, "max_attempts", 10, "err", pingErr)
 		time.Sleep(1 * time.Second)
 	}
 	if pingErr != nil {
-		log.Fatalf("runner DB ping failed after retries: %v", pingErr)
+		slog.Error("runner DB ping failed after retries", "err", pingErr)
+		os.Exit(1)
 	}
 }
 
+func runnerPostgresDSN() string {
+	host := getenv("RUNNER_DB_HOST", getenv("DB_HOST", "db"))
+	port := getenv("RUNNER_DB_PORT", getenv("DB_PORT", "5432"))
+	user := getenv("RUNNER_DB_USER", getenv("DB_USER", "app_runner"))
+	pass := deriveRunnerDBPassword()
+	name := getenv("RUNNER_DB_NAME", getenv("DB_NAME", "postgres"))
+	ssl := getenv("DB_SSLMODE", "disable")
+	return "host=" + host + " port=" + port + " user=" + user + " password=" + pass + " dbname=" + name + " sslmode=" + ssl
+}
+
 func getenv(k, def string) string {
 	v := os.Getenv(k)
 	if strings.TrimSpace(v) == "" {
@@ -71,7 +94,8 @@ func deriveRunnerDBPassword() string {
 	}
 	data, err := os.ReadFile(flagPath)
 	if err != nil {
-		log.Fatalf("runner: failed to read password flag file %s: %v", flagPath, err)
+		slog.Error("runner: failed to read password flag file", "path", flagPath, "err", err)
+		os.Exit(1)
 	}
 	sum := sha256.Sum256(data)
 	return fmt.Sprintf("%x", sum[:])
@@ -82,7 +106,7 @@ func getRunnerTests(challenge, mode string) []runnerTest {
 	if mode == "sample" {
 		rows, err := rdb.Query(`SELECT idx, input, output FROM sample_cases WHERE challenge=$1 ORDER BY idx ASC`, challenge)
 		if err != nil {
-			log.Printf("runner: query sample cases failed: %v", err)
+			slog.Error("runner: query sample cases failed", "challenge", challenge, "err", err)
 			return nil
 		}
 		defer rows.Close()
@@ -91,7 +115,7 @@ func getRunnerTests(challenge, mode string) []runnerTest {
 			var idx int
 			var in, out string
 			if err := rows.Scan(&idx, &in, &out); err != nil {
-				log.Printf("runner: scan sample case failed: %v", err)
+				slog.Error("runner: scan sample case failed", "challenge", challenge, "err", err)
 				return nil
 			}
 			tests = append(tests, runnerTest{Input: in, Output: out, IsSample: true})
@@ -110,14 +134,14 @@ func getRunnerTests(challenge, mode string) []runnerTest {
 	var tests []runnerTest
 	rows, err := rdb.Query(`SELECT idx, input, output FROM sample_cases WHERE challenge=$1 ORDER BY idx ASC`, challenge)
 	if err != nil {
-		log.Printf("runner: query sample cases failed: %v", err)
+		slog.Error("runner: query sample cases failed", "challenge", challenge, "err", err)
 	} else {
 		for rows.Next() {
 			var idx int
 			var in, out string
 			if err := rows.Scan(&idx, &in, &out); err != nil {
 				rows.Close()
-				log.Printf("runner: scan sample case failed: %v", err)
+				slog.Error("runner: scan sample case failed", "challenge", challenge, "err", err)
 				return nil
 			}
 			tests = append(tests, runnerTest{Input: in, Output: out, IsSample: true})
@@ -126,14 +150,14 @@ func getRunnerTests(challenge, mode string) []runnerTest {
 	}
 	rows, err = rdb.Query(`SELECT idx, input, output FROM judge_cases WHERE challenge=$1 ORDER BY idx ASC`, challenge)
 	if err != nil {
-		log.Printf("runner: query judge cases failed: %v", err)
+		slog.Error("runner: query judge cases failed", "challenge", challenge, "err", err)
 	} else {
 		for rows.Next() {
 			var idx int
 			var in, out string
 			if err := rows.Scan(&idx, &in, &out); err != nil {
 				rows.Close()
-				log.Printf("runner: scan judge case failed: %v", err)
+				slog.Error("runner: scan judge case failed", "challenge", challenge, "err", err)
 				return nil
 			}
 			tests = append(tests, runnerTest{Input: in, Output: out})
@@ -151,7 +175,7 @@ func getChallengeMeta(name string) (challengeMeta, bool) {
 	}
 	rows, err := rdb.Query(`SELECT input, output FROM sample_cases WHERE challenge=$1 ORDER BY idx ASC`, name)
 	if err != nil {
-		log.Printf("runner: query samples failed: %v", err)
+		slog.Error("runner: query samples failed", "challenge", name, "err", err)
 		return challengeMeta{}, false
 	}
 	defer rows.Close()
@@ -0,0 +1,119 @@
+// Hostile program for TestSandboxEscapes. stdin: attempt token canary port.
+// Prints "ESCAPE: ..." for every attempt that succeeds.
+#include <arpa/inet.h>
+#include <fcntl.h>
+#include <netinet/in.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/ptrace.h>
+#include <sys/socket.h>
+#include <sys/stat.h>
+#include <sys/types.h>
+#include <unistd.h>
+
+static char token[128], canary[1024];
+static int port;
+
+static void try_read(const char *path) {
+    char buf[256];
+    FILE *f = fopen(path, "r");
+    if (!f) return;
+    size_t n = fread(buf, 1, sizeof buf - 1, f);
+    fclose(f);
+    buf[n] = 0;
+    printf("ESCAPE: read %s: %s\n", path, buf);
+}
+
+static void try_write(const char *dir) {
+    char path[4096];
+    snprintf(path, sizeof path, "%s/%s", dir, token);
+    int fd = open(path, O_WRONLY | O_CREAT, 0644);
+    if (fd < 0) return;
+    close(fd);
+    printf("ESCAPE: wrote %s\n", path);
+}
+
+static void try_connect(const char *ip, int p) {
+    int fd = socket(AF_INET, SOCK_STREAM, 0);
+    if (fd < 0) return;
+    struct sockaddr_in sa = {.sin_family = AF_INET, .sin_port = htons(p)};
+    inet_pton(AF_INET, ip, &sa.sin_addr);
+    struct timeval tv = {1, 0};
+    setsockopt(fd, SOL_SOCKET, SO_SNDTIMEO, &tv, sizeof tv);
+    if (connect(fd, (struct sockaddr *)&sa, sizeof sa) == 0)
+        printf("ESCAPE: connected to %s:%d\n", ip, p);
+    close(fd);
+}
+
+int main(void) {
+    char attempt[64], path[4096];
+    if (scanf("%63s %127s %1023s %d", attempt, token, canary, &port) != 4) return 2;
+
+    if (!strcmp(attempt, "flags")) {
+        const char *flags[] = {"/flag1", "/flag2", "/env/flag1", "/env/flag2", "/workspace/flag2", "/env/workspace/flag2", "flag2"};
+        for (size_t i = 0; i < sizeof flags / sizeof *flags; i++) try_read(flags[i]);
+    } else if (!strcmp(attempt, "host")) {
+        try_read(canary);
+        snprintf(path, sizeof path, "/proc/1/root%s", canary);
+        try_read(path);
+        snprintf(path, sizeof path, "/proc/self/root/../../../..%s", canary);
+        try_read(path);
+        snprintf(path, sizeof path, "../../../../../..%s", canary);
+        try_read(path);
+    } else if (!strcmp(attempt, "network")) {
+        try_connect("127.0.0.1", port);
+        try_connect("1.1.1.1", 53);
+    } else if (!strcmp(attempt, "ptrace")) {
+        if (ptrace(PTRACE_ATTACH, getppid(), 0, 0) == 0) printf("ESCAPE: ptrace attached to parent\n");
+        if (ptrace(PTRACE_ATTACH, 1, 0, 0) == 0) printf("ESCAPE: ptrace attached to pid 1\n");
+    } else if (!strcmp(attempt, "write")) {
+        const char *dirs[] = {"/", "/env", "/bin", "/usr/bin", "/etc", "/.runner"};
+        for (size_t i = 0; i < sizeof dirs / sizeof *dirs; i++) try_write(dirs[i]);
+    } else if (!strcmp(attempt, "privileges")) {
+        uid_t before = geteuid();
+        if (before != 0 && setuid(0) == 0 && geteuid() == 0) printf("ESCAPE: setuid 0\n");
+        if (getegid() != 0 && setgid(0) == 0 && getegid() == 0) printf("ESCAPE: setgid 0\n");
+        // the classic chroot breakout with a leftover CAP_SYS_CHROOT
+        mkdir("/tmp/breakout", 0755);
+        if (chroot("/tmp/breakout") == 0) {
+            for (int i = 0; i < 64; i++) chdir("..");
+            if (chroot(".") == 0) try_read(canary);
+        }
+        // the compiled program must not carry file capabilities either
+        FILE *status = fopen("/proc/self/status", "r");
+        char line[256];
+        unsigned long long caps;
+        while (status && fgets(line, sizeof line, status)) {
+            if ((sscanf(line, "CapEff: %llx", &caps) == 1 || sscanf(line, "CapPrm: %llx", &caps) == 1) && caps != 0)
+                printf("ESCAPE: capabilities %s", line);
+        }
+        if (status) fclose(status);
+    } else if (!strcmp(attempt, "persist-write")) {
+        const char *dirs[] = {"/tmp", "/env/tmp", ".", getenv("HOME") ? getenv("HOME") : "/tmp"};
+        for (size_t i = 0; i < sizeof dirs / sizeof *dirs; i++) {
+            snprintf(path, sizeof path, "%s/%s", dirs[i], token);
+            int fd = open(path, O_WRONLY | O_CREAT, 0644);
+            if (fd >= 0) close(fd);
+        }
+        if (fork() == 0) {
+            setsid();
+            sleep(3);
+            snprintf(path, sizeof path, "/tmp/%s-late", token);
+            int fd = open(path, O_WRONLY | O_CREAT, 0644);
+            if (fd >= 0) close(fd);
+            _exit(0);
+        }
+    } else if (!strcmp(attempt, "persist-read")) {
+        const char *dirs[] = {"/tmp", "/env/tmp", ".", getenv("HOME") ? getenv("HOME") : "/tmp"};
+        for (size_t i = 0; i < sizeof dirs / sizeof *dirs; i++) {
+            struct stat st;
+            snprintf(path, sizeof path, "%s/%s", dirs[i], token);
+            if (stat(path, &st) == 0) printf("ESCAPE: %s survived\n", path);
+            snprintf(path, sizeof path, "%s/%s-late", dirs[i], token);
+            if (stat(path, &st) == 0) printf("ESCAPE: %s survived\n", path);
+        }
+    }
+    printf("done\n");
+    return 0;
+}
@@ -0,0 +1,106 @@
+// Hostile program for TestSandboxEscapes. stdin: attempt token canary port.
+// Prints "ESCAPE: ..." for every attempt that succeeds.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var token, canary string
+
+func tryRead(path string) {
+	if data, err := os.ReadFile(path); err == nil {
+		fmt.Printf("ESCAPE: read %s: %.256s\n", path, data)
+	}
+}
+
+func tryWrite(dir string) {
+	path := filepath.Join(dir, token)
+	if err := os.WriteFile(path, nil, 0o644); err == nil {
+		fmt.Printf("ESCAPE: wrote %s\n", path)
+	}
+}
+
+func tryConnect(addr string) {
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		fmt.Printf("ESCAPE: connected to %s\n", addr)
+	}
+}
+
+func persistDirs() []string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = "/tmp"
+	}
+	return []string{"/tmp", "/env/tmp", ".", home}
+}
+
+func main() {
+	var attempt string
+	var port int
+	if _, err := fmt.Scan(&attempt, &token, &canary, &port); err != nil {
+		os.Exit(2)
+	}
+	switch attempt {
+	case "flags":
+		for _, p := range []string{"/flag1", "/flag2", "/env/flag1", "/env/flag2", "/workspace/flag2", "/env/workspace/flag2", "flag2"} {
+			tryRead(p)
+		}
+	case "host":
+		for _, p := range []string{canary, "/proc/1/root" + canary, "/proc/self/root/../../../.." + canary, "../../../../../.." + canary} {
+			tryRead(p)
+		}
+	case "network":
+		tryConnect("127.0.0.1:" + strconv.Itoa(port))
+		tryConnect("1.1.1.1:53")
+	case "ptrace":
+		if syscall.PtraceAttach(os.Getppid()) == nil {
+			fmt.Println("ESCAPE: ptrace attached to parent")
+		}
+		if syscall.PtraceAttach(1) == nil {
+			fmt.Println("ESCAPE: ptrace attached to pid 1")
+		}
+	case "write":
+		for _, d := range []string{"/", "/env", "/bin", "/usr/bin", "/etc", "/.runner"} {
+			tryWrite(d)
+		}
+	case "privileges":
+		if os.Geteuid() != 0 && syscall.Setuid(0) == nil && os.Geteuid() == 0 {
+			fmt.Println("ESCAPE: setuid 0")
+		}
+		// the classic chroot breakout with a leftover CAP_SYS_CHROOT
+		os.MkdirAll("/tmp/breakout", 0o755)
+		if syscall.Chroot("/tmp/breakout") == nil {
+			for i := 0; i < 64; i++ {
+				os.Chdir("..")
+			}
+			if syscall.Chroot(".") == nil {
+				tryRead(canary)
+			}
+		}
+	case "persist-write":
+		for _, d := range persistDirs() {
+			os.WriteFile(filepath.Join(d, token), nil, 0o644)
+		}
+		// a detached shell outlives the program unless the jail kills it
+		late := filepath.Join("/tmp", token+"-late")
+		cmd := &syscall.ProcAttr{Sys: &syscall.SysProcAttr{Setsid: true}}
+		syscall.ForkExec("/bin/sh", []string{"sh", "-c", "sleep 3; : > " + late}, cmd)
+	case "persist-read":
+		for _, d := range persistDirs() {
+			for _, name := range []string{token, token + "-late"} {
+				if _, err := os.Stat(filepath.Join(d, name)); err == nil {
+					fmt.Printf("ESCAPE: %s survived\n", filepath.Join(d, name))
+				}
+			}
+		}
+	}
+	fmt.Println("done")
+}
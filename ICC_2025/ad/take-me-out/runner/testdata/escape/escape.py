@@ -0,0 +1,95 @@
+# Hostile program for TestSandboxEscapes. stdin: attempt token canary port.
+# Prints "ESCAPE: ..." for every attempt that succeeds.
+import ctypes
+import os
+import socket
+import time
+
+attempt, token, canary, port = input().split()
+port = int(port)
+persist_dirs = ["/tmp", "/env/tmp", ".", os.environ.get("HOME", "/tmp")]
+
+
+def try_read(path):
+    try:
+        with open(path) as f:
+            print(f"ESCAPE: read {path}: {f.read(256)}")
+    except OSError:
+        pass
+
+
+def try_write(d):
+    path = os.path.join(d, token)
+    try:
+        open(path, "w").close()
+        print(f"ESCAPE: wrote {path}")
+    except OSError:
+        pass
+
+
+def try_connect(host, p):
+    try:
+        socket.create_connection((host, p), timeout=1).close()
+        print(f"ESCAPE: connected to {host}:{p}")
+    except OSError:
+        pass
+
+
+if attempt == "flags":
+    for path in ["/flag1", "/flag2", "/env/flag1", "/env/flag2", "/workspace/flag2", "/env/workspace/flag2", "flag2"]:
+        try_read(path)
+elif attempt == "host":
+    for path in [canary, "/proc/1/root" + canary, "/proc/self/root/../../../.." + canary, "../../../../../.." + canary]:
+        try_read(path)
+elif attempt == "network":
+    try_connect("127.0.0.1", port)
+    try_connect("1.1.1.1", 53)
+elif attempt == "ptrace":
+    libc = ctypes.CDLL(None, use_errno=True)
+    libc.ptrace.argtypes = [ctypes.c_long, ctypes.c_long, ctypes.c_void_p, ctypes.c_void_p]
+    PTRACE_ATTACH = 16
+    if libc.ptrace(PTRACE_ATTACH, os.getppid(), None, None) == 0:
+        print("ESCAPE: ptrace attached to parent")
+    if libc.ptrace(PTRACE_ATTACH, 1, None, None) == 0:
+        print("ESCAPE: ptrace attached to pid 1")
+elif attempt == "write":
+    for d in ["/", "/env", "/bin", "/usr/bin", "/etc", "/.runner"]:
+        try_write(d)
+elif attempt == "privileges":
+    if os.geteuid() != 0:
+        try:
+            os.setuid(0)
+            if os.geteuid() == 0:
+                print("ESCAPE: setuid 0")
+        except OSError:
+            pass
+    # the classic chroot breakout with a leftover CAP_SYS_CHROOT
+    try:
+        os.makedirs("/tmp/breakout", exist_ok=True)
+        os.chroot("/tmp/breakout")
+        for _ in range(64):
+            os.chdir("..")
+        os.chroot(".")
+        try_read(canary)
+    except OSError:
+        pass
+elif attempt == "persist-write":
+    for d in persist_dirs:
+        try:
+            open(os.path.join(d, token), "w").close()
+        except OSError:
+            pass
+    if os.fork() == 0:
+        os.setsid()
+        time.sleep(3)
+        try:
+            open(f"/tmp/{token}-late", "w").close()
+        except OSError:
+            pass
+        os._exit(0)
+elif attempt == "persist-read":
+    for d in persist_dirs:
+        for name in [token, token + "-late"]:
+            if os.path.exists(os.path.join(d, name)):
+                print(f"ESCAPE: {os.path.join(d, name)} survived")
+print("done")
@@ -0,0 +1,84 @@
+# Hostile program for TestSandboxEscapes. stdin: attempt token canary port.
+# Prints "ESCAPE: ..." for every attempt that succeeds.
+require 'fiddle'
+require 'fileutils'
+require 'socket'
+
+attempt, token, canary, port = $stdin.read.split
+port = port.to_i
+persist_dirs = ['/tmp', '/env/tmp', '.', ENV.fetch('HOME', '/tmp')]
+
+def try_read(path)
+  puts "ESCAPE: read #{path}: #{File.read(path, 256)}"
+rescue SystemCallError, IOError
+end
+
+def try_write(dir, token)
+  path = File.join(dir, token)
+  File.write(path, '')
+  puts "ESCAPE: wrote #{path}"
+rescue SystemCallError, IOError
+end
+
+def try_connect(host, port)
+  Socket.tcp(host, port, connect_timeout: 1).close
+  puts "ESCAPE: connected to #{host}:#{port}"
+rescue SystemCallError, IOError, SocketError
+end
+
+case attempt
+when 'flags'
+  ['/flag1', '/flag2', '/env/flag1', '/env/flag2', '/workspace/flag2', '/env/workspace/flag2', 'flag2'].each { |p| try_read(p) }
+when 'host'
+  [canary, "/proc/1/root#{canary}", "/proc/self/root/../../../..#{canary}", "../../../../../..#{canary}"].each { |p| try_read(p) }
+when 'network'
+  try_connect('127.0.0.1', port)
+  try_connect('1.1.1.1', 53)
+when 'ptrace'
+  ptrace = Fiddle::Function.new(Fiddle.dlopen(nil)['ptrace'],
+                                [Fiddle::TYPE_LONG, Fiddle::TYPE_LONG, Fiddle::TYPE_VOIDP, Fiddle::TYPE_VOIDP],
+                                Fiddle::TYPE_LONG)
+  ptrace_attach = 16
+  puts 'ESCAPE: ptrace attached to parent' if ptrace.call(ptrace_attach, Process.ppid, nil, nil).zero?
+  puts 'ESCAPE: ptrace attached to pid 1' if ptrace.call(ptrace_attach, 1, nil, nil).zero?
+when 'write'
+  ['/', '/env', '/bin', '/usr/bin', '/etc', '/.runner'].each { |d| try_write(d, token) }
+when 'privileges'
+  if Process.euid != 0
+    begin
+      Process::Sys.setuid(0)
+      puts 'ESCAPE: setuid 0' if Process.euid.zero?
+    rescue SystemCallError
+    end
+  end
+  # the classic chroot breakout with a leftover CAP_SYS_CHROOT
+  begin
+    FileUtils.mkdir_p('/tmp/breakout')
+    Dir.chroot('/tmp/breakout')
+    64.times { Dir.chdir('..') }
+    Dir.chroot('.')
+    try_read(canary)
+  rescue SystemCallError
+  end
+when 'persist-write'
+  persist_dirs.each do |d|
+    File.write(File.join(d, token), '')
+  rescue SystemCallError
+  end
+  fork do
+    Process.setsid
+    sleep 3
+    begin
+      File.write("/tmp/#{token}-late", '')
+    rescue SystemCallError
+    end
+  end
+when 'persist-read'
+  persist_dirs.each do |d|
+    [token, "#{token}-late"].each do |name|
+      path = File.join(d, name)
+      puts "ESCAPE: #{path} survived" if File.exist?(path)
+    end
+  end
+end
+puts 'done'